@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminPrefix is the route prefix of the operator endpoints
+const adminPrefix = "/admin/"
+
+// maxSeedCount caps POST /admin/seed?count=
+const maxSeedCount = 1_000_000
+
+// seedManufacturers are cycled through by generated products
+var seedManufacturers = []string{"Acme", "Globex", "Initech", "Umbrella", "Stark", "Wayne", "Wonka", "Tyrell"}
+
+// storeClearer is implemented by stores that can drop everything at once
+type storeClearer interface {
+	Clear() (int, error)
+}
+
+// ClearResponse is the body returned by DELETE /admin/products
+type ClearResponse struct {
+	Removed int `json:"removed"`
+}
+
+// SeedResponse is the body returned by POST /admin/seed
+type SeedResponse struct {
+	Seeded  int `json:"seeded"`
+	Failed  int `json:"failed"`
+	FirstID int `json:"first_id"`
+	LastID  int `json:"last_id"`
+}
+
+// adminEnabled hides the /admin endpoints behind 404 unless ADMIN_ENABLED is
+// set or ADMIN_API_KEYS is configured, so a deployment can't be wiped by
+// accident
+func (a *API) adminEnabled(c *gin.Context) {
+	if a.cfg.AdminEnabled || len(a.cfg.AdminAPIKeys) > 0 {
+		c.Next()
+		return
+	}
+	abortWithError(c, http.StatusNotFound, ErrorResponse{
+		Error:     "NOT_FOUND",
+		Message:   "Admin endpoints are disabled",
+		Details:   "Set ADMIN_ENABLED=true or ADMIN_API_KEYS to enable them",
+		RequestID: requestID(c),
+	})
+}
+
+// clearProducts handles DELETE /admin/products
+// Returns 200 with the number of products removed, 501 if the store can't
+// be cleared in one step
+func (a *API) clearProducts(c *gin.Context) {
+	clearer, ok := a.baseStore(c.Request.Context()).(storeClearer)
+	if !ok {
+		writeError(c, http.StatusNotImplemented, ErrorResponse{
+			Error:     "NOT_IMPLEMENTED",
+			Message:   "Store cannot be cleared",
+			Details:   "STORE_BACKEND=" + a.cfg.StoreBackend + " does not support clearing",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	removed, err := clearer.Clear()
+	if a.responses != nil {
+		a.responses.clear()
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	a.logger.Warn("store cleared", "request_id", requestID(c), "removed", removed)
+	c.JSON(http.StatusOK, ClearResponse{Removed: removed})
+}
+
+// seedProducts handles POST /admin/seed?count=N&start_id=M
+// Generates count valid products with sequential IDs from start_id
+// (default 1). The same parameters always produce the same products, which
+// replace any existing ones with those IDs. Writes go through PutBatch
+// maxBatchSize at a time.
+// Returns 200 when every product was stored, 207 if some collided on SKU,
+// 400 if count or start_id is invalid
+func (a *API) seedProducts(c *gin.Context) {
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count < 1 || count > maxSeedCount {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid count",
+			Details:   "count must be an integer between 1 and " + strconv.Itoa(maxSeedCount),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	start := 1
+	if raw, present := c.GetQuery("start_id"); present {
+		start, err = strconv.Atoi(raw)
+		if err != nil || start < 1 || start > math.MaxInt32-count+1 {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid start_id",
+				Details:   "start_id must be a positive integer and start_id+count-1 must fit in 32 bits",
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	resp := SeedResponse{FirstID: start, LastID: start + count - 1}
+	batch := make([]Product, 0, min(count, maxBatchSize))
+	for id := start; id <= resp.LastID; id++ {
+		batch = append(batch, seedProduct(id))
+		if len(batch) < maxBatchSize && id < resp.LastID {
+			continue
+		}
+		for _, err := range a.wrappedStore().PutBatch(c.Request.Context(), batch, false) {
+			if err != nil {
+				resp.Failed++
+				continue
+			}
+			resp.Seeded++
+		}
+		batch = batch[:0]
+	}
+
+	a.logger.Info("store seeded", "request_id", requestID(c), "seeded", resp.Seeded, "failed", resp.Failed)
+	if resp.Failed > 0 {
+		c.JSON(http.StatusMultiStatus, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// seedProduct is the generated product with the given ID
+func seedProduct(id int) Product {
+	return Product{
+		ProductID:    id,
+		SKU:          fmt.Sprintf("SEED-%08d", id),
+		Manufacturer: seedManufacturers[id%len(seedManufacturers)],
+		CategoryID:   id%20 + 1,
+		Weight:       id % 1000,
+		SomeOtherID:  id%100 + 1,
+	}
+}
+
+// isAdminRoute reports whether route is one of the /admin endpoints
+func isAdminRoute(route string) bool {
+	return strings.HasPrefix(route, adminPrefix)
+}
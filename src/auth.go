@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyHeader carries the client's key on write requests
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyIDKey is the gin context key holding the ID of the key that
+// authenticated the request, for the request log
+const apiKeyIDKey = "api_key_id"
+
+// AUTH_MODE values: which credentials writes need
+const (
+	authModeAPIKey = "api-key"
+	authModeJWT    = "jwt"
+	authModeBoth   = "both"
+	authModeNone   = "none"
+)
+
+// APIKey is one accepted key. ID names it in logs so the secret itself is
+// never written anywhere.
+type APIKey struct {
+	ID   string
+	hash [sha256.Size]byte
+}
+
+// parseAPIKeys reads the entries of env key name (API_KEYS or
+// ADMIN_API_KEYS), each either "id:secret" or a bare secret whose ID becomes
+// a short hash of it
+func parseAPIKeys(name string, entries []string) ([]APIKey, error) {
+	keys := make([]APIKey, 0, len(entries))
+	for _, entry := range entries {
+		id, secret, named := strings.Cut(entry, ":")
+		if !named {
+			secret = entry
+		}
+		if secret == "" {
+			return nil, errors.New(name + ": entries must not have an empty secret")
+		}
+		hash := sha256.Sum256([]byte(secret))
+		if !named || id == "" {
+			id = "key-" + hex.EncodeToString(hash[:4])
+		}
+		keys = append(keys, APIKey{ID: id, hash: hash})
+	}
+	return keys, nil
+}
+
+// authenticate checks the request's credentials as AUTH_MODE says
+func (a *API) authenticate(c *gin.Context) {
+	switch a.cfg.AuthMode {
+	case authModeNone:
+		c.Next()
+	case authModeJWT, authModeBoth:
+		a.requireToken(c)
+	default:
+		a.requireAPIKey(c)
+	}
+}
+
+// writeMethod reports whether method is one of the write methods auth guards
+func writeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requireAPIKey rejects POST, PUT, PATCH and DELETE requests without a valid
+// X-API-Key with 401 once API_KEYS is configured. Reads stay open, but one
+// sending a valid key is still attributed to it, for quotas. With
+// ADMIN_API_KEYS set, /admin routes are left to requireAdmin instead.
+func (a *API) requireAPIKey(c *gin.Context) {
+	if len(a.cfg.APIKeys) == 0 || (len(a.cfg.AdminAPIKeys) > 0 && isAdminRoute(c.FullPath())) {
+		c.Next()
+		return
+	}
+	if !writeMethod(c.Request.Method) {
+		if id, ok := matchAPIKey(a.cfg.APIKeys, c.GetHeader(apiKeyHeader)); ok {
+			c.Set(apiKeyIDKey, id)
+		}
+		c.Next()
+		return
+	}
+
+	if id, ok := matchAPIKey(a.cfg.APIKeys, c.GetHeader(apiKeyHeader)); ok {
+		c.Set(apiKeyIDKey, id)
+		c.Next()
+		return
+	}
+	c.Header("WWW-Authenticate", `ApiKey header="`+apiKeyHeader+`"`)
+	abortWithError(c, http.StatusUnauthorized, ErrorResponse{
+		Error:     "UNAUTHORIZED",
+		Message:   "Missing or invalid API key",
+		Details:   "Write requests require a valid " + apiKeyHeader + " header",
+		RequestID: requestID(c),
+	})
+}
+
+// requireAdmin guards operator endpoints such as /stats and /admin with ADMIN_API_KEYS,
+// answering 401 without a matching X-API-Key. With no admin keys configured
+// it lets every request through.
+func (a *API) requireAdmin(c *gin.Context) {
+	if len(a.cfg.AdminAPIKeys) == 0 {
+		c.Next()
+		return
+	}
+	if id, ok := matchAPIKey(a.cfg.AdminAPIKeys, c.GetHeader(apiKeyHeader)); ok {
+		c.Set(apiKeyIDKey, id)
+		c.Next()
+		return
+	}
+	c.Header("WWW-Authenticate", `ApiKey header="`+apiKeyHeader+`"`)
+	abortWithError(c, http.StatusUnauthorized, ErrorResponse{
+		Error:     "UNAUTHORIZED",
+		Message:   "Missing or invalid admin API key",
+		Details:   "Admin endpoints require an " + apiKeyHeader + " header listed in ADMIN_API_KEYS",
+		RequestID: requestID(c),
+	})
+}
+
+// matchAPIKey returns the ID of the key equal to provided. Hashes are
+// compared in constant time and every key is checked, so timing reveals
+// neither how much of a key matched nor which one it was.
+func matchAPIKey(keys []APIKey, provided string) (string, bool) {
+	if provided == "" {
+		return "", false
+	}
+	hash := sha256.Sum256([]byte(provided))
+	matched := -1
+	for i, k := range keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return "", false
+	}
+	return keys[matched].ID, true
+}
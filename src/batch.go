@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchSize caps how many products a single POST /products/batch may carry
+const maxBatchSize = 1000
+
+// BatchItemResult reports the outcome for one element of a batch request
+type BatchItemResult struct {
+	Index     int          `json:"index"`
+	ProductID int          `json:"product_id"`
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// BatchResponse is the body returned by POST /products/batch
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+}
+
+// Batch item statuses
+const (
+	batchStatusOK      = "ok"
+	batchStatusError   = "error"
+	batchStatusSkipped = "skipped"
+)
+
+// addProductsBatch handles POST /products/batch
+// With atomic=true every item is validated before any is written, and the
+// store applies all of them or none; readers never see part of the batch.
+// Returns 200 if every item was stored, 207 if some items failed,
+// 400 if the body is invalid or an atomic batch is larger than the store
+// can apply at once, 422 if atomic=true and any item failed
+func (a *API) addProductsBatch(c *gin.Context) {
+	atomic := c.Query("atomic") == "true"
+
+	// Bind JSON array body
+	var items []Product
+	if err := a.readJSON(c, &items); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchSize {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid batch size",
+			Details:   "batch must contain between 1 and " + strconv.Itoa(maxBatchSize) + " products",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	// Validate everything before touching the store
+	resp := BatchResponse{Results: make([]BatchItemResult, len(items))}
+	for i := range items {
+		normalizeProduct(&items[i])
+		items[i].ExpiresAt = nil // TTLs are only taken on POST /products/{id}/details
+		p := items[i]
+		resp.Results[i] = BatchItemResult{Index: i, ProductID: p.ProductID, Status: batchStatusOK}
+		if errs := a.validateWrite(p, ""); errs != nil {
+			resp.Results[i].Status = batchStatusError
+			resp.Results[i].Error = fieldErrorsDetails(errs)
+			resp.Results[i].Fields = errs
+			resp.Failed++
+		}
+	}
+
+	if atomic && resp.Failed > 0 {
+		markSkipped(resp.Results)
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	// Only hand the store the items that passed validation
+	pending := make([]Product, 0, len(items))
+	positions := make([]int, 0, len(items))
+	for i, p := range items {
+		if resp.Results[i].Status == batchStatusOK {
+			pending = append(pending, p)
+			positions = append(positions, i)
+		}
+	}
+
+	errs := a.wrappedStore().PutBatch(c.Request.Context(), pending, atomic)
+	var tooLarge *AtomicBatchTooLargeError
+	if len(errs) > 0 && errors.As(errs[0], &tooLarge) {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid batch size",
+			Details:   tooLarge.Error() + " with this store; send smaller batches or drop atomic=true",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	for k, err := range errs {
+		r := &resp.Results[positions[k]]
+		switch {
+		case err == nil:
+			resp.Applied++
+		case errors.Is(err, ErrBatchAborted):
+			r.Status = batchStatusSkipped
+		default:
+			r.Status = batchStatusError
+			r.Error = err.Error()
+			resp.Failed++
+		}
+	}
+	switch {
+	case resp.Failed == 0:
+		c.JSON(http.StatusOK, resp)
+	case atomic:
+		c.JSON(http.StatusUnprocessableEntity, resp)
+	default:
+		c.JSON(http.StatusMultiStatus, resp)
+	}
+}
+
+// markSkipped flags every item that did not fail as skipped, used when an
+// atomic batch is rejected as a whole.
+func markSkipped(results []BatchItemResult) {
+	for i := range results {
+		if results[i].Status == batchStatusOK {
+			results[i].Status = batchStatusSkipped
+		}
+	}
+}
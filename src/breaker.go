@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Circuit breaker states, also the values of the store_circuit_breaker_state
+// gauge
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+// breakerStateNames names the states in logs and metric labels
+var breakerStateNames = [...]string{breakerClosed: "closed", breakerHalfOpen: "half_open", breakerOpen: "open"}
+
+// CircuitOpenError is returned in place of a store call while the breaker
+// is open. It wraps ErrStoreUnavailable, so it is answered 503.
+type CircuitOpenError struct {
+	// RetryAfter is how long until the breaker next lets a call through
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "store circuit breaker open; retry in " + e.RetryAfter.Round(time.Second).String()
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrStoreUnavailable }
+
+// retryAfterSeconds is RetryAfter rounded up to whole seconds, at least 1
+func (e *CircuitOpenError) retryAfterSeconds() int {
+	return max(int(math.Ceil(e.RetryAfter.Seconds())), 1)
+}
+
+// circuitBreaker counts store calls and their failures over fixed windows.
+// Once a window has seen minRequests calls with at least errorRate of them
+// failing, it opens: every call fails at once with *CircuitOpenError. After
+// openFor it half-opens and lets a single call through; if it succeeds the
+// breaker closes, if not it opens again.
+type circuitBreaker struct {
+	errorRate   float64
+	minRequests int
+	window      time.Duration
+	openFor     time.Duration
+
+	mu          sync.Mutex
+	state       int
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+
+	// Transitions into each state, for metrics
+	transitions [len(breakerStateNames)]atomic.Int64
+	// state, readable without mu
+	current atomic.Int32
+}
+
+func newCircuitBreaker(cfg Config) *circuitBreaker {
+	return &circuitBreaker{
+		errorRate:   cfg.BreakerErrorRate,
+		minRequests: cfg.BreakerMinRequests,
+		window:      cfg.BreakerWindow,
+		openFor:     cfg.BreakerOpenDuration,
+		windowStart: time.Now(),
+	}
+}
+
+// allow reports whether a call may go ahead. If it may, done must be called
+// with the call's outcome; if not, the error is a *CircuitOpenError.
+func (b *circuitBreaker) allow() (done func(failed bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openFor - now.Sub(b.openedAt); wait > 0 {
+			return nil, &CircuitOpenError{RetryAfter: wait}
+		}
+		b.setState(breakerHalfOpen, "the open period is over; probing the store")
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return nil, &CircuitOpenError{RetryAfter: time.Second}
+		}
+		b.probing = true
+		return b.probed, nil
+	}
+	if now.Sub(b.windowStart) >= b.window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	return b.record, nil
+}
+
+// record counts a call made while closed and trips the breaker if the
+// window's error rate reaches the threshold
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		// Started before the breaker opened; its window is gone
+		return
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.minRequests && float64(b.failures) >= b.errorRate*float64(b.requests) {
+		b.trip("error rate " + strconv.Itoa(b.failures) + "/" + strconv.Itoa(b.requests) + " reached the threshold")
+	}
+}
+
+// probed ends the half-open probe
+func (b *circuitBreaker) probed(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if failed {
+		b.trip("probe failed")
+		return
+	}
+	b.windowStart, b.requests, b.failures = time.Now(), 0, 0
+	b.setState(breakerClosed, "probe succeeded")
+}
+
+// trip opens the breaker. Callers must hold mu.
+func (b *circuitBreaker) trip(reason string) {
+	b.openedAt = time.Now()
+	b.setState(breakerOpen, reason)
+}
+
+// setState moves to state and logs why. Callers must hold mu.
+func (b *circuitBreaker) setState(state int, reason string) {
+	level := slog.LevelInfo
+	if state == breakerOpen {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "store circuit breaker "+breakerStateNames[state],
+		"from", breakerStateNames[b.state], "reason", reason)
+	b.state = state
+	b.current.Store(int32(state))
+	b.transitions[state].Add(1)
+}
+
+// State returns the current state without waiting for any call
+func (b *circuitBreaker) State() int {
+	return int(b.current.Load())
+}
+
+// storeFailure reports whether err means the store itself failed, rather
+// than refusing the call for a reason of its own or the caller giving up
+func storeFailure(err error) bool {
+	var dup *DuplicateSKUError
+	var exists *ProductExistsError
+	var tooLarge *AtomicBatchTooLargeError
+	return err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrBatchAborted) &&
+		!errors.Is(err, context.Canceled) && !errors.As(err, &dup) && !errors.As(err, &exists) &&
+		!errors.As(err, &tooLarge)
+}
+
+// breakerStore guards every call to next with breaker
+type breakerStore struct {
+	next    ProductStore
+	breaker *circuitBreaker
+}
+
+// bulkBreakerStore is a breakerStore whose next is a bulkWriter
+type bulkBreakerStore struct {
+	breakerStore
+}
+
+// newBreakerStore wraps next, keeping its bulk write if it has one
+func newBreakerStore(next ProductStore, breaker *circuitBreaker) ProductStore {
+	s := breakerStore{next: next, breaker: breaker}
+	if _, ok := next.(bulkWriter); ok {
+		return bulkBreakerStore{s}
+	}
+	return s
+}
+
+// call runs fn if the breaker allows it and reports the outcome
+func (s breakerStore) call(fn func() error) error {
+	done, err := s.breaker.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(storeFailure(err))
+	return err
+}
+
+func (s breakerStore) Get(ctx context.Context, id int) (Product, error) {
+	var p Product
+	err := s.call(func() (err error) {
+		p, err = s.next.Get(ctx, id)
+		return err
+	})
+	return p, err
+}
+
+func (s breakerStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	var p Product
+	err := s.call(func() (err error) {
+		p, err = s.next.GetBySKU(ctx, sku)
+		return err
+	})
+	return p, err
+}
+
+func (s breakerStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	var found map[int]Product
+	err := s.call(func() (err error) {
+		found, err = s.next.GetMany(ctx, ids)
+		return err
+	})
+	return found, err
+}
+
+func (s breakerStore) Put(ctx context.Context, p *Product) (bool, error) {
+	var created bool
+	err := s.call(func() (err error) {
+		created, err = s.next.Put(ctx, p)
+		return err
+	})
+	return created, err
+}
+
+func (s breakerStore) Create(ctx context.Context, p *Product) error {
+	return s.call(func() error { return s.next.Create(ctx, p) })
+}
+
+// PutBatch counts as one call, failed if any item failed in the store
+func (s breakerStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	var errs []error
+	err := s.call(func() error {
+		errs = s.next.PutBatch(ctx, items, atomic)
+		for _, err := range errs {
+			if storeFailure(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if errs == nil {
+		errs = make([]error, len(items))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// Update doesn't count errors from fn, which the store only passes on
+func (s breakerStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	var p Product
+	var fnErr error
+	err := s.call(func() (err error) {
+		p, err = s.next.Update(ctx, id, func(p *Product) error {
+			fnErr = fn(p)
+			return fnErr
+		})
+		if err != nil && err == fnErr {
+			return nil
+		}
+		return err
+	})
+	if err == nil && fnErr != nil {
+		return p, fnErr
+	}
+	return p, err
+}
+
+func (s breakerStore) Delete(ctx context.Context, id int) error {
+	return s.call(func() error { return s.next.Delete(ctx, id) })
+}
+
+func (s breakerStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	var removed bool
+	err := s.call(func() (err error) {
+		removed, err = deleteIf(ctx, s.next, id, cond)
+		return err
+	})
+	return removed, err
+}
+
+func (s breakerStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	var items []Product
+	err := s.call(func() (err error) {
+		items, err = s.next.List(ctx, filter)
+		return err
+	})
+	return items, err
+}
+
+func (s breakerStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	var items []Product
+	var total int
+	err := s.call(func() (err error) {
+		items, total, err = listWindow(ctx, s.next, filter, limit, offset)
+		return err
+	})
+	return items, total, err
+}
+
+// Ping goes straight to next: readiness checks the breaker separately
+func (s breakerStore) Ping(ctx context.Context) error {
+	if p, ok := s.next.(storePinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// WriteBatch guards next's bulk write, for write-behind flushes
+func (s bulkBreakerStore) WriteBatch(ctx context.Context, puts []Product, deletes []int) error {
+	return s.call(func() error { return s.next.(bulkWriter).WriteBatch(ctx, puts, deletes) })
+}
@@ -0,0 +1,61 @@
+// Package buildinfo identifies the running binary. Version, Commit and
+// BuildTime are stamped at build time:
+//
+//	go build -ldflags "-X text/main/buildinfo.Version=v1.4.0 \
+//	  -X text/main/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X text/main/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, Commit falls back to the revision the Go toolchain embeds
+// when building inside a git checkout, and to "unknown" like BuildTime.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X; see the package doc
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	// The tree had uncommitted changes; only known when Commit came from
+	// the toolchain
+	Modified bool `json:"modified,omitempty"`
+}
+
+var info = load()
+
+// Get returns the build of the running binary
+func Get() Info {
+	return info
+}
+
+func load() Info {
+	i := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok && i.Commit == "" {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				i.Commit = s.Value
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	if i.Commit == "" {
+		i.Commit = "unknown"
+	}
+	if i.BuildTime == "" {
+		i.BuildTime = "unknown"
+	}
+	return i
+}
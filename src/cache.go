@@ -0,0 +1,250 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheGenStripes is how many write generations cachingStore keeps; IDs
+// share one by ID modulo this
+const cacheGenStripes = 1024
+
+// cacheCounter is implemented by stores that cache reads in front of
+// another store
+type cacheCounter interface {
+	CacheCounts() (hits, misses, evictions, stale int64)
+}
+
+// cachingStore is a read-through cache of products by ID in front of a
+// remote store. Get and GetMany are answered from the cache when they can;
+// every other read goes to next. Writes go to next first and then drop the
+// cached entry, so a read after a write on this instance always reaches
+// next. Writes made by other instances show up once the entry's ttl runs
+// out. While next is unavailable, as when its circuit breaker is open, Get
+// and GetMany serve entries past their ttl rather than fail.
+//
+// A miss racing a write must not put the value it read before the write
+// into the cache. Each write bumps the generation of its ID's stripe after
+// dropping the entry, and a miss only fills the cache if the generation it
+// saw before reading next is still current.
+type cachingStore struct {
+	next       ProductStore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[int]*list.Element
+	lru     list.List // of *cacheEntry, most recently used first
+
+	gens [cacheGenStripes]atomic.Uint64
+
+	hits, misses, evictions, stales atomic.Int64
+}
+
+// cacheEntry is one cached product and when it stops being served
+type cacheEntry struct {
+	p       Product
+	expires time.Time
+}
+
+// newCachingStore caches up to maxEntries products of next for ttl each
+func newCachingStore(next ProductStore, maxEntries int, ttl time.Duration) *cachingStore {
+	return &cachingStore{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[int]*list.Element, maxEntries),
+	}
+}
+
+// gen returns the write generation of id's stripe
+func (s *cachingStore) gen(id int) *atomic.Uint64 {
+	return &s.gens[uint(id)%cacheGenStripes]
+}
+
+// lookup returns the cached copy of id, counting the hit or miss. Expired
+// entries are kept until replaced or evicted, for stale to fall back on.
+func (s *cachingStore) lookup(id int, now time.Time) (Product, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		if e := el.Value.(*cacheEntry); now.Before(e.expires) {
+			s.lru.MoveToFront(el)
+			s.hits.Add(1)
+			return e.p.clone(), true
+		}
+	}
+	s.misses.Add(1)
+	return Product{}, false
+}
+
+// stale returns the cached copy of id however old it is, for when next is
+// unavailable
+func (s *cachingStore) stale(id int) (Product, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		s.stales.Add(1)
+		return el.Value.(*cacheEntry).p.clone(), true
+	}
+	return Product{}, false
+}
+
+// fill caches p unless a write to its stripe happened since gen was read,
+// evicting the least recently used entry when full
+func (s *cachingStore) fill(p Product, gen uint64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Checked under mu, which invalidate holds while bumping, so a write
+	// can't slip in between the check and the insert
+	if s.gen(p.ProductID).Load() != gen {
+		return
+	}
+	e := &cacheEntry{p: p.clone(), expires: now.Add(s.ttl)}
+	if el, ok := s.entries[p.ProductID]; ok {
+		el.Value = e
+		s.lru.MoveToFront(el)
+		return
+	}
+	if len(s.entries) >= s.maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheEntry).p.ProductID)
+		s.evictions.Add(1)
+	}
+	s.entries[p.ProductID] = s.lru.PushFront(e)
+}
+
+// invalidate drops the cached copies of ids and stops misses already in
+// flight for them from filling the cache. It runs after the write, whether
+// or not it succeeded, since a failed write may still have been applied.
+func (s *cachingStore) invalidate(ids ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.gen(id).Add(1)
+		if el, ok := s.entries[id]; ok {
+			s.lru.Remove(el)
+			delete(s.entries, id)
+		}
+	}
+}
+
+func (s *cachingStore) Get(ctx context.Context, id int) (Product, error) {
+	now := time.Now()
+	if p, ok := s.lookup(id, now); ok {
+		return p, nil
+	}
+	gen := s.gen(id).Load()
+	p, err := s.next.Get(ctx, id)
+	switch {
+	case err == nil:
+		s.fill(p, gen, now)
+	case errors.Is(err, ErrStoreUnavailable):
+		if old, ok := s.stale(id); ok {
+			return old, nil
+		}
+	}
+	return p, err
+}
+
+func (s *cachingStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	now := time.Now()
+	found := make(map[int]Product, len(ids))
+	var missing []int
+	var gens []uint64
+	for _, id := range ids {
+		if _, seen := found[id]; seen {
+			continue
+		}
+		if p, ok := s.lookup(id, now); ok {
+			found[id] = p
+			continue
+		}
+		missing = append(missing, id)
+		gens = append(gens, s.gen(id).Load())
+	}
+	if len(missing) == 0 {
+		return found, nil
+	}
+	fetched, err := s.next.GetMany(ctx, missing)
+	if errors.Is(err, ErrStoreUnavailable) {
+		for _, id := range missing {
+			p, ok := s.stale(id)
+			if !ok {
+				return found, err
+			}
+			found[id] = p
+		}
+		return found, nil
+	}
+	for i, id := range missing {
+		if p, ok := fetched[id]; ok {
+			found[id] = p
+			s.fill(p, gens[i], now)
+		}
+	}
+	return found, err
+}
+
+func (s *cachingStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	return s.next.GetBySKU(ctx, sku)
+}
+
+func (s *cachingStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	return s.next.List(ctx, filter)
+}
+
+func (s *cachingStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	return listWindow(ctx, s.next, filter, limit, offset)
+}
+
+func (s *cachingStore) Put(ctx context.Context, p *Product) (bool, error) {
+	defer s.invalidate(p.ProductID)
+	return s.next.Put(ctx, p)
+}
+
+func (s *cachingStore) Create(ctx context.Context, p *Product) error {
+	defer s.invalidate(p.ProductID)
+	return s.next.Create(ctx, p)
+}
+
+func (s *cachingStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	ids := make([]int, len(items))
+	for i, p := range items {
+		ids[i] = p.ProductID
+	}
+	defer s.invalidate(ids...)
+	return s.next.PutBatch(ctx, items, atomic)
+}
+
+func (s *cachingStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	defer s.invalidate(id)
+	return s.next.Update(ctx, id, fn)
+}
+
+func (s *cachingStore) Delete(ctx context.Context, id int) error {
+	defer s.invalidate(id)
+	return s.next.Delete(ctx, id)
+}
+
+func (s *cachingStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	defer s.invalidate(id)
+	return deleteIf(ctx, s.next, id, cond)
+}
+
+// Ping checks next, so readiness still reflects the backing store
+func (s *cachingStore) Ping(ctx context.Context) error {
+	if p, ok := s.next.(storePinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *cachingStore) CacheCounts() (hits, misses, evictions, stale int64) {
+	return s.hits.Load(), s.misses.Load(), s.evictions.Load(), s.stales.Load()
+}
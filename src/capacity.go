@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// Values of EVICTION
+const (
+	evictionReject = "reject"
+	evictionLRU    = "lru"
+)
+
+// CLOCK marks: a read is worth two passes of the hand, a write one
+const (
+	clockWritten uint32 = 1
+	clockRead    uint32 = 2
+)
+
+// evictionCounter is implemented by stores that evict products to stay
+// within a size cap
+type evictionCounter interface {
+	Evicted() int64
+}
+
+// SetCapacity caps the store at max products from now on, 0 for no cap.
+// Writes that would add a product beyond the cap fail with ErrStoreFull,
+// unless lru is set: then the write goes through and the least recently
+// read products are evicted to make room. Recency is tracked with a CLOCK
+// approximation, so a read only sets a mark under the shard's read lock.
+// A store already over the cap is evicted down to it straight away.
+func (s *InMemoryStore) SetCapacity(max int, lru bool) {
+	s.lockAll()
+	s.maxProducts = int64(max)
+	s.evictLRU = lru && max > 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.clockIDs, sh.clockRefs, sh.clockHand = nil, nil, 0
+		sh.clockPos = make(map[int]int)
+		if s.evictLRU {
+			for _, id := range sh.ids {
+				sh.clockAdd(id)
+			}
+		}
+	}
+	s.unlockAll()
+	s.evictOverflow()
+}
+
+// Evicted returns how many products have been evicted since startup
+func (s *InMemoryStore) Evicted() int64 {
+	return s.evictedCount.Load()
+}
+
+// reserve counts one more stored product, reporting false, and counting
+// nothing, if that would break a cap that refuses writes. Callers must hold
+// the new product's shard.
+func (s *InMemoryStore) reserve() bool {
+	n := s.size.Add(1)
+	if s.maxProducts > 0 && !s.evictLRU && n > s.maxProducts {
+		s.size.Add(-1)
+		return false
+	}
+	return true
+}
+
+// evictOverflow evicts products until the store is back within its cap.
+// Concurrent writes can overshoot it until their own call gets here.
+// Callers must hold no shard.
+func (s *InMemoryStore) evictOverflow() {
+	if !s.evictLRU {
+		return
+	}
+	for s.size.Load() > s.maxProducts {
+		if !s.evictOne() {
+			return
+		}
+	}
+}
+
+// evictOne evicts the next CLOCK victim and reports whether it found one.
+// The shards' rings form one ring, so recency is compared across shards.
+func (s *InMemoryStore) evictOne() bool {
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+	// Enough visits for the hand to go round the whole ring clockRead+1 times
+	for range (int(clockRead)+1)*storeShards + 1 {
+		sh := &s.shards[s.clockShard]
+		sh.mu.Lock()
+		id, ok := sh.clockVictim()
+		if !ok {
+			sh.clockHand = 0
+			sh.mu.Unlock()
+			s.clockShard = (s.clockShard + 1) % storeShards
+			continue
+		}
+		unlock := s.lockSKUs(sh.products[id].SKU, "", false)
+		err := s.deleteLocked(id)
+		unlock()
+		sh.mu.Unlock()
+		if err != nil {
+			slog.Error("eviction failed", "product_id", id, "error", err)
+			return false
+		}
+		s.evictedCount.Add(1)
+		slog.Debug("product evicted", "product_id", id)
+		return true
+	}
+	return false
+}
+
+// touch marks id as recently read. Callers must hold sh.mu, for reading
+// is enough; the load first keeps a hot product's cache line shared.
+func (sh *storeShard) touch(id int) {
+	if i, ok := sh.clockPos[id]; ok && atomic.LoadUint32(&sh.clockRefs[i]) != clockRead {
+		atomic.StoreUint32(&sh.clockRefs[i], clockRead)
+	}
+}
+
+// clockAdd puts a new product on the ring, marked as written so it
+// survives the hand's next pass. Callers must hold sh.mu for writing.
+func (sh *storeShard) clockAdd(id int) {
+	sh.clockPos[id] = len(sh.clockIDs)
+	sh.clockIDs = append(sh.clockIDs, id)
+	sh.clockRefs = append(sh.clockRefs, clockWritten)
+}
+
+// clockRemove takes id off the ring, moving the last entry, the newest,
+// into its slot; if that slot is under the hand, the hand moves past it so
+// the newest entry isn't examined next. Callers must hold sh.mu for writing.
+func (sh *storeShard) clockRemove(id int) {
+	i, ok := sh.clockPos[id]
+	if !ok {
+		return
+	}
+	last := len(sh.clockIDs) - 1
+	sh.clockIDs[i], sh.clockRefs[i] = sh.clockIDs[last], sh.clockRefs[last]
+	sh.clockPos[sh.clockIDs[i]] = i
+	sh.clockIDs, sh.clockRefs = sh.clockIDs[:last], sh.clockRefs[:last]
+	delete(sh.clockPos, id)
+	if i == sh.clockHand {
+		sh.clockHand++
+	}
+}
+
+// clockVictim advances the hand towards the end of the shard's ring past
+// marked products, lowering their mark by one, and returns the first
+// unmarked one. false means the hand reached the end. Callers must hold
+// sh.mu for writing.
+func (sh *storeShard) clockVictim() (int, bool) {
+	for ; sh.clockHand < len(sh.clockIDs); sh.clockHand++ {
+		i := sh.clockHand
+		if sh.clockRefs[i] == 0 {
+			return sh.clockIDs[i], true
+		}
+		sh.clockRefs[i]--
+	}
+	return 0, false
+}
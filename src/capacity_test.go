@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCapacityRejects(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore(false)
+	s.SetCapacity(3, false)
+	mustPut(t, s, testProduct(1), testProduct(2), testProduct(3))
+
+	p := testProduct(4)
+	if _, err := s.Put(ctx, &p); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("put over the cap: got %v, want ErrStoreFull", err)
+	}
+	if errs := s.PutBatch(ctx, []Product{testProduct(5)}, false); !errors.Is(errs[0], ErrStoreFull) {
+		t.Errorf("batch over the cap: got %v, want ErrStoreFull", errs)
+	}
+	// Replacing, or adding once there is room again, still works
+	mustPut(t, s, testProduct(2))
+	if err := s.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	items, _ := s.List(ctx, ListFilter{})
+	if len(items) != 2 {
+		t.Fatalf("got %d products, want 2", len(items))
+	}
+	mustPut(t, s, testProduct(4))
+	if s.Evicted() != 0 {
+		t.Errorf("evicted %d with EVICTION=reject", s.Evicted())
+	}
+}
+
+func TestCapacityEvictsUnread(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore(false)
+	s.SetCapacity(100, true)
+	for id := 1; id <= 100; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+	for id := 1; id <= 50; id++ {
+		if _, err := s.Get(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 101; id <= 150; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+
+	if got := s.Evicted(); got != 50 {
+		t.Errorf("evicted %d, want 50", got)
+	}
+	// CLOCK only approximates recency, so which unread products went is
+	// loose, but none of the read ones should have
+	for id := 1; id <= 50; id++ {
+		if _, err := s.Get(ctx, id); err != nil {
+			t.Errorf("read product %d: %v", id, err)
+		}
+	}
+	items, _ := s.List(ctx, ListFilter{})
+	if len(items) != 100 {
+		t.Errorf("got %d products, want the cap of 100", len(items))
+	}
+
+	// Lowering the cap evicts straight away
+	s.SetCapacity(10, true)
+	if items, _ := s.List(ctx, ListFilter{}); len(items) != 10 || s.Evicted() != 140 {
+		t.Errorf("after lowering the cap: %d products, %d evicted; want 10 and 140", len(items), s.Evicted())
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Category gives a category_id a name
+type Category struct {
+	CategoryID int    `json:"category_id"`
+	Name       string `json:"name"`
+}
+
+// ErrCategoryNotFound is returned by a CategoryStore for an unknown category_id
+var ErrCategoryNotFound = errors.New("category not found")
+
+// ErrCategoryExists is returned by CategoryStore.Create when the ID is taken
+var ErrCategoryExists = errors.New("category already exists")
+
+// CategoryStore keeps categories in memory whatever STORE_BACKEND is. It is
+// safe for concurrent use.
+type CategoryStore struct {
+	mu         sync.RWMutex
+	categories map[int]Category
+}
+
+// NewCategoryStore returns an empty CategoryStore
+func NewCategoryStore() *CategoryStore {
+	return &CategoryStore{categories: make(map[int]Category)}
+}
+
+// Get returns the category with the given ID or ErrCategoryNotFound
+func (s *CategoryStore) Get(id int) (Category, error) {
+	s.mu.RLock()
+	cat, exists := s.categories[id]
+	s.mu.RUnlock()
+	if !exists {
+		return Category{}, ErrCategoryNotFound
+	}
+	return cat, nil
+}
+
+// Create stores cat, or returns ErrCategoryExists if its ID is taken
+func (s *CategoryStore) Create(cat Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.categories[cat.CategoryID]; exists {
+		return ErrCategoryExists
+	}
+	s.categories[cat.CategoryID] = cat
+	return nil
+}
+
+// Delete removes a category or returns ErrCategoryNotFound
+func (s *CategoryStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.categories[id]; !exists {
+		return ErrCategoryNotFound
+	}
+	delete(s.categories, id)
+	return nil
+}
+
+// List returns every category ordered by category_id
+func (s *CategoryStore) List() []Category {
+	s.mu.RLock()
+	cats := make([]Category, 0, len(s.categories))
+	for _, cat := range s.categories {
+		cats = append(cats, cat)
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(cats, func(x, y Category) int { return x.CategoryID - y.CategoryID })
+	return cats
+}
+
+// validateCategory checks cat against the api.yaml schema and returns every
+// violation, or nil if it is valid
+func validateCategory(cat Category) []FieldError {
+	var errs []FieldError
+	if cat.CategoryID < 1 {
+		errs = append(errs, FieldError{Field: "category_id", Constraint: "must be >= 1", Value: cat.CategoryID})
+	}
+	if n := utf8.RuneCountInString(cat.Name); n < 1 || n > 100 {
+		errs = append(errs, FieldError{Field: "name", Constraint: "must be between 1 and 100 characters", Value: cat.Name})
+	}
+	if !validText(cat.Name, false) {
+		errs = append(errs, FieldError{Field: "name", Constraint: textConstraint, Value: cat.Name})
+	}
+	return errs
+}
+
+// listCategories handles GET /categories
+// Returns 200 with every category ordered by category_id
+func (a *API) listCategories(c *gin.Context) {
+	c.JSON(http.StatusOK, a.categoriesFor(c.Request.Context()).List())
+}
+
+// getCategory handles GET /categories/{categoryId}
+// Returns 200 with the category, 400 if bad ID, 404 if not found
+func (a *API) getCategory(c *gin.Context) {
+	categoryID, ok := parseCategoryID(c)
+	if !ok {
+		return
+	}
+
+	cat, err := a.categoriesFor(c.Request.Context()).Get(categoryID)
+	if err != nil {
+		writeCategoryNotFound(c, categoryID)
+		return
+	}
+	c.JSON(http.StatusOK, cat)
+}
+
+// listCategoryProducts handles GET /categories/{categoryId}/products
+// Served from the store's category index; limit, offset, sort and fields
+// work as on GET /products
+// Returns 200 with the category's products ordered by product_id, 400 if a
+// parameter is invalid, 404 if the category does not exist
+func (a *API) listCategoryProducts(c *gin.Context) {
+	categoryID, ok := parseCategoryID(c)
+	if !ok {
+		return
+	}
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	order, ok := parseSort(c)
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+	if _, err := a.categoriesFor(c.Request.Context()).Get(categoryID); err != nil {
+		writeCategoryNotFound(c, categoryID)
+		return
+	}
+
+	items, total, err := a.listSorted(c.Request.Context(), ListFilter{CategoryID: categoryID}, order, limit, offset)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	writeProducts(c, http.StatusOK, projectAll(items, fields))
+}
+
+// createCategory handles POST /categories
+// Returns 201 with Location and the category, 400 if invalid input, 409 if
+// the category_id is taken
+func (a *API) createCategory(c *gin.Context) {
+	var cat Category
+	if err := a.readJSON(c, &cat); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+
+	cat.Name = normalizeLine(cat.Name)
+	if errs := validateCategory(cat); errs != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	cats, err := a.categoriesToCreateIn(c.Request.Context())
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	if err := cats.Create(cat); err != nil {
+		writeError(c, http.StatusConflict, ErrorResponse{
+			Error:     "CONFLICT",
+			Message:   "Category already exists",
+			Details:   "Category " + strconv.Itoa(cat.CategoryID) + " already exists",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.Header("Location", routePrefix(c)+"/categories/"+strconv.Itoa(cat.CategoryID))
+	c.JSON(http.StatusCreated, cat)
+}
+
+// deleteCategory handles DELETE /categories/{categoryId}
+// With VALIDATE_CATEGORY a category still referenced by products is kept.
+// The check is not atomic with product writes, so a product written while
+// its category is being deleted can still end up pointing at nothing.
+// Returns 204 on success, 400 if bad ID, 404 if not found, 409 if products
+// still reference it
+func (a *API) deleteCategory(c *gin.Context) {
+	categoryID, ok := parseCategoryID(c)
+	if !ok {
+		return
+	}
+
+	cats := a.categoriesFor(c.Request.Context())
+	if a.cfg.ValidateCategory {
+		if _, err := cats.Get(categoryID); err != nil {
+			writeCategoryNotFound(c, categoryID)
+			return
+		}
+		products, err := a.wrappedStore().List(c.Request.Context(), ListFilter{CategoryID: categoryID})
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		if len(products) > 0 {
+			writeError(c, http.StatusConflict, ErrorResponse{
+				Error:     "CATEGORY_IN_USE",
+				Message:   "Category has products",
+				Details:   strconv.Itoa(len(products)) + " products still reference category " + strconv.Itoa(categoryID) + "; move or delete them first",
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	if err := cats.Delete(categoryID); err != nil {
+		writeCategoryNotFound(c, categoryID)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkCategory reports whether products may reference categoryID: always
+// with VALIDATE_CATEGORY off, otherwise only if the category exists
+func (a *API) checkCategory(ctx context.Context, categoryID int) bool {
+	if !a.cfg.ValidateCategory {
+		return true
+	}
+	_, err := a.categoriesFor(ctx).Get(categoryID)
+	return err == nil
+}
+
+// unknownCategoryResponse is the 422 for a product naming a category that
+// doesn't exist
+func unknownCategoryResponse(c *gin.Context, categoryID int) ErrorResponse {
+	return ErrorResponse{
+		Error:   "UNKNOWN_CATEGORY",
+		Message: "Category does not exist",
+		Details: "category_id " + strconv.Itoa(categoryID) + " does not exist; create it with POST /categories first",
+		Fields: []FieldError{
+			{Field: "category_id", Constraint: "must name an existing category", Value: categoryID},
+		},
+		RequestID: requestID(c),
+	}
+}
+
+// parseCategoryID extracts and validates the categoryId path parameter.
+// On failure it writes a 400 response and returns false.
+func parseCategoryID(c *gin.Context) (int, bool) {
+	categoryID, err := strconv.Atoi(c.Param("categoryId"))
+	if err != nil || categoryID < 1 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid category ID",
+			Details:   "Category ID must be a positive integer",
+			RequestID: requestID(c),
+		})
+		return 0, false
+	}
+	return categoryID, true
+}
+
+// writeCategoryNotFound writes the 404 for an unknown categoryID
+func writeCategoryNotFound(c *gin.Context, categoryID int) {
+	writeError(c, http.StatusNotFound, ErrorResponse{
+		Error:     "NOT_FOUND",
+		Message:   "Category not found",
+		Details:   "No category found with ID " + strconv.Itoa(categoryID),
+		RequestID: requestID(c),
+	})
+}
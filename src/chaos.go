@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chaosFaultKey is the gin context key holding the fault injectChaos added
+// to the request, for metrics and the request log
+const chaosFaultKey = "chaos_fault"
+
+// Faults injectChaos adds, as recorded under chaosFaultKey
+const (
+	chaosNone    = "none"
+	chaosLatency = "latency"
+	chaosError   = "error"
+)
+
+// maxChaosLatency caps an injected delay
+const maxChaosLatency = time.Minute
+
+// Chaos is the fault injection in force, set from the CHAOS_* settings at
+// startup and through POST /admin/chaos after. With both rates 0 nothing is
+// injected.
+type Chaos struct {
+	// Share of matching requests delayed, 0 to 1, each by a time picked
+	// uniformly between the two bounds; equal bounds give a fixed delay
+	LatencyRate  float64 `json:"latency_rate"`
+	LatencyMinMS int     `json:"latency_min_ms"`
+	LatencyMaxMS int     `json:"latency_max_ms"`
+	// Share of matching requests answered with ErrorStatus (500 or 503)
+	// instead of reaching the handler, 0 to 1. Drawn after any delay.
+	ErrorRate   float64 `json:"error_rate"`
+	ErrorStatus int     `json:"error_status"`
+	// Route templates and methods faults are limited to; empty matches
+	// every one. A template without a version prefix also matches the
+	// versioned routes, so /products/:productId covers
+	// /v1/products/:productId.
+	Routes  []string `json:"routes,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// active reports whether ch injects anything at all
+func (ch *Chaos) active() bool {
+	return ch.LatencyRate > 0 || ch.ErrorRate > 0
+}
+
+// matches reports whether ch applies to a request to route with method.
+// The operator endpoints and probes are never faulted, so chaos can always
+// be turned back off and doesn't get the task replaced.
+func (ch *Chaos) matches(route, method string) bool {
+	if route == "" || isAdminRoute(route) || loadSheddingExempt[route] {
+		return false
+	}
+	if len(ch.Methods) > 0 && !slices.Contains(ch.Methods, method) {
+		return false
+	}
+	return len(ch.Routes) == 0 || slices.Contains(ch.Routes, route) || slices.Contains(ch.Routes, routeTemplate(route))
+}
+
+// latency picks one delay
+func (ch *Chaos) latency() time.Duration {
+	ms := ch.LatencyMinMS
+	if ch.LatencyMaxMS > ms {
+		ms += rand.IntN(ch.LatencyMaxMS - ms + 1)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// validateChaos checks a configuration sent to POST /admin/chaos
+func validateChaos(ch Chaos) []FieldError {
+	var errs []FieldError
+	for _, rate := range []struct {
+		field string
+		value float64
+	}{{"latency_rate", ch.LatencyRate}, {"error_rate", ch.ErrorRate}} {
+		if rate.value < 0 || rate.value > 1 {
+			errs = append(errs, FieldError{Field: rate.field, Constraint: "must be between 0 and 1", Value: rate.value})
+		}
+	}
+	maxMS := int(maxChaosLatency.Milliseconds())
+	if ch.LatencyMinMS < 0 || ch.LatencyMinMS > maxMS {
+		errs = append(errs, FieldError{Field: "latency_min_ms", Constraint: "must be between 0 and 60000", Value: ch.LatencyMinMS})
+	}
+	if ch.LatencyMaxMS < ch.LatencyMinMS || ch.LatencyMaxMS > maxMS {
+		errs = append(errs, FieldError{Field: "latency_max_ms", Constraint: "must be between latency_min_ms and 60000", Value: ch.LatencyMaxMS})
+	}
+	if ch.ErrorStatus != http.StatusInternalServerError && ch.ErrorStatus != http.StatusServiceUnavailable {
+		errs = append(errs, FieldError{Field: "error_status", Constraint: "must be 500 or 503", Value: ch.ErrorStatus})
+	}
+	for _, r := range ch.Routes {
+		if !strings.HasPrefix(r, "/") {
+			errs = append(errs, FieldError{Field: "routes", Constraint: "must be route templates such as /products/:productId", Value: r})
+		}
+	}
+	for _, m := range ch.Methods {
+		if m != strings.ToUpper(m) || m == "" {
+			errs = append(errs, FieldError{Field: "methods", Constraint: "must be upper-case HTTP methods", Value: m})
+		}
+	}
+	return errs
+}
+
+// injectChaos delays or fails requests as the Chaos in force says. A delay
+// ends early if the request's context does, as when REQUEST_TIMEOUT has
+// already answered 504. Either fault is recorded under chaosFaultKey, so
+// http_requests_total and the request log tell it from a real one.
+func (a *API) injectChaos(c *gin.Context) {
+	ch := a.chaos.Load()
+	if !ch.active() || !ch.matches(c.FullPath(), c.Request.Method) {
+		c.Next()
+		return
+	}
+
+	if ch.LatencyRate > 0 && rand.Float64() < ch.LatencyRate {
+		c.Set(chaosFaultKey, chaosLatency)
+		timer := time.NewTimer(ch.latency())
+		select {
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			timer.Stop()
+			c.Abort()
+			return
+		}
+	}
+	if ch.ErrorRate > 0 && rand.Float64() < ch.ErrorRate {
+		c.Set(chaosFaultKey, chaosError)
+		if ch.ErrorStatus == http.StatusServiceUnavailable {
+			c.Header("Retry-After", "1")
+		}
+		abortWithError(c, ch.ErrorStatus, ErrorResponse{
+			Error:     "CHAOS_INJECTED",
+			Message:   "Failure injected for chaos testing",
+			Details:   "See GET /admin/chaos",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Next()
+}
+
+// chaosFault returns the fault injectChaos added to c, chaosNone if any
+func chaosFault(c *gin.Context) string {
+	if fault := c.GetString(chaosFaultKey); fault != "" {
+		return fault
+	}
+	return chaosNone
+}
+
+// getChaos handles GET /admin/chaos
+// Returns 200 with the fault injection in force
+func (a *API) getChaos(c *gin.Context) {
+	c.JSON(http.StatusOK, a.chaos.Load())
+}
+
+// setChaos handles POST /admin/chaos
+// Replaces the fault injection in force; error_status defaults to 503.
+// Returns 200 with the new configuration, 400 if invalid input
+func (a *API) setChaos(c *gin.Context) {
+	var ch Chaos
+	if err := a.readJSON(c, &ch); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+	if ch.ErrorStatus == 0 {
+		ch.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if errs := validateChaos(ch); errs != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return
+	}
+	a.chaos.Store(&ch)
+	a.logger.Warn("chaos configuration changed", "request_id", requestID(c), "latency_rate", ch.LatencyRate,
+		"error_rate", ch.ErrorRate, "routes", ch.Routes, "methods", ch.Methods)
+	c.JSON(http.StatusOK, &ch)
+}
+
+// clearChaos handles DELETE /admin/chaos
+// Turns fault injection off.
+// Returns 204
+func (a *API) clearChaos(c *gin.Context) {
+	a.chaos.Store(&Chaos{ErrorStatus: http.StatusServiceUnavailable})
+	a.logger.Warn("chaos configuration cleared", "request_id", requestID(c))
+	c.Status(http.StatusNoContent)
+}
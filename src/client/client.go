@@ -0,0 +1,477 @@
+// Package client is a Go client for the Product API. It builds the URLs,
+// decodes error bodies into *APIError and retries requests the server
+// turned away with 429 or 503, honouring Retry-After.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for a Client built without options
+const (
+	DefaultTimeout    = 10 * time.Second
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 200 * time.Millisecond
+)
+
+// maxRetryWait caps how long a single Retry-After is honoured
+const maxRetryWait = 30 * time.Second
+
+// v1 prefixes the product routes of the API version this client speaks
+const v1 = "/v1"
+
+// Product matches the Product schema of the API
+type Product struct {
+	ProductID    int    `json:"product_id"`
+	SKU          string `json:"sku"`
+	Manufacturer string `json:"manufacturer"`
+	CategoryID   int    `json:"category_id"`
+	Weight       int    `json:"weight"`
+	SomeOtherID  int    `json:"some_other_id"`
+
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Price is in cents of Currency; the two are set together or not at all
+	Price    *int   `json:"price,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Quantity int    `json:"quantity"`
+
+	// Set by the server; ignored when writing
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	// Only set on soft-deleted products, listed with IncludeDeleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Only set on products written with a TTL
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// FieldError is one failed constraint reported with a 400
+type FieldError struct {
+	Field      string `json:"field"`
+	Constraint string `json:"constraint"`
+	Value      any    `json:"value"`
+}
+
+// APIError is a non-2xx response. Code is the API's error code, e.g.
+// NOT_FOUND or DUPLICATE_SKU, and is empty if the body wasn't an API error
+// document.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    string
+	Fields     []FieldError
+	RequestID  string
+
+	retryAfter string
+}
+
+func (e *APIError) Error() string {
+	msg := "product api: " + strconv.Itoa(e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Details != "" {
+		return msg + ": " + e.Details
+	}
+	if e.Message != "" {
+		return msg + ": " + e.Message
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is an APIError for a 404, or for the 410
+// answered for a soft-deleted product
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone)
+}
+
+// Client calls one deployment of the API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	timeout    time.Duration
+	apiKey     string
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout bounds each attempt, including reading the body
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithHTTPClient sends requests through a copy of hc, e.g. for a custom
+// transport; the copy gets the client's timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithAPIKey sends key as X-API-Key, which writes need when the server
+// runs with API_KEYS
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithRetries sets how many times a request answered 429 or 503 is retried
+// (0 disables retries) and the backoff before the first retry, which
+// doubles each time. A Retry-After header takes precedence over the backoff.
+func WithRetries(max int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.backoff = backoff
+	}
+}
+
+// New returns a Client for the API at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL must be an absolute http or https URL, got %q", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		timeout:    DefaultTimeout,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	hc := *c.httpClient
+	hc.Timeout = c.timeout
+	c.httpClient = &hc
+	return c, nil
+}
+
+// GetProduct returns the product with the given ID; the error is an
+// *APIError for which IsNotFound is true if it doesn't exist
+func (c *Client) GetProduct(ctx context.Context, id int) (Product, error) {
+	var p Product
+	_, err := c.do(ctx, http.MethodGet, v1+"/products/"+strconv.Itoa(id), nil, nil, &p)
+	return p, err
+}
+
+// PutProductDetails creates or replaces p and reports whether it was new
+func (c *Client) PutProductDetails(ctx context.Context, p Product) (created bool, err error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return false, fmt.Errorf("client: encode product: %w", err)
+	}
+	resp, err := c.do(ctx, http.MethodPost, v1+"/products/"+strconv.Itoa(p.ProductID)+"/details", nil, body, nil)
+	if err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// DeleteProduct removes the product with the given ID
+func (c *Client) DeleteProduct(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, v1+"/products/"+strconv.Itoa(id), nil, nil, nil)
+	return err
+}
+
+// RestoreProduct brings back a soft-deleted product and returns it. A
+// product that isn't deleted fails with an APIError whose Code is
+// NOT_DELETED.
+func (c *Client) RestoreProduct(ctx context.Context, id int) (Product, error) {
+	var p Product
+	_, err := c.do(ctx, http.MethodPost, v1+"/products/"+strconv.Itoa(id)+"/restore", nil, nil, &p)
+	return p, err
+}
+
+// AdjustQuantity adds delta, negative to remove, to the product's quantity
+// in one atomic step and returns the new quantity. Taking more than is in
+// stock fails with an APIError whose Code is INSUFFICIENT_QUANTITY. Retries
+// after a lost response could apply delta twice; the server only prevents
+// that for requests carrying an Idempotency-Key.
+func (c *Client) AdjustQuantity(ctx context.Context, id, delta int) (int, error) {
+	body, err := json.Marshal(map[string]int{"delta": delta})
+	if err != nil {
+		return 0, err
+	}
+	var res struct {
+		Quantity int `json:"quantity"`
+	}
+	_, err = c.do(ctx, http.MethodPost, v1+"/products/"+strconv.Itoa(id)+"/quantity/adjust", nil, body, &res)
+	return res.Quantity, err
+}
+
+// BatchItemResult is the outcome for one product of PutBatch
+type BatchItemResult struct {
+	Index     int          `json:"index"`
+	ProductID int          `json:"product_id"`
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// BatchResult is the response to PutBatch
+type BatchResult struct {
+	Results []BatchItemResult `json:"results"`
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+}
+
+// PutBatch creates or replaces up to 1000 products in one request. Items
+// that fail don't stop the rest; check Failed.
+func (c *Client) PutBatch(ctx context.Context, products []Product) (BatchResult, error) {
+	body, err := json.Marshal(products)
+	if err != nil {
+		return BatchResult{}, fmt.Errorf("client: encode batch: %w", err)
+	}
+	var res BatchResult
+	_, err = c.do(ctx, http.MethodPost, v1+"/products/batch", nil, body, &res)
+	return res, err
+}
+
+// ImportRowError is a CSV row ImportCSV did not store
+type ImportRowError struct {
+	Line      int          `json:"line"`
+	ProductID int          `json:"product_id,omitempty"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// ImportResult is the response to ImportCSV
+type ImportResult struct {
+	Imported        int              `json:"imported"`
+	Skipped         int              `json:"skipped"`
+	Conflicts       int              `json:"conflicts"`
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
+// ImportCSV streams a CSV file in the export layout to the server. With
+// upsert false existing products are reported as conflicts rather than
+// overwritten. The body can't be replayed, so ImportCSV is never retried.
+func (c *Client) ImportCSV(ctx context.Context, csv io.Reader, upsert bool) (ImportResult, error) {
+	q := url.Values{}
+	if !upsert {
+		q.Set("upsert", "false")
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, v1+"/products/import", q, csv, "text/csv")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var res ImportResult
+	_, err = c.send(req, &res)
+	return res, err
+}
+
+// SeedResult is the response to Seed
+type SeedResult struct {
+	Seeded  int `json:"seeded"`
+	Failed  int `json:"failed"`
+	FirstID int `json:"first_id"`
+	LastID  int `json:"last_id"`
+}
+
+// Seed has the server generate count products with IDs from startID. It
+// needs the admin endpoints enabled, and an admin key if the server has any.
+func (c *Client) Seed(ctx context.Context, count, startID int) (SeedResult, error) {
+	q := url.Values{"count": {strconv.Itoa(count)}, "start_id": {strconv.Itoa(startID)}}
+	var res SeedResult
+	_, err := c.do(ctx, http.MethodPost, "/admin/seed", q, nil, &res)
+	return res, err
+}
+
+// ListOptions are the filters and paging of List; zero values are left out
+type ListOptions struct {
+	Limit  int
+	Offset int
+	// Sort is a field name, prefixed with "-" for descending
+	Sort         string
+	CategoryID   int
+	Manufacturer string
+	MinWeight    *int
+	MaxWeight    *int
+	// IncludeDeleted also lists soft-deleted products
+	IncludeDeleted bool
+}
+
+// ListResult is one page of List
+type ListResult struct {
+	Products []Product
+	// Total counts every match, not just this page
+	Total int
+}
+
+// List returns one page of the products matching opts
+func (c *Client) List(ctx context.Context, opts ListOptions) (ListResult, error) {
+	q := url.Values{}
+	setInt := func(name string, v int) {
+		if v != 0 {
+			q.Set(name, strconv.Itoa(v))
+		}
+	}
+	setInt("limit", opts.Limit)
+	setInt("offset", opts.Offset)
+	setInt("category_id", opts.CategoryID)
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.Manufacturer != "" {
+		q.Set("manufacturer", opts.Manufacturer)
+	}
+	if opts.MinWeight != nil {
+		q.Set("min_weight", strconv.Itoa(*opts.MinWeight))
+	}
+	if opts.MaxWeight != nil {
+		q.Set("max_weight", strconv.Itoa(*opts.MaxWeight))
+	}
+	if opts.IncludeDeleted {
+		q.Set("include_deleted", "true")
+	}
+
+	var res ListResult
+	resp, err := c.do(ctx, http.MethodGet, v1+"/products", q, nil, &res.Products)
+	if err != nil {
+		return ListResult{}, err
+	}
+	res.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	return res, nil
+}
+
+// do sends one request, retrying on 429 and 503, and decodes a 2xx body
+// into out when it is non-nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, out any) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		contentType := ""
+		if body != nil {
+			reader = bytes.NewReader(body)
+			contentType = "application/json"
+		}
+		req, err := c.newRequest(ctx, method, path, query, reader, contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.send(req, out)
+		var apiErr *APIError
+		retryable := errors.As(err, &apiErr) &&
+			(apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusServiceUnavailable)
+		if !retryable || attempt >= c.maxRetries {
+			return resp, err
+		}
+		wait := retryAfter(apiErr.retryAfter, backoff)
+		backoff *= 2
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// newRequest builds a request for path on the base URL
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	return req, nil
+}
+
+// send makes one attempt at req, decoding a 2xx body into out when it is
+// non-nil and anything else into an *APIError
+func (c *Client) send(req *http.Request, out any) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := decodeError(resp.StatusCode, data)
+		apiErr.retryAfter = resp.Header.Get("Retry-After")
+		return nil, apiErr
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("client: decode %s %s response: %w", req.Method, req.URL.Path, err)
+		}
+	}
+	return resp, nil
+}
+
+// retryAfter is the wait a Retry-After header asks for, in seconds or as
+// an HTTP date, or fallback if it is absent or unreadable
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+	wait := fallback
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		wait = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(header); err == nil {
+		wait = max(time.Until(at), 0)
+	}
+	return min(wait, maxRetryWait)
+}
+
+// decodeError reads an Error or application/problem+json body into an
+// APIError; anything else leaves just the status code
+func decodeError(status int, body []byte) *APIError {
+	var doc struct {
+		Error     string       `json:"error"`
+		Message   string       `json:"message"`
+		Details   string       `json:"details"`
+		Fields    []FieldError `json:"fields"`
+		RequestID string       `json:"request_id"`
+
+		// problem+json
+		Title      string `json:"title"`
+		Detail     string `json:"detail"`
+		Extensions struct {
+			Code      string       `json:"code"`
+			Fields    []FieldError `json:"fields"`
+			RequestID string       `json:"request_id"`
+		} `json:"extensions"`
+	}
+	apiErr := &APIError{StatusCode: status}
+	if json.Unmarshal(body, &doc) != nil {
+		return apiErr
+	}
+	if doc.Error != "" {
+		apiErr.Code, apiErr.Message, apiErr.Details = doc.Error, doc.Message, doc.Details
+		apiErr.Fields, apiErr.RequestID = doc.Fields, doc.RequestID
+		return apiErr
+	}
+	apiErr.Code, apiErr.Message, apiErr.Details = doc.Extensions.Code, doc.Title, doc.Detail
+	apiErr.Fields, apiErr.RequestID = doc.Extensions.Fields, doc.Extensions.RequestID
+	return apiErr
+}
@@ -0,0 +1,297 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client for a server answering with handler,
+// retrying without delay
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, append([]Option{WithRetries(DefaultMaxRetries, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeJSON answers with status and v as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestGetProduct(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/products/7" || r.Header.Get("Accept") != "application/json" || r.Header.Get("X-API-Key") != "k1" {
+			t.Errorf("got %s %s with headers %v", r.Method, r.URL.Path, r.Header)
+		}
+		io.WriteString(w, `{"product_id":7,"sku":"SKU-7","manufacturer":"Acme","category_id":1,"weight":70,"some_other_id":1,"quantity":3,"created_at":"2026-01-02T03:04:05Z"}`)
+	}, WithAPIKey("k1"))
+	p, err := c.GetProduct(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ProductID != 7 || p.SKU != "SKU-7" || p.Quantity != 3 || !p.CreatedAt.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   int
+		body     string
+		want     APIError
+		notFound bool
+	}{
+		{"native", 404, `{"error":"NOT_FOUND","message":"Product not found","details":"No product with ID 7","request_id":"r1"}`,
+			APIError{StatusCode: 404, Code: "NOT_FOUND", Message: "Product not found", Details: "No product with ID 7", RequestID: "r1"}, true},
+		{"soft-deleted", 410, `{"error":"GONE","message":"Product deleted","request_id":"r2"}`,
+			APIError{StatusCode: 410, Code: "GONE", Message: "Product deleted", RequestID: "r2"}, true},
+		{"problem+json", 400, `{"type":"urn:product-api:problem:INVALID_INPUT","title":"Validation failed","status":400,"detail":"weight must be >= 0",` +
+			`"extensions":{"code":"INVALID_INPUT","fields":[{"field":"weight","constraint":"must be >= 0","value":-1}],"request_id":"r3"}}`,
+			APIError{StatusCode: 400, Code: "INVALID_INPUT", Message: "Validation failed", Details: "weight must be >= 0",
+				Fields: []FieldError{{Field: "weight", Constraint: "must be >= 0", Value: -1.0}}, RequestID: "r3"}, false},
+		{"not an API error", 502, `<html>Bad Gateway</html>`, APIError{StatusCode: 502}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			})
+			_, err := c.GetProduct(context.Background(), 7)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an *APIError", err)
+			}
+			got := *apiErr
+			got.retryAfter = ""
+			if gotJSON, wantJSON := mustJSON(t, got), mustJSON(t, tc.want); gotJSON != wantJSON {
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+			if IsNotFound(err) != tc.notFound {
+				t.Errorf("IsNotFound: got %v", !tc.notFound)
+			}
+		})
+	}
+
+	err := &APIError{StatusCode: 409, Code: "DUPLICATE_SKU", Message: "SKU already exists", Details: "SKU-1 belongs to product 2"}
+	if got, want := err.Error(), "product api: 409 DUPLICATE_SKU: SKU-1 belongs to product 2"; got != want {
+		t.Errorf("Error(): got %q, want %q", got, want)
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		statuses  []int // answered in turn, then 200
+		want      int   // attempts made
+		wantError int   // status of the error returned, 0 for success
+	}{
+		{"429 then success", []int{429, 429}, 3, 0},
+		{"503 then success", []int{503}, 2, 0},
+		{"retries exhausted", []int{503, 503, 503, 503, 503}, 1 + DefaultMaxRetries, 503},
+		{"500 not retried", []int{500}, 1, 500},
+		{"409 not retried", []int{409}, 1, 409},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				if body, _ := io.ReadAll(r.Body); string(body) != `{"delta":-2}` {
+					t.Errorf("attempt %d: body %q", n, body)
+				}
+				if n <= len(tc.statuses) {
+					w.Header().Set("Retry-After", "0")
+					writeJSON(w, tc.statuses[n-1], map[string]string{"error": "BUSY", "message": "try again"})
+					return
+				}
+				writeJSON(w, 200, map[string]int{"quantity": 8})
+			})
+			q, err := c.AdjustQuantity(context.Background(), 1, -2)
+			if got := int(attempts.Load()); got != tc.want {
+				t.Errorf("got %d attempts, want %d", got, tc.want)
+			}
+			var apiErr *APIError
+			switch {
+			case tc.wantError == 0 && (err != nil || q != 8):
+				t.Errorf("got %d, %v, want 8", q, err)
+			case tc.wantError != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tc.wantError):
+				t.Errorf("got %v, want a %d", err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	var first time.Time
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+			return
+		}
+		if waited := time.Since(first); waited < 900*time.Millisecond {
+			t.Errorf("retried after %v, want at least the 1s Retry-After", waited)
+		}
+		io.WriteString(w, `{"product_id":1}`)
+	}, WithRetries(1, time.Millisecond))
+	if _, err := c.GetProduct(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A canceled context stops the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	attempts.Store(0)
+	start := time.Now()
+	if _, err := c.GetProduct(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("returned after %v, long after the context ended", waited)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	fallback := 200 * time.Millisecond
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", fallback},
+		{"0", 0},
+		{"2", 2 * time.Second},
+		{"3600", maxRetryWait},
+		{"-1", fallback},
+		{"soon", fallback},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := retryAfter(tc.header, fallback); got != tc.want {
+			t.Errorf("retryAfter(%q): got %v, want %v", tc.header, got, tc.want)
+		}
+	}
+	if got := retryAfter(time.Now().Add(5*time.Second).UTC().Format(http.TimeFormat), fallback); got < 3*time.Second || got > 5*time.Second {
+		t.Errorf("HTTP date 5s ahead: got %v", got)
+	}
+}
+
+func TestPutProductDetails(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusCreated)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var p Product
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/products/3/details" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.SKU != "SKU-3" {
+			t.Errorf("body: %+v %v", p, err)
+		}
+		w.WriteHeader(int(status.Load()))
+	})
+	p := Product{ProductID: 3, SKU: "SKU-3", Manufacturer: "Acme", CategoryID: 1, SomeOtherID: 1}
+	if created, err := c.PutProductDetails(context.Background(), p); err != nil || !created {
+		t.Errorf("create: got %v, %v", created, err)
+	}
+	status.Store(http.StatusNoContent)
+	if created, err := c.PutProductDetails(context.Background(), p); err != nil || created {
+		t.Errorf("replace: got %v, %v", created, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		want := url.Values{"limit": {"2"}, "offset": {"4"}, "sort": {"-weight"}, "category_id": {"3"},
+			"manufacturer": {"Acme & Sons"}, "min_weight": {"0"}, "include_deleted": {"true"}}
+		if r.URL.Path != "/base/v1/products" || r.URL.Query().Encode() != want.Encode() {
+			t.Errorf("got %s?%s, want /base/v1/products?%s", r.URL.Path, r.URL.RawQuery, want.Encode())
+		}
+		w.Header().Set("X-Total-Count", "11")
+		io.WriteString(w, `[{"product_id":5},{"product_id":9}]`)
+	})
+	// A base URL with a path prefix keeps it
+	c.baseURL.Path = "/base"
+	zero := 0
+	res, err := c.List(context.Background(), ListOptions{Limit: 2, Offset: 4, Sort: "-weight", CategoryID: 3,
+		Manufacturer: "Acme & Sons", MinWeight: &zero, IncludeDeleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 11 || len(res.Products) != 2 || res.Products[1].ProductID != 9 {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestImportCSVNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if r.URL.RawQuery != "upsert=false" || r.Header.Get("Content-Type") != "text/csv" {
+			t.Errorf("got ?%s with %s", r.URL.RawQuery, r.Header.Get("Content-Type"))
+		}
+		writeJSON(w, 503, map[string]string{"error": "UNAVAILABLE", "message": "draining"})
+	})
+	_, err := c.ImportCSV(context.Background(), strings.NewReader("product_id,sku\n1,SKU-1\n"), false)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "UNAVAILABLE" || attempts.Load() != 1 {
+		t.Errorf("got %v after %d attempts, want one 503", err, attempts.Load())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, WithTimeout(50*time.Millisecond))
+	start := time.Now()
+	if _, err := c.GetProduct(context.Background(), 1); err == nil {
+		t.Fatal("no error from a server that never answers")
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("gave up after %v, want about 50ms", waited)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, raw := range []string{"", "localhost:8080", "ftp://example.com", "http://", "://bad"} {
+		if _, err := New(raw); err == nil {
+			t.Errorf("New(%q): no error", raw)
+		}
+	}
+	c, err := New("https://api.example.com/prefix/", WithHTTPClient(&http.Client{}), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.baseURL.String() != "https://api.example.com/prefix" || c.httpClient.Timeout != time.Second || c.maxRetries != DefaultMaxRetries {
+		t.Errorf("got %+v", c)
+	}
+}
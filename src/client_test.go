@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"text/main/client"
+)
+
+// TestClientAgainstAPI drives the client package against the real router,
+// so the two can't disagree about routes, bodies or error documents
+func TestClientAgainstAPI(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), nil)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	price := 1250
+	p := client.Product{ProductID: 1, SKU: "SKU-1", Manufacturer: "Acme", CategoryID: 2, Weight: 10, SomeOtherID: 1, Price: &price, Currency: "USD"}
+	if created, err := c.PutProductDetails(ctx, p); err != nil || !created {
+		t.Fatalf("create: got %v, %v", created, err)
+	}
+	p.Weight = 20
+	if created, err := c.PutProductDetails(ctx, p); err != nil || created {
+		t.Fatalf("replace: got %v, %v", created, err)
+	}
+	got, err := c.GetProduct(ctx, 1)
+	if err != nil || got.Weight != 20 || got.Price == nil || *got.Price != 1250 || got.CreatedAt.IsZero() {
+		t.Fatalf("get: got %+v, %v", got, err)
+	}
+
+	var apiErr *client.APIError
+	dup := p
+	dup.ProductID = 2
+	if _, err := c.PutProductDetails(ctx, dup); !errors.As(err, &apiErr) || apiErr.StatusCode != 409 || apiErr.Code != "DUPLICATE_SKU" || apiErr.RequestID == "" {
+		t.Errorf("duplicate SKU: got %v", err)
+	}
+	bad := p
+	bad.Weight = -1
+	if _, err := c.PutProductDetails(ctx, bad); !errors.As(err, &apiErr) || apiErr.Code != "INVALID_INPUT" || len(apiErr.Fields) == 0 || apiErr.Fields[0].Field != "weight" {
+		t.Errorf("invalid product: got %v", err)
+	}
+	if _, err := c.GetProduct(ctx, 99); !client.IsNotFound(err) {
+		t.Errorf("missing: got %v, want IsNotFound", err)
+	}
+
+	if q, err := c.AdjustQuantity(ctx, 1, 5); err != nil || q != 5 {
+		t.Errorf("adjust: got %d, %v", q, err)
+	}
+	if _, err := c.AdjustQuantity(ctx, 1, -6); !errors.As(err, &apiErr) || apiErr.Code != "INSUFFICIENT_QUANTITY" {
+		t.Errorf("adjust below zero: got %v", err)
+	}
+
+	batch, err := c.PutBatch(ctx, []client.Product{
+		{ProductID: 2, SKU: "SKU-2", Manufacturer: "Acme", CategoryID: 2, Weight: 30, SomeOtherID: 1},
+		{ProductID: 3, SKU: "SKU-1", Manufacturer: "Acme", CategoryID: 2, Weight: 40, SomeOtherID: 1},
+	})
+	if err != nil || batch.Applied != 1 || batch.Failed != 1 || len(batch.Results) != 2 {
+		t.Errorf("batch: got %+v, %v", batch, err)
+	}
+
+	list, err := c.List(ctx, client.ListOptions{CategoryID: 2, Sort: "-weight", Limit: 1})
+	if err != nil || list.Total != 2 || len(list.Products) != 1 || list.Products[0].ProductID != 2 {
+		t.Errorf("list: got %+v, %v", list, err)
+	}
+
+	if err := c.DeleteProduct(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetProduct(ctx, 1); !client.IsNotFound(err) {
+		t.Errorf("after delete: got %v, want IsNotFound", err)
+	}
+	if restored, err := c.RestoreProduct(ctx, 1); err != nil || restored.Weight != 20 {
+		t.Errorf("restore: got %+v, %v", restored, err)
+	}
+	if _, err := c.RestoreProduct(ctx, 1); !errors.As(err, &apiErr) || apiErr.Code != "NOT_DELETED" {
+		t.Errorf("restore again: got %v", err)
+	}
+}
+
+// TestClientRetriesRateLimit checks the client waits out the Retry-After
+// the rate limiter sends rather than failing
+func TestClientRetriesRateLimit(t *testing.T) {
+	router := seededRouter(t, 1, map[string]string{"RATE_LIMIT_RPS": "1", "RATE_LIMIT_BURST": "1"})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	ctx := context.Background()
+
+	noRetry, _ := client.New(srv.URL, client.WithRetries(0, 0))
+	patient, _ := client.New(srv.URL)
+	if _, err := noRetry.GetProduct(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.APIError
+	if _, err := noRetry.GetProduct(ctx, 1); !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Fatalf("over the limit: got %v, want a 429", err)
+	}
+	if p, err := patient.GetProduct(ctx, 1); err != nil || p.ProductID != 1 {
+		t.Errorf("with retries: got %+v, %v", p, err)
+	}
+}
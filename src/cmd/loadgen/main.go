@@ -0,0 +1,396 @@
+// Command loadgen load-tests a deployment of the Product API through the
+// client package. Workers loop on a mix of GetProduct and PutProductDetails
+// calls for a fixed time, then it prints throughput, latency percentiles and
+// error counts.
+//
+//	loadgen [--endpoint URL] [--concurrency N] [--duration D] [flags]
+//
+// Each worker draws its operations and product IDs from its own generator
+// seeded from --seed, so two runs with the same flags send the same
+// sequence of requests per worker; only how far each worker gets depends on
+// the server.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"text/main/client"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Operations a worker performs
+const (
+	opGet = "get"
+	opPut = "put"
+)
+
+// Outcomes of a request besides an HTTP_<status> error
+const (
+	outcomeOK        = "ok"
+	outcomeTimeout   = "timeout"
+	outcomeTransport = "transport"
+)
+
+// options are the command's flags
+type options struct {
+	endpoint    string
+	apiKey      string
+	timeout     time.Duration
+	retries     int
+	concurrency int
+	duration    time.Duration
+	rampUp      time.Duration
+	warmup      time.Duration
+	readRatio   float64
+	products    int
+	prefill     bool
+	seed        uint64
+	csvPath     string
+}
+
+// sample is one request made inside the measured window
+type sample struct {
+	worker    int
+	seq       int
+	op        string
+	productID int
+	// Since the measured window opened
+	start   time.Duration
+	latency time.Duration
+	outcome string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var o options
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.StringVar(&o.endpoint, "endpoint", envOr("PRODUCTCTL_ENDPOINT", "http://localhost:8080"), "base URL of the API ($PRODUCTCTL_ENDPOINT)")
+	fs.StringVar(&o.apiKey, "api-key", os.Getenv("PRODUCTCTL_API_KEY"), "API key sent as X-API-Key ($PRODUCTCTL_API_KEY)")
+	fs.DurationVar(&o.timeout, "timeout", client.DefaultTimeout, "timeout of each request")
+	fs.IntVar(&o.retries, "retries", 0, "retries of a request answered 429 or 503; retried time counts as latency")
+	fs.IntVar(&o.concurrency, "concurrency", 10, "workers sending requests, each one at a time")
+	fs.DurationVar(&o.duration, "duration", 30*time.Second, "length of the measured window, after the warm-up")
+	fs.DurationVar(&o.rampUp, "ramp-up", 0, "time over which workers are started, evenly spaced")
+	fs.DurationVar(&o.warmup, "warmup", 0, "time from the start whose requests are left out of the stats")
+	fs.Float64Var(&o.readRatio, "read-ratio", 0.9, "share of requests that are reads, 0 to 1; the rest are writes")
+	fs.IntVar(&o.products, "products", 1000, "product IDs used are 1 to this")
+	fs.BoolVar(&o.prefill, "prefill", false, "seed every product ID on the server before starting, so reads find them")
+	fs.Uint64Var(&o.seed, "seed", 1, "seed of the request sequence")
+	fs.StringVar(&o.csvPath, "csv", "", "write every measured request's latency to this CSV file")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := o.validate(); err != nil || fs.NArg() > 0 {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "loadgen:", err)
+		}
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runLoad(ctx, o); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func (o *options) validate() error {
+	switch {
+	case o.concurrency < 1:
+		return errors.New("--concurrency must be at least 1")
+	case o.duration <= 0:
+		return errors.New("--duration must be positive")
+	case o.rampUp < 0 || o.warmup < 0:
+		return errors.New("--ramp-up and --warmup can't be negative")
+	case o.readRatio < 0 || o.readRatio > 1:
+		return errors.New("--read-ratio must be between 0 and 1")
+	case o.products < 1:
+		return errors.New("--products must be at least 1")
+	case o.retries < 0:
+		return errors.New("--retries can't be negative")
+	}
+	return nil
+}
+
+func runLoad(ctx context.Context, o options) error {
+	c, err := client.New(o.endpoint, client.WithAPIKey(o.apiKey), client.WithTimeout(o.timeout),
+		client.WithRetries(o.retries, client.DefaultBackoff))
+	if err != nil {
+		return err
+	}
+	if o.prefill {
+		res, err := c.Seed(ctx, o.products, 1)
+		if err != nil {
+			return fmt.Errorf("prefill: %w", err)
+		}
+		if res.Failed > 0 {
+			return fmt.Errorf("prefill: %d of %d products were not seeded", res.Failed, o.products)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "loadgen: %d workers for %s against %s (ramp-up %s, warm-up %s)\n",
+		o.concurrency, o.duration, o.endpoint, o.rampUp, o.warmup)
+	start := time.Now()
+	measureFrom := start.Add(o.warmup)
+	ctx, cancel := context.WithDeadline(ctx, measureFrom.Add(o.duration))
+	defer cancel()
+
+	samples := make([][]sample, o.concurrency)
+	var wg sync.WaitGroup
+	for w := range o.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delay := o.rampUp * time.Duration(w) / time.Duration(o.concurrency)
+			samples[w] = runWorker(ctx, c, o, w, start.Add(delay), measureFrom)
+		}()
+	}
+	wg.Wait()
+
+	// Stopped early by a signal: report the part of the window that ran
+	window := min(time.Since(measureFrom), o.duration)
+	all := slices.Concat(samples...)
+	if o.csvPath != "" {
+		if err := writeCSV(o.csvPath, all); err != nil {
+			return err
+		}
+	}
+	return printReport(all, window)
+}
+
+// runWorker sends requests one after the other from startAt until ctx is
+// done, and returns those started at or after measureFrom
+func runWorker(ctx context.Context, c *client.Client, o options, worker int, startAt, measureFrom time.Time) []sample {
+	timer := time.NewTimer(time.Until(startAt))
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		return nil
+	}
+
+	rng := rand.New(rand.NewPCG(o.seed, uint64(worker)))
+	var samples []sample
+	for seq := 0; ctx.Err() == nil; seq++ {
+		s := sample{worker: worker, seq: seq, op: opGet, productID: rng.IntN(o.products) + 1}
+		var p client.Product
+		if rng.Float64() >= o.readRatio {
+			s.op = opPut
+			p = randomProduct(rng, s.productID)
+		}
+
+		begin := time.Now()
+		var err error
+		if s.op == opGet {
+			_, err = c.GetProduct(ctx, s.productID)
+		} else {
+			_, err = c.PutProductDetails(ctx, p)
+		}
+		s.latency = time.Since(begin)
+		if err != nil && ctx.Err() != nil {
+			// Cut off by the end of the run, not failed
+			break
+		}
+		if begin.Before(measureFrom) {
+			continue
+		}
+		s.start = begin.Sub(measureFrom)
+		s.outcome = outcome(err)
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// randomProduct returns a valid product with the given ID, like
+// locustfile.py's random_product
+func randomProduct(rng *rand.Rand, id int) client.Product {
+	const skuChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	sku := make([]byte, 10)
+	for i := range sku {
+		sku[i] = skuChars[rng.IntN(len(skuChars))]
+	}
+	return client.Product{
+		ProductID:    id,
+		SKU:          string(sku),
+		Manufacturer: "Manufacturer-" + strconv.Itoa(rng.IntN(100)+1),
+		CategoryID:   rng.IntN(50) + 1,
+		Weight:       rng.IntN(10001),
+		SomeOtherID:  rng.IntN(1000) + 1,
+	}
+}
+
+// outcome classifies the result of one request
+func outcome(err error) string {
+	var apiErr *client.APIError
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.As(err, &apiErr):
+		return "HTTP_" + strconv.Itoa(apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		return outcomeTimeout
+	default:
+		return outcomeTransport
+	}
+}
+
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
+
+// summary holds the stats of one group of samples
+type summary struct {
+	requests int
+	errors   int
+	mean     time.Duration
+	p50      time.Duration
+	p95      time.Duration
+	p99      time.Duration
+	max      time.Duration
+}
+
+func summarize(samples []sample) summary {
+	s := summary{requests: len(samples)}
+	if len(samples) == 0 {
+		return s
+	}
+	latencies := make([]time.Duration, len(samples))
+	var total time.Duration
+	for i, smp := range samples {
+		latencies[i] = smp.latency
+		total += smp.latency
+		if smp.outcome != outcomeOK {
+			s.errors++
+		}
+	}
+	slices.Sort(latencies)
+	s.mean = total / time.Duration(len(latencies))
+	s.p50 = percentile(latencies, 50)
+	s.p95 = percentile(latencies, 95)
+	s.p99 = percentile(latencies, 99)
+	s.max = latencies[len(latencies)-1]
+	return s
+}
+
+// percentile returns the nearest-rank pth percentile of sorted
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// printReport writes the stats of the measured window to stdout, overall
+// and per operation, then the count of each kind of error
+func printReport(samples []sample, window time.Duration) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "OP\tREQUESTS\tERRORS\tREQ/S\tMEAN\tP50\tP95\tP99\tMAX\t")
+	groups := []struct {
+		name    string
+		samples []sample
+	}{
+		{opGet, filter(samples, opGet)},
+		{opPut, filter(samples, opPut)},
+		{"total", samples},
+	}
+	for _, g := range groups {
+		s := summarize(g.samples)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t\n", g.name, s.requests, s.errors,
+			float64(s.requests)/window.Seconds(), ms(s.mean), ms(s.p50), ms(s.p95), ms(s.p99), ms(s.max))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, s := range samples {
+		if s.outcome != outcomeOK {
+			counts[s.outcome]++
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	fmt.Println("\nErrors:")
+	for _, kind := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("  %s\t%d\n", kind, counts[kind])
+	}
+	return nil
+}
+
+func filter(samples []sample, op string) []sample {
+	var out []sample
+	for _, s := range samples {
+		if s.op == op {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ms formats d in milliseconds with a fixed precision, so reports line up
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 2, 64) + "ms"
+}
+
+// writeCSV writes one row per sample, ordered by worker then sequence
+func writeCSV(path string, samples []sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"worker", "seq", "op", "product_id", "start_ms", "latency_us", "outcome"})
+	for _, s := range samples {
+		w.Write([]string{
+			strconv.Itoa(s.worker),
+			strconv.Itoa(s.seq),
+			s.op,
+			strconv.Itoa(s.productID),
+			strconv.FormatInt(s.start.Milliseconds(), 10),
+			strconv.FormatInt(s.latency.Microseconds(), 10),
+			s.outcome,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"text/main/client"
+)
+
+// importBatchSize is how many products of a JSON file go in one
+// POST /products/batch, the most the server accepts
+const importBatchSize = 1000
+
+// jsonImportResult summarises a JSON import. Index in Errors counts
+// products from the start of the file.
+type jsonImportResult struct {
+	Imported int                      `json:"imported"`
+	Failed   int                      `json:"failed"`
+	Errors   []client.BatchItemResult `json:"errors"`
+}
+
+func runImport(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "csv or json (default: from the file extension)")
+	noUpsert := fs.Bool("no-upsert", false, "CSV only: report existing products as conflicts instead of replacing them")
+	if err := parseFlags(fs, g, args, 1, 1); err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("%w: can't tell the format of %s; pass --format csv or --format json", errUsage, name)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		// The file goes out as the request body without being read in first
+		res, err := c.ImportCSV(ctx, f, !*noUpsert)
+		if err != nil {
+			return err
+		}
+		if err := printJSON(res); err != nil {
+			return err
+		}
+		if res.Skipped > 0 {
+			return fmt.Errorf("%d rows were not imported", res.Skipped)
+		}
+		return nil
+	}
+
+	res, err := importJSON(ctx, c, f)
+	if perr := printJSON(res); err == nil {
+		err = perr
+	}
+	if err == nil && res.Failed > 0 {
+		err = fmt.Errorf("%d products were not imported", res.Failed)
+	}
+	return err
+}
+
+// importJSON stores the products in r, a JSON array or a stream of JSON
+// objects, importBatchSize at a time so only one batch is held in memory
+func importJSON(ctx context.Context, c *client.Client, r io.Reader) (jsonImportResult, error) {
+	res := jsonImportResult{Errors: []client.BatchItemResult{}}
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	dec.DisallowUnknownFields()
+
+	array, err := startsArray(br)
+	if err != nil {
+		return res, err
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return res, err
+		}
+	}
+
+	batch := make([]client.Product, 0, importBatchSize)
+	if !array {
+		// A server export starts with its snapshot line rather than a product
+		var first json.RawMessage
+		if err := dec.Decode(&first); err != nil {
+			return res, fmt.Errorf("product 0: %w", err)
+		}
+		if !isExportHeader(first) {
+			var p client.Product
+			strict := json.NewDecoder(bytes.NewReader(first))
+			strict.DisallowUnknownFields()
+			if err := strict.Decode(&p); err != nil {
+				return res, fmt.Errorf("product 0: %w", err)
+			}
+			batch = append(batch, p)
+		}
+	}
+	offset := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		out, err := c.PutBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		res.Imported += out.Applied
+		res.Failed += out.Failed
+		for _, item := range out.Results {
+			if item.Status != "ok" {
+				item.Index += offset
+				res.Errors = append(res.Errors, item)
+			}
+		}
+		offset += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if array && !dec.More() {
+			break
+		}
+		var p client.Product
+		if err := dec.Decode(&p); err != nil {
+			if !array && errors.Is(err, io.EOF) {
+				break
+			}
+			return res, fmt.Errorf("product %d: %w", offset+len(batch), err)
+		}
+		batch = append(batch, p)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, flush()
+}
+
+// isExportHeader reports whether raw is the {snapshot_at, product_count}
+// line GET /products/export?format=json starts with
+func isExportHeader(raw json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil || len(fields) != 2 {
+		return false
+	}
+	_, at := fields["snapshot_at"]
+	_, count := fields["product_count"]
+	return at && count
+}
+
+// startsArray reports whether the first non-space byte of br is '['
+func startsArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return false, errors.New("file is empty")
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b == '[', br.UnreadByte()
+	}
+}
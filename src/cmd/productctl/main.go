@@ -0,0 +1,343 @@
+// Command productctl calls the Product API from the shell. It prints
+// responses as indented JSON and exits non-zero when the server answers
+// with an error.
+//
+//	productctl [--endpoint URL] [--api-key KEY] <command> [flags] [args]
+//
+// The endpoint and key default to $PRODUCTCTL_ENDPOINT and
+// $PRODUCTCTL_API_KEY.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"text/main/client"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: productctl [--endpoint URL] [--api-key KEY] <command> [flags] [args]
+
+Commands:
+  get <product_id>        Print one product
+  put [file]              Create or replace the product in a JSON file (stdin if none or -)
+  delete <product_id>     Delete one product
+  list                    Print a page of products (--table for a table)
+  import <file>           Store every product in a CSV or JSON file
+  seed --count N          Have the server generate N products
+
+Run "productctl <command> -h" for a command's flags.
+`
+
+// errUsage marks an error caused by how productctl was called
+var errUsage = errors.New("usage")
+
+// globals are the flags every command accepts
+type globals struct {
+	endpoint string
+	apiKey   string
+	timeout  time.Duration
+}
+
+// register adds the global flags to fs, defaulting to the values already
+// parsed so they can be given before or after the command
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.endpoint, "endpoint", g.endpoint, "base URL of the API ($PRODUCTCTL_ENDPOINT)")
+	fs.StringVar(&g.apiKey, "api-key", g.apiKey, "API key sent as X-API-Key ($PRODUCTCTL_API_KEY)")
+	fs.DurationVar(&g.timeout, "timeout", g.timeout, "timeout of each request")
+}
+
+// command runs one subcommand with the arguments after its name
+type command func(ctx context.Context, g *globals, args []string) error
+
+var commands = map[string]command{
+	"get":    runGet,
+	"put":    runPut,
+	"delete": runDelete,
+	"list":   runList,
+	"import": runImport,
+	"seed":   runSeed,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	g := &globals{
+		endpoint: envOr("PRODUCTCTL_ENDPOINT", "http://localhost:8080"),
+		apiKey:   os.Getenv("PRODUCTCTL_API_KEY"),
+		timeout:  client.DefaultTimeout,
+	}
+	fs := flag.NewFlagSet("productctl", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	g.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "productctl: unknown command %q\n\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := cmd(ctx, g, fs.Args()[1:])
+
+	var apiErr *client.APIError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		if msg := err.Error(); msg != errUsage.Error() {
+			fmt.Fprintln(os.Stderr, "productctl:", msg)
+		}
+		return exitUsage
+	case errors.As(err, &apiErr):
+		printAPIError(apiErr)
+		return exitError
+	default:
+		fmt.Fprintln(os.Stderr, "productctl:", err)
+		return exitError
+	}
+}
+
+// newClient returns a client for the global flags
+func (g *globals) newClient() (*client.Client, error) {
+	return client.New(g.endpoint, client.WithAPIKey(g.apiKey), client.WithTimeout(g.timeout))
+}
+
+// parseFlags parses a command's flags, global ones included, and checks it
+// got between minArgs and maxArgs positional arguments
+func parseFlags(fs *flag.FlagSet, g *globals, args []string, minArgs, maxArgs int) error {
+	g.register(fs)
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// productID parses a product_id argument
+func productID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%w: product_id must be a positive integer, got %q", errUsage, arg)
+	}
+	return id, nil
+}
+
+func runGet(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	if err := parseFlags(fs, g, args, 1, 1); err != nil {
+		return err
+	}
+	id, err := productID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	p, err := c.GetProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(p)
+}
+
+func runPut(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	if err := parseFlags(fs, g, args, 0, 1); err != nil {
+		return err
+	}
+	in := io.Reader(os.Stdin)
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var p client.Product
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return fmt.Errorf("read product: %w", err)
+	}
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	created, err := c.PutProductDetails(ctx, p)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]any{"product_id": p.ProductID, "created": created})
+}
+
+func runDelete(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	if err := parseFlags(fs, g, args, 1, 1); err != nil {
+		return err
+	}
+	id, err := productID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	return c.DeleteProduct(ctx, id)
+}
+
+func runList(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	var opts client.ListOptions
+	var minWeight, maxWeight int
+	fs.IntVar(&opts.Limit, "limit", 0, "products per page (server default if 0)")
+	fs.IntVar(&opts.Offset, "offset", 0, "products to skip")
+	fs.StringVar(&opts.Sort, "sort", "", `sort field, "-" prefix for descending`)
+	fs.IntVar(&opts.CategoryID, "category", 0, "only this category_id")
+	fs.StringVar(&opts.Manufacturer, "manufacturer", "", "only this manufacturer")
+	fs.IntVar(&minWeight, "min-weight", 0, "only products at least this heavy")
+	fs.IntVar(&maxWeight, "max-weight", 0, "only products at most this heavy")
+	table := fs.Bool("table", false, "print a table instead of JSON")
+	if err := parseFlags(fs, g, args, 0, 0); err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-weight":
+			opts.MinWeight = &minWeight
+		case "max-weight":
+			opts.MaxWeight = &maxWeight
+		}
+	})
+
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	res, err := c.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if *table {
+		return printTable(res)
+	}
+	return printJSON(map[string]any{"total": res.Total, "products": res.Products})
+}
+
+func runSeed(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := fs.Int("count", 0, "products to generate (required)")
+	startID := fs.Int("start-id", 1, "product_id of the first one")
+	if err := parseFlags(fs, g, args, 0, 0); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return fmt.Errorf("%w: --count must be at least 1", errUsage)
+	}
+	c, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	res, err := c.Seed(ctx, *count, *startID)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(res); err != nil {
+		return err
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("%d of %d products were not seeded", res.Failed, *count)
+	}
+	return nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// printTable writes one page of products as aligned columns
+func printTable(res client.ListResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PRODUCT_ID\tSKU\tMANUFACTURER\tCATEGORY_ID\tWEIGHT\tNAME\tPRICE")
+	for _, p := range res.Products {
+		price := ""
+		if p.Price != nil {
+			price = fmt.Sprintf("%d.%02d %s", *p.Price/100, *p.Price%100, p.Currency)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%s\t%s\n", p.ProductID, p.SKU, p.Manufacturer, p.CategoryID, p.Weight, p.Name, price)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Printf("%d of %d products\n", len(res.Products), res.Total)
+	return err
+}
+
+// printAPIError reports an error response on stderr
+func printAPIError(e *client.APIError) {
+	code := e.Code
+	if code == "" {
+		code = "HTTP_" + strconv.Itoa(e.StatusCode)
+	}
+	msg := e.Message
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	fmt.Fprintf(os.Stderr, "productctl: %s (%d): %s\n", code, e.StatusCode, msg)
+	for _, f := range e.Fields {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", f.Field, f.Constraint)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintln(os.Stderr, "  request_id:", e.RequestID)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriters recycles compressors; each one holds a few hundred KB of state
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// compress gzips responses of at least COMPRESS_MIN_BYTES for clients that
+// accept it. The body is buffered up to the threshold so small responses
+// (a single product, errors) go out as-is; 204, 304 and responses that
+// already carry a Content-Encoding are never touched.
+func (a *API) compress(c *gin.Context) {
+	if a.cfg.CompressMinBytes == 0 {
+		c.Next()
+		return
+	}
+	// Whether the body is compressed depends on Accept-Encoding
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Next()
+		return
+	}
+
+	w := &gzipWriter{ResponseWriter: c.Writer, minBytes: a.cfg.CompressMinBytes}
+	c.Writer = w
+	defer func() {
+		w.close()
+		c.Writer = w.ResponseWriter
+	}()
+	c.Next()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, i.e.
+// lists gzip or * without q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipWriter holds back the status line and body until either minBytes have
+// been written, the handler flushes, or the request finishes, and only then
+// decides whether to compress
+type gzipWriter struct {
+	gin.ResponseWriter
+	minBytes  int
+	buf       []byte
+	headerNow bool
+	started   bool
+	gz        *gzip.Writer
+}
+
+// WriteHeaderNow is deferred like the body so Content-Encoding can still be set
+func (w *gzipWriter) WriteHeaderNow() {
+	w.headerNow = true
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend
+// the write deadline of a long export
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipWriter) Written() bool {
+	return w.headerNow || len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minBytes {
+			return len(b), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush commits to compression: a handler that flushes is streaming, and a
+// stream is expected to grow past the threshold
+func (w *gzipWriter) Flush() {
+	if !w.started {
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start sends the headers, compressed if want is set and the response allows
+// it, followed by whatever was buffered
+func (w *gzipWriter) start(want bool) error {
+	w.started = true
+	h := w.Header()
+	status := w.Status()
+	if want && h.Get("Content-Encoding") == "" &&
+		status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close writes out a response that stayed under the threshold, or finishes
+// the gzip stream
+func (w *gzipWriter) close() {
+	if !w.started {
+		// Nothing written at all: leave it to gin so the status-only path is unchanged
+		if !w.headerNow && len(w.buf) == 0 {
+			return
+		}
+		w.start(false)
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// decompressRequest accepts Content-Encoding: gzip on batch ingest. The
+// decompressed stream is held to MAX_BATCH_BODY_BYTES as well, so a small
+// compressed body can't expand without bound; other encodings, and gzip on
+// other routes, get 415.
+func (a *API) decompressRequest(c *gin.Context) {
+	encoding := strings.TrimSpace(c.GetHeader("Content-Encoding"))
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		c.Next()
+		return
+	}
+	if !strings.EqualFold(encoding, "gzip") || routeTemplate(c.FullPath()) != batchRoute {
+		abortWithError(c, http.StatusUnsupportedMediaType, ErrorResponse{
+			Error:     "UNSUPPORTED_MEDIA_TYPE",
+			Message:   "Unsupported Content-Encoding",
+			Details:   "Only " + batchRoute + " accepts a compressed body, and only gzip; got " + encoding,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, &gzipBody{src: c.Request.Body}, a.live().MaxBatchBodyBytes)
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.ContentLength = -1
+	c.Next()
+}
+
+// gzipBody decompresses src. The gzip header is read on first use so a bad
+// header surfaces as a read error the handler reports as 400.
+type gzipBody struct {
+	src io.ReadCloser
+	zr  *gzip.Reader
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil {
+		zr, err := gzip.NewReader(b.src)
+		if err != nil {
+			return 0, err
+		}
+		b.zr = zr
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.src.Close()
+}
@@ -0,0 +1,727 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds every setting read from the environment at startup
+type Config struct {
+	// HTTP server
+	Port         int
+	GinMode      string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Time a client has to send its request headers
+	ReadHeaderTimeout time.Duration
+	// How long a keep-alive connection may sit idle between requests
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// gRPC server next to the HTTP one; 0 leaves it off
+	GRPCPort int
+	// Serve HTTPS on Port with this key pair; both empty serves plain HTTP
+	TLSCertFile string
+	TLSKeyFile  string
+	// Plain HTTP listener redirecting to HTTPS; 0 leaves it off
+	HTTPRedirectPort int
+	// Date sent in Sunset on the deprecated unversioned routes
+	LegacyRoutesSunset time.Time
+	// Time a request has to respond before it gets 504; 0 disables it
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+	// Body limit for POST /products/batch, which carries many products
+	MaxBatchBodyBytes int64
+	StrictJSON        bool
+	// Answer a first-time create with 201 and Location instead of 204
+	CreateReturns201 bool
+	// Answer GET on a soft-deleted product with 410 instead of 404
+	DeletedReturns410 bool
+	// Error body format: "native" ErrorResponse or RFC 7807 "problem"
+	ErrorFormat string
+	// Require category_id to name a category from /categories
+	ValidateCategory bool
+	// Check requests against api.yaml: "off", "log" or "enforce"
+	OpenAPIValidation string
+	ShutdownTimeout   time.Duration
+	ShutdownDelay     time.Duration
+	// Concurrent requests served before shedding with 503; 0 means no cap
+	MaxInFlight int
+	// Responses at least this large are gzipped; 0 disables compression
+	CompressMinBytes int
+
+	// Browser cross-origin access; no origins disables CORS, "*" allows any
+	CORSOrigins          []string
+	CORSMethods          []string
+	CORSHeaders          []string
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+
+	// How long POST responses are kept for Idempotency-Key replays; 0
+	// disables the cache
+	IdempotencyTTL     time.Duration
+	IdempotencyMaxKeys int
+
+	// Proxies whose X-Forwarded-For names the client; from anywhere else
+	// the connection's address is the client IP
+	TrustedProxies []netip.Prefix
+	// Client IPs accepted by the operator endpoints (/admin, /stats,
+	// /debug) and by every other route
+	AdminIPFilter IPFilter
+	APIIPFilter   IPFilter
+
+	// Per-client-IP token bucket; RateLimitRPS 0 disables it
+	RateLimitRPS   float64
+	RateLimitBurst int
+
+	// Credentials writes need: "api-key", "jwt", "both" or "none"
+	AuthMode string
+	// Keys accepted on write requests; empty leaves writes open
+	APIKeys []APIKey
+	// Bearer tokens: RS256 keys from JWTJWKSURL, fetched again every
+	// JWTJWKSRefresh; tokens must be issued by JWTIssuer, for JWTAudience
+	// when set, and writes need JWTWriterRole in the JWTRolesClaim claim
+	JWTJWKSURL     string
+	JWTJWKSRefresh time.Duration
+	JWTIssuer      string
+	JWTAudience    string
+	JWTRolesClaim  string
+	JWTWriterRole  string
+	// Keys accepted on operator endpoints such as /stats
+	AdminAPIKeys []APIKey
+	// Shared secrets writes must carry an X-Signature from; empty turns
+	// verification off. Signatures older or newer than SigningWindow are
+	// refused.
+	SigningSecrets []SigningSecret
+	SigningWindow  time.Duration
+	// Request budgets per API key ID, anonymousKeyID for requests without
+	// one; keys missing from Quotas get QuotaDefault
+	QuotaDefault Quota
+	Quotas       map[string]Quota
+	// Serve /admin without ADMIN_API_KEYS
+	AdminEnabled bool
+	// Mount net/http/pprof and /debug/vars
+	DebugPprof bool
+
+	// Span exporter: "none", "stdout" or "otlp"
+	TraceExporter string
+	ServiceName   string
+	// Send segments to the X-Ray daemon instead; exclusive with TraceExporter
+	XRayEnabled    bool
+	XRayDaemonAddr string
+
+	// Requests below LogLevel are not logged
+	LogLevel slog.Level
+
+	// Route templates left out of the /metrics request histograms
+	MetricsExcludeRoutes []string
+
+	// Store backend: "memory", "dynamodb", "redis", "postgres" or "sqlite"
+	StoreBackend     string
+	DynamoDBTable    string
+	DynamoDBEndpoint string
+	AWSRegion        string
+	RedisAddr        string
+	RedisPassword    string
+	RedisTTL         time.Duration
+	// PostgreSQL connection URL, pool bounds, and whether startup applies
+	// the embedded migrations
+	PostgresURL             string
+	PostgresMaxConns        int
+	PostgresMinConns        int
+	PostgresMaxConnLifetime time.Duration
+	PostgresMaxConnIdleTime time.Duration
+	PostgresMigrate         bool
+	// SQLite database file, created if missing
+	SQLitePath string
+
+	// Read-through cache of products by ID in front of a store other than
+	// memory: entries kept, 0 for no cache, and how long each is served
+	CacheMaxEntries int
+	CacheTTL        time.Duration
+
+	// Encoded GET /products/{productId} bodies kept, 0 for none, and how
+	// long each is served; writes on this instance drop them at once
+	ResponseCacheMaxEntries int
+	ResponseCacheTTL        time.Duration
+
+	// Circuit breaker around a store other than memory: the share of
+	// failed calls, 0 for no breaker, among at least BreakerMinRequests in
+	// one BreakerWindow that opens it, and how long it stays open
+	BreakerErrorRate    float64
+	BreakerMinRequests  int
+	BreakerWindow       time.Duration
+	BreakerOpenDuration time.Duration
+
+	// Retries of transiently failed calls to a store other than memory:
+	// attempts in all, 1 for none, and the backoff's base and cap
+	StoreRetryMaxAttempts int
+	StoreRetryBaseDelay   time.Duration
+	StoreRetryMaxDelay    time.Duration
+
+	// Serve a store other than memory from memory and write changes back
+	// in the background: pending product IDs kept, flushing goroutines,
+	// products per flush, how long a flush waits to fill up, and tries per
+	// flush
+	WriteBehind            bool
+	WriteBehindQueueSize   int
+	WriteBehindWorkers     int
+	WriteBehindBatchSize   int
+	WriteBehindLinger      time.Duration
+	WriteBehindMaxAttempts int
+
+	// Treat SKUs differing only in letter case as duplicates (memory only)
+	SKUCaseInsensitive bool
+	// Keep every X-Tenant-ID's products and categories apart (memory only)
+	MultiTenant bool
+	// Format every newly written SKU must match in full; nil accepts any
+	SKUPattern *regexp.Regexp
+
+	// Webhook subscriptions present at startup, all signed with
+	// WebhookSecret; more can be added through /admin/webhooks
+	WebhookURLs        []string
+	WebhookSecret      string
+	WebhookQueueSize   int
+	WebhookWorkers     int
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+
+	// Product change events: at most one of the SNS topic and SQS queue;
+	// EventsDryRun logs the messages instead
+	EventsSNSTopicARN string
+	EventsSQSQueueURL string
+	EventsDryRun      bool
+	EventsBufferSize  int
+
+	// In-memory persistence
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+	WALPath          string
+	WALFsync         bool
+
+	// Versions kept per product for /products/{id}/history (memory only;
+	// 0, the default, keeps none), and whether they outlive a delete
+	HistorySize         int
+	HistoryKeepOnDelete bool
+
+	// How often products past their TTL are removed (memory only)
+	TTLSweepInterval time.Duration
+
+	// Cap on stored products, 0 for none, and what reaching it does:
+	// "reject" new products or evict the least recently read ("lru")
+	MaxProducts int
+	Eviction    string
+
+	// Write requests kept for /admin/audit, and the JSON-lines file they
+	// are also appended to
+	AuditLogSize int
+	AuditLogPath string
+
+	// Products loaded before the listener starts; a missing or unreadable
+	// file is fatal only with SeedRequired
+	SeedFile     string
+	SeedRequired bool
+
+	// Latency and failure injection at startup; POST /admin/chaos replaces it
+	Chaos Chaos
+
+	// The raw value of every key read, for a reload to tell what changed
+	env map[string]string
+}
+
+// LoadConfig reads Config from the process environment. With CONFIG_FILE
+// set, the KEY=value lines of that file take precedence, so settings can be
+// changed for a reload without restarting the process.
+func LoadConfig() (Config, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return loadConfig(os.Getenv)
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return loadConfig(func(key string) string {
+		if v, ok := file[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	})
+}
+
+// readConfigFile parses KEY=value lines, skipping blank lines and # comments
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("CONFIG_FILE %s line %d: want KEY=value", path, i+1)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// loadConfig reads Config through getenv, applying defaults for unset keys.
+// Every invalid value is reported, not just the first.
+func loadConfig(getenv func(string) string) (Config, error) {
+	env := make(map[string]string)
+	e := envReader{getenv: func(key string) string {
+		v := getenv(key)
+		env[key] = v
+		return v
+	}}
+	cfg := Config{
+		Port:               e.intRange("PORT", 8080, 1, 65535),
+		GRPCPort:           e.intRange("GRPC_PORT", 0, 0, 65535),
+		TLSCertFile:        e.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:         e.str("TLS_KEY_FILE", ""),
+		HTTPRedirectPort:   e.intRange("HTTP_REDIRECT_PORT", 0, 0, 65535),
+		LegacyRoutesSunset: e.date("LEGACY_ROUTES_SUNSET", time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)),
+		GinMode:            e.oneOf("GIN_MODE", gin.DebugMode, gin.DebugMode, gin.ReleaseMode, gin.TestMode),
+		ReadTimeout:        e.duration("READ_TIMEOUT", 15*time.Second, true),
+		WriteTimeout:       e.duration("WRITE_TIMEOUT", 15*time.Second, true),
+		ReadHeaderTimeout:  e.duration("READ_HEADER_TIMEOUT", 5*time.Second, true),
+		IdleTimeout:        e.duration("IDLE_TIMEOUT", 60*time.Second, true),
+		MaxHeaderBytes:     e.intRange("MAX_HEADER_BYTES", 64<<10, 4<<10, 16<<20),
+		RequestTimeout:     e.duration("REQUEST_TIMEOUT", 5*time.Second, false),
+		MaxBodyBytes:       int64(e.intRange("MAX_BODY_BYTES", 1<<20, 1, 1<<30)),
+		StrictJSON:         e.boolean("STRICT_JSON", true),
+		CreateReturns201:   e.boolean("CREATE_RETURNS_201", true),
+		DeletedReturns410:  e.boolean("DELETED_RETURNS_410", true),
+		ErrorFormat:        e.oneOf("ERROR_FORMAT", errorFormatNative, errorFormatNative, errorFormatProblem),
+		ValidateCategory:   e.boolean("VALIDATE_CATEGORY", false),
+		OpenAPIValidation:  e.oneOf("OPENAPI_VALIDATION", openAPIValidationOff, openAPIValidationOff, openAPIValidationLog, openAPIValidationEnforce),
+
+		MaxBatchBodyBytes: int64(e.intRange("MAX_BATCH_BODY_BYTES", 8<<20, 1, 1<<30)),
+		ShutdownTimeout:   e.duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout, true),
+		ShutdownDelay:     e.duration("SHUTDOWN_DELAY", 0, false),
+		MaxInFlight:       e.intRange("MAX_INFLIGHT", 0, 0, 1<<20),
+		CompressMinBytes:  e.intRange("COMPRESS_MIN_BYTES", 1024, 0, 1<<30),
+
+		LogLevel: parseLogLevel(e.oneOf("LOG_LEVEL", "info", logLevelNames...)),
+
+		MetricsExcludeRoutes: e.list("METRICS_EXCLUDE_ROUTES", []string{"/metrics", "/health", "/healthz", "/readyz"}),
+
+		StoreBackend:     e.oneOf("STORE_BACKEND", "memory", "memory", "dynamodb", "redis", "postgres", "sqlite"),
+		DynamoDBTable:    e.str("DYNAMODB_TABLE", "products"),
+		DynamoDBEndpoint: e.str("DYNAMODB_ENDPOINT", ""),
+		AWSRegion:        e.str("AWS_REGION", ""),
+		RedisAddr:        e.str("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:    e.str("REDIS_PASSWORD", ""),
+		RedisTTL:         e.duration("REDIS_TTL", 0, false),
+
+		PostgresURL:             e.str("POSTGRES_URL", "postgres://localhost:5432/products"),
+		PostgresMaxConns:        e.intRange("POSTGRES_MAX_CONNS", 20, 1, 10000),
+		PostgresMinConns:        e.intRange("POSTGRES_MIN_CONNS", 0, 0, 10000),
+		PostgresMaxConnLifetime: e.duration("POSTGRES_MAX_CONN_LIFETIME", time.Hour, true),
+		PostgresMaxConnIdleTime: e.duration("POSTGRES_MAX_CONN_IDLE_TIME", 30*time.Minute, true),
+		PostgresMigrate:         e.boolean("POSTGRES_MIGRATE", true),
+
+		SQLitePath: e.str("SQLITE_PATH", "products.db"),
+
+		CacheMaxEntries: e.intRange("CACHE_MAX_ENTRIES", 0, 0, 1<<24),
+		CacheTTL:        e.duration("CACHE_TTL", 30*time.Second, true),
+
+		ResponseCacheMaxEntries: e.intRange("RESPONSE_CACHE_MAX_ENTRIES", 0, 0, 1<<24),
+		ResponseCacheTTL:        e.duration("RESPONSE_CACHE_TTL", 30*time.Second, true),
+
+		StoreRetryMaxAttempts: e.intRange("STORE_RETRY_MAX_ATTEMPTS", 3, 1, 10),
+		StoreRetryBaseDelay:   e.duration("STORE_RETRY_BASE_DELAY", 50*time.Millisecond, true),
+		StoreRetryMaxDelay:    e.duration("STORE_RETRY_MAX_DELAY", time.Second, true),
+
+		BreakerErrorRate:    e.floatRange("BREAKER_ERROR_RATE", 0.5, 0, 1),
+		BreakerMinRequests:  e.intRange("BREAKER_MIN_REQUESTS", 20, 1, 1<<20),
+		BreakerWindow:       e.duration("BREAKER_WINDOW", 10*time.Second, true),
+		BreakerOpenDuration: e.duration("BREAKER_OPEN_DURATION", 5*time.Second, true),
+
+		WriteBehind:            e.boolean("WRITE_BEHIND", false),
+		WriteBehindQueueSize:   e.intRange("WRITE_BEHIND_QUEUE_SIZE", 10000, 1, 1<<22),
+		WriteBehindWorkers:     e.intRange("WRITE_BEHIND_WORKERS", 4, 1, 256),
+		WriteBehindBatchSize:   e.intRange("WRITE_BEHIND_BATCH_SIZE", maxBatchWriteItems, 1, maxBatchWriteItems),
+		WriteBehindLinger:      e.duration("WRITE_BEHIND_LINGER", 50*time.Millisecond, false),
+		WriteBehindMaxAttempts: e.intRange("WRITE_BEHIND_MAX_ATTEMPTS", 5, 1, 20),
+
+		SKUCaseInsensitive: e.boolean("SKU_CASE_INSENSITIVE", false),
+		MultiTenant:        e.boolean("MULTI_TENANT", false),
+
+		SnapshotPath:     e.str("SNAPSHOT_PATH", ""),
+		SnapshotInterval: time.Duration(e.intRange("SNAPSHOT_INTERVAL", int(defaultSnapshotInterval/time.Second), 1, 1<<20)) * time.Second,
+		WALPath:          e.str("WAL_PATH", ""),
+		WALFsync:         e.boolean("WAL_FSYNC", false),
+
+		HistorySize:         e.intRange("HISTORY_SIZE", 0, 0, 1000),
+		HistoryKeepOnDelete: e.boolean("HISTORY_KEEP_ON_DELETE", false),
+
+		TTLSweepInterval: e.duration("TTL_SWEEP_INTERVAL", time.Second, true),
+
+		MaxProducts: e.intRange("MAX_PRODUCTS", 0, 0, 1<<30),
+		Eviction:    e.oneOf("EVICTION", evictionReject, evictionReject, evictionLRU),
+
+		AuditLogSize: e.intRange("AUDIT_LOG_SIZE", 10000, 1, 1<<22),
+		AuditLogPath: e.str("AUDIT_LOG_PATH", ""),
+
+		SeedFile:     e.str("SEED_FILE", ""),
+		SeedRequired: e.boolean("SEED_REQUIRED", false),
+	}
+
+	if cfg.GRPCPort == cfg.Port {
+		e.errs = append(e.errs, errors.New("GRPC_PORT must differ from PORT"))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		e.errs = append(e.errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if cfg.HTTPRedirectPort != 0 {
+		switch {
+		case cfg.TLSCertFile == "":
+			e.errs = append(e.errs, errors.New("HTTP_REDIRECT_PORT needs TLS_CERT_FILE and TLS_KEY_FILE"))
+		case cfg.HTTPRedirectPort == cfg.Port || cfg.HTTPRedirectPort == cfg.GRPCPort:
+			e.errs = append(e.errs, errors.New("HTTP_REDIRECT_PORT must differ from PORT and GRPC_PORT"))
+		}
+	}
+
+	latencyMin := e.duration("CHAOS_LATENCY_MIN", 0, false)
+	latencyMax := e.duration("CHAOS_LATENCY_MAX", latencyMin, false)
+	errorStatus, _ := strconv.Atoi(e.oneOf("CHAOS_ERROR_STATUS", "503", "500", "503"))
+	cfg.Chaos = Chaos{
+		LatencyRate:  e.floatRange("CHAOS_LATENCY_RATE", 0, 0, 1),
+		LatencyMinMS: int(latencyMin.Milliseconds()),
+		LatencyMaxMS: int(latencyMax.Milliseconds()),
+		ErrorRate:    e.floatRange("CHAOS_ERROR_RATE", 0, 0, 1),
+		ErrorStatus:  errorStatus,
+		Routes:       e.list("CHAOS_ROUTES", nil),
+		Methods:      e.list("CHAOS_METHODS", nil),
+	}
+	if latencyMax < latencyMin || latencyMax > maxChaosLatency {
+		e.errs = append(e.errs, errors.New("CHAOS_LATENCY_MAX must be between CHAOS_LATENCY_MIN and "+maxChaosLatency.String()))
+	}
+
+	cfg.WebhookURLs = e.list("WEBHOOK_URLS", nil)
+	cfg.WebhookSecret = e.str("WEBHOOK_SECRET", "")
+	cfg.WebhookQueueSize = e.intRange("WEBHOOK_QUEUE_SIZE", 1000, 1, 1<<20)
+	cfg.WebhookWorkers = e.intRange("WEBHOOK_WORKERS", 4, 1, 256)
+	cfg.WebhookMaxAttempts = e.intRange("WEBHOOK_MAX_ATTEMPTS", 5, 1, 20)
+	cfg.WebhookTimeout = e.duration("WEBHOOK_TIMEOUT", 5*time.Second, true)
+	for _, u := range cfg.WebhookURLs {
+		if errs := validateWebhook(Webhook{URL: u}); errs != nil {
+			e.fail("WEBHOOK_URLS", u, "absolute http or https URLs")
+		}
+	}
+
+	cfg.EventsSNSTopicARN = e.str("EVENTS_SNS_TOPIC_ARN", "")
+	cfg.EventsSQSQueueURL = e.str("EVENTS_SQS_QUEUE_URL", "")
+	cfg.EventsDryRun = e.boolean("EVENTS_DRY_RUN", false)
+	cfg.EventsBufferSize = e.intRange("EVENTS_BUFFER_SIZE", 10000, 1, 1<<22)
+	if cfg.EventsSNSTopicARN != "" && cfg.EventsSQSQueueURL != "" {
+		e.errs = append(e.errs, errors.New("EVENTS_SNS_TOPIC_ARN and EVENTS_SQS_QUEUE_URL cannot both be set; subscribe the queue to the topic instead"))
+	}
+
+	if (cfg.SnapshotPath != "" || cfg.WALPath != "") && cfg.StoreBackend != "memory" {
+		e.errs = append(e.errs, errors.New("SNAPSHOT_PATH and WAL_PATH are only supported with STORE_BACKEND=memory"))
+	}
+
+	if cfg.PostgresMinConns > cfg.PostgresMaxConns {
+		e.errs = append(e.errs, errors.New("POSTGRES_MIN_CONNS cannot be above POSTGRES_MAX_CONNS"))
+	}
+
+	if cfg.MaxProducts > 0 && cfg.StoreBackend != "memory" {
+		e.errs = append(e.errs, errors.New("MAX_PRODUCTS is only supported with STORE_BACKEND=memory"))
+	}
+
+	if cfg.CacheMaxEntries > 0 && cfg.StoreBackend == "memory" {
+		e.errs = append(e.errs, errors.New("CACHE_MAX_ENTRIES is only supported with STORE_BACKEND=dynamodb, redis, postgres or sqlite"))
+	}
+	if cfg.WriteBehind && cfg.StoreBackend == "memory" {
+		e.errs = append(e.errs, errors.New("WRITE_BEHIND is only supported with STORE_BACKEND=dynamodb, redis, postgres or sqlite"))
+	}
+	if cfg.ResponseCacheMaxEntries > 0 && cfg.MaxProducts > 0 && cfg.Eviction == evictionLRU {
+		e.errs = append(e.errs, errors.New("RESPONSE_CACHE_MAX_ENTRIES cannot be combined with EVICTION=lru; cached reads would not count as uses"))
+	}
+	if cfg.WriteBehind && cfg.CacheMaxEntries > 0 {
+		e.errs = append(e.errs, errors.New("CACHE_MAX_ENTRIES and WRITE_BEHIND cannot both be set; write-behind already serves reads from memory"))
+	}
+
+	if cfg.SeedFile != "" && seedFileFormat(cfg.SeedFile) == "" {
+		e.fail("SEED_FILE", cfg.SeedFile, "a path ending in .csv or .json")
+	}
+
+	cfg.IdempotencyTTL = e.duration("IDEMPOTENCY_TTL", 24*time.Hour, false)
+	cfg.IdempotencyMaxKeys = e.intRange("IDEMPOTENCY_MAX_KEYS", 10000, 1, 1<<24)
+
+	cfg.TrustedProxies = e.cidrs("TRUSTED_PROXIES")
+	cfg.AdminIPFilter = IPFilter{Allow: e.cidrs("ADMIN_ALLOW_CIDRS"), Deny: e.cidrs("ADMIN_DENY_CIDRS")}
+	cfg.APIIPFilter = IPFilter{Allow: e.cidrs("API_ALLOW_CIDRS"), Deny: e.cidrs("API_DENY_CIDRS")}
+
+	cfg.RateLimitRPS = e.floatRange("RATE_LIMIT_RPS", 0, 0, 1e6)
+	cfg.RateLimitBurst = e.intRange("RATE_LIMIT_BURST", max(1, int(math.Ceil(cfg.RateLimitRPS))), 1, 1<<20)
+
+	cfg.CORSOrigins = e.list("CORS_ALLOWED_ORIGINS", nil)
+	cfg.CORSMethods = e.list("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE"})
+	cfg.CORSHeaders = e.list("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization", "X-API-Key", "X-Tenant-ID", "X-Request-ID",
+		"X-Signature", "X-Signature-Timestamp", "If-Match", "If-None-Match", "Idempotency-Key", "X-TTL"})
+	cfg.CORSMaxAge = e.duration("CORS_MAX_AGE", 10*time.Minute, false)
+	cfg.CORSAllowCredentials = e.boolean("CORS_ALLOW_CREDENTIALS", false)
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSOrigins, "*") {
+		e.errs = append(e.errs, errors.New(`CORS_ALLOW_CREDENTIALS cannot be combined with CORS_ALLOWED_ORIGINS="*"`))
+	}
+
+	keys, err := parseAPIKeys("API_KEYS", e.list("API_KEYS", nil))
+	if err != nil {
+		e.errs = append(e.errs, err)
+	}
+	cfg.APIKeys = keys
+	adminKeys, err := parseAPIKeys("ADMIN_API_KEYS", e.list("ADMIN_API_KEYS", nil))
+	if err != nil {
+		e.errs = append(e.errs, err)
+	}
+	cfg.AdminAPIKeys = adminKeys
+	cfg.AuthMode = e.oneOf("AUTH_MODE", authModeAPIKey, authModeAPIKey, authModeJWT, authModeBoth, authModeNone)
+	cfg.JWTJWKSURL = e.str("JWT_JWKS_URL", "")
+	cfg.JWTJWKSRefresh = e.duration("JWT_JWKS_REFRESH", time.Hour, true)
+	cfg.JWTIssuer = e.str("JWT_ISSUER", "")
+	cfg.JWTAudience = e.str("JWT_AUDIENCE", "")
+	cfg.JWTRolesClaim = e.str("JWT_ROLES_CLAIM", "cognito:groups")
+	cfg.JWTWriterRole = e.str("JWT_WRITER_ROLE", "writer")
+	if (cfg.AuthMode == authModeJWT || cfg.AuthMode == authModeBoth) && (cfg.JWTJWKSURL == "" || cfg.JWTIssuer == "") {
+		e.errs = append(e.errs, errors.New("AUTH_MODE="+cfg.AuthMode+" needs JWT_JWKS_URL and JWT_ISSUER"))
+	}
+	if cfg.AuthMode == authModeBoth && len(cfg.APIKeys) == 0 {
+		e.errs = append(e.errs, errors.New("AUTH_MODE=both needs API_KEYS"))
+	}
+	signingSecrets, err := parseSigningSecrets(e.list("REQUEST_SIGNING_SECRETS", nil))
+	if err != nil {
+		e.errs = append(e.errs, err)
+	}
+	cfg.SigningSecrets = signingSecrets
+	cfg.SigningWindow = e.duration("REQUEST_SIGNING_WINDOW", 5*time.Minute, true)
+	cfg.QuotaDefault = Quota{
+		DailyRequests: e.intRange("QUOTA_DAILY_REQUESTS", 0, 0, 1<<40),
+		RPS:           e.floatRange("QUOTA_RPS", 0, 0, 1e6),
+	}
+	quotas, err := parseQuotas(e.list("API_KEY_QUOTAS", nil), cfg.APIKeys, cfg.QuotaDefault)
+	if err != nil {
+		e.errs = append(e.errs, err)
+	}
+	cfg.Quotas = quotas
+	cfg.AdminEnabled = e.boolean("ADMIN_ENABLED", false)
+	cfg.DebugPprof = e.boolean("DEBUG_PPROF", false)
+
+	cfg.TraceExporter = e.oneOf("OTEL_TRACES_EXPORTER", traceExporterNone, traceExporterNone, traceExporterStdout, traceExporterOTLP)
+	cfg.ServiceName = e.str("OTEL_SERVICE_NAME", "product-api")
+	cfg.XRayEnabled = e.boolean("XRAY_ENABLED", false)
+	cfg.XRayDaemonAddr = e.str("AWS_XRAY_DAEMON_ADDRESS", "127.0.0.1:2000")
+	if cfg.XRayEnabled && cfg.TraceExporter != traceExporterNone {
+		e.errs = append(e.errs, errors.New("XRAY_ENABLED cannot be combined with OTEL_TRACES_EXPORTER; pick one tracing backend"))
+	}
+
+	cfg.SKUPattern = e.pattern("SKU_PATTERN", defaultSKUPattern)
+
+	if cfg.SKUCaseInsensitive && cfg.StoreBackend != "memory" {
+		e.errs = append(e.errs, errors.New("SKU_CASE_INSENSITIVE is only supported with STORE_BACKEND=memory"))
+	}
+
+	if cfg.MultiTenant {
+		switch {
+		case cfg.StoreBackend != "memory":
+			e.errs = append(e.errs, errors.New("MULTI_TENANT is only supported with STORE_BACKEND=memory"))
+		case cfg.SnapshotPath != "" || cfg.WALPath != "":
+			e.errs = append(e.errs, errors.New("MULTI_TENANT cannot be combined with SNAPSHOT_PATH or WAL_PATH"))
+		}
+		if cfg.SeedFile != "" {
+			e.errs = append(e.errs, errors.New("MULTI_TENANT cannot be combined with SEED_FILE, which names no tenant"))
+		}
+		if cfg.GRPCPort != 0 {
+			e.errs = append(e.errs, errors.New("MULTI_TENANT cannot be combined with GRPC_PORT; the gRPC API has no tenant"))
+		}
+	}
+
+	if err := errors.Join(e.errs...); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	cfg.env = env
+	return cfg, nil
+}
+
+// envReader parses typed values from the environment, collecting one error
+// per bad key so they can all be reported together
+type envReader struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (e *envReader) fail(key, raw, want string) {
+	e.errs = append(e.errs, fmt.Errorf("%s=%q: must be %s", key, raw, want))
+}
+
+// pattern compiles a regular expression that must match a whole value.
+// "none" yields nil, meaning no pattern.
+func (e *envReader) pattern(key, def string) *regexp.Regexp {
+	raw := e.getenv(key)
+	if raw == "" {
+		raw = def
+	}
+	if raw == "none" {
+		return nil
+	}
+	if _, err := regexp.Compile(raw); err != nil {
+		e.fail(key, raw, "a valid regular expression ("+err.Error()+")")
+		return nil
+	}
+	return regexp.MustCompile(`^(?:` + raw + `)$`)
+}
+
+func (e *envReader) str(key, def string) string {
+	if raw := e.getenv(key); raw != "" {
+		return raw
+	}
+	return def
+}
+
+// cidrs parses a comma-separated list of CIDR prefixes such as 10.0.0.0/8
+// or 2001:db8::/32; a bare address stands for itself alone
+func (e *envReader) cidrs(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range e.list(key, nil) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				e.fail(key, item, "a CIDR prefix or IP address")
+				continue
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			e.fail(key, item, "a CIDR prefix or IP address")
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// list splits a comma-separated value, dropping empty entries. Setting the
+// key to "none" yields an empty list rather than the default.
+func (e *envReader) list(key string, def []string) []string {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	if raw == "none" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (e *envReader) oneOf(key, def string, allowed ...string) string {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if raw == a {
+			return raw
+		}
+	}
+	e.fail(key, raw, fmt.Sprintf("one of %q", allowed))
+	return def
+}
+
+func (e *envReader) floatRange(key string, def, lo, hi float64) float64 {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f < lo || f > hi {
+		e.fail(key, raw, fmt.Sprintf("a number between %g and %g", lo, hi))
+		return def
+	}
+	return f
+}
+
+func (e *envReader) intRange(key string, def, lo, hi int) int {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lo || n > hi {
+		e.fail(key, raw, fmt.Sprintf("an integer between %d and %d", lo, hi))
+		return def
+	}
+	return n
+}
+
+// duration parses a Go duration such as "500ms" or "10s". With positive set,
+// zero is rejected as well as negative values.
+func (e *envReader) duration(key string, def time.Duration, positive bool) time.Duration {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	switch {
+	case err != nil:
+		e.fail(key, raw, "a duration such as 10s")
+	case positive && d <= 0:
+		e.fail(key, raw, "a positive duration")
+	case d < 0:
+		e.fail(key, raw, "a non-negative duration")
+	default:
+		return d
+	}
+	return def
+}
+
+// date reads a calendar day such as 2027-06-30, as midnight UTC
+func (e *envReader) date(key string, def time.Time) time.Time {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		e.fail(key, raw, "a date such as 2027-06-30")
+		return def
+	}
+	return t
+}
+
+func (e *envReader) boolean(key string, def bool) bool {
+	raw := e.getenv(key)
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.fail(key, raw, "true or false")
+		return def
+	}
+	return b
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg := testConfig(t, nil)
+	for _, c := range []struct {
+		name      string
+		got, want any
+	}{
+		{"Port", cfg.Port, 8080},
+		{"GinMode", cfg.GinMode, gin.DebugMode},
+		{"ReadTimeout", cfg.ReadTimeout, 15 * time.Second},
+		{"WriteTimeout", cfg.WriteTimeout, 15 * time.Second},
+		{"ReadHeaderTimeout", cfg.ReadHeaderTimeout, 5 * time.Second},
+		{"RequestTimeout", cfg.RequestTimeout, 5 * time.Second},
+		{"MaxBodyBytes", cfg.MaxBodyBytes, int64(1 << 20)},
+		{"MaxBatchBodyBytes", cfg.MaxBatchBodyBytes, int64(8 << 20)},
+		{"StrictJSON", cfg.StrictJSON, true},
+		{"StoreBackend", cfg.StoreBackend, "memory"},
+		{"HistorySize", cfg.HistorySize, 0},
+		{"IdempotencyTTL", cfg.IdempotencyTTL, 24 * time.Hour},
+		{"LegacyRoutesSunset", cfg.LegacyRoutesSunset, time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)},
+		{"SKUPattern", cfg.SKUPattern.String(), "^(?:" + defaultSKUPattern + ")$"},
+		{"TrustedProxies", len(cfg.TrustedProxies), 0},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadConfigParses(t *testing.T) {
+	cfg := testConfig(t, map[string]string{
+		"PORT":                 "9090",
+		"GIN_MODE":             "release",
+		"READ_TIMEOUT":         "2500ms",
+		"REQUEST_TIMEOUT":      "0s",
+		"MAX_BODY_BYTES":       "2048",
+		"STRICT_JSON":          "false",
+		"TRUSTED_PROXIES":      " 10.0.0.1 , 192.168.7.0/16,,2001:db8::/32",
+		"SKU_PATTERN":          "none",
+		"LEGACY_ROUTES_SUNSET": "2030-01-31",
+	})
+	if cfg.Port != 9090 || cfg.GinMode != gin.ReleaseMode || cfg.ReadTimeout != 2500*time.Millisecond ||
+		cfg.RequestTimeout != 0 || cfg.MaxBodyBytes != 2048 || cfg.StrictJSON {
+		t.Errorf("got %+v", cfg)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/32"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if !slices.Equal(cfg.TrustedProxies, want) {
+		t.Errorf("TrustedProxies: got %v, want %v", cfg.TrustedProxies, want)
+	}
+	if cfg.SKUPattern != nil {
+		t.Errorf("SKU_PATTERN=none: got pattern %v", cfg.SKUPattern)
+	}
+	if want := time.Date(2030, time.January, 31, 0, 0, 0, 0, time.UTC); !cfg.LegacyRoutesSunset.Equal(want) {
+		t.Errorf("LegacyRoutesSunset: got %v, want %v", cfg.LegacyRoutesSunset, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"PORT": "0"}, `PORT="0": must be an integer between 1 and 65535`},
+		{map[string]string{"PORT": "70000"}, `PORT="70000"`},
+		{map[string]string{"PORT": "http"}, `PORT="http"`},
+		{map[string]string{"GIN_MODE": "production"}, `GIN_MODE="production": must be one of ["debug" "release" "test"]`},
+		{map[string]string{"READ_TIMEOUT": "15"}, `READ_TIMEOUT="15": must be a duration such as 10s`},
+		{map[string]string{"READ_TIMEOUT": "0s"}, `READ_TIMEOUT="0s": must be a positive duration`},
+		{map[string]string{"REQUEST_TIMEOUT": "-1s"}, `REQUEST_TIMEOUT="-1s": must be a non-negative duration`},
+		{map[string]string{"MAX_BODY_BYTES": "0"}, `MAX_BODY_BYTES="0"`},
+		{map[string]string{"STRICT_JSON": "maybe"}, `STRICT_JSON="maybe": must be true or false`},
+		{map[string]string{"TRUSTED_PROXIES": "10.0.0.0/33"}, `TRUSTED_PROXIES="10.0.0.0/33": must be a CIDR prefix or IP address`},
+		{map[string]string{"SKU_PATTERN": "[A-Z"}, `SKU_PATTERN="[A-Z": must be a valid regular expression`},
+		{map[string]string{"LEGACY_ROUTES_SUNSET": "June 2027"}, `LEGACY_ROUTES_SUNSET="June 2027"`},
+		{map[string]string{"STORE_BACKEND": "mysql"}, `STORE_BACKEND="mysql"`},
+		{map[string]string{"SEED_FILE": "products.txt"}, `SEED_FILE="products.txt": must be a path ending in .csv or .json`},
+		{map[string]string{"WRITE_BEHIND": "true"}, "WRITE_BEHIND is only supported with STORE_BACKEND=dynamodb"},
+		{map[string]string{"MAX_PRODUCTS": "10", "STORE_BACKEND": "redis"}, "MAX_PRODUCTS is only supported with STORE_BACKEND=memory"},
+	} {
+		_, err := loadConfig(func(key string) string { return tc.env[key] })
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got error %v, want one containing %q", tc.env, err, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	env := map[string]string{"PORT": "-1", "GIN_MODE": "loud", "WRITE_TIMEOUT": "soon"}
+	_, err := loadConfig(func(key string) string { return env[key] })
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for key := range env {
+		if !strings.Contains(err.Error(), key+"=") {
+			t.Errorf("error does not mention %s: %v", key, err)
+		}
+	}
+}
+
+func TestNewHTTPServerUsesConfig(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"PORT": "9090", "READ_TIMEOUT": "3s", "WRITE_TIMEOUT": "4s", "IDLE_TIMEOUT": "5s", "MAX_HEADER_BYTES": "8192"})
+	srv := newHTTPServer(cfg, cfg.Port, http.NotFoundHandler(), new(atomic.Int64))
+	if srv.Addr != ":9090" || srv.ReadTimeout != 3*time.Second || srv.WriteTimeout != 4*time.Second ||
+		srv.IdleTimeout != 5*time.Second || srv.ReadHeaderTimeout != cfg.ReadHeaderTimeout || srv.MaxHeaderBytes != 8192 {
+		t.Errorf("got server %+v", srv)
+	}
+}
+
+// TestNewHTTPServerDropsSlowClients serves newHTTPServer on a real
+// listener and checks connections that never finish their headers, or sit
+// idle after a request, are closed on time and leave the open count
+func TestNewHTTPServerDropsSlowClients(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"READ_HEADER_TIMEOUT": "100ms", "IDLE_TIMEOUT": "200ms"})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open atomic.Int64
+	srv := newHTTPServer(cfg, cfg.Port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &open)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	for _, tc := range []struct{ name, send string }{
+		{"silent", ""},
+		{"partial headers", "GET / HTTP/1.1\r\nHost: test\r\nX-Slow: "},
+		{"idle after a request", "GET / HTTP/1.1\r\nHost: test\r\n\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			start := time.Now()
+			conn.SetDeadline(start.Add(5 * time.Second))
+			io.WriteString(conn, tc.send)
+			// Read whatever the server answers until it hangs up
+			if _, err := io.Copy(io.Discard, conn); err != nil {
+				t.Fatalf("connection still open after %v: %v", time.Since(start), err)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("closed after %v, want well within a second", took)
+			}
+			for deadline := time.Now().Add(time.Second); open.Load() != 0; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d connections counted open after the close", open.Load())
+				}
+			}
+		})
+	}
+}
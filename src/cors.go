@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsExposedHeaders are response headers browser code may read
+var corsExposedHeaders = []string{"ETag", "Location", "X-Total-Count", "X-Request-ID", "Retry-After", "Idempotent-Replayed",
+	"X-API-Version", "Deprecation", "Sunset", "Link"}
+
+// cors adds Access-Control-* headers for origins in CORS_ALLOWED_ORIGINS and
+// answers preflight requests itself with 204, or 403 for an origin that is
+// not allowed. Requests without an Origin header, and all requests when no
+// origins are configured, pass through untouched.
+func (a *API) cors(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if len(a.cfg.CORSOrigins) == 0 || origin == "" {
+		c.Next()
+		return
+	}
+
+	wildcard := slices.Contains(a.cfg.CORSOrigins, "*")
+	if !wildcard {
+		// Caches must not reuse one origin's answer for another
+		c.Writer.Header().Add("Vary", "Origin")
+	}
+	allowed := wildcard || slices.Contains(a.cfg.CORSOrigins, origin)
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+	if !allowed {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		// Serve it without CORS headers; the browser withholds the response
+		c.Next()
+		return
+	}
+
+	h := c.Writer.Header()
+	if wildcard {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if a.cfg.CORSAllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		c.Next()
+		return
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", strings.Join(a.cfg.CORSMethods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(a.cfg.CORSHeaders, ", "))
+	h.Set("Access-Control-Max-Age", strconv.Itoa(int(a.cfg.CORSMaxAge.Seconds())))
+	c.AbortWithStatus(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var corsEnv = map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example.com", "CORS_ALLOW_CREDENTIALS": "true"}
+
+func TestCORSPreflight(t *testing.T) {
+	router := seededRouter(t, 1, corsEnv)
+	w := doRequest(router, http.MethodOptions, "/v1/products/1", "",
+		"Origin", "https://app.example.com", "Access-Control-Request-Method", "PATCH", "Access-Control-Request-Headers", "Content-Type, If-Match")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("got %d %s, want an empty 204", w.Code, w.Body)
+	}
+	h := w.Header()
+	allowHeaders := "Content-Type, Authorization, X-API-Key, X-Tenant-ID, X-Request-ID, " +
+		"X-Signature, X-Signature-Timestamp, If-Match, If-None-Match, Idempotency-Key, X-TTL"
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST, PUT, PATCH, DELETE",
+		"Access-Control-Allow-Headers":     allowHeaders,
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	for _, want := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+		if !slices.Contains(h.Values("Vary"), want) {
+			t.Errorf("Vary %v does not list %s", h.Values("Vary"), want)
+		}
+	}
+
+	// The admin PUTs, and the headers bearer tokens, tenants and signed
+	// requests need, are allowed by default
+	w = doRequest(router, http.MethodOptions, "/admin/loglevel", "",
+		"Origin", "https://app.example.com", "Access-Control-Request-Method", "PUT",
+		"Access-Control-Request-Headers", "Authorization, X-Tenant-ID, X-Signature, X-Signature-Timestamp")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("admin preflight: got %d %s, want 204", w.Code, w.Body)
+	}
+	if methods := strings.Split(w.Header().Get("Access-Control-Allow-Methods"), ", "); !slices.Contains(methods, "PUT") {
+		t.Errorf("admin preflight: PUT not in Access-Control-Allow-Methods %v", methods)
+	}
+	allowed := strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ", ")
+	for _, want := range []string{"Authorization", "X-Tenant-ID", "X-Signature", "X-Signature-Timestamp"} {
+		if !slices.Contains(allowed, want) {
+			t.Errorf("admin preflight: %s not in Access-Control-Allow-Headers %v", want, allowed)
+		}
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	router := seededRouter(t, 1, corsEnv)
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "", "Origin", "https://app.example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	h := w.Header()
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin: got %q", got)
+	}
+	if got := h.Get("Access-Control-Expose-Headers"); !strings.Contains(got, "ETag") || !strings.Contains(got, "X-Request-ID") {
+		t.Errorf("Access-Control-Expose-Headers: got %q", got)
+	}
+	if h.Get("Access-Control-Allow-Methods") != "" || h.Get("Access-Control-Max-Age") != "" {
+		t.Errorf("preflight headers on a simple request: %v", h)
+	}
+
+	// Without an Origin the response carries no CORS headers at all
+	w = doRequest(router, http.MethodGet, "/v1/products/1", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); w.Code != http.StatusOK || got != "" {
+		t.Errorf("no Origin: got %d with Access-Control-Allow-Origin %q", w.Code, got)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	router := seededRouter(t, 1, corsEnv)
+	w := doRequest(router, http.MethodOptions, "/v1/products/1", "",
+		"Origin", "https://evil.example.com", "Access-Control-Request-Method", "DELETE")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight: got %d, want 403", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight: Access-Control-Allow-Origin %q", got)
+	}
+
+	// A simple request is still served, but without the headers a browser
+	// needs to hand the response to the page
+	w = doRequest(router, http.MethodGet, "/v1/products/1", "", "Origin", "https://evil.example.com")
+	if w.Code != http.StatusOK {
+		t.Errorf("simple request: got %d", w.Code)
+	}
+	for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("simple request: %s %q", name, got)
+		}
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	router := seededRouter(t, 1, map[string]string{"CORS_ALLOWED_ORIGINS": "*"})
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "", "Origin", "https://anywhere.example.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin: got %q, want *", got)
+	}
+	if slices.Contains(w.Header().Values("Vary"), "Origin") {
+		t.Error("wildcard answer varies on Origin")
+	}
+}
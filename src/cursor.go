@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CursorPage is the body of GET /products?cursor=...
+type CursorPage struct {
+	XMLName  xml.Name `json:"-" xml:"page"`
+	Products any      `json:"products" xml:"products>product"`
+	// NextCursor continues after the last product; absent on the last page
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// pageCursor is what a cursor token encodes: the sort it was issued for and
+// the sort keys of the last product returned. It holds no server state, so
+// tokens stay valid across restarts and replicas.
+type pageCursor struct {
+	Sort string `json:"s,omitempty"`
+	// Last carries product_id plus the sorted field, if any; it decodes
+	// into a Product
+	Last map[string]any `json:"k"`
+}
+
+// pageLister is implemented by stores that can start a listing part-way
+// through product_id order without copying everything before it
+type pageLister interface {
+	ListPage(filter ListFilter, afterID, limit int) ([]Product, error)
+}
+
+// ListPage returns up to limit products matching filter whose IDs are above
+// afterID, in product_id order. Each shard walks its sorted IDs from a
+// binary search, so a page costs about limit products per shard, not a
+// copy and sort of the catalogue.
+func (s *InMemoryStore) ListPage(filter ListFilter, afterID, limit int) ([]Product, error) {
+	var page []Product
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		start, _ := slices.BinarySearch(sh.ids, afterID+1)
+		taken := 0
+		for _, id := range sh.ids[start:] {
+			if taken == limit {
+				break
+			}
+			if p := sh.products[id]; filter.matches(p) {
+				page = append(page, p)
+				taken++
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	slices.SortFunc(page, compareOn["product_id"])
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+// encodeCursor returns the token that continues after last
+func encodeCursor(sortParam string, last Product) string {
+	field, _ := strings.CutPrefix(sortParam, "-")
+	if field == "" {
+		field = "product_id"
+	}
+	keys := project(last, []string{"product_id", field}).(projection)
+	raw, _ := json.Marshal(pageCursor{Sort: sortParam, Last: keys})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a token from encodeCursor into the last product it
+// names, which holds just its sort keys. It fails for tokens that are
+// malformed or were issued for a different sort.
+func decodeCursor(token, sortParam string) (Product, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Product{}, false
+	}
+	var cur struct {
+		Sort string  `json:"s"`
+		Last Product `json:"k"`
+	}
+	if err := json.Unmarshal(raw, &cur); err != nil || cur.Sort != sortParam || cur.Last.ProductID < 1 {
+		return Product{}, false
+	}
+	return cur.Last, true
+}
+
+// listProductsPage serves GET /products?cursor=..., the keyset-paginated
+// form of the listing. An empty cursor starts from the beginning. Products
+// inserted or deleted between pages never shift the rest of the walk, as
+// each page starts strictly after the keys of the last one. Without a sort
+// the in-memory store seeks straight to the cursor; otherwise the matches
+// are listed and sorted as for offset pagination. X-Total-Count is not
+// sent, since counting would mean visiting every match.
+// Returns 200 with CursorPage, 400 if the cursor is invalid or offset is set
+func (a *API) listProductsPage(c *gin.Context, filter ListFilter, limit int, order func(x, y Product) int, fields []string) {
+	if _, present := c.GetQuery("offset"); present {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Conflicting pagination",
+			Details:   "offset cannot be combined with cursor",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	sortParam := c.Query("sort")
+	var last Product
+	if token := c.Query("cursor"); token != "" {
+		var ok bool
+		if last, ok = decodeCursor(token, sortParam); !ok {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_CURSOR",
+				Message:   "Invalid cursor",
+				Details:   "cursor must be a next_cursor returned by this listing with the same sort; start again without one",
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	// One extra product tells whether another page follows
+	var items []Product
+	var err error
+	if lister, ok := a.baseStore(c.Request.Context()).(pageLister); ok && order == nil {
+		items, err = lister.ListPage(filter, last.ProductID, limit+1)
+	} else {
+		items, err = a.wrappedStore().List(c.Request.Context(), filter)
+		if order == nil {
+			order = compareOn["product_id"]
+		}
+		slices.SortFunc(items, order)
+		if last.ProductID > 0 {
+			items = items[sort.Search(len(items), func(i int) bool { return order(items[i], last) > 0 }):]
+		}
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	page := CursorPage{}
+	if len(items) > limit {
+		items = items[:limit]
+		page.NextCursor = encodeCursor(sortParam, items[len(items)-1])
+	}
+	if items == nil {
+		items = []Product{}
+	}
+	page.Products = projectAll(items, fields)
+	writeProducts(c, http.StatusOK, page)
+}
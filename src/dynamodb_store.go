@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+)
+
+// skuIndexName is the global secondary index (partition key: sku) used for
+// GetBySKU and the duplicate-SKU check
+const skuIndexName = "sku-index"
+
+// maxUpdateAttempts bounds the optimistic retry loop in DynamoDBStore.Update
+const maxUpdateAttempts = 5
+
+// maxBatchWriteItems is the most requests one BatchWriteItem call accepts
+const maxBatchWriteItems = 25
+
+// maxTransactItems is the most actions one TransactWriteItems call accepts
+const maxTransactItems = 100
+
+// DynamoDBStore is a ProductStore backed by a DynamoDB table whose partition
+// key is the numeric attribute product_id. Attributes use the Product JSON
+// field names.
+//
+// SKU uniqueness is checked through the sku-index GSI before each write.
+// GSIs are eventually consistent, so two tasks racing to claim the same SKU
+// can both succeed; the in-memory store does not have this gap.
+type DynamoDBStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBStore loads AWS configuration from the environment and returns
+// a store for table. A non-empty endpoint overrides the service URL, which is
+// how DynamoDB Local is targeted. With instrument set every SDK call is
+// recorded as an X-Ray subsegment of the segment in its context.
+func NewDynamoDBStore(ctx context.Context, table, region, endpoint string, instrument bool) (*DynamoDBStore, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	if instrument {
+		awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &DynamoDBStore{client: client, table: table}, nil
+}
+
+// Ping checks that the table is reachable, for the readiness probe
+func (s *DynamoDBStore) Ping(ctx context.Context) error {
+	if _, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)}); err != nil {
+		return fmt.Errorf("dynamodb describe table: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) Get(ctx context.Context, id int) (Product, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            productKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Product{}, fmt.Errorf("dynamodb get item: %w", err)
+	}
+	if out.Item == nil {
+		return Product{}, ErrNotFound
+	}
+	return unmarshalProduct(out.Item)
+}
+
+func (s *DynamoDBStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 aws.String(skuIndexName),
+		KeyConditionExpression:    aws.String("sku = :sku"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":sku": &types.AttributeValueMemberS{Value: sku}},
+		Limit:                     aws.Int32(1),
+	})
+	if err != nil {
+		return Product{}, fmt.Errorf("dynamodb query sku: %w", err)
+	}
+	if len(out.Items) == 0 {
+		return Product{}, ErrNotFound
+	}
+	return unmarshalProduct(out.Items[0])
+}
+
+func (s *DynamoDBStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	found := make(map[int]Product, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	// BatchGetItem rejects duplicate keys and accepts at most 100 per call
+	seen := make(map[int]bool, len(ids))
+	keys := make([]map[string]types.AttributeValue, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			keys = append(keys, productKey(id))
+		}
+	}
+
+	for start := 0; start < len(keys); start += 100 {
+		end := min(start+100, len(keys))
+		request := map[string]types.KeysAndAttributes{
+			s.table: {Keys: keys[start:end], ConsistentRead: aws.Bool(true)},
+		}
+		// Keep resubmitting whatever DynamoDB leaves unprocessed
+		for len(request) > 0 {
+			out, err := s.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("dynamodb batch get item: %w", err)
+			}
+			for _, item := range out.Responses[s.table] {
+				p, err := unmarshalProduct(item)
+				if err != nil {
+					return nil, err
+				}
+				found[p.ProductID] = p
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+	return found, nil
+}
+
+func (s *DynamoDBStore) Put(ctx context.Context, p *Product) (bool, error) {
+	if err := s.checkSKU(ctx, *p); err != nil {
+		return false, err
+	}
+
+	// created_at is kept by the update expression, so only UpdatedAt matters here
+	stampProduct(p, time.Time{}, time.Now().UTC())
+	item, err := marshalProduct(*p)
+	if err != nil {
+		return false, err
+	}
+	expr, names, values := upsertExpression(item)
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       productKey(p.ProductID),
+		UpdateExpression:          aws.String(expr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		// The old item tells whether this was a create and which created_at was kept
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return false, fmt.Errorf("dynamodb update item: %w", err)
+	}
+	if len(out.Attributes) == 0 {
+		return true, nil
+	}
+	if old, err := unmarshalProduct(out.Attributes); err == nil && !old.CreatedAt.IsZero() {
+		p.CreatedAt = old.CreatedAt
+	}
+	return false, nil
+}
+
+func (s *DynamoDBStore) Create(ctx context.Context, p *Product) error {
+	if err := s.checkSKU(ctx, *p); err != nil {
+		return err
+	}
+
+	stampProduct(p, time.Time{}, time.Now().UTC())
+	item, err := marshalProduct(*p)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(product_id)"),
+		// Hand back the item that is in the way without a second read
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		existing, err := unmarshalProduct(conflict.Item)
+		if err != nil {
+			return err
+		}
+		return &ProductExistsError{Existing: existing}
+	}
+	if err != nil {
+		return fmt.Errorf("dynamodb put item: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := make([]error, len(items))
+	if !atomic {
+		for i, p := range items {
+			_, errs[i] = s.Put(ctx, &p)
+		}
+		return errs
+	}
+	abort := func(i int, err error) []error {
+		for j := range errs {
+			errs[j] = ErrBatchAborted
+		}
+		errs[i] = err
+		return errs
+	}
+
+	// A transaction may touch each item only once, so a product given more
+	// than once is written with its last version alone
+	last := make(map[int]int, len(items))
+	for i, p := range items {
+		last[p.ProductID] = i
+	}
+	if len(last) > maxTransactItems {
+		err := &AtomicBatchTooLargeError{Limit: maxTransactItems}
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// One TransactWriteItems call, so readers see every write or none. The
+	// SKU checks come first and share the sku-index gap described on
+	// DynamoDBStore; SKUs claimed twice within the batch are caught here.
+	now := time.Now().UTC()
+	skuOwners := make(map[string]int, len(last))
+	writes := make([]types.TransactWriteItem, 0, len(last))
+	positions := make([]int, 0, len(last))
+	for i, p := range items {
+		if last[p.ProductID] != i {
+			continue
+		}
+		if owner, claimed := skuOwners[p.SKU]; claimed {
+			return abort(i, &DuplicateSKUError{SKU: p.SKU, ProductID: owner})
+		}
+		skuOwners[p.SKU] = p.ProductID
+		if err := s.checkSKU(ctx, p); err != nil {
+			return abort(i, err)
+		}
+		stampProduct(&p, time.Time{}, now)
+		item, err := marshalProduct(p)
+		if err != nil {
+			return abort(i, err)
+		}
+		expr, names, values := upsertExpression(item)
+		writes = append(writes, types.TransactWriteItem{Update: &types.Update{
+			TableName:                 aws.String(s.table),
+			Key:                       productKey(p.ProductID),
+			UpdateExpression:          aws.String(expr),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}})
+		positions = append(positions, i)
+	}
+
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	if err == nil {
+		return errs
+	}
+	err = fmt.Errorf("dynamodb transact write items: %w", err)
+	// A canceled transaction names the write that caused it
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for k, reason := range canceled.CancellationReasons {
+			if k < len(positions) && aws.ToString(reason.Code) != "None" {
+				return abort(positions[k], err)
+			}
+		}
+	}
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (s *DynamoDBStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	// Optimistic read-modify-write: the put only succeeds if the item still
+	// holds exactly the attributes that were read
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            productKey(id),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return Product{}, fmt.Errorf("dynamodb get item: %w", err)
+		}
+		if out.Item == nil {
+			return Product{}, ErrNotFound
+		}
+		existing, err := unmarshalProduct(out.Item)
+		if err != nil {
+			return Product{}, err
+		}
+
+		updated := existing
+		if err := fn(&updated); err != nil {
+			return Product{}, err
+		}
+		stampProduct(&updated, existing.CreatedAt, time.Now().UTC())
+		if err := s.checkSKU(ctx, updated); err != nil {
+			return Product{}, err
+		}
+		item, err := marshalProduct(updated)
+		if err != nil {
+			return Product{}, err
+		}
+
+		cond, names, values := unchangedCondition(out.Item)
+		_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      item,
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err != nil {
+			return Product{}, fmt.Errorf("dynamodb put item: %w", err)
+		}
+		return updated, nil
+	}
+	return Product{}, fmt.Errorf("dynamodb update product %d: too many concurrent modifications", id)
+}
+
+func (s *DynamoDBStore) Delete(ctx context.Context, id int) error {
+	out, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(s.table),
+		Key:          productKey(id),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return fmt.Errorf("dynamodb delete item: %w", err)
+	}
+	if len(out.Attributes) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIf removes the product if cond reports true. As in Update, the
+// delete only succeeds if the item still holds what cond was shown.
+func (s *DynamoDBStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            productKey(id),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, fmt.Errorf("dynamodb get item: %w", err)
+		}
+		if out.Item == nil {
+			return false, ErrNotFound
+		}
+		existing, err := unmarshalProduct(out.Item)
+		if err != nil {
+			return false, err
+		}
+		if !cond(existing) {
+			return false, nil
+		}
+
+		expr, names, values := unchangedCondition(out.Item)
+		_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName:                 aws.String(s.table),
+			Key:                       productKey(id),
+			ConditionExpression:       aws.String(expr),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("dynamodb delete item: %w", err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("dynamodb delete product %d: too many concurrent modifications", id)
+}
+
+// WriteBatch stores puts and removes deletes with BatchWriteItem,
+// maxBatchWriteItems at a time, resending whatever DynamoDB leaves
+// unprocessed. The products are written exactly as given, timestamps
+// included, and no SKU check is made: the caller is the source of truth.
+func (s *DynamoDBStore) WriteBatch(ctx context.Context, puts []Product, deletes []int) error {
+	requests := make([]types.WriteRequest, 0, len(puts)+len(deletes))
+	for _, p := range puts {
+		item, err := marshalProduct(p)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	for _, id := range deletes {
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: productKey(id)}})
+	}
+
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		pending := requests[start:min(start+maxBatchWriteItems, len(requests))]
+		backoff := 50 * time.Millisecond
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == maxUpdateAttempts {
+				return fmt.Errorf("dynamodb batch write item: %d requests still unprocessed", len(pending))
+			}
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+			out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{s.table: pending},
+			})
+			if err != nil {
+				return fmt.Errorf("dynamodb batch write item: %w", err)
+			}
+			pending = out.UnprocessedItems[s.table]
+		}
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	input := &dynamodb.ScanInput{TableName: aws.String(s.table)}
+	if filter.CategoryID > 0 {
+		input.FilterExpression = aws.String("category_id = :category_id")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":category_id": &types.AttributeValueMemberN{Value: strconv.Itoa(filter.CategoryID)},
+		}
+	}
+
+	var items []Product
+	paginator := dynamodb.NewScanPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb scan: %w", err)
+		}
+		for _, item := range page.Items {
+			p, err := unmarshalProduct(item)
+			if err != nil {
+				return nil, err
+			}
+			if filter.matches(p) {
+				items = append(items, p)
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ProductID < items[j].ProductID
+	})
+	return items, nil
+}
+
+// checkSKU returns *DuplicateSKUError if p.SKU is owned by another product
+func (s *DynamoDBStore) checkSKU(ctx context.Context, p Product) error {
+	owner, err := s.GetBySKU(ctx, p.SKU)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner.ProductID != p.ProductID {
+		return &DuplicateSKUError{SKU: p.SKU, ProductID: owner.ProductID}
+	}
+	return nil
+}
+
+// dynamoThrottled reports whether err is DynamoDB refusing a request for
+// exceeding throughput or request limits, which leaves it unapplied
+func dynamoThrottled(err error) bool {
+	var provisioned *types.ProvisionedThroughputExceededException
+	var limit *types.RequestLimitExceeded
+	var throttled *types.ThrottlingException
+	return errors.As(err, &provisioned) || errors.As(err, &limit) || errors.As(err, &throttled)
+}
+
+// dynamoServerError reports whether err is a DynamoDB internal error, after
+// which a write may or may not have been applied
+func dynamoServerError(err error) bool {
+	var internal *types.InternalServerError
+	return errors.As(err, &internal)
+}
+
+// productKey builds the primary key for a product ID
+func productKey(id int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"product_id": &types.AttributeValueMemberN{Value: strconv.Itoa(id)},
+	}
+}
+
+// marshalProduct encodes p using its JSON field names as attribute names
+func marshalProduct(p Product) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMapWithOptions(p, func(o *attributevalue.EncoderOptions) {
+		o.TagKey = "json"
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal product: %w", err)
+	}
+	return item, nil
+}
+
+// unmarshalProduct decodes an item written by marshalProduct
+func unmarshalProduct(item map[string]types.AttributeValue) (Product, error) {
+	var p Product
+	if err := attributevalue.UnmarshalMapWithOptions(item, &p, func(o *attributevalue.DecoderOptions) {
+		o.TagKey = "json"
+	}); err != nil {
+		return Product{}, fmt.Errorf("unmarshal product: %w", err)
+	}
+	return p, nil
+}
+
+// optionalAttributes are Product fields left out of an item when empty; an
+// upsert removes them so the old values don't survive a replace
+var optionalAttributes = []string{"name", "description", "price", "currency", "deleted_at"}
+
+// upsertExpression builds an update expression that sets every attribute of
+// item except the key, and created_at only if the item doesn't have one yet.
+// Optional attributes item lacks are removed.
+func upsertExpression(item map[string]types.AttributeValue) (string, map[string]string, map[string]types.AttributeValue) {
+	names := make(map[string]string, len(item))
+	values := make(map[string]types.AttributeValue, len(item))
+
+	keys := make([]string, 0, len(item))
+	for k := range item {
+		if k != "product_id" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	sets := make([]string, 0, len(keys))
+	for i, k := range keys {
+		n, v := "#a"+strconv.Itoa(i), ":v"+strconv.Itoa(i)
+		names[n] = k
+		values[v] = item[k]
+		if k == "created_at" {
+			sets = append(sets, n+" = if_not_exists("+n+", "+v+")")
+		} else {
+			sets = append(sets, n+" = "+v)
+		}
+	}
+	expr := "SET " + strings.Join(sets, ", ")
+
+	var removes []string
+	for _, k := range optionalAttributes {
+		if _, present := item[k]; !present {
+			n := "#r" + strconv.Itoa(len(removes))
+			names[n] = k
+			removes = append(removes, n)
+		}
+	}
+	if len(removes) > 0 {
+		expr += " REMOVE " + strings.Join(removes, ", ")
+	}
+	return expr, names, values
+}
+
+// unchangedCondition builds a condition expression asserting that every
+// attribute of item still has the value it was read with
+func unchangedCondition(item map[string]types.AttributeValue) (string, map[string]string, map[string]types.AttributeValue) {
+	names := make(map[string]string, len(item))
+	values := make(map[string]types.AttributeValue, len(item))
+
+	// Sort attribute names so the expression is deterministic
+	keys := make([]string, 0, len(item))
+	for k := range item {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cond := ""
+	for i, k := range keys {
+		n, v := "#a"+strconv.Itoa(i), ":v"+strconv.Itoa(i)
+		names[n] = k
+		values[v] = item[k]
+		if cond != "" {
+			cond += " AND "
+		}
+		cond += n + " = " + v
+	}
+	return cond, names, values
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// openTestDynamoDB returns a store over a new table in the DynamoDB Local
+// at DYNAMODB_TEST_ENDPOINT, e.g. http://localhost:8000, dropped when the
+// test ends. The test is skipped without one.
+func openTestDynamoDB(t *testing.T) *DynamoDBStore {
+	t.Helper()
+	endpoint := os.Getenv("DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_TEST_ENDPOINT not set")
+	}
+	// DynamoDB Local takes any credentials, but the SDK wants some
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "local", "AWS_SECRET_ACCESS_KEY": "local"} {
+		if os.Getenv(key) == "" {
+			t.Setenv(key, value)
+		}
+	}
+
+	ctx := context.Background()
+	table := "products-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	s, err := NewDynamoDBStore(ctx, table, "us-east-1", endpoint, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The table the terraform module creates: product_id key, sku-index
+	// projecting everything
+	_, err = s.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("product_id"), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: aws.String("sku"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{{AttributeName: aws.String("product_id"), KeyType: types.KeyTypeHash}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  aws.String(skuIndexName),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: aws.String("sku"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	t.Cleanup(func() {
+		s.client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+	waiter := dynamodb.NewTableExistsWaiter(s.client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDynamoDBStoreConformance(t *testing.T) {
+	s := openTestDynamoDB(t)
+	runStoreConformance(t, storeFactory{
+		open: func(t *testing.T) ProductStore {
+			emptyStore(t, s)
+			return s
+		},
+		atomicBatchLimit:      maxTransactItems,
+		boundedUpdateRetries:  true,
+		skuCheckedBeforeWrite: true,
+	})
+}
+
+// TestDynamoDBStoreThroughAPI checks the responses the handlers give over
+// DynamoDB Local
+func TestDynamoDBStoreThroughAPI(t *testing.T) {
+	_, router := newTestAPI(t, openTestDynamoDB(t), nil)
+	if w := doRequest(router, http.MethodGet, "/v1/products/1", ""); w.Code != http.StatusNotFound || errorCode(t, w.Body.Bytes()) != "NOT_FOUND" {
+		t.Errorf("missing item: got %d %s, want 404 NOT_FOUND", w.Code, w.Body)
+	}
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1))); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	var got Product
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil || got.SKU != testProduct(1).SKU {
+		t.Errorf("get: got %d %s", w.Code, w.Body)
+	}
+}
+
+// fakeDynamoDB returns a store whose DynamoDB is an httptest server
+// answering GetItem with found, and every other call, GetItem too when
+// found is empty, with a ResourceNotFoundException: the table is missing
+func fakeDynamoDB(t *testing.T, found string) *DynamoDBStore {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if found != "" && r.Header.Get("X-Amz-Target") == "DynamoDB_20120810.GetItem" {
+			io.WriteString(w, found)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+	s, err := NewDynamoDBStore(context.Background(), "products", "us-east-1", srv.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDynamoDBStoreErrors(t *testing.T) {
+	_, router := newTestAPI(t, fakeDynamoDB(t, `{}`), nil)
+	if w := doRequest(router, http.MethodGet, "/v1/products/1", ""); w.Code != http.StatusNotFound || errorCode(t, w.Body.Bytes()) != "NOT_FOUND" {
+		t.Errorf("GetItem without an item: got %d %s, want 404 NOT_FOUND", w.Code, w.Body)
+	}
+
+	_, router = newTestAPI(t, fakeDynamoDB(t, ""), nil)
+	for _, r := range []struct{ method, target, body string }{
+		{http.MethodGet, "/v1/products/1", ""},
+		{http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1))},
+		{http.MethodDelete, "/v1/products/1", ""},
+	} {
+		if w := doRequest(router, r.method, r.target, r.body); w.Code != http.StatusInternalServerError || errorCode(t, w.Body.Bytes()) != "INTERNAL_ERROR" {
+			t.Errorf("%s %s on a missing table: got %d %s, want 500 INTERNAL_ERROR", r.method, r.target, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ERROR_FORMAT values: the service's own ErrorResponse, or RFC 7807
+// problem+json for every error
+const (
+	errorFormatNative  = "native"
+	errorFormatProblem = "problem"
+)
+
+// problemContentType is the media type of RFC 7807 documents
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes an error code to form the problem type URI,
+// e.g. urn:product-api:problem:NOT_FOUND
+const problemTypeBase = "urn:product-api:problem:"
+
+// ProblemDetails is an RFC 7807 error document; it carries the same
+// information as ErrorResponse
+type ProblemDetails struct {
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Detail     string            `json:"detail,omitempty"`
+	Instance   string            `json:"instance,omitempty"`
+	Extensions ProblemExtensions `json:"extensions"`
+}
+
+// ProblemExtensions holds the ErrorResponse members RFC 7807 has no place for
+type ProblemExtensions struct {
+	Code      string       `json:"code"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// writeError sends resp with status in the request's error format. Every
+// error response goes through here, so the two formats can't drift apart.
+func writeError(c *gin.Context, status int, resp ErrorResponse) {
+	contentType, body := encodeError(c, status, resp)
+	c.Data(status, contentType, body)
+}
+
+// abortWithError is writeError for middleware: the handlers after it don't run
+func abortWithError(c *gin.Context, status int, resp ErrorResponse) {
+	c.Abort()
+	writeError(c, status, resp)
+}
+
+// encodeError returns the content type and body writeError sends for resp:
+// XML or MessagePack if that was negotiated, otherwise ErrorResponse or
+// ProblemDetails JSON
+func encodeError(c *gin.Context, status int, resp ErrorResponse) (string, []byte) {
+	switch c.GetString(formatKey) {
+	case formatXML:
+		body, _ := xml.Marshal(xmlError{ErrorResponse: resp})
+		return "application/xml; charset=utf-8", body
+	case formatMsgpack:
+		return msgpackContentType, encodeMsgpack(resp)
+	case formatProblem:
+	default:
+		body, _ := marshalJSON(resp)
+		return jsonContentType, body
+	}
+	body, _ := json.Marshal(ProblemDetails{
+		Type:     problemTypeBase + resp.Error,
+		Title:    resp.Message,
+		Status:   status,
+		Detail:   resp.Details,
+		Instance: c.Request.URL.Path,
+		Extensions: ProblemExtensions{
+			Code:      resp.Error,
+			Fields:    resp.Fields,
+			RequestID: resp.RequestID,
+		},
+	})
+	return problemContentType, body
+}
+
+// errorCodeOf extracts the error code from a response body in either format
+func errorCodeOf(body []byte) string {
+	var doc struct {
+		Error      string `json:"error"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &doc) != nil {
+		return ""
+	}
+	if doc.Error != "" {
+		return doc.Error
+	}
+	return doc.Extensions.Code
+}
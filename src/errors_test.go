@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestErrorFormats sends a request failing with each error status through
+// a router in each ERROR_FORMAT and checks the body carries the same code,
+// message and request ID either way
+func TestErrorFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		env    map[string]string
+		store  ProductStore // seeded with products 1 and 2 if nil
+		method string
+		target string
+		body   string
+		header []string
+		repeat bool // the first request only sets the failure up
+		status int
+		code   string
+	}{
+		{"400", nil, nil, "GET", "/v1/products/abc", "", nil, false, 400, "INVALID_INPUT"},
+		{"404", nil, nil, "GET", "/v1/products/99", "", nil, false, 404, "NOT_FOUND"},
+		{"409", nil, nil, "POST", "/v1/products/2/details", `{"product_id":2,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":1,"some_other_id":1}`, nil, false, 409, "DUPLICATE_SKU"},
+		{"413", map[string]string{"MAX_BODY_BYTES": "64"}, nil, "POST", "/v1/products/1/details", productJSON(testProduct(1)), nil, false, 413, "PAYLOAD_TOO_LARGE"},
+		{"415", nil, nil, "POST", "/v1/products/1/details", "<product/>", []string{"Content-Type", "application/xml"}, false, 415, "UNSUPPORTED_MEDIA_TYPE"},
+		{"429", map[string]string{"RATE_LIMIT_RPS": "0.001", "RATE_LIMIT_BURST": "1"}, nil, "GET", "/v1/products/1", "", nil, true, 429, "RATE_LIMITED"},
+		{"500", nil, failingStore{errors.New("connection reset")}, "GET", "/v1/products/1", "", nil, false, 500, "INTERNAL_ERROR"},
+	} {
+		for _, format := range []string{errorFormatNative, errorFormatProblem} {
+			t.Run(tc.name+"/"+format, func(t *testing.T) {
+				env := map[string]string{"ERROR_FORMAT": format}
+				maps.Copy(env, tc.env)
+				store := tc.store
+				if store == nil {
+					mem := NewInMemoryStore(false)
+					mustPut(t, mem, testProduct(1), testProduct(2))
+					store = mem
+				}
+				_, router := newTestAPI(t, store, env)
+				if tc.repeat {
+					doRequest(router, tc.method, tc.target, tc.body, tc.header...)
+				}
+				w := doRequest(router, tc.method, tc.target, tc.body, tc.header...)
+				if w.Code != tc.status {
+					t.Fatalf("got %d %s, want %d", w.Code, w.Body, tc.status)
+				}
+				id := w.Header().Get("X-Request-ID")
+				contentType := w.Header().Get("Content-Type")
+
+				if format == errorFormatNative {
+					var resp ErrorResponse
+					if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+						t.Fatalf("body %s: %v", w.Body, err)
+					}
+					if contentType != jsonContentType || resp.Error != tc.code || resp.Message == "" || resp.RequestID != id {
+						t.Errorf("got %s %s, want %s with request_id %s", contentType, w.Body, tc.code, id)
+					}
+					return
+				}
+				var doc ProblemDetails
+				if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+					t.Fatalf("body %s: %v", w.Body, err)
+				}
+				want := ProblemDetails{
+					Type:       problemTypeBase + tc.code,
+					Title:      doc.Title,
+					Status:     tc.status,
+					Detail:     doc.Detail,
+					Instance:   tc.target,
+					Extensions: ProblemExtensions{Code: tc.code, Fields: doc.Extensions.Fields, RequestID: id},
+				}
+				if contentType != problemContentType || doc.Title == "" || !reflect.DeepEqual(doc, want) {
+					t.Errorf("got %s %s, want %+v", contentType, w.Body, want)
+				}
+				if got := errorCodeOf(w.Body.Bytes()); got != tc.code {
+					t.Errorf("errorCodeOf: got %q, want %q", got, tc.code)
+				}
+			})
+		}
+	}
+
+	// Under the native format a client can still ask for problem+json
+	router := seededRouter(t, 0, nil)
+	for _, req := range []struct{ method, target, body string }{
+		{http.MethodGet, "/v1/products/99", ""},
+		{http.MethodPost, "/v1/products/99/quantity/adjust", `{"delta":1}`},
+	} {
+		w := doRequest(router, req.method, req.target, req.body, "Accept", problemContentType)
+		if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != problemContentType || errorCodeOf(w.Body.Bytes()) != "NOT_FOUND" {
+			t.Errorf("%s %s with Accept %s: got %d %s %s", req.method, req.target, problemContentType, w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+	}
+}
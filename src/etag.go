@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// productETag returns a strong ETag derived from the product's JSON
+// encoding, so it changes whenever any field is overwritten and is the same
+// on every replica and store backend
+func productETag(p Product) string {
+	data, _ := json.Marshal(p) // Product has only plain fields; Marshal can't fail
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setProductValidators sets the ETag of p and, once p has been written, its
+// Last-Modified, and returns the ETag
+func setProductValidators(c *gin.Context, p Product) string {
+	etag := productETag(p)
+	c.Header("ETag", etag)
+	if !p.UpdatedAt.IsZero() {
+		c.Header("Last-Modified", p.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+	return etag
+}
+
+// etagMatches reports whether etag appears in an If-None-Match style header
+// value: a comma-separated list of entity tags, or "*" for any. Weak tags
+// (W/"...") compare equal to their strong form, as RFC 9110 requires for
+// If-None-Match.
+func etagMatches(header, etag string) bool {
+	return etagListContains(header, etag, true)
+}
+
+// ifMatchSatisfied reports whether an If-Match header value permits a write
+// to a resource whose current ETag is etag. If-Match uses the strong
+// comparison, so weak tags never match.
+func ifMatchSatisfied(header, etag string) bool {
+	return etagListContains(header, etag, false)
+}
+
+// notModified reports whether a GET whose current ETag is etag and which
+// was last written at modified can be answered 304. As RFC 9110 orders it,
+// If-Modified-Since is only looked at when If-None-Match is absent. HTTP
+// dates stop at the second, so modified is truncated before comparing: a
+// product written twice within one second keeps its Last-Modified, and
+// only the ETag tells the two writes apart.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+func etagListContains(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
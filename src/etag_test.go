@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stubStore answers Get with whatever product the test last stored in p,
+// stamped by the test's own clock
+type stubStore struct {
+	ProductStore
+	p *Product
+}
+
+func (s stubStore) Get(_ context.Context, id int) (Product, error) {
+	if s.p == nil || s.p.ProductID != id {
+		return Product{}, ErrNotFound
+	}
+	return *s.p, nil
+}
+
+func TestIfModifiedSinceWithinOneSecond(t *testing.T) {
+	current := testProduct(1)
+	_, router := newTestAPI(t, stubStore{NewInMemoryStore(false), &current}, nil)
+	created := time.Date(2026, 3, 4, 5, 6, 7, 200e6, time.UTC)
+	write := func(weight int, at time.Time) {
+		current.Weight = weight
+		stampProduct(&current, created, at)
+	}
+	get := func(header ...string) (int, string, string) {
+		w := doRequest(router, http.MethodGet, "/v1/products/1", "", header...)
+		return w.Code, w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	}
+
+	write(1, created)
+	_, firstETag, firstModified := get()
+	if want := "Wed, 04 Mar 2026 05:06:07 GMT"; firstModified != want {
+		t.Fatalf("Last-Modified %q, want %q", firstModified, want)
+	}
+	if code, _, _ := get("If-Modified-Since", firstModified); code != http.StatusNotModified {
+		t.Errorf("unchanged product, If-Modified-Since its Last-Modified: got %d, want 304", code)
+	}
+
+	// A second write 0.6s later falls in the same second
+	write(2, created.Add(600*time.Millisecond))
+	_, secondETag, secondModified := get()
+	if secondModified != firstModified || secondETag == firstETag {
+		t.Fatalf("same-second rewrite: Last-Modified %q then %q, ETag %s then %s; want the same date and a new ETag",
+			firstModified, secondModified, firstETag, secondETag)
+	}
+	for _, tc := range []struct {
+		name   string
+		header []string
+		want   int
+	}{
+		// Second precision can't tell the two writes apart
+		{"date only", []string{"If-Modified-Since", firstModified}, http.StatusNotModified},
+		{"stale ETag wins over the date", []string{"If-None-Match", firstETag, "If-Modified-Since", firstModified}, http.StatusOK},
+		{"current ETag wins over an old date", []string{"If-None-Match", secondETag, "If-Modified-Since", "Wed, 04 Mar 2026 04:00:00 GMT"}, http.StatusNotModified},
+		{"date a second earlier", []string{"If-Modified-Since", "Wed, 04 Mar 2026 05:06:06 GMT"}, http.StatusOK},
+		{"unparsable date", []string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+	} {
+		if code, _, _ := get(tc.header...); code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, code, tc.want)
+		}
+	}
+
+	// The next second moves Last-Modified on
+	write(3, created.Add(time.Second))
+	code, _, thirdModified := get("If-Modified-Since", firstModified)
+	if code != http.StatusOK || thirdModified != "Wed, 04 Mar 2026 05:06:08 GMT" {
+		t.Errorf("write in the next second: got %d with Last-Modified %q, want 200 and 05:06:08", code, thirdModified)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import "context"
+
+// Change events, one per successful write
+const (
+	eventProductCreated = "product.created"
+	eventProductUpdated = "product.updated"
+	eventProductDeleted = "product.deleted"
+)
+
+// changeSink is told about every successful write. product is the product
+// as stored, nil for product.deleted, and tenant the X-Tenant-ID it was
+// written for, empty unless MULTI_TENANT is on. Implementations must not
+// block: they run on the request path.
+type changeSink interface {
+	publishChange(tenant, event string, productID int, product *Product)
+}
+
+// notifyingStore passes every successful write on to sinks
+type notifyingStore struct {
+	ProductStore
+	sinks []changeSink
+}
+
+func (s notifyingStore) publish(ctx context.Context, event string, productID int, product *Product) {
+	tenant := tenantFrom(ctx)
+	for _, sink := range s.sinks {
+		sink.publishChange(tenant, event, productID, product)
+	}
+}
+
+func (s notifyingStore) Put(ctx context.Context, p *Product) (bool, error) {
+	created, err := s.ProductStore.Put(ctx, p)
+	if err == nil {
+		event := eventProductUpdated
+		if created {
+			event = eventProductCreated
+		}
+		stored := *p
+		s.publish(ctx, event, stored.ProductID, &stored)
+	}
+	return created, err
+}
+
+func (s notifyingStore) Create(ctx context.Context, p *Product) error {
+	err := s.ProductStore.Create(ctx, p)
+	if err == nil {
+		stored := *p
+		s.publish(ctx, eventProductCreated, stored.ProductID, &stored)
+	}
+	return err
+}
+
+// PutBatch reports every stored item as product.updated, since stores
+// don't say which items were new
+func (s notifyingStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := s.ProductStore.PutBatch(ctx, items, atomic)
+	for i, err := range errs {
+		if err == nil {
+			stored := items[i]
+			s.publish(ctx, eventProductUpdated, stored.ProductID, &stored)
+		}
+	}
+	return errs
+}
+
+func (s notifyingStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	p, err := s.ProductStore.Update(ctx, id, fn)
+	if err == nil {
+		stored := p
+		s.publish(ctx, eventProductUpdated, id, &stored)
+	}
+	return p, err
+}
+
+// Restore reports the product as product.created, undoing the
+// product.deleted sent when it was deleted
+func (s notifyingStore) Restore(ctx context.Context, id int) (Product, error) {
+	p, err := s.ProductStore.(productRestorer).Restore(ctx, id)
+	if err == nil {
+		stored := p
+		s.publish(ctx, eventProductCreated, id, &stored)
+	}
+	return p, err
+}
+
+func (s notifyingStore) Delete(ctx context.Context, id int) error {
+	err := s.ProductStore.Delete(ctx, id)
+	if err == nil {
+		s.publish(ctx, eventProductDeleted, id, nil)
+	}
+	return err
+}
+
+func (s notifyingStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	return listWindow(ctx, s.ProductStore, filter, limit, offset)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportChunkSize is how many products are written and flushed at a time
+const exportChunkSize = 500
+
+// exportColumns is the CSV header row; POST /products/import reads the same layout
+var exportColumns = []string{"product_id", "sku", "manufacturer", "category_id", "weight", "some_other_id", "name", "description", "price", "currency", "quantity"}
+
+// requiredColumns is how many leading export columns every import needs;
+// files from before name, description and price existed stop there
+const requiredColumns = 6
+
+// importLayouts are the leading export columns an import may have: files
+// from before name, description and price, from before quantity, and
+// current ones
+var importLayouts = []int{requiredColumns, 10, len(exportColumns)}
+
+// productExporter is implemented by stores that can copy their live
+// products at a single instant
+type productExporter interface {
+	// Export returns every live product in product_id order as of the
+	// returned time
+	Export() ([]Product, time.Time)
+}
+
+// exportHeader describes the snapshot an export was written from, so a
+// consumer can check it got every product: the CSV's leading # comment and
+// the first NDJSON line
+type exportHeader struct {
+	SnapshotAt   time.Time `json:"snapshot_at"`
+	ProductCount int       `json:"product_count"`
+}
+
+// Export copies the live products under every shard's read lock at once,
+// then sorts the copy with the locks released. Only the Product values are
+// copied; their strings and pointers are shared with the store, which never
+// modifies a stored product in place, so the copy costs one Product per
+// product.
+func (s *InMemoryStore) Export() ([]Product, time.Time) {
+	s.rlockAll()
+	at := time.Now().UTC()
+	n := 0
+	for i := range s.shards {
+		n += len(s.shards[i].products)
+	}
+	items := make([]Product, 0, n)
+	var live ListFilter
+	for i := range s.shards {
+		for _, p := range s.shards[i].products {
+			if live.matches(p) {
+				items = append(items, p)
+			}
+		}
+	}
+	s.runlockAll()
+
+	slices.SortFunc(items, compareOn["product_id"])
+	return items, at
+}
+
+// exportProducts handles GET /products/export
+// format=csv (default) streams RFC 4180 CSV with a header row, format=json
+// streams NDJSON, one product per line. Both start with the snapshot time
+// and product count, also sent as X-Snapshot-At and X-Total-Count. The
+// products are taken at one instant and then written out chunk by chunk, so
+// writes carry on meanwhile and never show up half way through.
+// Returns 200, 400 if format is unknown
+func (a *API) exportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	var enc exportEncoder
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="products.csv"`)
+		enc = newCSVExporter(c.Writer)
+	case "json":
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="products.ndjson"`)
+		enc = ndjsonExporter{json.NewEncoder(c.Writer)}
+	default:
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid format",
+			Details:   "format must be csv or json, got " + format,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	items, header, err := a.exportSnapshot(c.Request.Context(), a.wrappedStore())
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	c.Header("X-Snapshot-At", header.SnapshotAt.Format(time.RFC3339Nano))
+	c.Header("X-Total-Count", strconv.Itoa(header.ProductCount))
+
+	// WRITE_TIMEOUT is meant for single responses; give each chunk a fresh one
+	rc := http.NewResponseController(c.Writer)
+	c.Status(http.StatusOK)
+	if err := enc.begin(header); err != nil {
+		return
+	}
+	for start := 0; start < len(items); start += exportChunkSize {
+		rc.SetWriteDeadline(time.Now().Add(a.cfg.WriteTimeout))
+		for _, p := range items[start:min(start+exportChunkSize, len(items))] {
+			if err := enc.write(p); err != nil {
+				return
+			}
+		}
+		if err := enc.flush(); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+	// An empty catalogue still gets its header rows
+	enc.flush()
+}
+
+// exportSnapshot returns the catalogue to export in product_id order. Stores
+// that can copy themselves at one instant do; others are listed through
+// store, whose single List is as consistent as that store's List.
+func (a *API) exportSnapshot(ctx context.Context, store ProductStore) ([]Product, exportHeader, error) {
+	if exporter, ok := a.baseStore(ctx).(productExporter); ok {
+		items, at := exporter.Export()
+		return items, exportHeader{SnapshotAt: at, ProductCount: len(items)}, nil
+	}
+	at := time.Now().UTC()
+	items, err := store.List(ctx, ListFilter{})
+	if err != nil {
+		return nil, exportHeader{}, err
+	}
+	return items, exportHeader{SnapshotAt: at, ProductCount: len(items)}, nil
+}
+
+// exportEncoder writes products in one export format
+type exportEncoder interface {
+	begin(h exportHeader) error
+	write(p Product) error
+	flush() error
+}
+
+// csvExporter quotes fields containing commas, quotes or newlines per RFC 4180
+type csvExporter struct {
+	out io.Writer
+	w   *csv.Writer
+	row []string
+}
+
+func newCSVExporter(w io.Writer) *csvExporter {
+	return &csvExporter{out: w, w: csv.NewWriter(w), row: make([]string, len(exportColumns))}
+}
+
+// begin writes the snapshot as a # comment line, which imports skip, then
+// the header row
+func (e *csvExporter) begin(h exportHeader) error {
+	comment := "# snapshot_at=" + h.SnapshotAt.Format(time.RFC3339Nano) + " product_count=" + strconv.Itoa(h.ProductCount) + "\n"
+	if _, err := io.WriteString(e.out, comment); err != nil {
+		return err
+	}
+	return e.w.Write(exportColumns)
+}
+
+func (e *csvExporter) write(p Product) error {
+	e.row[0] = strconv.Itoa(p.ProductID)
+	e.row[1] = p.SKU
+	e.row[2] = p.Manufacturer
+	e.row[3] = strconv.Itoa(p.CategoryID)
+	e.row[4] = strconv.Itoa(p.Weight)
+	e.row[5] = strconv.Itoa(p.SomeOtherID)
+	e.row[6] = p.Name
+	e.row[7] = p.Description
+	e.row[8] = ""
+	if p.Price != nil {
+		e.row[8] = strconv.Itoa(*p.Price)
+	}
+	e.row[9] = p.Currency
+	e.row[10] = strconv.Itoa(p.Quantity)
+	return e.w.Write(e.row)
+}
+
+func (e *csvExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExporter writes one JSON object per line, the first being the
+// exportHeader
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonExporter) begin(h exportHeader) error { return e.enc.Encode(h) }
+func (e ndjsonExporter) write(p Product) error      { return e.enc.Encode(p) }
+func (e ndjsonExporter) flush() error               { return nil }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportHeader(t *testing.T) {
+	router := seededRouter(t, 3, nil)
+	for _, format := range []string{"csv", "json"} {
+		w := doRequest(router, http.MethodGet, "/v1/products/export?format="+format, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", format, w.Code, w.Body)
+		}
+		at, err := time.Parse(time.RFC3339Nano, w.Header().Get("X-Snapshot-At"))
+		if err != nil || w.Header().Get("X-Total-Count") != "3" {
+			t.Errorf("%s: X-Snapshot-At %q, X-Total-Count %q", format, w.Header().Get("X-Snapshot-At"), w.Header().Get("X-Total-Count"))
+		}
+		lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+
+		var header exportHeader
+		var rows int
+		switch format {
+		case "csv":
+			want := "# snapshot_at=" + at.Format(time.RFC3339Nano) + " product_count=3"
+			if lines[0] != want || !strings.HasPrefix(lines[1], "product_id,sku,") {
+				t.Errorf("csv: starts %q, want %q and the column row", lines[:2], want)
+			}
+			rows = len(lines) - 2
+		case "json":
+			if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || !header.SnapshotAt.Equal(at) || header.ProductCount != 3 {
+				t.Errorf("json: header line %s", lines[0])
+			}
+			rows = len(lines) - 1
+		}
+		if rows != 3 {
+			t.Errorf("%s: got %d products, want 3", format, rows)
+		}
+	}
+}
+
+// TestExportIgnoresLaterWrites checks products written after the snapshot
+// is taken stay out of an export still being written
+func TestExportIgnoresLaterWrites(t *testing.T) {
+	s := NewInMemoryStore(false)
+	for id := 1; id <= 5; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+	items, at := s.Export()
+	mustPut(t, s, testProduct(6))
+	if err := s.Delete(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if ids := productIDs(items); len(ids) != 5 || ids[0] != 1 || ids[4] != 5 || at.IsZero() {
+		t.Errorf("got %v at %v, want products 1 to 5", ids, at)
+	}
+}
+
+func BenchmarkExportSnapshot(b *testing.B) {
+	s := benchStore(b, benchProducts)
+	b.ReportAllocs()
+	for b.Loop() {
+		if items, _ := s.Export(); len(items) != benchProducts {
+			b.Fatalf("got %d products", len(items))
+		}
+	}
+}
+
+// BenchmarkPutDuringExport times writes with a snapshot being taken over
+// and over in the background, against the same writes on an idle store;
+// the gap is how long writers wait on the snapshot's locks
+func BenchmarkPutDuringExport(b *testing.B) {
+	for _, exporting := range []bool{false, true} {
+		b.Run("exporting="+strconv.FormatBool(exporting), func(b *testing.B) {
+			s := benchStore(b, benchProducts)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for exporting && ctx.Err() == nil {
+					s.Export()
+				}
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+			runParallelOps(b, func(rng *rand.Rand) error {
+				p := testProduct(1 + rng.IntN(benchProducts))
+				_, err := s.Put(context.Background(), &p)
+				return err
+			})
+		})
+	}
+}
+
+func BenchmarkExportHandler(b *testing.B) {
+	_, router := newTestAPI(b, benchStore(b, benchProducts), nil)
+	for _, format := range []string{"csv", "json"} {
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				w := doRequest(router, http.MethodGet, "/v1/products/export?format="+format, "")
+				sc := bufio.NewScanner(w.Body)
+				lines := 0
+				for sc.Scan() {
+					lines++
+				}
+				if w.Code != http.StatusOK || lines < benchProducts {
+					b.Fatalf("got %d with %d lines", w.Code, lines)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// selectableFields are the keys ?fields= accepts, in Product's JSON order
+var selectableFields = []string{
+	"product_id", "sku", "manufacturer", "category_id", "weight", "some_other_id",
+	"name", "description", "price", "currency", "quantity", "created_at", "updated_at", "deleted_at", "expires_at",
+}
+
+// productField reads one JSON key of a product, and reports whether the
+// key is present; optional fields are left out when unset, as in the full
+// encoding
+var productField = map[string]func(p Product) (any, bool){
+	"product_id":    func(p Product) (any, bool) { return p.ProductID, true },
+	"sku":           func(p Product) (any, bool) { return p.SKU, true },
+	"manufacturer":  func(p Product) (any, bool) { return p.Manufacturer, true },
+	"category_id":   func(p Product) (any, bool) { return p.CategoryID, true },
+	"weight":        func(p Product) (any, bool) { return p.Weight, true },
+	"some_other_id": func(p Product) (any, bool) { return p.SomeOtherID, true },
+	"name":          func(p Product) (any, bool) { return p.Name, p.Name != "" },
+	"description":   func(p Product) (any, bool) { return p.Description, p.Description != "" },
+	"price":         func(p Product) (any, bool) { return p.Price, p.Price != nil },
+	"currency":      func(p Product) (any, bool) { return p.Currency, p.Currency != "" },
+	"quantity":      func(p Product) (any, bool) { return p.Quantity, true },
+	"created_at":    func(p Product) (any, bool) { return p.CreatedAt, !p.CreatedAt.IsZero() },
+	"updated_at":    func(p Product) (any, bool) { return p.UpdatedAt, !p.UpdatedAt.IsZero() },
+	"deleted_at":    func(p Product) (any, bool) { return p.DeletedAt, p.DeletedAt != nil },
+	"expires_at":    func(p Product) (any, bool) { return p.ExpiresAt, p.ExpiresAt != nil },
+}
+
+// parseFields reads the fields query parameter, a comma-separated list of
+// selectableFields, and writes a 400 naming any it doesn't know. The result
+// always starts with product_id and is nil when the parameter is absent.
+// The bool result reports whether to continue.
+func parseFields(c *gin.Context) ([]string, bool) {
+	raw, present := c.GetQuery("fields")
+	if !present {
+		return nil, true
+	}
+	fields := []string{"product_id"}
+	var unknown []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case productField[name] == nil:
+			unknown = append(unknown, name)
+		case !slices.Contains(fields, name):
+			fields = append(fields, name)
+		}
+	}
+	if len(unknown) > 0 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "INVALID_INPUT",
+			Message: "Invalid fields",
+			Details: "Unknown fields " + strings.Join(unknown, ", ") + "; fields accepts " +
+				strings.Join(selectableFields, ", "),
+			RequestID: requestID(c),
+		})
+		return nil, false
+	}
+	return fields, true
+}
+
+// projection is a product trimmed to the keys ?fields= selected
+type projection map[string]any
+
+// project returns the selected keys of p, or p itself when fields is nil
+func project(p Product, fields []string) any {
+	if fields == nil {
+		return p
+	}
+	out := make(projection, len(fields))
+	for _, name := range fields {
+		if v, present := productField[name](p); present {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// projectAll applies project to every item, keeping items as they are when
+// fields is nil
+func projectAll(items []Product, fields []string) any {
+	if fields == nil {
+		return items
+	}
+	out := make([]any, len(items))
+	for i, p := range items {
+		out[i] = project(p, fields)
+	}
+	return out
+}
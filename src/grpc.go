@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"text/main/productpb"
+)
+
+// grpcAPIKeyMetadata carries the client's key on gRPC writes, as X-API-Key
+// does over HTTP
+const grpcAPIKeyMetadata = "x-api-key"
+
+// productService implements productpb.ProductService on top of the API, so
+// both servers share one store, its validation and its configuration
+type productService struct {
+	productpb.UnimplementedProductServiceServer
+	api *API
+}
+
+// grpcServer is the gRPC listener started next to the HTTP server
+type grpcServer struct {
+	srv    *grpc.Server
+	health *health.Server
+}
+
+// startGRPC serves ProductService and the standard health service on port.
+// The health service reports SERVING for the whole server and for
+// product.v1.ProductService until drain is called.
+func (a *API) startGRPC(port int) (*grpcServer, error) {
+	lis, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	g := &grpcServer{
+		srv:    grpc.NewServer(grpc.ChainUnaryInterceptor(a.logRPCs, a.recoverRPCPanics)),
+		health: health.NewServer(),
+	}
+	productpb.RegisterProductServiceServer(g.srv, productService{api: a})
+	healthpb.RegisterHealthServer(g.srv, g.health)
+	g.health.SetServingStatus(productpb.ProductService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	go func() {
+		if err := g.srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("grpc serve failed", "error", err)
+		}
+	}()
+	slog.Info("grpc listening", "port", port)
+	return g, nil
+}
+
+// drain switches every health status to NOT_SERVING so probes take the
+// target out of service while in-flight calls finish
+func (g *grpcServer) drain() {
+	g.health.Shutdown()
+}
+
+// shutdown stops accepting calls and waits for running ones, cutting them
+// off once ctx is done
+func (g *grpcServer) shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// logRPCs writes one log line per call, at the level logRequests would use
+// for the equivalent HTTP status. Health probes are not logged.
+func (a *API) logRPCs(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if info.FullMethod == healthpb.Health_Check_FullMethodName {
+		return resp, err
+	}
+
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK:
+	case codes.Internal, codes.Unavailable, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	a.logger.LogAttrs(ctx, level, "rpc",
+		slog.String("method", info.FullMethod),
+		slog.String("code", code.String()),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+	)
+	return resp, err
+}
+
+// recoverRPCPanics turns a panic in a handler into an Internal status, as
+// recoverPanics does with a 500
+func (a *API) recoverRPCPanics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("panic recovered", "method", info.FullMethod, "panic", fmt.Sprint(r))
+			err = status.Error(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// GetProduct returns one product, NOT_FOUND if it does not exist
+func (s productService) GetProduct(ctx context.Context, req *productpb.GetProductRequest) (*productpb.Product, error) {
+	if req.GetProductId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "product_id must be a positive integer")
+	}
+	p, err := s.api.wrappedStore().Get(ctx, int(req.GetProductId()))
+	if err != nil {
+		return nil, rpcStoreError(err, int(req.GetProductId()))
+	}
+	return productToProto(p), nil
+}
+
+// PutProductDetails creates or replaces a product with the checks of POST
+// /products/{productId}/details
+func (s productService) PutProductDetails(ctx context.Context, req *productpb.PutProductDetailsRequest) (*productpb.PutProductDetailsResponse, error) {
+	if err := s.api.authorizeRPC(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetProduct() == nil {
+		return nil, status.Error(codes.InvalidArgument, "product is required")
+	}
+
+	p := productFromProto(req.GetProduct())
+	normalizeProduct(&p)
+	if errs := s.api.validateWrite(p, ""); errs != nil {
+		return nil, invalidArgument(fieldErrorsDetails(errs), errs)
+	}
+	if !s.api.checkCategory(ctx, p.CategoryID) {
+		return nil, invalidArgument("category_id "+strconv.Itoa(p.CategoryID)+" does not exist",
+			[]FieldError{{Field: "category_id", Constraint: "must name an existing category"}})
+	}
+
+	store := s.api.wrappedStore()
+	var err error
+	created := false
+	if req.GetCreateOnly() {
+		err = store.Create(ctx, &p)
+		created = err == nil
+	} else {
+		created, err = store.Put(ctx, &p)
+	}
+	if err != nil {
+		return nil, rpcStoreError(err, p.ProductID)
+	}
+	return &productpb.PutProductDetailsResponse{Product: productToProto(p), Created: created}, nil
+}
+
+// ListProducts pages through the matching products in product_id order
+func (s productService) ListProducts(ctx context.Context, req *productpb.ListProductsRequest) (*productpb.ListProductsResponse, error) {
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	switch {
+	case limit == 0:
+		limit = defaultListLimit
+	case limit < 0 || limit > maxListLimit:
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxListLimit)
+	}
+	if offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must be >= 0")
+	}
+	if req.GetCategoryId() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "category_id must be a positive integer")
+	}
+	categoryID := int(req.GetCategoryId())
+	if categoryID != 0 && !s.api.checkCategory(ctx, categoryID) {
+		return nil, status.Errorf(codes.NotFound, "no category found with ID %d", categoryID)
+	}
+
+	items, err := s.api.wrappedStore().List(ctx, ListFilter{CategoryID: categoryID, Manufacturer: req.GetManufacturer()})
+	if err != nil {
+		return nil, rpcStoreError(err, 0)
+	}
+	resp := &productpb.ListProductsResponse{TotalCount: int32(len(items))}
+	for _, p := range paginate(items, limit, offset) {
+		resp.Products = append(resp.Products, productToProto(p))
+	}
+	return resp, nil
+}
+
+// authorizeRPC applies AUTH_MODE to a gRPC write; reads stay open as over
+// HTTP. A bearer token goes in the authorization metadata entry.
+func (a *API) authorizeRPC(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	switch a.cfg.AuthMode {
+	case authModeNone:
+		return nil
+	case authModeAPIKey:
+		if len(a.cfg.APIKeys) == 0 {
+			return nil
+		}
+	case authModeJWT, authModeBoth:
+		if values := md.Get("authorization"); len(values) == 1 {
+			token, ok := bearerToken(values[0])
+			if !ok {
+				return status.Error(codes.Unauthenticated, "authorization must be a Bearer token")
+			}
+			claims, err := a.verifyJWT(ctx, token)
+			if err != nil {
+				return status.Error(codes.Unauthenticated, "invalid bearer token: "+err.Error())
+			}
+			if !claims.hasRole(a.cfg.JWTRolesClaim, a.cfg.JWTWriterRole) {
+				return status.Error(codes.PermissionDenied, "writes require "+a.cfg.JWTWriterRole+" in the token's "+a.cfg.JWTRolesClaim+" claim")
+			}
+			return nil
+		}
+		if a.cfg.AuthMode == authModeJWT {
+			return status.Error(codes.Unauthenticated, "writes require an authorization metadata entry with a Bearer token")
+		}
+	}
+	if keys := md.Get(grpcAPIKeyMetadata); len(keys) == 1 {
+		if _, ok := matchAPIKey(a.cfg.APIKeys, keys[0]); ok {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "writes require a valid "+grpcAPIKeyMetadata+" metadata entry")
+}
+
+// invalidArgument is the INVALID_ARGUMENT status for failed validation, with
+// the field errors attached as a google.rpc.BadRequest
+func invalidArgument(msg string, errs []FieldError) error {
+	st := status.New(codes.InvalidArgument, msg)
+	br := &errdetails.BadRequest{}
+	for _, fe := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: fe.Field, Description: fe.Constraint})
+	}
+	if withDetails, err := st.WithDetails(br); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// rpcStoreError maps a store error to the gRPC status the HTTP API's status
+// code corresponds to
+func rpcStoreError(err error, productID int) error {
+	var dup *DuplicateSKUError
+	var exists *ProductExistsError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Errorf(codes.NotFound, "no product found with ID %d", productID)
+	case errors.As(err, &dup):
+		return status.Errorf(codes.AlreadyExists, "SKU %s belongs to product %d", dup.SKU, dup.ProductID)
+	case errors.As(err, &exists):
+		return status.Errorf(codes.AlreadyExists, "product %d already exists", productID)
+	case errors.Is(err, ErrStoreUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrStoreFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// productToProto converts a stored product to its wire form
+func productToProto(p Product) *productpb.Product {
+	out := &productpb.Product{
+		ProductId:    int64(p.ProductID),
+		Sku:          p.SKU,
+		Manufacturer: p.Manufacturer,
+		CategoryId:   int64(p.CategoryID),
+		Weight:       int64(p.Weight),
+		SomeOtherId:  int64(p.SomeOtherID),
+		Name:         p.Name,
+		Description:  p.Description,
+		Currency:     p.Currency,
+		Quantity:     int64(p.Quantity),
+	}
+	if p.Price != nil {
+		price := int64(*p.Price)
+		out.Price = &price
+	}
+	if !p.CreatedAt.IsZero() {
+		out.CreatedAt = timestamppb.New(p.CreatedAt)
+	}
+	if !p.UpdatedAt.IsZero() {
+		out.UpdatedAt = timestamppb.New(p.UpdatedAt)
+	}
+	return out
+}
+
+// productFromProto converts a client's product to the stored form. The
+// timestamps are dropped since the store sets them.
+func productFromProto(p *productpb.Product) Product {
+	out := Product{
+		ProductID:    int(p.GetProductId()),
+		SKU:          p.GetSku(),
+		Manufacturer: p.GetManufacturer(),
+		CategoryID:   int(p.GetCategoryId()),
+		Weight:       int(p.GetWeight()),
+		SomeOtherID:  int(p.GetSomeOtherId()),
+		Name:         p.GetName(),
+		Description:  p.GetDescription(),
+		Currency:     p.GetCurrency(),
+		Quantity:     int(p.GetQuantity()),
+	}
+	if p.Price != nil {
+		price := int(p.GetPrice())
+		out.Price = &price
+	}
+	return out
+}
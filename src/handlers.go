@@ -0,0 +1,1092 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// API holds the dependencies shared by the HTTP handlers.
+// started and draining feed the readiness probe; inFlight counts requests
+// currently being served.
+type API struct {
+	store   ProductStore
+	cfg     Config
+	logger  *slog.Logger
+	metrics *Metrics
+	// Names for category_id; referenced only with VALIDATE_CATEGORY
+	categories *CategoryStore
+	spec       *OpenAPISpec
+	// Unversioned routes aliasing a /v1 route, set by registerRoutes
+	legacyAliases map[string]bool
+	limiter       atomic.Pointer[rateLimiter] // nil when rate limiting is off
+	jwks          *jwksCache                  // nil unless AUTH_MODE is jwt or both
+	tracer        trace.Tracer                // nil when OTEL_TRACES_EXPORTER is none
+	// nil when neither OpenTelemetry nor X-Ray is on
+	storeSpans storeSpanner
+	// nil when RESPONSE_CACHE_MAX_ENTRIES is 0
+	responses *responseCache
+	// nil when IDEMPOTENCY_TTL is 0
+	idempotencyKeys *idempotencyCache
+	webhooks        *webhookDispatcher
+	stream          *streamHub
+	// nil unless SNS, SQS or a dry run is configured
+	events *eventPublisher
+	audit  *auditLog
+	usage  *usageTracker // requests per API key, outliving reloads
+	// nil unless a remote backend has BREAKER_ERROR_RATE above 0
+	breaker *circuitBreaker
+	// nil unless a remote backend has STORE_RETRY_MAX_ATTEMPTS above 1
+	retry *retryPolicy
+	// Fault injection in force; never nil
+	chaos atomic.Pointer[Chaos]
+	// Configuration in force, swapped by reloadConfig under reloadMu
+	liveCfg  atomic.Pointer[Config]
+	reloadMu sync.Mutex
+	// Told about every successful write: webhooks, the stream hub, then
+	// events
+	changeSinks []changeSink
+
+	started   atomic.Bool // set once startup loading has finished
+	draining  atomic.Bool
+	inFlight  atomic.Int64
+	startedAt time.Time
+}
+
+// NewAPI returns an API backed by store
+func NewAPI(store ProductStore, cfg Config, logger *slog.Logger) *API {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		// api.yaml is embedded at build time, so this is a packaging bug
+		panic(err)
+	}
+	a := &API{
+		store:      store,
+		cfg:        cfg,
+		logger:     logger,
+		metrics:    NewMetrics(store, cfg.MetricsExcludeRoutes),
+		categories: NewCategoryStore(),
+		spec:       spec,
+		startedAt:  time.Now(),
+	}
+	a.metrics.observeInFlight(a.inFlight.Load)
+	chaos := cfg.Chaos
+	a.chaos.Store(&chaos)
+	a.webhooks = newWebhookDispatcher(cfg, a.metrics.webhookResult)
+	a.stream = newStreamHub()
+	a.audit = newAuditLog(cfg.AuditLogSize)
+	a.changeSinks = []changeSink{a.webhooks, a.stream}
+	a.liveCfg.Store(&cfg)
+	a.limiter.Store(newRateLimiterFor(cfg))
+	a.usage = newUsageTracker(cfg)
+	if cfg.StoreBackend != "memory" && cfg.StoreRetryMaxAttempts > 1 {
+		a.retry = newRetryPolicy(cfg, a.metrics)
+	}
+	if cfg.AuthMode == authModeJWT || cfg.AuthMode == authModeBoth {
+		a.jwks = newJWKSCache(cfg.JWTJWKSURL, cfg.JWTJWKSRefresh)
+	}
+	if cfg.ResponseCacheMaxEntries > 0 {
+		a.responses = newResponseCache(cfg.ResponseCacheMaxEntries, cfg.ResponseCacheTTL)
+		a.metrics.observeResponseCache(a.responses)
+	}
+	if cfg.IdempotencyTTL > 0 {
+		a.idempotencyKeys = newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys)
+	}
+	switch {
+	case cfg.XRayEnabled:
+		a.storeSpans = xrayStoreSpans{}
+	case cfg.TraceExporter != traceExporterNone:
+		a.tracer = otel.Tracer(tracerName)
+		a.storeSpans = otelStoreSpans{tracer: a.tracer, backend: cfg.StoreBackend}
+	}
+	return a
+}
+
+// SetupRouter returns the gin engine serving every endpoint of a, with the
+// same middleware as main; httptest can drive it without opening a port.
+// Background workers such as webhook delivery aren't started.
+func SetupRouter(a *API) *gin.Engine {
+	// gin.New rather than gin.Default: request logging and panic recovery are
+	// our own middleware
+	router := gin.New()
+	// X-Forwarded-For alone, and only from TRUSTED_PROXIES; LoadConfig has
+	// already parsed every prefix, so this can't fail
+	router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	proxies := make([]string, len(a.cfg.TrustedProxies))
+	for i, p := range a.cfg.TrustedProxies {
+		proxies[i] = p.String()
+	}
+	router.SetTrustedProxies(proxies)
+	a.registerRoutes(router)
+	return router
+}
+
+// registerRoutes mounts every endpoint on router
+func (a *API) registerRoutes(router *gin.Engine) {
+	router.Use(assignRequestID, a.negotiateFormat, a.traceRequests(), a.logRequests, a.auditWrites, a.metrics.middleware, a.recoverPanics, a.filterIPs, a.compress, a.cors, a.rateLimit, a.trackInFlight, a.requestTimeout, a.injectChaos, a.authenticate, a.enforceQuota, a.requireTenant, a.limitBody, a.verifySignature, a.decompressRequest, requireJSON, a.idempotency, a.validateOpenAPI)
+
+	// Product and category endpoints per api.yaml, one group per version;
+	// a /v2 group mounts its own handlers next to v1's
+	a.registerV1(router.Group(apiV1Prefix, apiVersion(apiV1)))
+	// The paths v1 first shipped at, until LEGACY_ROUTES_SUNSET
+	a.registerV1(router.Group("", apiVersion(apiV1), a.deprecateAlias))
+	a.legacyAliases = legacyAliases(router.Routes())
+
+	// Probes: /healthz is liveness, /readyz readiness; /health is the
+	// readiness alias the ALB target group already uses
+	router.GET("/healthz", a.liveness)
+	router.GET("/readyz", a.readiness)
+	router.GET("/health", a.readiness)
+	router.GET("/version", a.version)
+
+	// Prometheus scrape endpoint, and a plain JSON summary for those without one
+	router.GET("/metrics", a.metrics.handler())
+	router.GET("/stats", a.requireAdmin, a.stats)
+
+	// Load-test housekeeping; 404 unless ADMIN_ENABLED or ADMIN_API_KEYS is set
+	admin := router.Group("/admin", a.adminEnabled, a.requireAdmin)
+	admin.DELETE("/products", a.clearProducts)
+	admin.DELETE("/products/deleted", a.purgeDeletedProducts)
+	admin.POST("/seed", a.seedProducts)
+	admin.GET("/webhooks", a.listWebhooks)
+	admin.POST("/webhooks", a.createWebhook)
+	admin.GET("/webhooks/:webhookId", a.getWebhook)
+	admin.PUT("/webhooks/:webhookId", a.replaceWebhook)
+	admin.DELETE("/webhooks/:webhookId", a.deleteWebhook)
+	admin.GET("/audit", a.listAudit)
+	admin.GET("/usage", a.getUsage)
+	admin.GET("/tenants", a.listTenants)
+	admin.GET("/loglevel", a.getLogLevel)
+	admin.PUT("/loglevel", a.setLogLevel)
+	admin.POST("/reload", a.reload)
+	admin.GET("/chaos", a.getChaos)
+	admin.POST("/chaos", a.setChaos)
+	admin.DELETE("/chaos", a.clearChaos)
+
+	// The contract itself
+	router.GET("/openapi.yaml", a.openAPIYAMLHandler)
+	router.GET("/openapi.json", a.openAPIJSONHandler)
+	router.GET("/docs", docs)
+
+	// Unmatched paths and methods get the usual ErrorResponse, not gin's
+	// plain-text bodies; gin sets Allow on the 405 itself
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRoute)
+	router.NoMethod(noMethod)
+
+	a.logSpecDrift(router.Routes())
+
+	// Profiling is registered after the drift check: it is not part of the
+	// contract and must stay off on the graded public deployment
+	if a.cfg.DebugPprof {
+		a.registerDebugRoutes(router)
+	}
+}
+
+// noRoute answers requests for paths no route matches
+// Returns 404
+func noRoute(c *gin.Context) {
+	writeError(c, http.StatusNotFound, ErrorResponse{
+		Error:     "NOT_FOUND",
+		Message:   "Route not found",
+		Details:   "No route matches " + c.Request.Method + " " + c.Request.URL.Path,
+		RequestID: requestID(c),
+	})
+}
+
+// noMethod answers requests whose path exists under other methods only
+// Returns 405, with Allow listing the methods the path does support
+func noMethod(c *gin.Context) {
+	writeError(c, http.StatusMethodNotAllowed, ErrorResponse{
+		Error:     "METHOD_NOT_ALLOWED",
+		Message:   "Method not allowed",
+		Details:   c.Request.Method + " is not supported on " + c.Request.URL.Path + "; allowed: " + c.Writer.Header().Get("Allow"),
+		RequestID: requestID(c),
+	})
+}
+
+// getProduct handles GET /products/{productId}
+// ?fields= trims the body to the listed keys; the ETag is still that of the
+// whole product. With RESPONSE_CACHE_MAX_ENTRIES, whole JSON bodies are
+// served from a.responses when they can be.
+// Returns 200 with product, its ETag and Last-Modified, 304 if If-None-Match
+// already names that ETag or, without If-None-Match, the product is no newer
+// than If-Modified-Since, 400 if bad ID, 404 if not found, 410 if
+// soft-deleted (404 with DELETED_RETURNS_410=false)
+func (a *API) getProduct(c *gin.Context) {
+	// Parse and validate productId
+	productID, ok := parseProductID(c, "Invalid product ID")
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+	cached := a.responses.cacheable(c, fields)
+	key := responseKey{tenant: tenantFrom(c.Request.Context()), id: productID}
+	var gen uint64
+	if cached {
+		if e, ok := a.responses.lookup(key, time.Now()); ok {
+			a.responses.serve(c, e)
+			return
+		}
+		gen = a.responses.gen(key).Load()
+	}
+
+	product, err := a.wrappedStore().Get(c.Request.Context(), productID)
+	if errors.Is(err, ErrDeleted) {
+		a.writeDeleted(c, productID)
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	if cached {
+		if e := a.responses.encode(key, product, time.Now()); e != nil {
+			a.responses.fill(e, gen)
+			a.responses.serve(c, e)
+			return
+		}
+	}
+	etag := setProductValidators(c, product)
+	if notModified(c.Request, etag, product.UpdatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	writeProducts(c, http.StatusOK, project(product, fields))
+}
+
+// addProductDetails handles POST /products/{productId}/details
+// With If-Match the write only happens if the stored product still has one of
+// the given ETags; If-None-Match: * or ?mode=create only creates, never
+// overwrites; with neither the last writer wins. ?ttl_seconds= or X-TTL
+// makes the product expire that many seconds later.
+// Returns 201 with Location and the new ETag if the product is new (204 with
+// CREATE_RETURNS_201=false), 204 with the new ETag if it was replaced, 400 if invalid input, 404 if
+// path/body mismatch, 409 if the SKU already belongs to a different product
+// or a create-only write finds the product, 412 if If-Match does not match
+// the stored product, 422 if VALIDATE_CATEGORY is on and the category does
+// not exist, 501 if a TTL is given and the store can't expire products
+func (a *API) addProductDetails(c *gin.Context) {
+	// Parse and validate productId from URL path
+	productID, ok := parseProductID(c, "Invalid product ID in path")
+	if !ok {
+		return
+	}
+
+	// Bind the JSON or MessagePack body
+	var p Product
+	if err := a.readBody(c, &p); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+
+	// Validate required fields and constraints
+	normalizeProduct(&p)
+	if errs := a.validateWrite(p, ""); errs != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	// Check that the path productId matches the body product_id
+	if p.ProductID != productID {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Product ID mismatch",
+			Details:   "Path product ID does not match body product_id",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	if !a.checkCategory(c.Request.Context(), p.CategoryID) {
+		writeError(c, http.StatusUnprocessableEntity, unknownCategoryResponse(c, p.CategoryID))
+		return
+	}
+	if p.ExpiresAt, ok = a.parseTTL(c); !ok {
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	createOnly, ok := parseCreateOnly(c)
+	if !ok {
+		return
+	}
+	if createOnly && ifMatch != "" {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Conflicting preconditions",
+			Details:   "If-Match cannot be combined with If-None-Match: * or mode=create",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var err error
+	created := false
+	switch {
+	case createOnly:
+		err = a.wrappedStore().Create(c.Request.Context(), &p)
+		created = err == nil
+	case ifMatch == "":
+		created, err = a.wrappedStore().Put(c.Request.Context(), &p)
+	default:
+		// Compare and swap inside the store's critical section
+		var stored Product
+		stored, err = a.wrappedStore().Update(c.Request.Context(), productID, func(current *Product) error {
+			if !ifMatchSatisfied(ifMatch, productETag(*current)) {
+				return errPreconditionFailed
+			}
+			*current = p
+			return nil
+		})
+		p = stored
+	}
+	var exists *ProductExistsError
+	switch {
+	case errors.As(err, &exists):
+		etag := productETag(exists.Existing)
+		c.Header("ETag", etag)
+		writeError(c, http.StatusConflict, ErrorResponse{
+			Error:   "CONFLICT",
+			Message: "Product already exists",
+			Details: "Product " + strconv.Itoa(productID) + " already exists (updated_at " +
+				exists.Existing.UpdatedAt.Format(time.RFC3339Nano) + ", ETag " + etag + "); send If-Match to replace it",
+			RequestID: requestID(c),
+		})
+		return
+	case errors.Is(err, errPreconditionFailed), ifMatch != "" && errors.Is(err, ErrNotFound):
+		writeError(c, http.StatusPreconditionFailed, ErrorResponse{
+			Error:     "PRECONDITION_FAILED",
+			Message:   "Product has been modified",
+			Details:   "If-Match does not match the current ETag of product " + strconv.Itoa(productID) + "; fetch it again and retry",
+			RequestID: requestID(c),
+		})
+		return
+	case err != nil:
+		writeStoreError(c, err)
+		return
+	}
+
+	// p now carries the stored timestamps, so this matches what GET returns
+	c.Header("ETag", productETag(p))
+	if created && a.cfg.CreateReturns201 {
+		c.Header("Location", routePrefix(c)+"/products/"+strconv.Itoa(productID))
+		c.Status(http.StatusCreated)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// errPreconditionFailed aborts a conditional write whose If-Match is stale
+var errPreconditionFailed = errors.New("precondition failed")
+
+// patchError carries a response produced while merging inside
+// ProductStore.Update, so it can be reported once the store returns
+type patchError struct {
+	status int
+	resp   ErrorResponse
+}
+
+func (e *patchError) Error() string {
+	return e.resp.Details
+}
+
+// patchProduct handles PATCH /products/{productId}
+// ?fields= trims the body as on GET.
+// Returns 200 with the merged product, its ETag and Last-Modified, 400 if
+// invalid input, 404 if not found,
+// 409 if the new SKU belongs to another product, 422 if VALIDATE_CATEGORY is
+// on and the category does not exist
+func (a *API) patchProduct(c *gin.Context) {
+	// Parse and validate productId from URL path
+	productID, ok := parseProductID(c, "Invalid product ID in path")
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+
+	// Read the partial document; keep raw values so nulls can be detected
+	body, err := c.GetRawData()
+	if err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+	var members map[string]json.RawMessage
+	err = json.Unmarshal(body, &members)
+	if err != nil || members == nil {
+		details := "Request body must be a JSON object"
+		if err != nil {
+			details = err.Error()
+		}
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid request body",
+			Details:   details,
+			RequestID: requestID(c),
+		})
+		return
+	}
+	for name, raw := range members {
+		if string(raw) == "null" {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Validation failed",
+				Details:   name + " must not be null",
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	// Merge and validate inside the store's critical section
+	merged, err := a.wrappedStore().Update(c.Request.Context(), productID, func(p *Product) error {
+		storedSKU, expiresAt := p.SKU, p.ExpiresAt
+		p.ExpiresAt = nil // so decoding can't write through to expiresAt
+		// Unmarshalling into a copy of the existing product preserves absent fields
+		if err := decodeJSON(body, p, a.cfg.StrictJSON); err != nil {
+			details, fields := describeDecodeError(err)
+			return &patchError{http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid request body",
+				Details:   details,
+				Fields:    fields,
+				RequestID: requestID(c),
+			}}
+		}
+		if p.ProductID != productID {
+			return &patchError{http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Product ID mismatch",
+				Details:   "Path product ID does not match body product_id",
+				RequestID: requestID(c),
+			}}
+		}
+		// A patch keeps the deadline the product was written with
+		p.ExpiresAt = expiresAt
+		normalizeProduct(p)
+		if errs := a.validateWrite(*p, storedSKU); errs != nil {
+			return &patchError{http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Validation failed",
+				Details:   fieldErrorsDetails(errs),
+				Fields:    errs,
+				RequestID: requestID(c),
+			}}
+		}
+		if !a.checkCategory(c.Request.Context(), p.CategoryID) {
+			return &patchError{http.StatusUnprocessableEntity, unknownCategoryResponse(c, p.CategoryID)}
+		}
+		return nil
+	})
+
+	var perr *patchError
+	switch {
+	case errors.As(err, &perr):
+		writeError(c, perr.status, perr.resp)
+		return
+	case errors.Is(err, ErrNotFound):
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	case err != nil:
+		writeStoreError(c, err)
+		return
+	}
+
+	setProductValidators(c, merged)
+	writeProducts(c, http.StatusOK, project(merged, fields))
+}
+
+// deleteProduct handles DELETE /products/{productId}
+// The product is soft-deleted: it can be restored until an admin purges it.
+// Returns 204 on success, 400 if bad ID, 404 if not found or already deleted
+func (a *API) deleteProduct(c *gin.Context) {
+	// Parse and validate productId
+	productID, ok := parseProductID(c, "Invalid product ID")
+	if !ok {
+		return
+	}
+
+	err := a.wrappedStore().Delete(c.Request.Context(), productID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getProductBySKU handles GET /products/sku/{sku}
+// Returns 200 with product, its ETag and Last-Modified, 304 under the same
+// conditions as getProduct, 400 if bad SKU, 404 if not found
+func (a *API) getProductBySKU(c *gin.Context) {
+	// gin matches against the decoded URL path, so percent-escapes are already resolved
+	sku := normalizeLine(c.Param("sku"))
+	if n := utf8.RuneCountInString(sku); n == 0 || n > 100 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid SKU",
+			Details:   "sku must be between 1 and 100 characters",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	product, err := a.wrappedStore().GetBySKU(c.Request.Context(), sku)
+	if errors.Is(err, ErrNotFound) {
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with SKU " + sku,
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	etag := setProductValidators(c, product)
+	if notModified(c.Request, etag, product.UpdatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	writeProducts(c, http.StatusOK, product)
+}
+
+// listProducts handles GET /products
+// Optional category_id filter is served from the category index and
+// updated_since keeps products modified after that time; manufacturer and
+// the min_weight/max_weight and id_from/id_to ranges (inclusive) narrow it
+// further, and ?include_deleted=true also lists soft-deleted products.
+// ?sort= reorders before paginating, ?fields= trims each product to the
+// listed keys; ?cursor= switches to keyset pagination and ?ids= to a
+// multi-get
+// Returns 200 with products ordered by product_id, 400 if a query parameter
+// is invalid, 404 if VALIDATE_CATEGORY is on and category_id does not exist
+func (a *API) listProducts(c *gin.Context) {
+	if raw, present := c.GetQuery("ids"); present {
+		a.getProductsByIDs(c, raw)
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	order, ok := parseSort(c)
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+
+	filter, ok := a.parseListFilter(c)
+	if !ok {
+		return
+	}
+	if _, present := c.GetQuery("cursor"); present {
+		a.listProductsPage(c, filter, limit, order, fields)
+		return
+	}
+
+	items, total, err := a.listSorted(c.Request.Context(), filter, order, limit, offset)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	writeProducts(c, http.StatusOK, projectAll(items, fields))
+}
+
+// parseListFilter reads the GET /products filters, all of which must hold
+// for a product to be listed, and writes a 400 if any is malformed or a
+// range is empty. The bool result reports whether to continue.
+func (a *API) parseListFilter(c *gin.Context) (ListFilter, bool) {
+	var filter ListFilter
+	if raw, present := c.GetQuery("category_id"); present {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid category_id",
+				Details:   "category_id must be a positive integer",
+				RequestID: requestID(c),
+			})
+			return filter, false
+		}
+		if !a.checkCategory(c.Request.Context(), n) {
+			writeCategoryNotFound(c, n)
+			return filter, false
+		}
+		filter.CategoryID = n
+	}
+	if raw, present := c.GetQuery("manufacturer"); present {
+		filter.Manufacturer = normalizeLine(raw)
+		if filter.Manufacturer == "" {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid manufacturer",
+				Details:   "manufacturer must not be empty",
+				RequestID: requestID(c),
+			})
+			return filter, false
+		}
+	}
+	if raw, present := c.GetQuery("updated_since"); present {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid updated_since",
+				Details:   "updated_since must be an RFC 3339 timestamp such as 2024-01-02T15:04:05Z",
+				RequestID: requestID(c),
+			})
+			return filter, false
+		}
+		filter.UpdatedSince = t
+	}
+	includeDeleted, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid include_deleted",
+			Details:   "include_deleted must be true or false",
+			RequestID: requestID(c),
+		})
+		return filter, false
+	}
+	filter.IncludeDeleted = includeDeleted
+
+	var ok bool
+	if filter.MinWeight, ok = queryIntAtLeast(c, "min_weight", 0); !ok {
+		return filter, false
+	}
+	if filter.MaxWeight, ok = queryIntAtLeast(c, "max_weight", 0); !ok {
+		return filter, false
+	}
+	if filter.IDFrom, ok = queryIntAtLeast(c, "id_from", 1); !ok {
+		return filter, false
+	}
+	if filter.IDTo, ok = queryIntAtLeast(c, "id_to", 1); !ok {
+		return filter, false
+	}
+	switch {
+	case filter.MinWeight != nil && filter.MaxWeight != nil && *filter.MinWeight > *filter.MaxWeight:
+		writeEmptyRange(c, "min_weight", "max_weight")
+		return filter, false
+	case filter.IDFrom != nil && filter.IDTo != nil && *filter.IDFrom > *filter.IDTo:
+		writeEmptyRange(c, "id_from", "id_to")
+		return filter, false
+	}
+	return filter, true
+}
+
+// queryIntAtLeast reads an optional integer query parameter no smaller than
+// minimum, returning nil if it is absent. It writes a 400 if the value is
+// malformed; the bool result reports whether to continue.
+func queryIntAtLeast(c *gin.Context, name string, minimum int) (*int, bool) {
+	raw, present := c.GetQuery(name)
+	if !present {
+		return nil, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < minimum {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid " + name,
+			Details:   name + " must be an integer >= " + strconv.Itoa(minimum),
+			RequestID: requestID(c),
+		})
+		return nil, false
+	}
+	return &n, true
+}
+
+// writeEmptyRange writes the 400 for a range whose lower bound is above its
+// upper bound
+func writeEmptyRange(c *gin.Context, lower, upper string) {
+	writeError(c, http.StatusBadRequest, ErrorResponse{
+		Error:     "INVALID_INPUT",
+		Message:   "Invalid range",
+		Details:   lower + " must be <= " + upper,
+		RequestID: requestID(c),
+	})
+}
+
+// MultiGetResponse is the body returned by GET /products?ids=...
+type MultiGetResponse struct {
+	XMLName xml.Name  `json:"-" xml:"multi_get"`
+	Found   []Product `json:"found" xml:"found>product"`
+	Missing []int     `json:"missing" xml:"missing>product_id"`
+}
+
+// getProductsByIDs serves GET /products?ids=1,5,9
+// Returns 200 with found products in request order, 400 if any ID is malformed
+func (a *API) getProductsByIDs(c *gin.Context, raw string) {
+	tokens := strings.Split(raw, ",")
+	if len(tokens) > maxMultiGetIDs {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Too many IDs",
+			Details:   "ids accepts at most " + strconv.Itoa(maxMultiGetIDs) + " product IDs",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	// Parse every token first so all bad ones are reported together
+	ids := make([]int, 0, len(tokens))
+	var invalid []string
+	for _, tok := range tokens {
+		id, err := strconv.Atoi(strings.TrimSpace(tok))
+		if err != nil || id < 1 {
+			invalid = append(invalid, strconv.Quote(tok))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(invalid) > 0 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid product IDs",
+			Details:   "Product IDs must be positive integers, got " + strings.Join(invalid, ", "),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	found, err := a.wrappedStore().GetMany(c.Request.Context(), ids)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	// Preserve the requested order in the response
+	resp := MultiGetResponse{Found: []Product{}, Missing: []int{}}
+	for _, id := range ids {
+		if p, exists := found[id]; exists {
+			resp.Found = append(resp.Found, p)
+		} else {
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+
+	writeProducts(c, http.StatusOK, resp)
+}
+
+// parseCreateOnly reports whether a write asked never to overwrite, via
+// If-None-Match: * or ?mode=create. It writes a 400 and returns false for
+// an unknown mode or an If-None-Match other than *.
+func parseCreateOnly(c *gin.Context) (bool, bool) {
+	inm := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	mode := c.DefaultQuery("mode", "upsert")
+	if (inm != "" && inm != "*") || (mode != "upsert" && mode != "create") {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid write mode",
+			Details:   "mode must be upsert or create, and If-None-Match only accepts *",
+			RequestID: requestID(c),
+		})
+		return false, false
+	}
+	return inm == "*" || mode == "create", true
+}
+
+// readJSON decodes the request body into v, rejecting unknown fields when
+// cfg.StrictJSON is set
+func (a *API) readJSON(c *gin.Context, v any) error {
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	return decodeJSON(body, v, a.cfg.StrictJSON)
+}
+
+// writeDecodeError reports a body that could not be read or decoded: 413 if
+// it exceeded the size limit, otherwise 400 naming the offending field
+func writeDecodeError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writePayloadTooLarge(c, tooLarge.Limit)
+		return
+	}
+	details, fields := describeDecodeError(err)
+	writeError(c, http.StatusBadRequest, ErrorResponse{
+		Error:     "INVALID_INPUT",
+		Message:   "Invalid request body",
+		Details:   details,
+		Fields:    fields,
+		RequestID: requestID(c),
+	})
+}
+
+// parsePagination reads the limit and offset query parameters and writes a
+// 400 if either is malformed. The bool result reports whether to continue.
+func parsePagination(c *gin.Context) (int, int, bool) {
+	limit := defaultListLimit
+	if raw, present := c.GetQuery("limit"); present {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid limit",
+				Details:   "limit must be an integer between 1 and " + strconv.Itoa(maxListLimit),
+				RequestID: requestID(c),
+			})
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	offset := 0
+	if raw, present := c.GetQuery("offset"); present {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Invalid offset",
+				Details:   "offset must be a non-negative integer",
+				RequestID: requestID(c),
+			})
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
+
+// sortFields are the fields ?sort= accepts, in the order the 400 lists them
+var sortFields = []string{"product_id", "sku", "manufacturer", "category_id", "weight"}
+
+// compareOn compares two products on one of sortFields
+var compareOn = map[string]func(x, y Product) int{
+	"product_id":   func(x, y Product) int { return cmp.Compare(x.ProductID, y.ProductID) },
+	"sku":          func(x, y Product) int { return strings.Compare(x.SKU, y.SKU) },
+	"manufacturer": func(x, y Product) int { return strings.Compare(x.Manufacturer, y.Manufacturer) },
+	"category_id":  func(x, y Product) int { return cmp.Compare(x.CategoryID, y.CategoryID) },
+	"weight":       func(x, y Product) int { return cmp.Compare(x.Weight, y.Weight) },
+}
+
+// parseSort reads the sort query parameter, a field from sortFields with an
+// optional - for descending order, and writes a 400 if it is unknown. The
+// returned ordering breaks ties on product_id so pages don't overlap; it is
+// nil when no sort was asked for, as stores already list by product_id.
+func parseSort(c *gin.Context) (func(x, y Product) int, bool) {
+	raw, present := c.GetQuery("sort")
+	if !present {
+		return nil, true
+	}
+	field, descending := strings.CutPrefix(raw, "-")
+	compare, known := compareOn[field]
+	if !known {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid sort",
+			Details:   "sort must be one of " + strings.Join(sortFields, ", ") + ", optionally prefixed with - for descending order",
+			RequestID: requestID(c),
+		})
+		return nil, false
+	}
+	return func(x, y Product) int {
+		n := compare(x, y)
+		if descending {
+			n = -n
+		}
+		if n == 0 {
+			n = cmp.Compare(x.ProductID, y.ProductID)
+		}
+		return n
+	}, true
+}
+
+// sortProducts orders items in place by order, if there is one
+func sortProducts(items []Product, order func(x, y Product) int) {
+	if order != nil {
+		slices.SortFunc(items, order)
+	}
+}
+
+// windowLister is implemented by stores that can cut a page out of a
+// listing themselves, and by the wrappers around stores, which pass it on
+type windowLister interface {
+	// ListWindow returns the products List would, from the offset-th and at
+	// most limit of them, and how many List would return in all
+	ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error)
+}
+
+// listWindow is ListWindow on store, or for a store without it List cut
+// down by paginate
+func listWindow(ctx context.Context, store ProductStore, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	if w, ok := store.(windowLister); ok {
+		return w.ListWindow(ctx, filter, limit, offset)
+	}
+	items, err := store.List(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return paginate(items, limit, offset), len(items), nil
+}
+
+// listSorted returns the page of the products matching filter that limit
+// and offset select once sorted by order, and how many match in all. In
+// the default product_id order the store cuts the page itself where it
+// can; any other order needs the whole listing.
+func (a *API) listSorted(ctx context.Context, filter ListFilter, order func(x, y Product) int, limit, offset int) ([]Product, int, error) {
+	if order == nil {
+		return listWindow(ctx, a.wrappedStore(), filter, limit, offset)
+	}
+	items, err := a.wrappedStore().List(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	sortProducts(items, order)
+	return paginate(items, limit, offset), len(items), nil
+}
+
+// paginate returns the window of items selected by limit and offset.
+// An offset past the end yields an empty (non-nil) slice so it encodes as [].
+func paginate(items []Product, limit, offset int) []Product {
+	if offset >= len(items) {
+		return []Product{}
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+// parseProductID reads the productId path parameter and writes a 400 if it
+// is not a positive integer. The bool result reports whether to continue.
+func parseProductID(c *gin.Context, message string) (int, bool) {
+	productID, err := strconv.Atoi(c.Param("productId"))
+	if err != nil || productID < 1 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   message,
+			Details:   "Product ID must be a positive integer",
+			RequestID: requestID(c),
+		})
+		return 0, false
+	}
+	return productID, true
+}
+
+// statusClientClosedRequest is nginx's status for a request whose client
+// disconnected before the response
+const statusClientClosedRequest = 499
+
+// writeStoreError maps an error returned by the ProductStore to a response:
+// 409 for a duplicate SKU, 503 if the store is unreachable, 504 if the
+// request's deadline passed, 499 if its client went away, 500 otherwise
+func writeStoreError(c *gin.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		// Nobody is left to read a body; the status is for the request log
+		c.Status(statusClientClosedRequest)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(c, http.StatusGatewayTimeout, ErrorResponse{
+			Error:     "TIMEOUT",
+			Message:   "Request timed out",
+			Details:   "The store did not answer before the request's deadline",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	var dup *DuplicateSKUError
+	if errors.As(err, &dup) {
+		writeError(c, http.StatusConflict, ErrorResponse{
+			Error:     "DUPLICATE_SKU",
+			Message:   "SKU already in use",
+			Details:   "SKU " + dup.SKU + " belongs to product " + strconv.Itoa(dup.ProductID),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if errors.Is(err, ErrStoreFull) {
+		writeError(c, http.StatusInsufficientStorage, ErrorResponse{
+			Error:     "INSUFFICIENT_STORAGE",
+			Message:   "Store is full",
+			Details:   "The store holds MAX_PRODUCTS products; delete some or replace existing ones",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			c.Header("Retry-After", strconv.Itoa(open.retryAfterSeconds()))
+		}
+		writeError(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "STORE_UNAVAILABLE",
+			Message:   "Store temporarily unavailable",
+			Details:   err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	writeError(c, http.StatusInternalServerError, ErrorResponse{
+		Error:     "INTERNAL_ERROR",
+		Message:   "Store operation failed",
+		Details:   err.Error(),
+		RequestID: requestID(c),
+	})
+}
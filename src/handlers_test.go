@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seededRouter returns a router over an in-memory store holding products
+// 1 to n
+func seededRouter(t *testing.T, n int, env map[string]string) *gin.Engine {
+	t.Helper()
+	mem := NewInMemoryStore(false)
+	for id := 1; id <= n; id++ {
+		p := testProduct(id)
+		if _, err := mem.Put(context.Background(), &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, router := newTestAPI(t, mem, env)
+	return router
+}
+
+// errorCode returns the error field of a JSON ErrorResponse body
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("error body %q: %v", body, err)
+	}
+	if resp.RequestID == "" {
+		t.Errorf("error body %s has no request_id", body)
+	}
+	return resp.Error
+}
+
+func TestRoutes(t *testing.T) {
+	p1 := testProduct(1)
+	replaced := p1
+	replaced.Weight = 99
+	other := testProduct(2)
+	other.SKU = p1.SKU
+	mismatch := testProduct(7)
+	long := strings.Repeat("A", 101)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header []string
+		status int
+		code   string // error field of a 4xx body
+	}{
+		{"get", "GET", "/v1/products/1", "", nil, 200, ""},
+		{"get alias", "GET", "/products/1", "", nil, 200, ""},
+		{"get bad id", "GET", "/v1/products/abc", "", nil, 400, "INVALID_INPUT"},
+		{"get zero id", "GET", "/v1/products/0", "", nil, 400, "INVALID_INPUT"},
+		{"get missing", "GET", "/v1/products/99", "", nil, 404, "NOT_FOUND"},
+		{"get bad fields", "GET", "/v1/products/1?fields=nope", "", nil, 400, "INVALID_INPUT"},
+
+		{"create", "POST", "/v1/products/6/details", productJSON(testProduct(6)), nil, 201, ""},
+		{"replace", "POST", "/v1/products/1/details", productJSON(replaced), nil, 204, ""},
+		{"create id mismatch", "POST", "/v1/products/6/details", productJSON(mismatch), nil, 400, "INVALID_INPUT"},
+		{"create bad id", "POST", "/v1/products/x/details", productJSON(p1), nil, 400, "INVALID_INPUT"},
+		{"create malformed", "POST", "/v1/products/6/details", `{"product_id":`, nil, 400, "INVALID_INPUT"},
+		{"create invalid", "POST", "/v1/products/6/details", `{"product_id":6,"sku":"","manufacturer":"Acme","category_id":1,"weight":1,"some_other_id":1}`, nil, 400, "INVALID_INPUT"},
+		{"create duplicate sku", "POST", "/v1/products/2/details", productJSON(other), nil, 409, "DUPLICATE_SKU"},
+		{"create only, exists", "POST", "/v1/products/1/details?mode=create", productJSON(p1), nil, 409, "CONFLICT"},
+		{"create only, If-None-Match", "POST", "/v1/products/1/details", productJSON(p1), []string{"If-None-Match", "*"}, 409, "CONFLICT"},
+		{"stale If-Match", "POST", "/v1/products/1/details", productJSON(replaced), []string{"If-Match", `"stale"`}, 412, "PRECONDITION_FAILED"},
+		{"If-Match on missing", "POST", "/v1/products/6/details", productJSON(testProduct(6)), []string{"If-Match", `"stale"`}, 412, "PRECONDITION_FAILED"},
+
+		{"patch", "PATCH", "/v1/products/1", `{"weight":5}`, nil, 200, ""},
+		{"patch missing", "PATCH", "/v1/products/99", `{"weight":5}`, nil, 404, "NOT_FOUND"},
+		{"patch null", "PATCH", "/v1/products/1", `{"weight":null}`, nil, 400, "INVALID_INPUT"},
+		{"patch not an object", "PATCH", "/v1/products/1", `[1]`, nil, 400, "INVALID_INPUT"},
+		{"patch invalid", "PATCH", "/v1/products/1", `{"weight":-1}`, nil, 400, "INVALID_INPUT"},
+		{"patch id change", "PATCH", "/v1/products/1", `{"product_id":2}`, nil, 400, "INVALID_INPUT"},
+		{"patch duplicate sku", "PATCH", "/v1/products/1", `{"sku":"SKU-2"}`, nil, 409, "DUPLICATE_SKU"},
+
+		{"delete", "DELETE", "/v1/products/1", "", nil, 204, ""},
+		{"delete missing", "DELETE", "/v1/products/99", "", nil, 404, "NOT_FOUND"},
+		{"delete bad id", "DELETE", "/v1/products/-1", "", nil, 400, "INVALID_INPUT"},
+
+		{"sku", "GET", "/v1/products/sku/SKU-3", "", nil, 200, ""},
+		{"sku escaped", "GET", "/v1/products/sku/" + url.PathEscape("SKU-3"), "", nil, 200, ""},
+		{"sku missing", "GET", "/v1/products/sku/NOPE", "", nil, 404, "NOT_FOUND"},
+		{"sku too long", "GET", "/v1/products/sku/" + long, "", nil, 400, "INVALID_INPUT"},
+
+		{"list", "GET", "/v1/products", "", nil, 200, ""},
+		{"list bad limit", "GET", "/v1/products?limit=0", "", nil, 400, "INVALID_INPUT"},
+		{"list limit too big", "GET", "/v1/products?limit=100000", "", nil, 400, "INVALID_INPUT"},
+		{"list bad offset", "GET", "/v1/products?offset=-1", "", nil, 400, "INVALID_INPUT"},
+		{"list bad sort", "GET", "/v1/products?sort=nope", "", nil, 400, "INVALID_INPUT"},
+		{"list bad cursor", "GET", "/v1/products?cursor=!!", "", nil, 400, "INVALID_CURSOR"},
+
+		{"unknown route", "GET", "/v1/nope", "", nil, 404, "NOT_FOUND"},
+		{"wrong method", "PUT", "/v1/products/1", "", nil, 405, "METHOD_NOT_ALLOWED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := seededRouter(t, 5, nil)
+			w := doRequest(router, tt.method, tt.target, tt.body, tt.header...)
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.code != "" {
+				if got := errorCode(t, w.Body.Bytes()); got != tt.code {
+					t.Errorf("error %q, want %q", got, tt.code)
+				}
+			}
+		})
+	}
+}
+
+// TestStrictJSONErrors checks the fields and details a create reports for a
+// body STRICT_JSON rejects, and that the same bodies are accepted with it off
+func TestStrictJSONErrors(t *testing.T) {
+	base := strings.TrimSuffix(productJSON(testProduct(6)), "}")
+	tests := []struct {
+		name    string
+		body    string
+		fields  []FieldError
+		details string
+	}{
+		{"unknown field", base + `,"extra":1}`,
+			[]FieldError{{Field: "extra", Constraint: "is not a recognized field"}}, "extra is not a recognized field"},
+		{"repeated key", base + `,"sku":"SKU-66"}`,
+			[]FieldError{{Field: "sku", Constraint: "must appear only once"}}, "sku must appear only once"},
+		{"repeated nested key", base + `,"meta":{"a":1,"a":2}}`,
+			[]FieldError{{Field: "meta.a", Constraint: "must appear only once"}}, "meta.a must appear only once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(seededRouter(t, 5, nil), "POST", "/v1/products/6/details", tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("strict: got %d %s, want 400", w.Code, w.Body)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error != "INVALID_INPUT" || resp.Details != tt.details {
+				t.Errorf("strict: got %s %q, want INVALID_INPUT %q", resp.Error, resp.Details, tt.details)
+			}
+			if !slices.Equal(resp.Fields, tt.fields) {
+				t.Errorf("strict: fields %+v, want %+v", resp.Fields, tt.fields)
+			}
+
+			w = doRequest(seededRouter(t, 5, map[string]string{"STRICT_JSON": "false"}), "POST", "/v1/products/6/details", tt.body)
+			if w.Code != http.StatusCreated {
+				t.Errorf("lenient: got %d %s, want 201", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestUnmatchedRequests(t *testing.T) {
+	router := seededRouter(t, 1, nil)
+
+	w := doRequest(router, http.MethodGet, "/v1/widgets/1", "")
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusNotFound || err != nil || resp.Error != "NOT_FOUND" {
+		t.Fatalf("unknown path: got %d %s", w.Code, w.Body)
+	}
+	if resp.Details != "No route matches GET /v1/widgets/1" {
+		t.Errorf("unknown path: details %q", resp.Details)
+	}
+
+	for _, tc := range []struct {
+		method, target, allow string
+	}{
+		{http.MethodPut, "/v1/products/1", "GET, PATCH, DELETE"},
+		{http.MethodDelete, "/v1/products", "GET"},
+		{http.MethodGet, "/v1/products/1/details", "POST"},
+		{http.MethodPut, "/products/1", "GET, PATCH, DELETE"},
+	} {
+		w := doRequest(router, tc.method, tc.target, "")
+		if w.Code != http.StatusMethodNotAllowed || errorCode(t, w.Body.Bytes()) != "METHOD_NOT_ALLOWED" {
+			t.Errorf("%s %s: got %d %s, want 405", tc.method, tc.target, w.Code, w.Body)
+			continue
+		}
+		if got := w.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tc.method, tc.target, got, tc.allow)
+		}
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if !strings.HasSuffix(resp.Details, "allowed: "+tc.allow) {
+			t.Errorf("%s %s: details %q do not name the allowed methods", tc.method, tc.target, resp.Details)
+		}
+	}
+
+	// A trailing slash redirects to the route without it, keeping the
+	// method and body for anything but GET
+	for _, tc := range []struct {
+		method, target, location string
+		status                   int
+	}{
+		{http.MethodGet, "/v1/products/1/", "/v1/products/1", http.StatusMovedPermanently},
+		{http.MethodGet, "/v1/products/", "/v1/products", http.StatusMovedPermanently},
+		{http.MethodPost, "/v1/products/1/details/", "/v1/products/1/details", http.StatusTemporaryRedirect},
+	} {
+		w := doRequest(router, tc.method, tc.target, "")
+		if w.Code != tc.status || w.Header().Get("Location") != tc.location {
+			t.Errorf("%s %s: got %d to %q, want %d to %q", tc.method, tc.target, w.Code, w.Header().Get("Location"), tc.status, tc.location)
+		}
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/widgets/", ""); w.Code != http.StatusNotFound || errorCode(t, w.Body.Bytes()) != "NOT_FOUND" {
+		t.Errorf("unknown path with a trailing slash: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCreateThenReplace(t *testing.T) {
+	router := seededRouter(t, 0, nil)
+	body := productJSON(testProduct(1))
+
+	w := doRequest(router, http.MethodPost, "/v1/products/1/details", body)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/v1/products/1" || w.Header().Get("ETag") == "" {
+		t.Fatalf("create: got %d, Location %q, ETag %q", w.Code, w.Header().Get("Location"), w.Header().Get("ETag"))
+	}
+	if w := doRequest(router, http.MethodPost, "/products/2/details", productJSON(testProduct(2))); w.Header().Get("Location") != "/products/2" {
+		t.Errorf("create through the alias: Location %q, want /products/2", w.Header().Get("Location"))
+	}
+	created := w.Header().Get("ETag")
+	if get := doRequest(router, http.MethodGet, "/v1/products/1", ""); get.Header().Get("ETag") != created {
+		t.Errorf("GET ETag %s, create returned %s", get.Header().Get("ETag"), created)
+	}
+
+	w = doRequest(router, http.MethodPost, "/v1/products/1/details", body, "If-Match", created)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("replace: got %d with %d bytes, want an empty 204", w.Code, w.Body.Len())
+	}
+	if w.Header().Get("ETag") == created {
+		t.Error("replace kept the ETag; updated_at should have changed it")
+	}
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", body, "If-Match", created); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("replace with the old ETag: got %d, want 412", w.Code)
+	}
+
+	router = seededRouter(t, 0, map[string]string{"CREATE_RETURNS_201": "false"})
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", body); w.Code != http.StatusNoContent {
+		t.Errorf("create with CREATE_RETURNS_201=false: got %d, want 204", w.Code)
+	}
+}
+
+func TestDeletedProduct(t *testing.T) {
+	router := seededRouter(t, 2, nil)
+	if w := doRequest(router, http.MethodDelete, "/v1/products/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", w.Code)
+	}
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	if w.Code != http.StatusGone || errorCode(t, w.Body.Bytes()) != "GONE" {
+		t.Errorf("GET deleted: got %d %s, want 410 GONE", w.Code, w.Body)
+	}
+	if w := doRequest(router, http.MethodDelete, "/v1/products/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: got %d, want 404", w.Code)
+	}
+	if w := doRequest(router, http.MethodPost, "/v1/products/2/restore", ""); w.Code != http.StatusConflict {
+		t.Errorf("restore a live product: got %d, want 409", w.Code)
+	}
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/restore", ""); w.Code != http.StatusOK {
+		t.Errorf("restore: got %d, want 200", w.Code)
+	}
+
+	router = seededRouter(t, 1, map[string]string{"DELETED_RETURNS_410": "false"})
+	doRequest(router, http.MethodDelete, "/v1/products/1", "")
+	if w := doRequest(router, http.MethodGet, "/v1/products/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted with DELETED_RETURNS_410=false: got %d, want 404", w.Code)
+	}
+}
+
+func TestPatchKeepsAbsentFields(t *testing.T) {
+	router := seededRouter(t, 1, nil)
+	w := doRequest(router, http.MethodPatch, "/v1/products/1", `{"weight":42,"name":"Bolt"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	var got Product
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := testProduct(1)
+	if got.Weight != 42 || got.Name != "Bolt" || got.SKU != want.SKU || got.Manufacturer != want.Manufacturer || got.CategoryID != want.CategoryID {
+		t.Errorf("merged %+v, want %+v with weight 42 and name Bolt", got, want)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	router := seededRouter(t, 7, nil)
+
+	w := doRequest(router, http.MethodGet, "/v1/products?limit=3&offset=2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if total := w.Header().Get("X-Total-Count"); total != "7" {
+		t.Errorf("X-Total-Count %q, want 7", total)
+	}
+	var page []Product
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if ids := productIDs(page); !slices.Equal(ids, []int{3, 4, 5}) {
+		t.Errorf("offset page %v, want [3 4 5]", ids)
+	}
+
+	w = doRequest(router, http.MethodGet, "/v1/products?offset=10", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("past the end: got %d %s, want an empty list", w.Code, w.Body)
+	}
+
+	// Walk the keyset pages until next_cursor runs out
+	var seen []int
+	target := "/v1/products?cursor=&limit=3&sort=-weight"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor pagination did not end")
+		}
+		w := doRequest(router, http.MethodGet, target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", target, w.Code, w.Body)
+		}
+		var cp struct {
+			Products   []Product `json:"products"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &cp); err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, productIDs(cp.Products)...)
+		if cp.NextCursor == "" {
+			break
+		}
+		target = "/v1/products?limit=3&sort=-weight&cursor=" + url.QueryEscape(cp.NextCursor)
+	}
+	if !slices.Equal(seen, []int{7, 6, 5, 4, 3, 2, 1}) {
+		t.Errorf("cursor pages %v, want every product by weight descending", seen)
+	}
+}
+
+// shuffledStore lists in a different order on every call, as a store
+// without a natural order may
+type shuffledStore struct {
+	ProductStore
+}
+
+func (s shuffledStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	items, err := s.ProductStore.List(ctx, filter)
+	rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	return items, err
+}
+
+// TestSortedPagesStable walks sort=category_id page by page, where most
+// products tie, and checks the pages join up to one ordering with no
+// product repeated or skipped
+func TestSortedPagesStable(t *testing.T) {
+	mem := NewInMemoryStore(false)
+	for id := 1; id <= 10; id++ {
+		mustPut(t, mem, testProduct(id))
+	}
+	_, router := newTestAPI(t, shuffledStore{mem}, nil)
+
+	for _, tc := range []struct {
+		sort string
+		want []int
+	}{
+		// Categories 1, 2 and 3 hold ids divisible by 3, then 1 more, then 2 more
+		{"category_id", []int{3, 6, 9, 1, 4, 7, 10, 2, 5, 8}},
+		{"-category_id", []int{2, 5, 8, 1, 4, 7, 10, 3, 6, 9}},
+	} {
+		for _, limit := range []int{1, 3, 4} {
+			var seen []int
+			for offset := 0; offset < 10; offset += limit {
+				w := doRequest(router, http.MethodGet, fmt.Sprintf("/v1/products?sort=%s&limit=%d&offset=%d", tc.sort, limit, offset), "")
+				var page []Product
+				if err := json.Unmarshal(w.Body.Bytes(), &page); w.Code != http.StatusOK || err != nil {
+					t.Fatalf("got %d %s", w.Code, w.Body)
+				}
+				seen = append(seen, productIDs(page)...)
+			}
+			if !slices.Equal(seen, tc.want) {
+				t.Errorf("sort=%s, limit=%d: offset pages %v, want %v", tc.sort, limit, seen, tc.want)
+			}
+		}
+
+		var seen []int
+		target := "/v1/products?cursor=&limit=3&sort=" + tc.sort
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("cursor pagination did not end")
+			}
+			w := doRequest(router, http.MethodGet, target, "")
+			var cp struct {
+				Products   []Product `json:"products"`
+				NextCursor string    `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &cp); w.Code != http.StatusOK || err != nil {
+				t.Fatalf("%s: got %d %s", target, w.Code, w.Body)
+			}
+			seen = append(seen, productIDs(cp.Products)...)
+			if cp.NextCursor == "" {
+				break
+			}
+			target = "/v1/products?limit=3&sort=" + tc.sort + "&cursor=" + url.QueryEscape(cp.NextCursor)
+		}
+		if !slices.Equal(seen, tc.want) {
+			t.Errorf("sort=%s: cursor pages %v, want %v", tc.sort, seen, tc.want)
+		}
+	}
+}
+
+// TestConcurrentRequests drives every route from several goroutines at
+// once; run with -race
+func TestConcurrentRequests(t *testing.T) {
+	router := seededRouter(t, 20, nil)
+	done := make(chan struct{})
+	for g := range 8 {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for i := range 50 {
+				id := strconv.Itoa(1 + (g*50+i)%20)
+				doRequest(router, http.MethodGet, "/v1/products/"+id, "")
+				doRequest(router, http.MethodPatch, "/v1/products/"+id, `{"weight":`+strconv.Itoa(i+1)+`}`)
+				doRequest(router, http.MethodGet, "/v1/products?limit=5", "")
+				doRequest(router, http.MethodGet, "/v1/products/sku/SKU-"+id, "")
+			}
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+}
+
+// failingStore is a fake ProductStore whose every call fails with err
+type failingStore struct{ err error }
+
+func (s failingStore) Get(context.Context, int) (Product, error) {
+	return Product{}, s.err
+}
+
+func (s failingStore) GetBySKU(context.Context, string) (Product, error) {
+	return Product{}, s.err
+}
+
+func (s failingStore) GetMany(context.Context, []int) (map[int]Product, error) {
+	return nil, s.err
+}
+
+func (s failingStore) Put(context.Context, *Product) (bool, error) {
+	return false, s.err
+}
+
+func (s failingStore) Create(context.Context, *Product) error {
+	return s.err
+}
+
+func (s failingStore) PutBatch(_ context.Context, items []Product, _ bool) []error {
+	errs := make([]error, len(items))
+	for i := range errs {
+		errs[i] = s.err
+	}
+	return errs
+}
+
+func (s failingStore) Update(context.Context, int, func(*Product) error) (Product, error) {
+	return Product{}, s.err
+}
+
+func (s failingStore) Delete(context.Context, int) error {
+	return s.err
+}
+
+func (s failingStore) List(context.Context, ListFilter) ([]Product, error) {
+	return nil, s.err
+}
+
+// TestStoreErrors checks the response each route gives for each kind of
+// store failure
+func TestStoreErrors(t *testing.T) {
+	routes := []struct {
+		method, target, body string
+		// lookup is whether the route needs the product to exist
+		lookup bool
+	}{
+		{"GET", "/v1/products/1", "", true},
+		{"GET", "/v1/products/sku/SKU-1", "", true},
+		{"GET", "/v1/products", "", false},
+		{"POST", "/v1/products/1/details", productJSON(testProduct(1)), false},
+		{"PATCH", "/v1/products/1", `{"weight":5}`, true},
+		{"DELETE", "/v1/products/1", "", true},
+	}
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"failure", errors.New("connection reset"), 500, "INTERNAL_ERROR"},
+		{"unavailable", fmt.Errorf("%w: throttled", ErrStoreUnavailable), 503, "STORE_UNAVAILABLE"},
+		{"deadline", context.DeadlineExceeded, 504, "TIMEOUT"},
+		{"full", ErrStoreFull, 507, "INSUFFICIENT_STORAGE"},
+		{"duplicate SKU", &DuplicateSKUError{SKU: "SKU-1", ProductID: 2}, 409, "DUPLICATE_SKU"},
+	} {
+		for _, r := range routes {
+			t.Run(tc.name+" "+r.method+" "+r.target, func(t *testing.T) {
+				// A fresh API each time, so failures don't add up to open
+				// the circuit breaker
+				_, router := newTestAPI(t, failingStore{tc.err}, nil)
+				w := doRequest(router, r.method, r.target, r.body)
+				if w.Code != tc.status {
+					t.Fatalf("got %d %s, want %d", w.Code, w.Body, tc.status)
+				}
+				if code := errorCode(t, w.Body.Bytes()); code != tc.code {
+					t.Errorf("got error %s, want %s", code, tc.code)
+				}
+			})
+		}
+	}
+
+	_, router := newTestAPI(t, failingStore{ErrNotFound}, nil)
+	for _, r := range routes {
+		if !r.lookup {
+			continue
+		}
+		if w := doRequest(router, r.method, r.target, r.body); w.Code != 404 || errorCode(t, w.Body.Bytes()) != "NOT_FOUND" {
+			t.Errorf("not found %s %s: got %d %s", r.method, r.target, w.Code, w.Body)
+		}
+	}
+}
+
+// TestDeleteWhileReading deletes every product while other goroutines
+// read them; run with -race. A read sees a product whole or deleted.
+func TestDeleteWhileReading(t *testing.T) {
+	const n = 200
+	router := seededRouter(t, n, nil)
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2 * n {
+				id := 1 + (g*37+i)%n
+				w := doRequest(router, http.MethodGet, "/v1/products/"+strconv.Itoa(id), "")
+				switch w.Code {
+				case http.StatusOK:
+					var p Product
+					if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.ProductID != id || p.SKU != testProduct(id).SKU {
+						t.Errorf("get %d: got %s", id, w.Body)
+						return
+					}
+				case http.StatusGone:
+				default:
+					t.Errorf("get %d: got %d %s", id, w.Code, w.Body)
+					return
+				}
+			}
+		}()
+	}
+	for g := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := 1 + g; id <= n; id += 2 {
+				if w := doRequest(router, http.MethodDelete, "/v1/products/"+strconv.Itoa(id), ""); w.Code != http.StatusNoContent {
+					t.Errorf("delete %d: got %d %s", id, w.Code, w.Body)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for id := 1; id <= n; id++ {
+		if w := doRequest(router, http.MethodGet, "/v1/products/"+strconv.Itoa(id), ""); w.Code != http.StatusGone {
+			t.Fatalf("get %d after every delete: got %d", id, w.Code)
+		}
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products", ""); w.Body.String() != "[]" {
+		t.Errorf("list after every delete: got %s", w.Body)
+	}
+}
+
+func TestPatchProductSetsValidators(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), nil)
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1))); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d", w.Code)
+	}
+
+	w := doRequest(router, http.MethodPatch, "/v1/products/1?fields=weight", `{"weight":7}`, "Accept", "application/xml")
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch: got %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("Content-Type %q, want application/xml", ct)
+	}
+	if body := w.Body.String(); strings.Contains(body, "<sku>") || !strings.Contains(body, "<weight>7</weight>") {
+		t.Errorf("body %s, want only product_id and weight", body)
+	}
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("ETag %q, Last-Modified %q; want both set", etag, modified)
+	}
+
+	get := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	if got := get.Header().Get("ETag"); got != etag {
+		t.Errorf("GET ETag %s, PATCH returned %s", got, etag)
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products/1", "", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("GET with the PATCH ETag: got %d, want 304", w.Code)
+	}
+}
+
+func TestGetProductBySKUConditional(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), nil)
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1))); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d", w.Code)
+	}
+	byID := doRequest(router, http.MethodGet, "/v1/products/1", "")
+
+	w := doRequest(router, http.MethodGet, "/v1/products/sku/SKU-1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag != byID.Header().Get("ETag") {
+		t.Errorf("ETag %q, want %q as on GET by ID", etag, byID.Header().Get("ETag"))
+	}
+	if w.Header().Get("Last-Modified") != byID.Header().Get("Last-Modified") {
+		t.Errorf("Last-Modified %q, want %q", w.Header().Get("Last-Modified"), byID.Header().Get("Last-Modified"))
+	}
+
+	if w := doRequest(router, http.MethodGet, "/v1/products/sku/SKU-1", "", "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: got %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products/sku/SKU-1", "", "If-Modified-Since", w.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d, want 304", w.Code)
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products/sku/SKU-1", "", "Accept", msgpackContentType); w.Header().Get("Content-Type") != msgpackContentType {
+		t.Errorf("Accept msgpack: got Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products/sku/SKU-1", "", "Accept", "text/csv"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("Accept text/csv: got %d, want 406", w.Code)
+	}
+}
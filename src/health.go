@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text/main/buildinfo"
+)
+
+// readinessTimeout bounds how long /readyz waits on the backing store
+const readinessTimeout = time.Second
+
+// storePinger is implemented by stores with a remote dependency that
+// readiness should check. The in-memory store has none, so probing it never
+// touches its lock.
+type storePinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ReadinessCheck is one entry of the /readyz body
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "ok" or "fail"
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the body returned by /readyz and /health
+type ReadinessResponse struct {
+	Status string           `json:"status"` // "ready" or "not_ready"
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// liveness handles GET /healthz
+// Returns 200 whenever the process can serve HTTP at all
+func (a *API) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// VersionResponse is the body of GET /version
+type VersionResponse struct {
+	buildinfo.Info
+	StartedAt time.Time `json:"started_at"`
+}
+
+// version handles GET /version
+// Returns the build this process runs and when it started, to tell which
+// commit a task is on
+func (a *API) version(c *gin.Context) {
+	c.JSON(http.StatusOK, VersionResponse{Info: buildinfo.Get(), StartedAt: a.startedAt.UTC()})
+}
+
+// readiness handles GET /readyz and GET /health
+// Returns 200 once startup loading is done, the store answers and shutdown
+// hasn't begun; 503 with the failing checks otherwise, so the ALB stops routing here
+func (a *API) readiness(c *gin.Context) {
+	checks := []ReadinessCheck{
+		boolCheck("startup", a.started.Load(), "still loading persisted data"),
+		boolCheck("draining", !a.draining.Load(), "graceful shutdown in progress"),
+	}
+	if a.breaker != nil {
+		checks = append(checks, boolCheck("store_breaker", a.breaker.State() != breakerOpen, "store circuit breaker is open"))
+	}
+	if p, ok := a.store.(storePinger); ok {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		err := p.Ping(ctx)
+		cancel()
+		check := ReadinessCheck{Name: "store", Status: "ok"}
+		if err != nil {
+			check.Status, check.Error = "fail", err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	resp := ReadinessResponse{Status: "ready", Checks: checks}
+	for _, check := range checks {
+		if check.Status != "ok" {
+			resp.Status = "not_ready"
+			c.JSON(http.StatusServiceUnavailable, resp)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// boolCheck builds a ReadinessCheck that fails with reason unless ok
+func boolCheck(name string, ok bool, reason string) ReadinessCheck {
+	if ok {
+		return ReadinessCheck{Name: name, Status: "ok"}
+	}
+	return ReadinessCheck{Name: name, Status: "fail", Error: reason}
+}
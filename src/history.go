@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyStore is implemented by stores that keep earlier versions of each
+// product
+type historyStore interface {
+	// History returns the kept versions of a product, newest first, and
+	// whether history is being kept at all
+	History(id int) ([]ProductVersion, bool)
+}
+
+// ProductVersion is one stored state of a product. Revision counts the
+// product's writes, starting at 1.
+type ProductVersion struct {
+	Revision  int       `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Product   Product   `json:"product"`
+}
+
+// ProductHistoryResponse is the body returned by GET /products/{productId}/history
+type ProductHistoryResponse struct {
+	ProductID int              `json:"product_id"`
+	Versions  []ProductVersion `json:"versions"`
+}
+
+// productHistory is the ring of a product's latest versions
+type productHistory struct {
+	revision int
+	versions []ProductVersion // oldest first, at most historySize
+}
+
+// EnableHistory keeps the last size versions of every product from now on.
+// With keepDeleted, a deleted product's history stays readable and its
+// revisions carry on if the ID is reused. History is not persisted.
+func (s *InMemoryStore) EnableHistory(size int, keepDeleted bool) {
+	s.lockAll()
+	defer s.unlockAll()
+	s.historySize = size
+	s.keepDeletedHistory = keepDeleted
+}
+
+// recordLocked adds p as the newest version of its product. It runs in the
+// same critical section as the write, so revisions follow the order writes
+// took effect. Callers must hold p's shard for writing.
+func (s *InMemoryStore) recordLocked(p Product) {
+	if s.historySize == 0 {
+		return
+	}
+	sh := s.shardFor(p.ProductID)
+	h := sh.history[p.ProductID]
+	if h == nil {
+		h = &productHistory{}
+		sh.history[p.ProductID] = h
+	}
+	h.revision++
+	if len(h.versions) == s.historySize {
+		h.versions = append(h.versions[:0], h.versions[1:]...)
+	}
+	h.versions = append(h.versions, ProductVersion{Revision: h.revision, UpdatedAt: p.UpdatedAt, Product: p.clone()})
+}
+
+func (s *InMemoryStore) History(id int) ([]ProductVersion, bool) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if s.historySize == 0 {
+		return nil, false
+	}
+	h := sh.history[id]
+	if h == nil {
+		return nil, true
+	}
+	versions := make([]ProductVersion, len(h.versions))
+	for i, v := range h.versions {
+		versions[len(versions)-1-i] = v
+	}
+	return versions, true
+}
+
+// productHistory handles GET /products/{productId}/history
+// Lists the last HISTORY_SIZE versions of the product, newest first. Only
+// writes since startup are known.
+// Returns 200 with the versions, 400 if bad ID, 404 if the product has no
+// history and doesn't exist, 404 HISTORY_DISABLED if no history is kept
+func (a *API) productHistory(c *gin.Context) {
+	productID, ok := parseProductID(c, "Invalid product ID")
+	if !ok {
+		return
+	}
+	hs, ok := a.baseStore(c.Request.Context()).(historyStore)
+	var versions []ProductVersion
+	if ok {
+		versions, ok = hs.History(productID)
+	}
+	if !ok {
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "HISTORY_DISABLED",
+			Message:   "Product history is not kept",
+			Details:   "History is off by default; it needs STORE_BACKEND=memory and HISTORY_SIZE above 0",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	if len(versions) == 0 {
+		// Tell a product written before startup apart from one that never existed
+		if _, err := a.wrappedStore().Get(c.Request.Context(), productID); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeError(c, http.StatusNotFound, ErrorResponse{
+					Error:     "NOT_FOUND",
+					Message:   "Product not found",
+					Details:   "No product found with ID " + strconv.Itoa(productID),
+					RequestID: requestID(c),
+				})
+				return
+			}
+			writeStoreError(c, err)
+			return
+		}
+		versions = []ProductVersion{}
+	}
+	c.JSON(http.StatusOK, ProductHistoryResponse{ProductID: productID, Versions: versions})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHistoryOffByDefault(t *testing.T) {
+	if cfg := testConfig(t, nil); cfg.HistorySize != 0 {
+		t.Fatalf("HistorySize defaults to %d, want 0", cfg.HistorySize)
+	}
+	_, router := newTestAPI(t, NewInMemoryStore(false), nil)
+	doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1)))
+
+	w := doRequest(router, http.MethodGet, "/v1/products/1/history", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"HISTORY_DISABLED"`) {
+		t.Errorf("got %d %s, want 404 HISTORY_DISABLED", w.Code, w.Body)
+	}
+}
+
+func TestHistoryKeepsLatestVersions(t *testing.T) {
+	mem := NewInMemoryStore(false)
+	mem.EnableHistory(2, false)
+	_, router := newTestAPI(t, mem, map[string]string{"HISTORY_SIZE": "2"})
+	p := testProduct(1)
+	for _, weight := range []int{1, 2, 3} {
+		p.Weight = weight
+		doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(p))
+	}
+
+	w := doRequest(router, http.MethodGet, "/v1/products/1/history", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	var resp ProductHistoryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Versions) != 2 || resp.Versions[0].Revision != 3 || resp.Versions[0].Product.Weight != 3 || resp.Versions[1].Product.Weight != 2 {
+		t.Errorf("versions %+v, want revisions 3 and 2, newest first", resp.Versions)
+	}
+	if w := doRequest(router, http.MethodGet, "/v1/products/9/history", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"NOT_FOUND"`) {
+		t.Errorf("unknown product: got %d %s, want 404 NOT_FOUND", w.Code, w.Body)
+	}
+}
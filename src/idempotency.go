@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idempotency-Key handling
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader marks a response served from the key cache
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+// idempotencyReplayHeaders are the response headers stored with a key and
+// sent again on replay
+var idempotencyReplayHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyEntry is one remembered request. Until done is set the original
+// request is still being served.
+type idempotencyEntry struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	elem        *list.Element
+}
+
+// idempotencyCache remembers responses by Idempotency-Key for ttl, holding
+// at most max keys. Every entry lives for the same ttl, so insertion order is
+// expiry order and order's front is always the next to go.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*idempotencyEntry
+	order   *list.List
+}
+
+func newIdempotencyCache(ttl time.Duration, max int) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, max: max, entries: make(map[string]*idempotencyEntry), order: list.New()}
+}
+
+// begin claims key for a request with fingerprint. If the key is already
+// known it returns a copy of that entry and false; otherwise it records an
+// in-progress entry and returns true.
+func (ic *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte, now time.Time) (idempotencyEntry, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	for front := ic.order.Front(); front != nil; front = ic.order.Front() {
+		e := front.Value.(*idempotencyEntry)
+		if now.Before(e.expires) {
+			break
+		}
+		ic.removeLocked(e)
+	}
+	if e, exists := ic.entries[key]; exists {
+		return *e, false
+	}
+
+	e := &idempotencyEntry{key: key, fingerprint: fingerprint, expires: now.Add(ic.ttl)}
+	e.elem = ic.order.PushBack(e)
+	ic.entries[key] = e
+	for ic.order.Len() > ic.max {
+		ic.removeLocked(ic.order.Front().Value.(*idempotencyEntry))
+	}
+	return idempotencyEntry{}, true
+}
+
+// finish stores the response for key. Server errors and 499, which mean the
+// write did not complete, are forgotten instead, so a retry runs the request
+// again.
+func (ic *idempotencyCache) finish(key string, status int, header http.Header, body []byte) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	e, exists := ic.entries[key]
+	if !exists {
+		return // evicted while the request ran
+	}
+	if status >= http.StatusInternalServerError || status == statusClientClosedRequest {
+		ic.removeLocked(e)
+		return
+	}
+	e.done, e.status, e.header, e.body = true, status, header, body
+}
+
+// forget drops key, used when the request never produced a response
+func (ic *idempotencyCache) forget(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if e, exists := ic.entries[key]; exists && !e.done {
+		ic.removeLocked(e)
+	}
+}
+
+func (ic *idempotencyCache) removeLocked(e *idempotencyEntry) {
+	ic.order.Remove(e.elem)
+	delete(ic.entries, e.key)
+}
+
+// idempotency makes POST requests carrying an Idempotency-Key safe to retry:
+// the first response for a key is remembered for IDEMPOTENCY_TTL and
+// replayed for later requests with the same key instead of running the
+// write again. Reusing a key with a different method, URL or body is
+// rejected with 422, and a retry that arrives while the original is still
+// running gets 409. Keys are scoped to the authenticating API key.
+func (a *API) idempotency(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if a.idempotencyKeys == nil || key == "" || c.Request.Method != http.MethodPost {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid Idempotency-Key",
+			Details:   "Idempotency-Key must be at most 255 characters",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writePayloadTooLarge(c, tooLarge.Limit)
+			c.Abort()
+			return
+		}
+		writeDecodeError(c, err)
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+	h.Write(body)
+	var fingerprint [sha256.Size]byte
+	h.Sum(fingerprint[:0])
+
+	scoped := c.GetString(tenantIDKey) + "\x00" + c.GetString(apiKeyIDKey) + "\x00" + c.GetString(jwtSubjectKey) + "\x00" + key
+	prior, fresh := a.idempotencyKeys.begin(scoped, fingerprint, time.Now())
+	switch {
+	case !fresh && prior.fingerprint != fingerprint:
+		abortWithError(c, http.StatusUnprocessableEntity, ErrorResponse{
+			Error:     "IDEMPOTENCY_KEY_REUSED",
+			Message:   "Idempotency-Key was used for a different request",
+			Details:   "The key was first sent with a different method, URL or body; use a new key for a new request",
+			RequestID: requestID(c),
+		})
+		return
+	case !fresh && !prior.done:
+		c.Header("Retry-After", "1")
+		abortWithError(c, http.StatusConflict, ErrorResponse{
+			Error:     "REQUEST_IN_PROGRESS",
+			Message:   "A request with this Idempotency-Key is still being processed",
+			Details:   "Retry once the original request has completed",
+			RequestID: requestID(c),
+		})
+		return
+	case !fresh:
+		for name, values := range prior.header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header(idempotencyReplayedHeader, "true")
+		c.Status(prior.status)
+		c.Writer.Write(prior.body)
+		c.Abort()
+		return
+	}
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	finished := false
+	defer func() {
+		c.Writer = w.ResponseWriter
+		if !finished {
+			a.idempotencyKeys.forget(scoped)
+		}
+	}()
+	c.Next()
+	// The handler's status tells whether the write happened, even once the
+	// deadline has passed: a write that committed after the client got its
+	// 504 must replay its result rather than run again
+
+	header := make(http.Header)
+	for _, name := range idempotencyReplayHeaders {
+		if v := w.Header().Values(name); len(v) > 0 {
+			header[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	a.idempotencyKeys.finish(scoped, w.Status(), header, w.body.Bytes())
+	finished = true
+}
+
+// recordingWriter keeps a copy of the response body as it is written
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
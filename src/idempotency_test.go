@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowPutStore completes each Put shortly after its context is done, like a
+// backend whose write commits once the request has timed out
+type slowPutStore struct {
+	*InMemoryStore
+	puts atomic.Int32
+}
+
+func (s *slowPutStore) Put(ctx context.Context, p *Product) (bool, error) {
+	<-ctx.Done()
+	// Leaves requestTimeout time to send the 504 first
+	time.Sleep(20 * time.Millisecond)
+	s.puts.Add(1)
+	return s.InMemoryStore.Put(context.WithoutCancel(ctx), p)
+}
+
+func TestIdempotencyReplaysWriteCommittedAfterDeadline(t *testing.T) {
+	store := &slowPutStore{InMemoryStore: NewInMemoryStore(false)}
+	_, router := newTestAPI(t, store, map[string]string{"REQUEST_TIMEOUT": "50ms"})
+	body := productJSON(testProduct(1))
+
+	w := doRequest(router, http.MethodPost, "/v1/products/1/details", body, idempotencyKeyHeader, "k1")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("first request: got %d, want 504", w.Code)
+	}
+	w = doRequest(router, http.MethodPost, "/v1/products/1/details", body, idempotencyKeyHeader, "k1")
+	if w.Code != http.StatusCreated || w.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Errorf("retry: got %d replayed=%q, want the recorded 201", w.Code, w.Header().Get(idempotencyReplayedHeader))
+	}
+	if n := store.puts.Load(); n != 1 {
+		t.Errorf("store written %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyForgetsKeyWithoutResponse(t *testing.T) {
+	ic := newIdempotencyCache(time.Hour, 10)
+	for _, status := range []int{http.StatusGatewayTimeout, statusClientClosedRequest} {
+		var fp [32]byte
+		if _, fresh := ic.begin("k", fp, time.Now()); !fresh {
+			t.Fatalf("status %d: key still held from the previous round", status)
+		}
+		ic.finish("k", status, nil, nil)
+		if _, fresh := ic.begin("k", fp, time.Now()); !fresh {
+			t.Errorf("status %d: key kept; a retry would not run the write", status)
+		}
+		ic.forget("k")
+	}
+}
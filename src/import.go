@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// importRoute takes CSV rather than JSON and gets the batch body limit
+const importRoute = "/products/import"
+
+// maxImportErrors caps the row errors listed in an ImportResponse; the counts
+// still cover every row
+const maxImportErrors = 100
+
+// ImportRowError reports a CSV row that was not stored. Line is the row's
+// line number in the file, counting the header as line 1.
+type ImportRowError struct {
+	Line      int          `json:"line"`
+	ProductID int          `json:"product_id,omitempty"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// ImportResponse is the body returned by POST /products/import
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	// Conflicts counts rows refused with upsert=false because the product exists
+	Conflicts       int              `json:"conflicts"`
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
+// importProducts handles POST /products/import
+// The body is CSV in the export layout, sent as text/csv or as the "file"
+// part of a multipart/form-data upload. Rows are validated and stored
+// exportChunkSize at a time; with upsert=false existing product IDs are
+// reported as conflicts instead of being overwritten.
+// Returns 200 if every row was stored, 207 if some were skipped,
+// 400 if the header row is missing or wrong, 415 for other content types
+func (a *API) importProducts(c *gin.Context) {
+	upsert := c.Query("upsert") != "false"
+
+	body, ok := importBody(c)
+	if !ok {
+		return
+	}
+
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+	r.Comment = '#' // the snapshot line of an export
+	header, err := r.Read()
+	if err == nil {
+		err = checkImportHeader(header)
+	}
+	columns := len(header)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writePayloadTooLarge(c, tooLarge.Limit)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			err = errors.New("CSV is empty")
+		}
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid CSV header",
+			Details:   err.Error() + "; expected " + strings.Join(exportColumns, ","),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	imp := &importer{ctx: c.Request.Context(), store: a.wrappedStore(), validate: a.validateWrite, upsert: upsert, resp: ImportResponse{Errors: []ImportRowError{}}}
+	if !upsert {
+		imp.seen = make(map[int]bool)
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			if imp.resp.Imported == 0 && len(imp.pending) == 0 {
+				writePayloadTooLarge(c, tooLarge.Limit)
+				return
+			}
+			imp.fail(ImportRowError{Error: "body exceeds " + strconv.FormatInt(tooLarge.Limit, 10) + " bytes; remaining rows were not read"})
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && !errors.Is(err, csv.ErrFieldCount) {
+			// The reader can't resync after malformed quoting
+			imp.fail(ImportRowError{Line: parseErr.StartLine, Error: "malformed CSV: " + parseErr.Err.Error() + "; remaining rows were not read"})
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			imp.fail(ImportRowError{Line: line, Error: "row has " + strconv.Itoa(len(record)) + " fields, want " + strconv.Itoa(columns)})
+			continue
+		}
+		imp.add(line, record)
+	}
+	imp.flush()
+	// Store errors are only known after their chunk was written
+	slices.SortStableFunc(imp.resp.Errors, func(x, y ImportRowError) int { return x.Line - y.Line })
+
+	switch {
+	case imp.resp.Skipped == 0 && imp.resp.Imported == 0:
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "CSV has no data rows",
+			Details:   "the body contains only a header row",
+			RequestID: requestID(c),
+		})
+	case imp.resp.Skipped == 0:
+		c.JSON(http.StatusOK, imp.resp)
+	default:
+		c.JSON(http.StatusMultiStatus, imp.resp)
+	}
+}
+
+// importBody returns the CSV stream of the request: the body itself for
+// text/csv, or the "file" part of a multipart upload. It writes the error
+// response and returns false otherwise.
+func importBody(c *gin.Context) (io.Reader, bool) {
+	header := c.GetHeader("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(header)
+	switch {
+	case err == nil && mediaType == "text/csv":
+		return c.Request.Body, true
+	case err == nil && mediaType == "multipart/form-data":
+		mr, err := c.Request.MultipartReader()
+		if err != nil {
+			break
+		}
+		// Read parts as a stream; ParseMultipartForm would spool the file to disk
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					writePayloadTooLarge(c, tooLarge.Limit)
+					return nil, false
+				}
+				writeError(c, http.StatusBadRequest, ErrorResponse{
+					Error:     "INVALID_INPUT",
+					Message:   "Missing file",
+					Details:   `multipart body must contain a "file" part`,
+					RequestID: requestID(c),
+				})
+				return nil, false
+			}
+			if part.FormName() == "file" {
+				return part, true
+			}
+		}
+	}
+
+	details := "Content-Type must be text/csv or multipart/form-data"
+	if header != "" {
+		details += ", got " + header
+	}
+	writeError(c, http.StatusUnsupportedMediaType, ErrorResponse{
+		Error:     "UNSUPPORTED_MEDIA_TYPE",
+		Message:   "Request body must be CSV",
+		Details:   details,
+		RequestID: requestID(c),
+	})
+	return nil, false
+}
+
+// checkImportHeader requires the export columns in the export order, as
+// many as one of importLayouts
+func checkImportHeader(header []string) error {
+	got := make([]string, len(header))
+	for i, h := range header {
+		got[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+	// Spreadsheets like to prepend a byte order mark
+	if len(got) > 0 {
+		got[0] = strings.TrimPrefix(got[0], "\ufeff")
+	}
+	if !slices.Contains(importLayouts, len(got)) || !slices.Equal(got, exportColumns[:len(got)]) {
+		return errors.New("header is " + strings.Join(header, ","))
+	}
+	return nil
+}
+
+// importer accumulates valid rows and writes them a chunk at a time
+type importer struct {
+	// ctx is the import request's, passed to every store call
+	ctx      context.Context
+	store    ProductStore
+	validate func(p Product, storedSKU string) []FieldError
+	upsert   bool
+	// seen holds IDs already taken by earlier rows when upsert is off
+	seen    map[int]bool
+	pending []Product
+	lines   []int
+	resp    ImportResponse
+}
+
+// fail records a row that was not stored
+func (imp *importer) fail(e ImportRowError) {
+	imp.resp.Skipped++
+	if len(imp.resp.Errors) == maxImportErrors {
+		imp.resp.ErrorsTruncated = true
+		return
+	}
+	imp.resp.Errors = append(imp.resp.Errors, e)
+}
+
+// add parses and validates one data row and queues it for writing
+func (imp *importer) add(line int, record []string) {
+	var p Product
+	var errs []FieldError
+	ints := []*int{&p.ProductID, nil, nil, &p.CategoryID, &p.Weight, &p.SomeOtherID}
+	for i, dst := range ints {
+		if dst == nil {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(record[i]))
+		if err != nil {
+			errs = append(errs, FieldError{Field: exportColumns[i], Constraint: "must be an integer", Value: record[i]})
+			continue
+		}
+		*dst = n
+	}
+	p.SKU = record[1]
+	p.Manufacturer = record[2]
+	if len(record) > requiredColumns {
+		p.Name = record[6]
+		p.Description = record[7]
+		if raw := strings.TrimSpace(record[8]); raw != "" {
+			price, err := strconv.Atoi(raw)
+			if err != nil {
+				errs = append(errs, FieldError{Field: "price", Constraint: "must be an integer", Value: record[8]})
+			}
+			p.Price = &price
+		}
+		p.Currency = record[9]
+	}
+	if len(record) > 10 {
+		n, err := strconv.Atoi(strings.TrimSpace(record[10]))
+		if err != nil {
+			errs = append(errs, FieldError{Field: "quantity", Constraint: "must be an integer", Value: record[10]})
+		}
+		p.Quantity = n
+	}
+	if errs == nil {
+		normalizeProduct(&p)
+		errs = imp.validate(p, "")
+	}
+	if errs != nil {
+		imp.fail(ImportRowError{Line: line, ProductID: p.ProductID, Error: fieldErrorsDetails(errs), Fields: errs})
+		return
+	}
+	imp.queue(line, p)
+}
+
+// queue adds a valid product to the next chunk, writing the chunk once full
+func (imp *importer) queue(line int, p Product) {
+	imp.pending = append(imp.pending, p)
+	imp.lines = append(imp.lines, line)
+	if len(imp.pending) == exportChunkSize {
+		imp.flush()
+	}
+}
+
+// flush writes the queued rows. With upsert off, rows whose product already
+// exists (in the store or earlier in the file) become conflicts; the check
+// runs just before the write, so a product created concurrently by another
+// client can still be overwritten.
+func (imp *importer) flush() {
+	if len(imp.pending) == 0 {
+		return
+	}
+	items, lines := imp.pending, imp.lines
+	imp.pending, imp.lines = imp.pending[:0], imp.lines[:0]
+
+	if !imp.upsert {
+		ids := make([]int, len(items))
+		for i, p := range items {
+			ids[i] = p.ProductID
+		}
+		existing, err := imp.store.GetMany(imp.ctx, ids)
+		if err != nil {
+			for i, p := range items {
+				imp.fail(ImportRowError{Line: lines[i], ProductID: p.ProductID, Error: err.Error()})
+			}
+			return
+		}
+		kept := 0
+		for i, p := range items {
+			if _, exists := existing[p.ProductID]; exists || imp.seen[p.ProductID] {
+				imp.resp.Conflicts++
+				imp.fail(ImportRowError{Line: lines[i], ProductID: p.ProductID, Error: "product " + strconv.Itoa(p.ProductID) + " already exists"})
+				continue
+			}
+			imp.seen[p.ProductID] = true
+			items[kept], lines[kept] = p, lines[i]
+			kept++
+		}
+		items, lines = items[:kept], lines[:kept]
+	}
+
+	for i, err := range imp.store.PutBatch(imp.ctx, items, false) {
+		if err != nil {
+			imp.fail(ImportRowError{Line: lines[i], ProductID: items[i].ProductID, Error: err.Error()})
+			continue
+		}
+		imp.resp.Imported++
+	}
+}
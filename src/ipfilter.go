@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IP filter route groups, as labelled in ip_filter_blocked_total
+const (
+	ipGroupAdmin = "admin"
+	ipGroupAPI   = "api"
+)
+
+// IPFilter is one route group's CIDR lists. A client in Deny is refused,
+// and so is one outside Allow when Allow isn't empty.
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// permits reports whether f lets ip through
+func (f IPFilter) permits(ip netip.Addr) bool {
+	contains := func(p netip.Prefix) bool { return p.Contains(ip) }
+	if slices.ContainsFunc(f.Deny, contains) {
+		return false
+	}
+	return len(f.Allow) == 0 || slices.ContainsFunc(f.Allow, contains)
+}
+
+// ipGroup names the filter group of route: the operator endpoints are
+// admin, everything else, probes included, is api
+func ipGroup(route string) string {
+	if isAdminRoute(route) || route == "/stats" || strings.HasPrefix(route, "/debug/") {
+		return ipGroupAdmin
+	}
+	return ipGroupAPI
+}
+
+// filterIPs answers 403 to a client the IP filter of its route's group
+// refuses. The client IP is c.ClientIP(): the connection's address, or,
+// when that is one of TRUSTED_PROXIES, the last X-Forwarded-For hop that
+// isn't, so a forged X-Forwarded-For from anywhere else counts for nothing.
+// Refusals are counted in ip_filter_blocked_total.
+func (a *API) filterIPs(c *gin.Context) {
+	group := ipGroup(c.FullPath())
+	filter := a.cfg.APIIPFilter
+	if group == ipGroupAdmin {
+		filter = a.cfg.AdminIPFilter
+	}
+	if len(filter.Allow) == 0 && len(filter.Deny) == 0 {
+		c.Next()
+		return
+	}
+
+	ip, err := netip.ParseAddr(c.ClientIP())
+	if err == nil && filter.permits(ip.Unmap()) {
+		c.Next()
+		return
+	}
+	a.metrics.ipBlocked.WithLabelValues(group).Inc()
+	abortWithError(c, http.StatusForbidden, ErrorResponse{
+		Error:     "FORBIDDEN",
+		Message:   "Client address not allowed",
+		Details:   "Requests to this endpoint are not accepted from " + c.ClientIP(),
+		RequestID: requestID(c),
+	})
+}
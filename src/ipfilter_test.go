@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), map[string]string{
+		"TRUSTED_PROXIES":   "10.0.0.0/8,fd00::/8",
+		"API_ALLOW_CIDRS":   "203.0.113.0/24,2001:db8::/32",
+		"API_DENY_CIDRS":    "203.0.113.66,2001:db8:bad::/48",
+		"ADMIN_ALLOW_CIDRS": "192.0.2.1",
+	})
+
+	for _, tc := range []struct {
+		name    string
+		target  string
+		peer    string
+		xff     string
+		blocked bool
+	}{
+		{"allowed IPv4 peer", "/v1/products/1", "203.0.113.5:4000", "", false},
+		{"denied IPv4 peer", "/v1/products/1", "203.0.113.66:4000", "", true},
+		{"IPv4 peer outside the allow list", "/v1/products/1", "198.51.100.1:4000", "", true},
+		{"allowed IPv6 peer", "/v1/products/1", "[2001:db8::1]:4000", "", false},
+		{"denied IPv6 peer", "/v1/products/1", "[2001:db8:bad::1]:4000", "", true},
+		{"IPv6 peer outside the allow list", "/v1/products/1", "[2001:db9::1]:4000", "", true},
+		{"IPv4-mapped IPv6 peer", "/v1/products/1", "[::ffff:203.0.113.5]:4000", "", false},
+
+		{"allowed client behind a proxy", "/v1/products/1", "10.1.2.3:4000", "203.0.113.5", false},
+		{"refused client behind a proxy", "/v1/products/1", "10.1.2.3:4000", "198.51.100.1", true},
+		{"proxy chain ending in an allowed client", "/v1/products/1", "10.1.2.3:4000", "198.51.100.1, 203.0.113.5, 10.9.9.9", false},
+		{"forged first hop before a refused client", "/v1/products/1", "10.1.2.3:4000", "203.0.113.5, 198.51.100.1", true},
+		{"IPv6 client behind an IPv6 proxy", "/v1/products/1", "[fd00::1]:4000", "2001:db8::7", false},
+		{"denied IPv6 client behind proxies", "/v1/products/1", "[fd00::1]:4000", "2001:db8:bad::2, fd00::2", true},
+
+		{"forged XFF from an untrusted peer", "/v1/products/1", "198.51.100.1:4000", "203.0.113.5", true},
+		{"XFF naming a denied address ignored from an untrusted peer", "/v1/products/1", "203.0.113.5:4000", "203.0.113.66", false},
+		{"forged XFF naming a trusted proxy", "/v1/products/1", "198.51.100.1:4000", "203.0.113.5, 10.1.2.3", true},
+
+		{"admin group has its own list", "/stats", "203.0.113.5:4000", "", true},
+		{"admin client allowed", "/stats", "192.0.2.1:4000", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			req.RemoteAddr = tc.peer
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			refused := w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "Client address not allowed")
+			if refused != tc.blocked {
+				t.Errorf("got %d %s, want blocked %v", w.Code, w.Body, tc.blocked)
+			}
+			if refused && errorCode(t, w.Body.Bytes()) != "FORBIDDEN" {
+				t.Errorf("got error %s, want FORBIDDEN", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// productJSONSize is a typical encoded product, for sizing buffers
+const productJSONSize = 320
+
+// writeJSON is c.JSON through marshalJSON: the same bytes and Content-Type,
+// encoded without reflection for the types on the hot path
+func writeJSON(c *gin.Context, status int, v any) {
+	body, err := marshalJSON(v)
+	if err != nil {
+		// gin's own handling of an unencodable value
+		c.JSON(status, v)
+		return
+	}
+	c.Data(status, jsonContentType, body)
+}
+
+// appendProductJSON appends p as encoding/json would encode it: fields in
+// declaration order, omitempty and omitzero honoured, strings escaped for
+// HTML. It reports false for a product it leaves to encoding/json: one
+// with a string that isn't valid UTF-8 or a timestamp outside years
+// 0-9999, which encoding/json refuses.
+func appendProductJSON(b []byte, p *Product) ([]byte, bool) {
+	if !validUTF8(p.SKU, p.Manufacturer, p.Name, p.Description, p.Currency) {
+		return b, false
+	}
+	b = append(b, `{"product_id":`...)
+	b = strconv.AppendInt(b, int64(p.ProductID), 10)
+	b = append(b, `,"sku":`...)
+	b = appendJSONString(b, p.SKU)
+	b = append(b, `,"manufacturer":`...)
+	b = appendJSONString(b, p.Manufacturer)
+	b = append(b, `,"category_id":`...)
+	b = strconv.AppendInt(b, int64(p.CategoryID), 10)
+	b = append(b, `,"weight":`...)
+	b = strconv.AppendInt(b, int64(p.Weight), 10)
+	b = append(b, `,"some_other_id":`...)
+	b = strconv.AppendInt(b, int64(p.SomeOtherID), 10)
+	if p.Name != "" {
+		b = append(b, `,"name":`...)
+		b = appendJSONString(b, p.Name)
+	}
+	if p.Description != "" {
+		b = append(b, `,"description":`...)
+		b = appendJSONString(b, p.Description)
+	}
+	if p.Price != nil {
+		b = append(b, `,"price":`...)
+		b = strconv.AppendInt(b, int64(*p.Price), 10)
+	}
+	if p.Currency != "" {
+		b = append(b, `,"currency":`...)
+		b = appendJSONString(b, p.Currency)
+	}
+	b = append(b, `,"quantity":`...)
+	b = strconv.AppendInt(b, int64(p.Quantity), 10)
+
+	ok := true
+	if !p.CreatedAt.IsZero() {
+		b = append(b, `,"created_at":`...)
+		b, ok = appendJSONTime(b, p.CreatedAt)
+	}
+	if ok && !p.UpdatedAt.IsZero() {
+		b = append(b, `,"updated_at":`...)
+		b, ok = appendJSONTime(b, p.UpdatedAt)
+	}
+	if ok && p.DeletedAt != nil {
+		b = append(b, `,"deleted_at":`...)
+		b, ok = appendJSONTime(b, *p.DeletedAt)
+	}
+	if ok && p.ExpiresAt != nil {
+		b = append(b, `,"expires_at":`...)
+		b, ok = appendJSONTime(b, *p.ExpiresAt)
+	}
+	return append(b, '}'), ok
+}
+
+// appendErrorJSON appends resp as encoding/json would encode it. A field
+// error value other than a string, an int or nil goes through
+// encoding/json. It reports false, as appendProductJSON does, for invalid
+// UTF-8 or a value that failed to encode.
+func appendErrorJSON(b []byte, resp *ErrorResponse) ([]byte, bool) {
+	if !validUTF8(resp.Error, resp.Message, resp.Details, resp.RequestID) {
+		return b, false
+	}
+	b = append(b, `{"error":`...)
+	b = appendJSONString(b, resp.Error)
+	b = append(b, `,"message":`...)
+	b = appendJSONString(b, resp.Message)
+	if resp.Details != "" {
+		b = append(b, `,"details":`...)
+		b = appendJSONString(b, resp.Details)
+	}
+	if len(resp.Fields) > 0 {
+		b = append(b, `,"fields":[`...)
+		for i, f := range resp.Fields {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if !validUTF8(f.Field, f.Constraint) {
+				return b, false
+			}
+			b = append(b, `{"field":`...)
+			b = appendJSONString(b, f.Field)
+			b = append(b, `,"constraint":`...)
+			b = appendJSONString(b, f.Constraint)
+			b = append(b, `,"value":`...)
+			switch v := f.Value.(type) {
+			case nil:
+				b = append(b, "null"...)
+			case string:
+				if !utf8.ValidString(v) {
+					return b, false
+				}
+				b = appendJSONString(b, v)
+			case int:
+				b = strconv.AppendInt(b, int64(v), 10)
+			default:
+				value, err := json.Marshal(v)
+				if err != nil {
+					return b, false
+				}
+				b = append(b, value...)
+			}
+			b = append(b, '}')
+		}
+		b = append(b, ']')
+	}
+	if resp.RequestID != "" {
+		b = append(b, `,"request_id":`...)
+		b = appendJSONString(b, resp.RequestID)
+	}
+	return append(b, '}'), true
+}
+
+// validUTF8 reports whether every one of ss is valid UTF-8
+func validUTF8(ss ...string) bool {
+	for _, s := range ss {
+		if !utf8.ValidString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendJSONTime appends t as time.Time's MarshalJSON does, reporting
+// false for the years it refuses
+func appendJSONTime(b []byte, t time.Time) ([]byte, bool) {
+	if y := t.Year(); y < 0 || y > 9999 {
+		return b, false
+	}
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"'), true
+}
+
+// appendJSONString appends s, which must be valid UTF-8, quoted and
+// escaped the way encoding/json does by default: <, > and & as \u escapes,
+// and U+2028 and U+2029, which break JavaScript string literals, too.
+// Invalid UTF-8 is left to encoding/json, whose replacement of it has
+// changed between Go releases.
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,41 @@
+//go:build !stdjson
+
+package main
+
+import "encoding/json"
+
+// marshalJSON is json.Marshal, except that products, lists of products and
+// error responses are appended by hand, which is several times faster and
+// allocates once. Build with -tags stdjson to send everything through
+// encoding/json.
+func marshalJSON(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case Product:
+		if b, ok := appendProductJSON(make([]byte, 0, productJSONSize), &v); ok {
+			return b, nil
+		}
+	case []Product:
+		if v == nil {
+			return []byte("null"), nil
+		}
+		b := make([]byte, 0, 2+len(v)*(productJSONSize+1))
+		b = append(b, '[')
+		ok := true
+		for i := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, ok = appendProductJSON(b, &v[i]); !ok {
+				break
+			}
+		}
+		if ok {
+			return append(b, ']'), nil
+		}
+	case ErrorResponse:
+		if b, ok := appendErrorJSON(make([]byte, 0, 256), &v); ok {
+			return b, nil
+		}
+	}
+	return json.Marshal(v)
+}
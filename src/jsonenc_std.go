@@ -0,0 +1,10 @@
+//go:build stdjson
+
+package main
+
+import "encoding/json"
+
+// marshalJSON is json.Marshal; this build was made with -tags stdjson
+func marshalJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fullProduct sets every Product field, timestamps off UTC and with
+// nanoseconds so their formatting is exercised
+func fullProduct() Product {
+	price := 1999
+	zone := time.FixedZone("", -5*3600-30*60)
+	created := time.Date(2026, 1, 2, 3, 4, 5, 600700800, zone)
+	deleted, expires := created.Add(time.Hour), time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+	return Product{
+		ProductID: 42, SKU: "SKU-42", Manufacturer: "Acme", CategoryID: 7, Weight: 1500, SomeOtherID: 3,
+		Name: "Widget", Description: "A widget.\nTwo lines.", Price: &price, Currency: "USD", Quantity: 12,
+		CreatedAt: created, UpdatedAt: created.Add(time.Nanosecond), DeletedAt: &deleted, ExpiresAt: &expires,
+	}
+}
+
+func TestMarshalJSONMatchesEncodingJSON(t *testing.T) {
+	zero := 0
+	year10000 := time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+	with := func(edit func(p *Product)) Product {
+		p := fullProduct()
+		edit(&p)
+		return p
+	}
+	for _, tc := range []struct {
+		name string
+		v    any
+		// fast is whether appendProductJSON or appendErrorJSON should
+		// encode v itself rather than leave it to encoding/json
+		fast bool
+	}{
+		{"zero product", Product{}, true},
+		{"full product", fullProduct(), true},
+		{"nil pointers and zero times", with(func(p *Product) {
+			p.Price, p.DeletedAt, p.ExpiresAt = nil, nil, nil
+			p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{}
+			p.Currency = ""
+		}), true},
+		{"zero price", with(func(p *Product) { p.Price = &zero }), true},
+		{"negative numbers", with(func(p *Product) { p.Weight, p.Quantity, p.ProductID = -1, -2, -1<<62 }), true},
+		{"escapes", with(func(p *Product) {
+			p.Name = `quote " backslash \ slash / <b>&amp;</b>`
+			p.Description = "\b\f\n\r\t\x00\x01\x1f\x7f"
+		}), true},
+		{"line and paragraph separators", with(func(p *Product) { p.Description = "a\u2028b\u2029c" }), true},
+		{"non-ASCII", with(func(p *Product) {
+			p.Manufacturer = "Crème Brûlée Société"
+			p.Name = "日本語の名前"
+			p.Description = "rocket \U0001F680, e + combining acute e\u0301, BOM \ufeff, U+FFFD \ufffd"
+		}), true},
+		{"invalid UTF-8", with(func(p *Product) { p.Name = "bad \xff byte" }), false},
+		{"year 10000", with(func(p *Product) { p.ExpiresAt = &year10000 }), false},
+
+		{"nil list", []Product(nil), true},
+		{"empty list", []Product{}, true},
+		{"list", []Product{fullProduct(), {}, with(func(p *Product) { p.SKU = "<SKU>" })}, true},
+		{"list with invalid UTF-8", []Product{fullProduct(), with(func(p *Product) { p.SKU = "\xc3" })}, false},
+
+		{"minimal error", ErrorResponse{Error: "NOT_FOUND", Message: "Product not found"}, true},
+		{"full error", ErrorResponse{
+			Error: "INVALID_INPUT", Message: "Validation failed", Details: `sku "<x>" & more`, RequestID: "req-ü-1",
+			Fields: []FieldError{
+				{Field: "sku", Constraint: "must be between 1 and 100 characters", Value: ""},
+				{Field: "weight", Constraint: "must be >= 0", Value: -5},
+				{Field: "price", Constraint: "must be set", Value: nil},
+				{Field: "ratio", Constraint: "must be whole", Value: 1.5},
+				{Field: "tags", Constraint: "must be short", Value: []string{"a<b", "é"}},
+				{Field: "name", Constraint: "must not contain \u2028", Value: "line\u2029sep"},
+			},
+		}, true},
+		{"error with empty fields", ErrorResponse{Error: "X", Message: "y", Fields: []FieldError{}}, true},
+		{"error with invalid UTF-8", ErrorResponse{Error: "X", Message: "bad \xfe"}, false},
+		{"field value with invalid UTF-8", ErrorResponse{Error: "X", Message: "y", Fields: []FieldError{{Field: "sku", Constraint: "c", Value: "\xff"}}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want, wantErr := json.Marshal(tc.v)
+			got, err := marshalJSON(tc.v)
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("got error %v, encoding/json got %v", err, wantErr)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+
+			var fast bool
+			switch v := tc.v.(type) {
+			case Product:
+				_, fast = appendProductJSON(nil, &v)
+			case []Product:
+				fast = true
+				for i := range v {
+					if _, ok := appendProductJSON(nil, &v[i]); !ok {
+						fast = false
+					}
+				}
+			case ErrorResponse:
+				_, fast = appendErrorJSON(nil, &v)
+			}
+			if fast != tc.fast {
+				t.Errorf("encoded by hand: %v, want %v", fast, tc.fast)
+			}
+		})
+	}
+}
+
+// benchmarkMarshal times marshalJSON against json.Marshal on v
+func benchmarkMarshal(b *testing.B, v any) {
+	for _, bc := range []struct {
+		name    string
+		marshal func(any) ([]byte, error)
+	}{
+		{"marshalJSON", marshalJSON},
+		{"encoding-json", json.Marshal},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := bc.marshal(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMarshalProduct(b *testing.B) {
+	benchmarkMarshal(b, fullProduct())
+}
+
+func BenchmarkMarshalProductList(b *testing.B) {
+	items := make([]Product, 500)
+	for i := range items {
+		items[i] = fullProduct()
+		items[i].ProductID = i + 1
+	}
+	benchmarkMarshal(b, items)
+}
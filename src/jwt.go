@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// jwtSubjectKey is the gin context key holding the sub claim of the bearer
+// token that authenticated the request, for the request log and audit
+const jwtSubjectKey = "jwt_subject"
+
+// jwtClockSkew is how far exp and nbf may be missed by, for clocks that
+// disagree a little
+const jwtClockSkew = time.Minute
+
+// jwksMinRefetch spaces out the fetches a token with an unknown kid causes,
+// so a stream of forged tokens can't hammer the JWKS URL
+const jwksMinRefetch = time.Minute
+
+// jwksFetchTimeout bounds one JWKS download. It is the fetch's own
+// deadline, not the request's, so a client that gives up doesn't cut short
+// a fetch other requests are waiting on.
+const jwksFetchTimeout = 5 * time.Second
+
+// maxJWKSBytes caps a JWKS response body
+const maxJWKSBytes = 1 << 20
+
+// jwtClaims are the claims a token is checked against
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ClientID  string      `json:"client_id"`
+	ExpiresAt float64     `json:"exp"`
+	NotBefore float64     `json:"nbf"`
+	// Every claim, for the roles one
+	all map[string]any
+}
+
+// jwtAudience is an aud claim, which may be one string or a list
+type jwtAudience []string
+
+func (aud *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*aud = jwtAudience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(aud))
+}
+
+// hasRole reports whether the claim named claim holds role, either as its
+// whole value or as one element of a list, as Cognito's cognito:groups does
+func (c *jwtClaims) hasRole(claim, role string) bool {
+	switch v := c.all[claim].(type) {
+	case string:
+		return slices.Contains(strings.Fields(v), role)
+	case []any:
+		return slices.Contains(v, any(role))
+	}
+	return false
+}
+
+// jwksCache holds the RS256 keys published at a JWKS URL by kid. They are
+// fetched on first use and again once older than refresh, or when a token
+// names a kid that isn't known yet, as happens after the issuer rotates.
+// A failed fetch keeps the keys already held. One fetch runs at a time,
+// outside mu, so verifying against a key already held never waits on it.
+type jwksCache struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+func newJWKSCache(url string, refresh time.Duration) *jwksCache {
+	return &jwksCache{url: url, refresh: refresh, client: &http.Client{}}
+}
+
+// key returns the key for kid. A stale key is returned at once while a
+// fetch refreshes it; a kid not held yet waits for the fetch, or until ctx
+// ends.
+func (j *jwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	now := time.Now()
+	k, known := j.keys[kid]
+	refetch := (!known || now.Sub(j.fetchedAt) >= j.refresh) && now.Sub(j.triedAt) >= jwksMinRefetch
+	j.mu.Unlock()
+
+	if refetch {
+		done := j.fetches.DoChan("", j.refetch)
+		if known {
+			return k, nil
+		}
+		select {
+		case res := <-done:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		j.mu.Lock()
+		k, known = j.keys[kid]
+		j.mu.Unlock()
+	}
+	if !known {
+		return nil, fmt.Errorf("no signing key with kid %q", kid)
+	}
+	return k, nil
+}
+
+// refetch downloads the JWKS and replaces the keys held if that worked. A
+// fetch that ran out of time doesn't count as tried, so the next token
+// fetches again rather than waiting out jwksMinRefetch with no keys.
+func (j *jwksCache) refetch() (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	if err == nil {
+		j.keys, j.fetchedAt = keys, now
+	}
+	if !contextError(err) {
+		j.triedAt = now
+	}
+	return nil, err
+}
+
+// fetch downloads and parses the JWKS, keeping its RSA signing keys
+func (j *jwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s answered %d", j.url, resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("jwks: decode: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// verifyJWT checks an RS256 token's signature against the JWKS and its
+// issuer, audience and lifetime against JWT_ISSUER and JWT_AUDIENCE.
+// Cognito access tokens carry no aud; their client_id is checked instead.
+func (a *API) verifyJWT(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("alg %q is not RS256", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	key, err := a.jwks.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("bad signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := decodeJWTPart(parts[1], &claims.all); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != a.cfg.JWTIssuer:
+		return nil, fmt.Errorf("issuer %q is not accepted", claims.Issuer)
+	case a.cfg.JWTAudience != "" && !slices.Contains(claims.Audience, a.cfg.JWTAudience) &&
+		(len(claims.Audience) > 0 || claims.ClientID != a.cfg.JWTAudience):
+		return nil, errors.New("token is for another audience")
+	case claims.ExpiresAt == 0 || now.After(time.Unix(int64(claims.ExpiresAt), 0).Add(jwtClockSkew)):
+		return nil, errors.New("token has expired")
+	case claims.NotBefore != 0 && now.Add(jwtClockSkew).Before(time.Unix(int64(claims.NotBefore), 0)):
+		return nil, errors.New("token is not valid yet")
+	}
+	return &claims, nil
+}
+
+// decodeJWTPart decodes one base64url JSON segment of a token into v
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token segment")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token segment")
+	}
+	return nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requireToken applies AUTH_MODE=jwt or both. Writes need a bearer token
+// whose JWT_ROLES_CLAIM holds JWT_WRITER_ROLE (with both, a valid X-API-Key
+// does too); reads without credentials stay open, and any valid token reads.
+// A missing, invalid or expired token is 401 and one lacking the role is
+// 403. With ADMIN_API_KEYS set, /admin routes are left to requireAdmin.
+func (a *API) requireToken(c *gin.Context) {
+	if len(a.cfg.AdminAPIKeys) > 0 && isAdminRoute(c.FullPath()) {
+		c.Next()
+		return
+	}
+	write := writeMethod(c.Request.Method)
+	if a.cfg.AuthMode == authModeBoth && c.GetHeader(apiKeyHeader) != "" {
+		if id, ok := matchAPIKey(a.cfg.APIKeys, c.GetHeader(apiKeyHeader)); ok {
+			c.Set(apiKeyIDKey, id)
+			c.Next()
+			return
+		}
+		if write {
+			a.abortUnauthorized(c, "Missing or invalid API key", "Write requests require a valid "+apiKeyHeader+" header or bearer token")
+			return
+		}
+	}
+
+	token, ok := bearerToken(c.GetHeader("Authorization"))
+	if !ok {
+		if !write {
+			c.Next()
+			return
+		}
+		a.abortUnauthorized(c, "Missing bearer token", "Write requests require an Authorization: Bearer token")
+		return
+	}
+	claims, err := a.verifyJWT(c.Request.Context(), token)
+	if err != nil {
+		a.logger.Info("bearer token rejected", "request_id", requestID(c), "error", err)
+		a.abortUnauthorized(c, "Invalid bearer token", err.Error())
+		return
+	}
+	c.Set(jwtSubjectKey, claims.Subject)
+	if write && !claims.hasRole(a.cfg.JWTRolesClaim, a.cfg.JWTWriterRole) {
+		abortWithError(c, http.StatusForbidden, ErrorResponse{
+			Error:     "FORBIDDEN",
+			Message:   "Token lacks the writer role",
+			Details:   "Write requests require " + a.cfg.JWTWriterRole + " in the token's " + a.cfg.JWTRolesClaim + " claim",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Next()
+}
+
+// abortUnauthorized answers 401 naming the credentials AUTH_MODE accepts
+func (a *API) abortUnauthorized(c *gin.Context, message, details string) {
+	challenge := `Bearer error="invalid_token"`
+	if a.cfg.AuthMode == authModeBoth {
+		challenge += `, ApiKey header="` + apiKeyHeader + `"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	abortWithError(c, http.StatusUnauthorized, ErrorResponse{
+		Error:     "UNAUTHORIZED",
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(c),
+	})
+}
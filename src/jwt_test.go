@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer publishes key as kid "k1", waiting delay before each answer,
+// and counts the requests it gets
+func jwksServer(t *testing.T, key *rsa.PublicKey, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	body, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "k1", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(delay)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func testRSAKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key.PublicKey
+}
+
+// TestJWKSFetchOutlivesCanceledRequest checks a request that gives up
+// while the first fetch runs doesn't leave the cache keyless for
+// jwksMinRefetch
+func TestJWKSFetchOutlivesCanceledRequest(t *testing.T) {
+	key := testRSAKey(t)
+	srv, hits := jwksServer(t, key, 200*time.Millisecond)
+	j := newJWKSCache(srv.URL, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := j.key(ctx, "k1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the request's own deadline", err)
+	}
+	got, err := j.key(context.Background(), "k1")
+	if err != nil || !got.Equal(key) {
+		t.Fatalf("after a canceled first request: got %v, %v", got, err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("%d fetches, want the first one to have been waited on", n)
+	}
+}
+
+func TestJWKSConcurrentLookupsShareOneFetch(t *testing.T) {
+	key := testRSAKey(t)
+	srv, hits := jwksServer(t, key, 100*time.Millisecond)
+	j := newJWKSCache(srv.URL, time.Hour)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := j.key(context.Background(), "k1"); err != nil || !got.Equal(key) {
+				t.Errorf("got %v, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := hits.Load(); n != 1 {
+		t.Errorf("%d fetches for 20 concurrent lookups, want 1", n)
+	}
+	if _, err := j.key(context.Background(), "k2"); err == nil {
+		t.Error("unknown kid within jwksMinRefetch of the last fetch: no error")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("unknown kid fetched again within jwksMinRefetch: %d fetches", n)
+	}
+}
+
+func TestJWKSStaleKeyDoesNotWait(t *testing.T) {
+	key := testRSAKey(t)
+	srv, hits := jwksServer(t, key, 500*time.Millisecond)
+	j := newJWKSCache(srv.URL, time.Hour)
+	// Held keys from a fetch older than refresh and jwksMinRefetch
+	j.keys = map[string]*rsa.PublicKey{"k1": key}
+	j.fetchedAt, j.triedAt = time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)
+
+	start := time.Now()
+	if got, err := j.key(context.Background(), "k1"); err != nil || !got.Equal(key) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("stale key returned after %v, behind the refresh", waited)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		j.mu.Lock()
+		refreshed := time.Since(j.fetchedAt) < time.Hour
+		j.mu.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale keys never refreshed")
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+}
@@ -47,9 +47,9 @@ class ProductHttpUser(HttpUser):
         pid = ProductHttpUser.max_product_id
         payload = random_product(pid)
         self.client.post(
-            f"/products/{pid}/details",
+            f"/v1/products/{pid}/details",
             json=payload,
-            name="/products/[id]/details",
+            name="/v1/products/[id]/details",
         )
 
     @task(5)  # weight=5 → more frequent (simulates read-heavy real world)
@@ -61,8 +61,8 @@ class ProductHttpUser(HttpUser):
             return
         pid = random.randint(1, ProductHttpUser.max_product_id)
         self.client.get(
-            f"/products/{pid}",
-            name="/products/[id]",
+            f"/v1/products/{pid}",
+            name="/v1/products/[id]",
         )
 
     @task(1)
@@ -70,8 +70,8 @@ class ProductHttpUser(HttpUser):
         """GET a product that doesn't exist (test 404 handling)."""
         pid = random.randint(900000, 999999)
         with self.client.get(
-            f"/products/{pid}",
-            name="/products/[id] (404)",
+            f"/v1/products/{pid}",
+            name="/v1/products/[id] (404)",
             catch_response=True,
         ) as resp:
             if resp.status_code == 404:
@@ -82,9 +82,9 @@ class ProductHttpUser(HttpUser):
         """POST invalid data (test 400 handling)."""
         pid = random.randint(1, 100)
         with self.client.post(
-            f"/products/{pid}/details",
+            f"/v1/products/{pid}/details",
             json={"product_id": pid},  # missing required fields
-            name="/products/[id]/details (400)",
+            name="/v1/products/[id]/details (400)",
             catch_response=True,
         ) as resp:
             if resp.status_code == 400:
@@ -106,9 +106,9 @@ class ProductFastHttpUser(FastHttpUser):
         pid = ProductFastHttpUser.max_product_id
         payload = random_product(pid)
         self.client.post(
-            f"/products/{pid}/details",
+            f"/v1/products/{pid}/details",
             json=payload,
-            name="/products/[id]/details",
+            name="/v1/products/[id]/details",
         )
 
     @task(5)
@@ -118,16 +118,16 @@ class ProductFastHttpUser(FastHttpUser):
             return
         pid = random.randint(1, ProductFastHttpUser.max_product_id)
         self.client.get(
-            f"/products/{pid}",
-            name="/products/[id]",
+            f"/v1/products/{pid}",
+            name="/v1/products/[id]",
         )
 
     @task(1)
     def get_nonexistent_product(self):
         pid = random.randint(900000, 999999)
         with self.client.get(
-            f"/products/{pid}",
-            name="/products/[id] (404)",
+            f"/v1/products/{pid}",
+            name="/v1/products/[id] (404)",
             catch_response=True,
         ) as resp:
             if resp.status_code == 404:
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the current request ID
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds client-supplied IDs so they can't bloat log lines
+const maxRequestIDLength = 128
+
+// logLevel is the level of the logger newLogger returns; PUT /admin/loglevel
+// changes it while running
+var logLevel slog.LevelVar
+
+// newLogger returns a JSON logger writing to stdout at level and installs it
+// as the slog and log package default so every log line is structured
+func newLogger(level slog.Level) *slog.Logger {
+	logLevel.Set(level)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel}))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// parseLogLevel maps a LOG_LEVEL value to a slog.Level
+func parseLogLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// assignRequestID reuses an incoming X-Request-ID or generates one, echoes
+// it in the response and stores it on the context for handlers and logs
+func assignRequestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// newRequestID returns 16 random bytes, hex encoded
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestID returns the ID assigned by assignRequestID, or "" outside a request
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// logRequests emits one JSON line per request. 5xx responses log at error and
+// 4xx at warn, so LOG_LEVEL=warn keeps only failed requests.
+func (a *API) logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	if !a.logger.Enabled(c.Request.Context(), level) {
+		return
+	}
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", requestID(c)),
+		slog.String("method", c.Request.Method),
+		slog.String("route", route),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if id := c.GetString(apiKeyIDKey); id != "" {
+		attrs = append(attrs, slog.String("api_key_id", id))
+	}
+	if sub := c.GetString(jwtSubjectKey); sub != "" {
+		attrs = append(attrs, slog.String("subject", sub))
+	}
+	if id := c.GetString(signingKeyIDKey); id != "" {
+		attrs = append(attrs, slog.String("signing_key_id", id))
+	}
+	if tenant := c.GetString(tenantIDKey); tenant != "" {
+		attrs = append(attrs, slog.String("tenant_id", tenant))
+	}
+	if a.logger.Enabled(c.Request.Context(), slog.LevelDebug) {
+		attrs = append(attrs, slog.Any("request_headers", dumpHeaders(c.Request.Header)))
+	}
+	if fault := c.GetString(chaosFaultKey); fault != "" {
+		attrs = append(attrs, slog.String("chaos", fault))
+	}
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	a.logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
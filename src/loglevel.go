@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLevelNames are the accepted LOG_LEVEL and PUT /admin/loglevel values
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// redactedHeaders are logged without their value by request dumps
+var redactedHeaders = []string{apiKeyHeader, "Authorization", "Cookie"}
+
+// LogLevelResponse is the body of GET and PUT /admin/loglevel
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// logLevelName names level as LOG_LEVEL does
+func logLevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// dumpHeaders returns the request headers for a debug request log, secrets
+// redacted
+func dumpHeaders(h http.Header) map[string]string {
+	dump := make(map[string]string, len(h))
+	for name, values := range h {
+		if slices.ContainsFunc(redactedHeaders, func(r string) bool { return http.CanonicalHeaderKey(r) == name }) {
+			dump[name] = "[redacted]"
+			continue
+		}
+		dump[name] = strings.Join(values, ", ")
+	}
+	return dump
+}
+
+// getLogLevel handles GET /admin/loglevel
+// Returns 200 with the level in force
+func (a *API) getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, LogLevelResponse{Level: logLevelName(logLevel.Level())})
+}
+
+// setLogLevel handles PUT /admin/loglevel
+// Changes the level of every later log line, without a restart. At debug
+// the request log also carries each request's headers, secrets redacted.
+// Returns 200 with the new level, 400 if the level isn't one of logLevelNames
+func (a *API) setLogLevel(c *gin.Context) {
+	var req LogLevelResponse
+	if err := a.readJSON(c, &req); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(req.Level))
+	if !slices.Contains(logLevelNames, name) {
+		errs := []FieldError{{Field: "level", Constraint: "must be one of " + strings.Join(logLevelNames, ", "), Value: req.Level}}
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	from := logLevel.Level()
+	logLevel.Set(parseLogLevel(name))
+	// Warn, so the change is logged whatever the old and new levels are
+	a.logger.Warn("log level changed", "from", logLevelName(from), "to", name,
+		"request_id", requestID(c), "api_key_id", c.GetString(apiKeyIDKey))
+	c.JSON(http.StatusOK, LogLevelResponse{Level: name})
+}
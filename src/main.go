@@ -1,156 +1,391 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"text/main/buildinfo"
 )
 
 // Product matches the Product schema in api.yaml
 type Product struct {
-	ProductID    int    `json:"product_id"`
-	SKU          string `json:"sku"`
-	Manufacturer string `json:"manufacturer"`
-	CategoryID   int    `json:"category_id"`
-	Weight       int    `json:"weight"`
-	SomeOtherID  int    `json:"some_other_id"`
+	ProductID    int    `json:"product_id" xml:"product_id"`
+	SKU          string `json:"sku" xml:"sku"`
+	Manufacturer string `json:"manufacturer" xml:"manufacturer"`
+	CategoryID   int    `json:"category_id" xml:"category_id"`
+	Weight       int    `json:"weight" xml:"weight"`
+	SomeOtherID  int    `json:"some_other_id" xml:"some_other_id"`
+
+	// Optional descriptive fields; Price is in cents of Currency, and the two
+	// are set together or not at all
+	Name        string `json:"name,omitempty" xml:"name,omitempty"`
+	Description string `json:"description,omitempty" xml:"description,omitempty"`
+	Price       *int   `json:"price,omitempty" xml:"price,omitempty"`
+	Currency    string `json:"currency,omitempty" xml:"currency,omitempty"`
+	// Units in stock; POST /products/{id}/quantity/adjust changes it
+	// without racing other writers
+	Quantity int `json:"quantity" xml:"quantity"`
+
+	// Set by the store on every write; values sent by clients are ignored
+	CreatedAt time.Time `json:"created_at,omitzero" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at,omitzero" xml:"updated_at"`
+	// Set while the product is soft-deleted: DELETE sets it, POST
+	// /products/{id}/restore clears it, and clients cannot write it
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+	// Set when the product was written with a TTL; from then on it reads
+	// as not found
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+}
+
+// clone returns a copy of p that shares no memory with it, so the copy can
+// be decoded into without touching p
+func (p Product) clone() Product {
+	if p.Price != nil {
+		price := *p.Price
+		p.Price = &price
+	}
+	if p.DeletedAt != nil {
+		deletedAt := *p.DeletedAt
+		p.DeletedAt = &deletedAt
+	}
+	if p.ExpiresAt != nil {
+		expiresAt := *p.ExpiresAt
+		p.ExpiresAt = &expiresAt
+	}
+	return p
 }
 
-// ErrorResponse matches the Error schema in api.yaml
+// ErrorResponse matches the Error schema in api.yaml, plus the request ID
+// so a client report can be matched to the server's log line
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-	Details string `json:"details,omitempty"`
+	Error     string       `json:"error" xml:"code"`
+	Message   string       `json:"message" xml:"message"`
+	Details   string       `json:"details,omitempty" xml:"details,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty" xml:"field,omitempty"`
+	RequestID string       `json:"request_id,omitempty" xml:"request_id,omitempty"`
 }
 
-// In-memory store: hashmap for O(1) lookups
-// sync.RWMutex for thread-safe concurrent access
-var (
-	products = make(map[int]Product)
-	mu       sync.RWMutex
+// Pagination bounds for list endpoints
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+	maxMultiGetIDs   = 100
 )
 
-func main() {
-	router := gin.Default()
-
-	// Product endpoints per api.yaml
-	router.GET("/products/:productId", getProduct)
-	router.POST("/products/:productId/details", addProductDetails)
-
-	// Health check (useful for ECS health checks)
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
+// defaultShutdownTimeout bounds how long in-flight requests may take to
+// finish after SIGTERM when SHUTDOWN_TIMEOUT is not set
+const defaultShutdownTimeout = 10 * time.Second
 
-	router.Run(":8080")
-}
-
-// getProduct handles GET /products/{productId}
-// Returns 200 with product, 400 if bad ID, 404 if not found
-func getProduct(c *gin.Context) {
-	// Parse and validate productId
-	productID, err := strconv.Atoi(c.Param("productId"))
-	if err != nil || productID < 1 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "INVALID_INPUT",
-			Message: "Invalid product ID",
-			Details: "Product ID must be a positive integer",
-		})
+func main() {
+	migrate := flag.Bool("migrate", false, "apply the PostgreSQL migrations and exit")
+	flag.Parse()
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *migrate {
+		if err := runMigrations(cfg); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
 		return
 	}
+	gin.SetMode(cfg.GinMode)
+	logger := newLogger(cfg.LogLevel)
+	build := buildinfo.Get()
+	slog.Info("starting", "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime,
+		"go_version", build.GoVersion, "modified", build.Modified)
 
-	// Lookup in map (read lock for concurrency safety)
-	mu.RLock()
-	product, exists := products[productID]
-	mu.RUnlock()
+	// ECS sends SIGTERM before killing the task; SIGINT covers Ctrl-C locally
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if !exists {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Error:   "NOT_FOUND",
-			Message: "Product not found",
-			Details: "No product found with ID " + strconv.Itoa(productID),
-		})
-		return
+	// Background workers outlive the HTTP server so they can flush after the drain
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var wal *WAL
+
+	// A bad key pair fails startup before any store is touched
+	var certs *certReloader
+	if cfg.TLSCertFile != "" {
+		if certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
 	}
 
-	c.JSON(http.StatusOK, product)
-}
+	tp, err := newTracerProvider(ctx, cfg)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	if cfg.XRayEnabled {
+		if err := configureXRay(cfg); err != nil {
+			log.Fatalf("xray: %v", err)
+		}
+	}
 
-// addProductDetails handles POST /products/{productId}/details
-// Returns 204 on success, 400 if invalid input, 404 if path/body mismatch
-func addProductDetails(c *gin.Context) {
-	// Parse and validate productId from URL path
-	productID, err := strconv.Atoi(c.Param("productId"))
-	if err != nil || productID < 1 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "INVALID_INPUT",
-			Message: "Invalid product ID in path",
-			Details: "Product ID must be a positive integer",
-		})
-		return
+	store, err := newStore(cfg)
+	if err != nil {
+		log.Fatalf("store: %v", err)
+	}
+	var breaker *circuitBreaker
+	if cfg.StoreBackend != "memory" && cfg.BreakerErrorRate > 0 {
+		breaker = newCircuitBreaker(cfg)
+		store = newBreakerStore(store, breaker)
+	}
+	if cfg.CacheMaxEntries > 0 {
+		store = newCachingStore(store, cfg.CacheMaxEntries, cfg.CacheTTL)
+	}
+	var writeBehind *writeBehindStore
+	if cfg.WriteBehind {
+		if writeBehind, err = newWriteBehindStore(ctx, store, cfg); err != nil {
+			log.Fatalf("write-behind: %v", err)
+		}
+		slog.Info("write-behind enabled", "backend", cfg.StoreBackend, "products", writeBehind.Count())
+		store = writeBehind
 	}
 
-	// Bind JSON body
-	var p Product
-	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "INVALID_INPUT",
-			Message: "Invalid request body",
-			Details: err.Error(),
-		})
-		return
+	// Optional snapshot + WAL persistence for the in-memory store
+	if mem, ok := store.(*InMemoryStore); ok {
+		var snapSeq uint64
+		if cfg.SnapshotPath != "" {
+			snapSeq = restoreSnapshot(mem, cfg.SnapshotPath)
+		}
+		if cfg.WALPath != "" {
+			var records []walRecord
+			wal, records, err = OpenWAL(cfg.WALPath, cfg.WALFsync)
+			if err != nil {
+				log.Fatalf("wal: %v", err)
+			}
+			replayed := mem.Replay(records, snapSeq)
+			slog.Info("wal replayed", "path", cfg.WALPath, "applied", replayed, "records", len(records))
+			wal.advanceTo(snapSeq)
+			mem.AttachWAL(wal)
+		}
+		if cfg.HistorySize > 0 {
+			mem.EnableHistory(cfg.HistorySize, cfg.HistoryKeepOnDelete)
+		}
+		if cfg.MaxProducts > 0 {
+			mem.SetCapacity(cfg.MaxProducts, cfg.Eviction == evictionLRU)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runExpirySweeper(workers, mem, cfg.TTLSweepInterval)
+		}()
+		if cfg.SnapshotPath != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runSnapshotter(workers, mem, cfg.SnapshotPath, cfg.SnapshotInterval)
+			}()
+		}
+	}
+	// A tenantStore sets up each tenant's store itself
+	if ts, ok := store.(*tenantStore); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runExpirySweeper(workers, ts, cfg.TTLSweepInterval)
+		}()
 	}
 
-	// Validate required fields and constraints
-	if err := validateProduct(p); err != "" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "INVALID_INPUT",
-			Message: "Validation failed",
-			Details: err,
-		})
-		return
+	api := NewAPI(store, cfg, logger)
+	if breaker != nil {
+		api.breaker = breaker
+		api.metrics.observeBreaker(breaker)
+	}
+	if cfg.AuditLogPath != "" {
+		if err := api.audit.appendTo(cfg.AuditLogPath); err != nil {
+			log.Fatalf("audit log: %v", err)
+		}
+	}
+	router := SetupRouter(api)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		api.webhooks.run(workers, cfg.WebhookWorkers)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reloadOnSIGHUP(workers, api, certs)
+	}()
+	if writeBehind != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writeBehind.run(workers, api.metrics.writeBehindFlush)
+		}()
 	}
+	if api.events, err = newEventPublisher(ctx, cfg, api.metrics.eventResult); err != nil {
+		log.Fatalf("events: %v", err)
+	}
+	if api.events != nil {
+		api.changeSinks = append(api.changeSinks, api.events)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			api.events.run(workers)
+		}()
+	}
+	if cfg.SeedFile != "" {
+		if err := api.loadSeedFile(cfg.SeedFile); err != nil {
+			if cfg.SeedRequired {
+				log.Fatalf("seed: %v", err)
+			}
+			slog.Warn("seed file not loaded, continuing", "path", cfg.SeedFile, "error", err)
+		}
+	}
+	// Snapshot, WAL and seed file are loaded above, so traffic can be
+	// accepted right away
+	api.started.Store(true)
 
-	// Check that the path productId matches the body product_id
-	if p.ProductID != productID {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "INVALID_INPUT",
-			Message: "Product ID mismatch",
-			Details: "Path product ID does not match body product_id",
-		})
-		return
+	var openConns atomic.Int64
+	api.metrics.observeOpenConnections(openConns.Load)
+	srv := newHTTPServer(cfg, cfg.Port, router, &openConns)
+	if certs != nil {
+		srv.TLSConfig = serverTLSConfig(certs)
+	}
+	go func() {
+		listen := srv.ListenAndServe
+		if certs != nil {
+			// The certificate comes from TLSConfig.GetCertificate
+			listen = func() error { return srv.ListenAndServeTLS("", "") }
+		}
+		if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("listen: %v", err)
+		}
+	}()
+	var redirectSrv *http.Server
+	if cfg.HTTPRedirectPort != 0 {
+		redirectSrv = newHTTPServer(cfg, cfg.HTTPRedirectPort, httpsRedirect(cfg.Port), &openConns)
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("redirect listen: %v", err)
+			}
+		}()
+	}
+	var grpcSrv *grpcServer
+	if cfg.GRPCPort != 0 {
+		if grpcSrv, err = api.startGRPC(cfg.GRPCPort); err != nil {
+			log.Fatalf("grpc listen: %v", err)
+		}
 	}
 
-	// Store in memory (write lock)
-	mu.Lock()
-	products[productID] = p
-	mu.Unlock()
+	<-ctx.Done()
+	stop()
+	slog.Info("shutdown: draining", "timeout", cfg.ShutdownTimeout.String())
 
-	// 204 No Content on success
-	c.Status(http.StatusNoContent)
-}
+	// Fail readiness first so the ALB stops sending new traffic
+	api.draining.Store(true)
+	if grpcSrv != nil {
+		grpcSrv.drain()
+	}
+	time.Sleep(cfg.ShutdownDelay)
+	// Event streams never end by themselves
+	api.stream.close()
 
-// validateProduct checks all field constraints from the api.yaml schema
-func validateProduct(p Product) string {
-	if p.ProductID < 1 {
-		return "product_id must be >= 1"
+	// Both servers drain at once, within the same deadline
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	grpcDone := make(chan error, 1)
+	if grpcSrv != nil {
+		go func() { grpcDone <- grpcSrv.shutdown(drainCtx) }()
+	} else {
+		grpcDone <- nil
 	}
-	if len(p.SKU) == 0 || len(p.SKU) > 100 {
-		return "sku must be between 1 and 100 characters"
+	if redirectSrv != nil {
+		// Redirects finish at once, so this doesn't eat into the drain
+		_ = redirectSrv.Shutdown(drainCtx)
 	}
-	if len(p.Manufacturer) == 0 || len(p.Manufacturer) > 200 {
-		return "manufacturer must be between 1 and 200 characters"
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("shutdown: drain timed out", "error", err, "in_flight", api.inFlight.Load())
+	}
+	if err := <-grpcDone; err != nil {
+		slog.Warn("shutdown: grpc drain timed out", "error", err)
+	}
+
+	// Final snapshot and write-behind flush, then close the log
+	stopWorkers()
+	wg.Wait()
+	if err := api.audit.close(); err != nil {
+		slog.Error("audit log close failed", "error", err)
 	}
-	if p.CategoryID < 1 {
-		return "category_id must be >= 1"
+	if wal != nil {
+		if err := wal.Close(); err != nil {
+			slog.Error("wal close failed", "error", err)
+		}
 	}
-	if p.Weight < 0 {
-		return "weight must be >= 0"
+	if tp != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(flushCtx); err != nil {
+			slog.Error("trace flush failed", "error", err)
+		}
 	}
-	if p.SomeOtherID < 1 {
-		return "some_other_id must be >= 1"
+	slog.Info("shutdown: complete")
+}
+
+// newStore builds the ProductStore selected by cfg.StoreBackend
+func newStore(cfg Config) (ProductStore, error) {
+	switch cfg.StoreBackend {
+	case "dynamodb":
+		return NewDynamoDBStore(context.Background(), cfg.DynamoDBTable, cfg.AWSRegion, cfg.DynamoDBEndpoint, cfg.XRayEnabled)
+	case "redis":
+		return NewRedisStore(context.Background(), cfg.RedisAddr, cfg.RedisPassword, cfg.RedisTTL)
+	case "postgres":
+		store, err := NewPostgresStore(context.Background(), cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.PostgresMigrate {
+			if _, err := store.Migrate(context.Background()); err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+		return store, nil
+	case "sqlite":
+		return NewSQLiteStore(context.Background(), cfg.SQLitePath)
+	default:
+		if cfg.MultiTenant {
+			return newTenantStore(cfg), nil
+		}
+		return NewInMemoryStore(cfg.SKUCaseInsensitive), nil
+	}
+}
+
+// newHTTPServer serves handler on port with cfg's timeouts and header limit,
+// so a client can't hold a connection open by sending its request slowly or
+// not at all. open tracks the server's connections.
+func newHTTPServer(cfg Config, port int, handler http.Handler, open *atomic.Int64) *http.Server {
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(port),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				open.Add(1)
+			case http.StateHijacked, http.StateClosed:
+				open.Add(-1)
+			}
+		},
 	}
-	return ""
 }
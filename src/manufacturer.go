@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ManufacturerCount is one entry of GET /manufacturers
+type ManufacturerCount struct {
+	Name     string `json:"name"`
+	Products int    `json:"products"`
+}
+
+// manufacturerCounter is implemented by stores that index products by
+// manufacturer, so GET /manufacturers doesn't have to walk every product
+type manufacturerCounter interface {
+	ManufacturerCounts() map[string]int
+}
+
+// ManufacturerCounts sums the shards' manufacturer indexes, one shard at a time
+func (s *InMemoryStore) ManufacturerCounts() map[string]int {
+	s.SweepExpired(time.Now())
+	counts := make(map[string]int)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for name, ids := range sh.manufacturerIndex {
+			counts[name] += len(ids)
+		}
+		sh.mu.RUnlock()
+	}
+	return counts
+}
+
+// listManufacturers handles GET /manufacturers
+// Returns 200 with every distinct manufacturer and its product count,
+// ordered by name
+func (a *API) listManufacturers(c *gin.Context) {
+	var counts map[string]int
+	if mc, ok := a.baseStore(c.Request.Context()).(manufacturerCounter); ok {
+		counts = mc.ManufacturerCounts()
+	} else {
+		items, err := a.wrappedStore().List(c.Request.Context(), ListFilter{})
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		counts = make(map[string]int)
+		for _, p := range items {
+			counts[p.Manufacturer]++
+		}
+	}
+
+	resp := make([]ManufacturerCount, 0, len(counts))
+	for name, n := range counts {
+		resp = append(resp, ManufacturerCount{Name: name, Products: n})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	c.JSON(http.StatusOK, resp)
+}
+
+// listManufacturerProducts handles GET /manufacturers/{name}/products
+// The name matches exactly unless ?ci=true; limit, offset, sort and fields
+// work as on GET /products
+// Returns 200 with the products ordered by product_id, empty if the
+// manufacturer has none, 400 if a parameter is invalid
+func (a *API) listManufacturerProducts(c *gin.Context) {
+	// gin matches against the decoded URL path, so percent-escapes are already resolved
+	name := normalizeLine(c.Param("name"))
+	if n := utf8.RuneCountInString(name); n == 0 || n > 200 {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid manufacturer",
+			Details:   "manufacturer must be between 1 and 200 characters",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	fold, err := strconv.ParseBool(c.DefaultQuery("ci", "false"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid ci",
+			Details:   "ci must be true or false",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	order, ok := parseSort(c)
+	if !ok {
+		return
+	}
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+
+	filter := ListFilter{Manufacturer: name, ManufacturerFold: fold}
+	items, total, err := a.listSorted(c.Request.Context(), filter, order, limit, offset)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	writeProducts(c, http.StatusOK, projectAll(items, fields))
+}
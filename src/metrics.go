@@ -0,0 +1,261 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"text/main/buildinfo"
+)
+
+// unmatchedRoute labels requests that hit no registered route, so random
+// paths from scanners can't blow up label cardinality
+const unmatchedRoute = "unmatched"
+
+// productCounter is implemented by stores that can report their size cheaply
+type productCounter interface {
+	Count() int
+}
+
+// Metrics owns the Prometheus registry and the collectors served at /metrics
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	excluded map[string]bool
+
+	rateLimited *prometheus.CounterVec
+	ipBlocked   *prometheus.CounterVec
+	shed        prometheus.Counter
+	webhooks    *prometheus.CounterVec
+	events      *prometheus.CounterVec
+	flushes     prometheus.Histogram
+	retries     *prometheus.CounterVec
+	retried     *prometheus.CounterVec
+}
+
+// NewMetrics registers the HTTP collectors plus a product-count gauge when
+// store implements productCounter. Requests to excludedRoutes are not recorded.
+func NewMetrics(store ProductStore, excludedRoutes []string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests processed, by route template, method, status code and fault injected by chaos testing (none, latency or error).",
+		}, []string{"route", "method", "status", "chaos"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency, by route template, method, status code and fault injected by chaos testing.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. ~4s
+		}, []string{"route", "method", "status", "chaos"}),
+		excluded: make(map[string]bool, len(excludedRoutes)),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limit_requests_total",
+			Help: "Requests checked by the per-IP rate limiter, by decision (allowed or rejected).",
+		}, []string{"decision"}),
+		ipBlocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ip_filter_blocked_total",
+			Help: "Requests refused with 403 by the client IP filters, by route group (admin or api).",
+		}, []string{"group"}),
+		shed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_requests_shed_total",
+			Help: "Requests rejected with 503 because MAX_INFLIGHT was reached.",
+		}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Webhook deliveries finished, by result (delivered, failed after every retry, or dropped on a full queue).",
+		}, []string{"result"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "product_events_total",
+			Help: "Product change events sent to SNS or SQS, by result (published, failed, or dropped on a full buffer).",
+		}, []string{"result"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_retries_total",
+			Help: "Store calls repeated after a transient failure, by operation.",
+		}, []string{"operation"}),
+		retried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_retried_calls_total",
+			Help: "Store calls that needed a retry, by outcome (succeeded, failed, exhausted every attempt, or stopped by the request deadline).",
+		}, []string{"outcome"}),
+		flushes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "write_behind_flush_duration_seconds",
+			Help:    "Time taken by successful WRITE_BEHIND flushes to the backing store.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms .. ~8s
+		}),
+	}
+	for _, r := range excludedRoutes {
+		m.excluded[r] = true
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.rateLimited,
+		m.ipBlocked,
+		m.shed,
+		m.webhooks,
+		m.events,
+		m.flushes,
+		m.retries,
+		m.retried,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	build := buildinfo.Get()
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "build_info",
+		Help:        "Always 1; the labels identify the running build, as GET /version does.",
+		ConstLabels: prometheus.Labels{"version": build.Version, "commit": build.Commit, "go_version": build.GoVersion},
+	}, func() float64 { return 1 }))
+	if counter, ok := store.(productCounter); ok {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "products_in_store",
+			Help: "Number of products currently stored.",
+		}, func() float64 { return float64(counter.Count()) }))
+	}
+	if evictions, ok := store.(evictionCounter); ok {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "products_evicted_total",
+			Help: "Products evicted to stay within MAX_PRODUCTS.",
+		}, func() float64 { return float64(evictions.Evicted()) }))
+	}
+	if wb, ok := store.(writeBehindCounter); ok {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "write_behind_queue_depth",
+			Help: "Product writes waiting to be flushed to the backing store.",
+		}, func() float64 { return float64(wb.QueueDepth()) }))
+		for _, c := range []struct {
+			result string
+			pick   func(flushed, dropped, failed int64) int64
+		}{
+			{"flushed", func(f, _, _ int64) int64 { return f }},
+			{"dropped", func(_, d, _ int64) int64 { return d }},
+			{"failed", func(_, _, f int64) int64 { return f }},
+		} {
+			m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "write_behind_writes_total",
+				Help:        "Product writes copied to the backing store, by result (flushed, dropped on a full queue, or failed after every retry).",
+				ConstLabels: prometheus.Labels{"result": c.result},
+			}, func() float64 { return float64(c.pick(wb.WriteBehindCounts())) }))
+		}
+	}
+	if cache, ok := store.(cacheCounter); ok {
+		for _, c := range []struct {
+			name, help string
+			pick       func(hits, misses, evictions, stale int64) int64
+		}{
+			{"store_cache_hits_total", "Product reads answered from the CACHE_MAX_ENTRIES cache.", func(h, _, _, _ int64) int64 { return h }},
+			{"store_cache_misses_total", "Product reads the cache sent to the backing store.", func(_, m, _, _ int64) int64 { return m }},
+			{"store_cache_evictions_total", "Cached products dropped to stay within CACHE_MAX_ENTRIES.", func(_, _, e, _ int64) int64 { return e }},
+			{"store_cache_stale_total", "Expired cached products served because the backing store was unavailable.", func(_, _, _, s int64) int64 { return s }},
+		} {
+			m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: c.name,
+				Help: c.help,
+			}, func() float64 { return float64(c.pick(cache.CacheCounts())) }))
+		}
+	}
+	return m
+}
+
+// observeResponseCache exports the RESPONSE_CACHE_MAX_ENTRIES cache's counts
+func (m *Metrics) observeResponseCache(r *responseCache) {
+	for _, c := range []struct {
+		name, help string
+		pick       func(hits, misses, evictions int64) int64
+	}{
+		{"response_cache_hits_total", "GET /products/{productId} responses served from the RESPONSE_CACHE_MAX_ENTRIES cache.", func(h, _, _ int64) int64 { return h }},
+		{"response_cache_misses_total", "Cacheable GET /products/{productId} responses that had to be encoded.", func(_, m, _ int64) int64 { return m }},
+		{"response_cache_evictions_total", "Cached responses dropped to stay within RESPONSE_CACHE_MAX_ENTRIES.", func(_, _, e int64) int64 { return e }},
+	} {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: c.name,
+			Help: c.help,
+		}, func() float64 { return float64(c.pick(r.counts())) }))
+	}
+}
+
+// observeBreaker exports the store circuit breaker's state and transitions
+func (m *Metrics) observeBreaker(b *circuitBreaker) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "store_circuit_breaker_state",
+		Help: "State of the store circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, func() float64 { return float64(b.State()) }))
+	for state, name := range breakerStateNames {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "store_circuit_breaker_transitions_total",
+			Help:        "Store circuit breaker state changes, by the state entered.",
+			ConstLabels: prometheus.Labels{"state": name},
+		}, func() float64 { return float64(b.transitions[state].Load()) }))
+	}
+}
+
+// observeInFlight exports load() as the http_requests_in_flight gauge
+func (m *Metrics) observeInFlight(load func() int64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Requests currently being served.",
+	}, func() float64 { return float64(load()) }))
+}
+
+// observeOpenConnections exports load() as the http_open_connections gauge
+func (m *Metrics) observeOpenConnections(load func() int64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_open_connections",
+		Help: "Client connections currently open to the HTTP listeners, idle ones included.",
+	}, func() float64 { return float64(load()) }))
+}
+
+// webhookResult counts one finished webhook delivery
+func (m *Metrics) webhookResult(result string) {
+	m.webhooks.WithLabelValues(result).Inc()
+}
+
+// storeRetry counts one repeated store call
+func (m *Metrics) storeRetry(op string) {
+	m.retries.WithLabelValues(op).Inc()
+}
+
+// storeRetryOutcome counts one store call that needed a retry
+func (m *Metrics) storeRetryOutcome(outcome string) {
+	m.retried.WithLabelValues(outcome).Inc()
+}
+
+// writeBehindFlush records the duration of one write-behind flush
+func (m *Metrics) writeBehindFlush(d time.Duration) {
+	m.flushes.Observe(d.Seconds())
+}
+
+// eventResult counts one product change event handled by the publisher
+func (m *Metrics) eventResult(result string) {
+	m.events.WithLabelValues(result).Inc()
+}
+
+// middleware records count and latency for every request, labelled by the
+// matched route template (/products/:productId) rather than the raw path
+func (m *Metrics) middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	if m.excluded[route] {
+		return
+	}
+
+	status := strconv.Itoa(c.Writer.Status())
+	fault := chaosFault(c)
+	m.requests.WithLabelValues(route, c.Request.Method, status, fault).Inc()
+	m.duration.WithLabelValues(route, c.Request.Method, status, fault).Observe(time.Since(start).Seconds())
+}
+
+// handler serves the registry in the Prometheus text format
+func (m *Metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadSheddingExempt routes are served even above MAX_INFLIGHT so the ALB
+// doesn't mark a busy task unhealthy and scrapes still show the overload
+var loadSheddingExempt = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/metrics": true}
+
+// trackInFlight counts requests currently being served so shutdown can report
+// how many were cut off when the drain timeout fired. With MAX_INFLIGHT set,
+// requests beyond the cap are shed with 503 immediately instead of queueing.
+func (a *API) trackInFlight(c *gin.Context) {
+	n := a.inFlight.Add(1)
+	defer a.inFlight.Add(-1)
+
+	if a.cfg.MaxInFlight > 0 && n > int64(a.cfg.MaxInFlight) && !loadSheddingExempt[c.FullPath()] {
+		a.metrics.shed.Inc()
+		c.Header("Retry-After", "1")
+		abortWithError(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "OVERLOADED",
+			Message:   "Server is overloaded",
+			Details:   "More than " + strconv.Itoa(a.cfg.MaxInFlight) + " requests in flight; retry shortly",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Next()
+}
+
+// recoverPanics turns a panic in a handler into a logged stack trace and a
+// 500 carrying only the request ID; the panic value stays in the logs. If
+// the response had already started there is nothing left to send, so the
+// connection just ends with what was written.
+func (a *API) recoverPanics(c *gin.Context) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		if rec == http.ErrAbortHandler {
+			// Deliberate abort; net/http closes the connection quietly
+			panic(rec)
+		}
+		a.logger.Error("panic recovered",
+			"request_id", requestID(c),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"panic", fmt.Sprint(rec),
+			"stack", string(debug.Stack()),
+		)
+		if c.Writer.Written() {
+			c.Abort()
+			return
+		}
+		abortWithError(c, http.StatusInternalServerError, ErrorResponse{
+			Error:     "INTERNAL",
+			Message:   "internal server error",
+			Details:   requestID(c),
+			RequestID: requestID(c),
+		})
+	}()
+	c.Next()
+}
+
+// batchRoute is the JSON batch ingest endpoint
+const batchRoute = "/products/batch"
+
+// bulkRoutes get MAX_BATCH_BODY_BYTES instead of MAX_BODY_BYTES
+var bulkRoutes = map[string]bool{batchRoute: true, importRoute: true}
+
+// limitBody caps how much of a request body handlers can read at
+// MAX_BODY_BYTES (MAX_BATCH_BODY_BYTES for batch ingest and CSV import). A declared
+// Content-Length over the limit is rejected with 413 before anything is read;
+// chunked bodies fail with *http.MaxBytesError once they cross it.
+func (a *API) limitBody(c *gin.Context) {
+	cfg := a.live()
+	limit := cfg.MaxBodyBytes
+	if bulkRoutes[routeTemplate(c.FullPath())] {
+		limit = cfg.MaxBatchBodyBytes
+	}
+	if c.Request.ContentLength > limit {
+		// Don't keep the connection: the unread body would have to be drained
+		c.Header("Connection", "close")
+		writePayloadTooLarge(c, limit)
+		c.Abort()
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	c.Next()
+}
+
+// requireJSON rejects POST, PUT and PATCH requests whose body is not JSON
+// with 415. application/json (with any parameters, e.g. charset) and
+// structured-syntax types such as application/merge-patch+json are accepted,
+// as is application/msgpack on msgpackBodyRoutes; requests with no body pass
+// through so the handler can report what's missing. CSV import checks its
+// own content type.
+func requireJSON(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		c.Next()
+		return
+	}
+	if routeTemplate(c.FullPath()) == importRoute {
+		c.Next()
+		return
+	}
+	if c.Request.ContentLength == 0 {
+		c.Next()
+		return
+	}
+
+	header := c.GetHeader("Content-Type")
+	if msgpackBodyRoutes[routeTemplate(c.FullPath())] && isMsgpack(header) {
+		c.Next()
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		details := "Content-Type must be application/json"
+		if header != "" {
+			details += ", got " + header
+		}
+		abortWithError(c, http.StatusUnsupportedMediaType, ErrorResponse{
+			Error:     "UNSUPPORTED_MEDIA_TYPE",
+			Message:   "Request body must be JSON",
+			Details:   details,
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Next()
+}
+
+// writePayloadTooLarge writes the 413 response for a body over limit bytes
+func writePayloadTooLarge(c *gin.Context, limit int64) {
+	writeError(c, http.StatusRequestEntityTooLarge, ErrorResponse{
+		Error:     "PAYLOAD_TOO_LARGE",
+		Message:   "Request body too large",
+		Details:   "Request body must not exceed " + strconv.FormatInt(limit, 10) + " bytes",
+		RequestID: requestID(c),
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bodyLimitEnv caps bodies small enough to exceed cheaply
+var bodyLimitEnv = map[string]string{"MAX_BODY_BYTES": "1024", "MAX_BATCH_BODY_BYTES": "8192"}
+
+// paddedProduct is the body creating product id, padded with whitespace to
+// size bytes
+func paddedProduct(id, size int) string {
+	body := productJSON(testProduct(id))
+	return body + strings.Repeat(" ", size-len(body))
+}
+
+func TestBodyLimit(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), bodyLimitEnv)
+	for _, tc := range []struct {
+		name, target, body string
+		chunked            bool
+		want               int
+	}{
+		{"at the limit", "/v1/products/1/details", paddedProduct(1, 1024), false, http.StatusCreated},
+		{"declared over the limit", "/v1/products/2/details", paddedProduct(2, 1025), false, http.StatusRequestEntityTooLarge},
+		{"chunked over the limit", "/v1/products/3/details", paddedProduct(3, 4096), true, http.StatusRequestEntityTooLarge},
+		{"batch under its own limit", "/v1/products/batch", "[" + paddedProduct(4, 4096) + "]", false, http.StatusOK},
+		{"batch over its own limit", "/v1/products/batch", "[" + paddedProduct(5, 9000) + "]", true, http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tc.want)
+			}
+			if tc.want == http.StatusRequestEntityTooLarge && errorCode(t, w.Body.Bytes()) != "PAYLOAD_TOO_LARGE" {
+				t.Errorf("got %s, want PAYLOAD_TOO_LARGE", w.Body)
+			}
+		})
+	}
+}
+
+// TestBodyLimitLeavesConnectionUsable sends an oversized body with a
+// second request pipelined behind it. The connection must either be
+// closed after the 413 or answer the second request; the rejected body
+// must never be read as a request.
+func TestBodyLimitLeavesConnectionUsable(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), bodyLimitEnv)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		body   string
+	}{
+		{"declared length", "Content-Length: 4096\r\n", paddedProduct(1, 4096)},
+		{"chunked", "Transfer-Encoding: chunked\r\n", "1000\r\n" + paddedProduct(1, 4096) + "\r\n0\r\n\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			io.WriteString(conn, "POST /v1/products/1/details HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\n"+tc.header+"\r\n"+tc.body+
+				"GET /healthz HTTP/1.1\r\nHost: test\r\n\r\n")
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&e); resp.StatusCode != http.StatusRequestEntityTooLarge || err != nil || e.Error != "PAYLOAD_TOO_LARGE" {
+				t.Fatalf("got %d %+v %v, want 413 PAYLOAD_TOO_LARGE", resp.StatusCode, e, err)
+			}
+			resp.Body.Close()
+
+			next, err := http.ReadResponse(r, nil)
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				if !resp.Close {
+					t.Error("connection closed without Connection: close on the 413")
+				}
+			case err != nil:
+				t.Fatalf("reading the pipelined response: %v", err)
+			case next.StatusCode != http.StatusOK:
+				t.Errorf("pipelined GET: got %d, want 200", next.StatusCode)
+			}
+		})
+	}
+
+	// And the client carries on over a fresh or reused connection
+	client := srv.Client()
+	resp, err := client.Post(srv.URL+"/v1/products/1/details", "application/json", strings.NewReader(paddedProduct(1, 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	for i := range 2 {
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(t.Context(), trace), http.MethodPost, srv.URL+"/v1/products/1/details",
+			strings.NewReader(productJSON(testProduct(1))))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d after the 413: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Errorf("request %d after the 413: got %d", i, resp.StatusCode)
+		}
+		if i == 1 && !reused {
+			t.Error("keep-alive connection not reused for a request within the limit")
+		}
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	router := seededRouter(t, 1, nil)
+	replace := productJSON(testProduct(1))
+	for _, tc := range []struct {
+		name, method, target, body string
+		contentType                string // "-" sends none
+		want                       int
+	}{
+		{"json", "POST", "/v1/products/1/details", replace, "application/json", 204},
+		{"json with charset", "POST", "/v1/products/1/details", replace, "application/json; charset=utf-8", 204},
+		{"json in capitals", "POST", "/v1/products/1/details", replace, `Application/JSON; Charset="UTF-8"`, 204},
+		{"merge patch", "PATCH", "/v1/products/1", `{"weight":3}`, "application/merge-patch+json", 200},
+		{"missing", "POST", "/v1/products/1/details", replace, "-", 415},
+		{"xml", "POST", "/v1/products/1/details", "<product/>", "application/xml", 415},
+		{"form", "POST", "/v1/products/1/details", "product_id=1", "application/x-www-form-urlencoded", 415},
+		{"text", "PATCH", "/v1/products/1", `{"weight":3}`, "text/plain", 415},
+		{"unparsable", "POST", "/v1/products/1/details", replace, "application/json; charset", 415},
+
+		{"get", "GET", "/v1/products/1", "", "application/xml", 200},
+		{"delete", "DELETE", "/v1/products/1", "", "text/plain", 204},
+		{"post without a body", "POST", "/v1/products/99/restore", "", "-", 404},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req := httptest.NewRequest(tc.method, tc.target, body)
+			if tc.contentType != "-" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tc.want)
+			}
+			if tc.want == http.StatusUnsupportedMediaType && errorCode(t, w.Body.Bytes()) != "UNSUPPORTED_MEDIA_TYPE" {
+				t.Errorf("got %s, want UNSUPPORTED_MEDIA_TYPE", w.Body)
+			}
+		})
+	}
+}
+
+// panickingStore panics on every Get with a value that must not reach clients
+type panickingStore struct {
+	ProductStore
+}
+
+func (panickingStore) Get(context.Context, int) (Product, error) {
+	panic("connecting to db-internal:5432 as admin")
+}
+
+// panicRouter is the router over a panickingStore, with routes that panic
+// after writing and with http.ErrAbortHandler, logging as JSON into logs
+func panicRouter(t *testing.T, env map[string]string, logs io.Writer) *gin.Engine {
+	t.Helper()
+	router := SetupRouter(NewAPI(panickingStore{NewInMemoryStore(false)}, testConfig(t, env), slog.New(slog.NewJSONHandler(logs, nil))))
+	router.GET("/test/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("after the header")
+	})
+	router.GET("/test/abort", func(*gin.Context) { panic(http.ErrAbortHandler) })
+	return router
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var logs bytes.Buffer
+	router := panicRouter(t, nil, &logs)
+
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusInternalServerError || err != nil {
+		t.Fatalf("got %d %s, want a JSON 500", w.Code, w.Body)
+	}
+	id := w.Header().Get("X-Request-ID")
+	if want := (ErrorResponse{Error: "INTERNAL", Message: "internal server error", Details: id, RequestID: id}); id == "" || !reflect.DeepEqual(resp, want) {
+		t.Errorf("got %+v, want %+v", resp, want)
+	}
+	if strings.Contains(w.Body.String(), "db-internal") {
+		t.Errorf("panic value leaked to the client: %s", w.Body)
+	}
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Panic     string `json:"panic"`
+		Stack     string `json:"stack"`
+	}
+	for line := range strings.SplitSeq(logs.String(), "\n") {
+		if strings.Contains(line, `"panic recovered"`) {
+			json.Unmarshal([]byte(line), &entry)
+		}
+	}
+	if entry.RequestID != id || !strings.Contains(entry.Panic, "db-internal") || !strings.Contains(entry.Stack, "panickingStore") {
+		t.Errorf("panic log entry %+v", entry)
+	}
+
+	// Under REQUEST_TIMEOUT what the handler wrote is still buffered, so
+	// it is thrown away in favour of the 500
+	w = doRequest(router, http.MethodGet, "/test/partial", "")
+	if w.Code != http.StatusInternalServerError || errorCode(t, w.Body.Bytes()) != "INTERNAL" {
+		t.Errorf("panic after a buffered write: got %d %q, want only the 500", w.Code, w.Body)
+	}
+
+	// Without it the response has started, and a 500 would corrupt it
+	unbuffered := panicRouter(t, map[string]string{"REQUEST_TIMEOUT": "0s"}, io.Discard)
+	w = doRequest(unbuffered, http.MethodGet, "/test/partial", "")
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("panic after writing: got %d %q, want the 200 already sent", w.Code, w.Body)
+	}
+	if w := doRequest(unbuffered, http.MethodGet, "/v1/products/1", ""); w.Code != http.StatusInternalServerError || errorCode(t, w.Body.Bytes()) != "INTERNAL" {
+		t.Errorf("unbuffered panic: got %d %s", w.Code, w.Body)
+	}
+
+	// And a deliberate abort still reaches net/http
+	func() {
+		defer func() {
+			if rec := recover(); rec != http.ErrAbortHandler {
+				t.Errorf("ErrAbortHandler: recovered %v", rec)
+			}
+		}()
+		doRequest(router, http.MethodGet, "/test/abort", "")
+	}()
+}
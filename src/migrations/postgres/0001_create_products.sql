@@ -0,0 +1,27 @@
+-- One row per product, a column per field of the Product schema. IDs and
+-- numbers are bigint so every value the API accepts fits.
+CREATE TABLE products (
+    product_id    bigint      PRIMARY KEY,
+    sku           text        NOT NULL,
+    manufacturer  text        NOT NULL,
+    category_id   bigint      NOT NULL,
+    weight        bigint      NOT NULL,
+    some_other_id bigint      NOT NULL,
+    name          text        NOT NULL DEFAULT '',
+    description   text        NOT NULL DEFAULT '',
+    price         bigint,
+    currency      text        NOT NULL DEFAULT '',
+    quantity      bigint      NOT NULL DEFAULT 0,
+    created_at    timestamptz NOT NULL,
+    updated_at    timestamptz NOT NULL,
+    deleted_at    timestamptz,
+    expires_at    timestamptz,
+    -- PostgresStore maps violations of this name to 409 DUPLICATE_SKU
+    CONSTRAINT products_sku_key UNIQUE (sku)
+);
+
+-- The list filters: ?category_id= and /categories/{id}/products in
+-- product_id order, /manufacturers/{name}/products and the weight range
+CREATE INDEX products_category_id_idx ON products (category_id, product_id);
+CREATE INDEX products_manufacturer_idx ON products (manufacturer);
+CREATE INDEX products_weight_idx ON products (weight);
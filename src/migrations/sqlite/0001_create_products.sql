@@ -0,0 +1,29 @@
+-- One row per product, a column per field of the Product schema. Times are
+-- Unix nanoseconds in UTC, so they keep full precision and sort as numbers.
+CREATE TABLE products (
+    product_id    INTEGER PRIMARY KEY,
+    sku           TEXT    NOT NULL,
+    manufacturer  TEXT    NOT NULL,
+    category_id   INTEGER NOT NULL,
+    weight        INTEGER NOT NULL,
+    some_other_id INTEGER NOT NULL,
+    name          TEXT    NOT NULL DEFAULT '',
+    description   TEXT    NOT NULL DEFAULT '',
+    price         INTEGER,
+    currency      TEXT    NOT NULL DEFAULT '',
+    quantity      INTEGER NOT NULL DEFAULT 0,
+    created_at    INTEGER NOT NULL,
+    updated_at    INTEGER NOT NULL,
+    deleted_at    INTEGER,
+    expires_at    INTEGER
+);
+
+-- GET /products/sku/{sku}, and a backstop for the SKU check SQLiteStore
+-- makes before every write
+CREATE UNIQUE INDEX products_sku_idx ON products (sku);
+
+-- The list filters: ?category_id= and /categories/{id}/products in
+-- product_id order, /manufacturers/{name}/products and the weight range
+CREATE INDEX products_category_id_idx ON products (category_id, product_id);
+CREATE INDEX products_manufacturer_idx ON products (manufacturer);
+CREATE INDEX products_weight_idx ON products (weight);
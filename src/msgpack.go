@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+)
+
+// msgpackContentType is the media type of MessagePack bodies
+const msgpackContentType = "application/msgpack"
+
+// msgpackBodyRoutes accept a MessagePack request body as well as JSON
+var msgpackBodyRoutes = map[string]bool{"/products/:productId/details": true}
+
+// newMsgpackHandle returns a MessagePack codec for the API types. The codec
+// reads codec tags and falls back to json ones, so Product and the response
+// types keep the same field names in both encodings. Times use the msgpack
+// timestamp extension. strict mirrors STRICT_JSON: unknown fields fail the
+// decode.
+func newMsgpackHandle(strict bool) *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.ErrorIfNoField = strict
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return h
+}
+
+var (
+	msgpackStrict  = newMsgpackHandle(true)
+	msgpackLenient = newMsgpackHandle(false)
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder(msgpackContentType, decodeMsgpackForValidation)
+}
+
+// isMsgpack reports whether a Content-Type header names MessagePack
+func isMsgpack(header string) bool {
+	mediaType, _, err := mime.ParseMediaType(header)
+	return err == nil && mediaType == msgpackContentType
+}
+
+// encodeMsgpack encodes v; the API types have nothing that can fail to encode
+func encodeMsgpack(v any) []byte {
+	var buf []byte
+	_ = codec.NewEncoderBytes(&buf, msgpackLenient).Encode(v)
+	return buf
+}
+
+// decodeMsgpack decodes one MessagePack value from body into v
+func decodeMsgpack(body []byte, v any, strict bool) error {
+	if len(body) == 0 {
+		return errEmptyBody
+	}
+	h := msgpackLenient
+	if strict {
+		h = msgpackStrict
+	}
+	return codec.NewDecoderBytes(body, h).Decode(v)
+}
+
+// readBody decodes the request body into v as MessagePack or JSON,
+// whichever its Content-Type names
+func (a *API) readBody(c *gin.Context, v any) error {
+	if !isMsgpack(c.GetHeader("Content-Type")) {
+		return a.readJSON(c, v)
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	return decodeMsgpack(body, v, a.cfg.StrictJSON)
+}
+
+// decodeMsgpackForValidation lets kin-openapi check MessagePack bodies
+// against the JSON schemas: the value is passed through JSON so numbers,
+// maps and timestamps arrive in the shapes the schema validator expects
+func decodeMsgpackForValidation(body io.Reader, header http.Header, schema *openapi3.SchemaRef, encFn openapi3filter.EncodingFn) (any, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := decodeMsgpack(raw, &v, false); err != nil {
+		return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+	}
+	asJSON, err := json.Marshal(v)
+	if err != nil {
+		return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+	}
+	return openapi3filter.JSONBodyDecoder(bytes.NewReader(asJSON), header, schema, encFn)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sameInstant reports whether two optional times are both unset or name the
+// same instant; MessagePack timestamps carry no zone
+func sameInstant(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// sameProductFields compares every Product field, times by instant
+func sameProductFields(t *testing.T, got, want Product) {
+	t.Helper()
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) ||
+		!sameInstant(got.DeletedAt, want.DeletedAt) || !sameInstant(got.ExpiresAt, want.ExpiresAt) {
+		t.Errorf("times: got %v %v %v %v, want %v %v %v %v", got.CreatedAt, got.UpdatedAt, got.DeletedAt, got.ExpiresAt,
+			want.CreatedAt, want.UpdatedAt, want.DeletedAt, want.ExpiresAt)
+	}
+	if (got.Price == nil) != (want.Price == nil) || got.Price != nil && *got.Price != *want.Price {
+		t.Errorf("price: got %v, want %v", got.Price, want.Price)
+	}
+	got.CreatedAt, got.UpdatedAt, got.DeletedAt, got.ExpiresAt, got.Price = want.CreatedAt, want.UpdatedAt, want.DeletedAt, want.ExpiresAt, want.Price
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	want := fullProduct()
+	var got Product
+	if err := decodeMsgpack(encodeMsgpack(want), &got, true); err != nil {
+		t.Fatal(err)
+	}
+	sameProductFields(t, got, want)
+
+	// Field names are the JSON ones, so either encoding reads the other's keys
+	var generic map[string]any
+	if err := decodeMsgpack(encodeMsgpack(want), &generic, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"product_id", "sku", "some_other_id", "created_at", "price"} {
+		if _, ok := generic[key]; !ok {
+			t.Errorf("no %q key in %v", key, generic)
+		}
+	}
+
+	unknown := encodeMsgpack(map[string]any{"product_id": 1, "sku": "SKU-1", "colour": "red"})
+	if err := decodeMsgpack(unknown, &got, true); err == nil {
+		t.Error("strict decode accepted an unknown field")
+	}
+	if err := decodeMsgpack(unknown, &got, false); err != nil {
+		t.Errorf("lenient decode: %v", err)
+	}
+}
+
+func TestMsgpackThroughAPI(t *testing.T) {
+	router := seededRouter(t, 0, nil)
+	send := func(method, target string, body []byte, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if body != nil {
+			req.Header.Set("Content-Type", msgpackContentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	p := testProduct(7)
+	price := 250
+	p.Name, p.Price, p.Currency = "Grüße", &price, "EUR"
+	if w := send(http.MethodPost, "/v1/products/7/details", encodeMsgpack(p)); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+
+	w := send(http.MethodGet, "/v1/products/7", nil, "Accept", msgpackContentType)
+	var got Product
+	if err := decodeMsgpack(w.Body.Bytes(), &got, true); w.Code != http.StatusOK || w.Header().Get("Content-Type") != msgpackContentType || err != nil {
+		t.Fatalf("get: got %d %s %v", w.Code, w.Header().Get("Content-Type"), err)
+	}
+	var asJSON Product
+	if err := json.Unmarshal(doRequest(router, http.MethodGet, "/v1/products/7", "").Body.Bytes(), &asJSON); err != nil {
+		t.Fatal(err)
+	}
+	sameProductFields(t, got, asJSON)
+
+	w = send(http.MethodGet, "/v1/products", nil, "Accept", msgpackContentType)
+	var list []Product
+	if err := decodeMsgpack(w.Body.Bytes(), &list, true); err != nil || len(list) != 1 || list[0].ProductID != 7 {
+		t.Errorf("list: got %d %v %v", w.Code, list, err)
+	}
+
+	w = send(http.MethodGet, "/v1/products/99", nil, "Accept", msgpackContentType)
+	var e ErrorResponse
+	if err := decodeMsgpack(w.Body.Bytes(), &e, true); w.Code != http.StatusNotFound || err != nil || e.Error != "NOT_FOUND" || e.RequestID == "" {
+		t.Errorf("missing: got %d %+v %v", w.Code, e, err)
+	}
+
+	invalid := testProduct(8)
+	invalid.Weight = -1
+	w = send(http.MethodPost, "/v1/products/8/details", encodeMsgpack(invalid))
+	if w.Code != http.StatusBadRequest || errorCode(t, w.Body.Bytes()) != "INVALID_INPUT" {
+		t.Errorf("invalid product: got %d %s", w.Code, w.Body)
+	}
+	w = send(http.MethodPost, "/v1/products/8/details", []byte{0xc1})
+	if w.Code != http.StatusBadRequest || errorCode(t, w.Body.Bytes()) != "INVALID_INPUT" {
+		t.Errorf("malformed body: got %d %s", w.Code, w.Body)
+	}
+}
+
+// BenchmarkProductCodecs compares encoding and decoding one product as
+// MessagePack and as JSON, and reports the size of each body
+func BenchmarkProductCodecs(b *testing.B) {
+	p := fullProduct()
+	for _, bc := range []struct {
+		name   string
+		encode func(v any) ([]byte, error)
+		decode func(body []byte, v any) error
+	}{
+		{"msgpack", func(v any) ([]byte, error) { return encodeMsgpack(v), nil }, func(body []byte, v any) error { return decodeMsgpack(body, v, true) }},
+		{"json", marshalJSON, func(body []byte, v any) error { return decodeJSON(body, v, true) }},
+	} {
+		body, err := bc.encode(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bc.name+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := bc.encode(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(body)), "body-bytes")
+		})
+		b.Run(bc.name+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				var got Product
+				if err := bc.decode(body, &got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
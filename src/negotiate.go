@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response formats a request can negotiate; formatKey holds the chosen one
+// in the gin context
+const (
+	formatJSON    = "json"
+	formatProblem = "problem" // JSON bodies, problem+json errors
+	formatXML     = "xml"
+	formatMsgpack = "msgpack"
+)
+
+const formatKey = "response_format"
+
+// negotiatedRoutes also answer in XML or MessagePack when Accept prefers
+// it; on these an Accept naming nothing servable is a 406. Every other
+// route ignores Accept apart from problem+json errors.
+var negotiatedRoutes = map[string]bool{
+	"/products":                        true,
+	"/products/:productId":             true,
+	"/products/sku/:sku":               true,
+	"/products/:productId/restore":     true,
+	"/admin/products/deleted":          true,
+	"/categories/:categoryId/products": true,
+	"/manufacturers/:name/products":    true,
+}
+
+// offeredTypes are the media types negotiatedRoutes serve, in order of
+// preference when Accept ranks them equally
+var offeredTypes = []string{"application/json", "application/xml", msgpackContentType, problemContentType}
+
+// negotiateFormat picks the format of the request's response and errors.
+// Errors are problem+json with ERROR_FORMAT=problem or when Accept asks for
+// it. Request bodies are read by their own Content-Type, not by this.
+func (a *API) negotiateFormat(c *gin.Context) {
+	accept := c.GetHeader("Accept")
+	format := formatJSON
+	if negotiatedRoutes[routeTemplate(c.FullPath())] {
+		c.Writer.Header().Add("Vary", "Accept")
+		switch preferredType(accept, offeredTypes) {
+		case "application/xml":
+			format = formatXML
+		case msgpackContentType:
+			format = formatMsgpack
+		case problemContentType:
+			format = formatProblem
+		case "":
+			// Reported as plain JSON; Accept ruled out everything else
+			c.Set(formatKey, formatJSON)
+			abortWithError(c, http.StatusNotAcceptable, ErrorResponse{
+				Error:     "NOT_ACCEPTABLE",
+				Message:   "No acceptable representation",
+				Details:   "Accept must allow one of " + strings.Join(offeredTypes, ", ") + ", got " + accept,
+				RequestID: requestID(c),
+			})
+			return
+		}
+	} else if acceptsProblemJSON(accept) {
+		format = formatProblem
+	}
+	if format == formatJSON && a.cfg.ErrorFormat == errorFormatProblem {
+		format = formatProblem
+	}
+	c.Set(formatKey, format)
+	c.Next()
+}
+
+// preferredType returns the offer an Accept header ranks highest, "" if it
+// rules all of them out. A missing Accept takes the first offer.
+func preferredType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality is the q value Accept gives mediaType through its most
+// specific matching range, 0 if none matches
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := 0
+		switch {
+		case rng == mediaType:
+			s = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		case rng == "*/*":
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if raw, present := params["q"]; present {
+			if v, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return q
+}
+
+// acceptsProblemJSON reports whether an Accept header names
+// application/problem+json; wildcards don't count, since every client
+// accepts */*
+func acceptsProblemJSON(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), problemContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeProducts sends a product body from project, projectAll or a
+// listing wrapper in the negotiated format
+func writeProducts(c *gin.Context, status int, body any) {
+	switch c.GetString(formatKey) {
+	case formatXML:
+	case formatMsgpack:
+		c.Data(status, msgpackContentType, encodeMsgpack(body))
+		return
+	default:
+		writeJSON(c, status, body)
+		return
+	}
+	switch v := body.(type) {
+	case Product:
+		body = xmlProduct{Product: v}
+	case []Product:
+		body = xmlProductList{Products: v}
+	case []any:
+		body = xmlProductList{Products: v}
+	}
+	c.XML(status, body)
+}
+
+// xmlProduct is the XML document for one product
+type xmlProduct struct {
+	XMLName xml.Name `xml:"product"`
+	Product
+}
+
+// xmlProductList is the XML document for a plain listing
+type xmlProductList struct {
+	XMLName  xml.Name `xml:"products"`
+	Products any      `xml:"product"`
+}
+
+// xmlError is the XML document for an ErrorResponse
+type xmlError struct {
+	XMLName xml.Name `xml:"error"`
+	ErrorResponse
+}
+
+// MarshalXML writes a ?fields= projection as a product element whose
+// children follow selectableFields order
+func (p projection) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "product"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range selectableFields {
+		if v, present := p[name]; present {
+			if err := e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
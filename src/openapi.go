@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIYAML is the API contract, compiled into the binary
+//
+//go:embed api.yaml
+var openAPIYAML []byte
+
+// docsPage renders the embedded spec with Redoc
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Product API</title>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+  <redoc spec-url="/openapi.json"></redoc>
+  <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// OpenAPISpec is the embedded document in both encodings, the parsed model
+// used for request validation, and the operations it declares keyed by
+// "METHOD /path/{param}"
+type OpenAPISpec struct {
+	yaml       []byte
+	json       []byte
+	doc        *openapi3.T
+	operations map[string]bool
+}
+
+// loadOpenAPISpec parses and validates the embedded api.yaml
+func loadOpenAPISpec() (*OpenAPISpec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPIYAML)
+	if err != nil {
+		return nil, fmt.Errorf("parse api.yaml: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("validate api.yaml: %w", err)
+	}
+	var full any
+	if err := yaml.Unmarshal(openAPIYAML, &full); err != nil {
+		return nil, fmt.Errorf("parse api.yaml: %w", err)
+	}
+	asJSON, err := json.Marshal(full)
+	if err != nil {
+		return nil, fmt.Errorf("convert api.yaml to JSON: %w", err)
+	}
+
+	spec := &OpenAPISpec{yaml: openAPIYAML, json: asJSON, doc: doc, operations: make(map[string]bool)}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			spec.operations[method+" "+path] = true
+		}
+	}
+	return spec, nil
+}
+
+// ginParam matches :name segments in gin route templates
+var ginParam = regexp.MustCompile(`:([^/]+)`)
+
+// specPath converts a gin route template to the api.yaml path form:
+// /products/:productId becomes /products/{productId}
+func specPath(route string) string {
+	return ginParam.ReplaceAllString(route, "{$1}")
+}
+
+// drift lists registered routes missing from the spec and documented
+// operations that no route serves
+func (s *OpenAPISpec) drift(routes gin.RoutesInfo) (undocumented, unserved []string) {
+	served := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		op := r.Method + " " + specPath(r.Path)
+		served[op] = true
+		if !s.operations[op] {
+			undocumented = append(undocumented, op)
+		}
+	}
+	for op := range s.operations {
+		if !served[op] {
+			unserved = append(unserved, op)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(unserved)
+	return undocumented, unserved
+}
+
+// logSpecDrift warns about any difference between the router and api.yaml
+func (a *API) logSpecDrift(routes gin.RoutesInfo) {
+	// Aliases count as the v1 route they stand for
+	documented := make(gin.RoutesInfo, len(routes))
+	for i, r := range routes {
+		r.Path = a.specRoute(r.Path)
+		documented[i] = r
+	}
+	undocumented, unserved := a.spec.drift(documented)
+	if len(undocumented) > 0 {
+		a.logger.Warn("routes missing from api.yaml", "routes", undocumented)
+	}
+	if len(unserved) > 0 {
+		a.logger.Warn("api.yaml operations without a route", "operations", unserved)
+	}
+}
+
+// openAPIYAMLHandler handles GET /openapi.yaml
+func (a *API) openAPIYAMLHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", a.spec.yaml)
+}
+
+// openAPIJSONHandler handles GET /openapi.json
+func (a *API) openAPIJSONHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", a.spec.json)
+}
+
+// docs handles GET /docs
+func docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
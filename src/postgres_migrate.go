@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresMigrationLock is the advisory lock key held while migrating, so
+// tasks starting together apply each migration once; it spells "product"
+const postgresMigrationLock = 0x70726f64756374
+
+// Migrate applies the embedded migrations not yet recorded in
+// schema_migrations, each in a transaction with its record, and returns how
+// many it applied. It holds an advisory lock throughout, so concurrent
+// calls from other tasks wait and then find nothing left to do.
+func (s *PostgresStore) Migrate(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations("postgres")
+	if err != nil {
+		return 0, err
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return 0, postgresError("migrate", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLock); err != nil {
+		return 0, postgresError("migrate: lock", err)
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, postgresMigrationLock)
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    integer     PRIMARY KEY,
+	name       text        NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`); err != nil {
+		return 0, postgresError("migrate: create schema_migrations", err)
+	}
+	rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, postgresError("migrate: read schema_migrations", err)
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, postgresError("migrate: read schema_migrations", err)
+	}
+	done := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		done[v] = true
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if done[m.version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Without arguments Exec uses the simple protocol, which takes
+			// a file of several statements
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return applied, postgresError("migrate: apply "+m.name, err)
+		}
+		slog.Info("postgres migration applied", "version", m.version, "name", m.name)
+		applied++
+	}
+	return applied, nil
+}
+
+// runMigrations handles -migrate: apply the migrations to STORE_BACKEND=
+// postgres and exit, for a one-off task run before a deploy when tasks
+// start with POSTGRES_MIGRATE=false
+func runMigrations(cfg Config) error {
+	if cfg.StoreBackend != "postgres" {
+		return errors.New("-migrate needs STORE_BACKEND=postgres")
+	}
+	ctx := context.Background()
+	store, err := NewPostgresStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	applied, err := store.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("postgres migrations done", "applied", applied)
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresUpsert writes a product keyed on product_id, keeping the
+// created_at of a row it replaces. xmax is 0 only on a freshly inserted row.
+const postgresUpsert = `INSERT INTO products (` + productColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT (product_id) DO UPDATE SET
+	sku = EXCLUDED.sku, manufacturer = EXCLUDED.manufacturer, category_id = EXCLUDED.category_id,
+	weight = EXCLUDED.weight, some_other_id = EXCLUDED.some_other_id, name = EXCLUDED.name,
+	description = EXCLUDED.description, price = EXCLUDED.price, currency = EXCLUDED.currency,
+	quantity = EXCLUDED.quantity, updated_at = EXCLUDED.updated_at,
+	deleted_at = EXCLUDED.deleted_at, expires_at = EXCLUDED.expires_at
+RETURNING created_at, xmax = 0`
+
+// postgresInsert is postgresUpsert that leaves an existing row alone, for
+// Create; it affects no rows when the ID is taken
+const postgresInsert = `INSERT INTO products (` + productColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT (product_id) DO NOTHING`
+
+// postgresUpdate rewrites every column of the row $1 but product_id.
+// Every parameter must appear, or the server can't type it.
+const postgresUpdate = `UPDATE products SET
+	sku = $2, manufacturer = $3, category_id = $4, weight = $5, some_other_id = $6, name = $7,
+	description = $8, price = $9, currency = $10, quantity = $11, created_at = $12, updated_at = $13,
+	deleted_at = $14, expires_at = $15
+WHERE product_id = $1`
+
+// postgresSKUConstraint is the unique constraint on products.sku, which
+// the migrations name explicitly
+const postgresSKUConstraint = "products_sku_key"
+
+// pgUniqueViolation is the SQLSTATE of a unique constraint violation
+const pgUniqueViolation = "23505"
+
+// PostgresStore is a ProductStore backed by a PostgreSQL products table
+// with a column per Product field, created by the migrations under
+// migrations/postgres. SKUs are kept unique by the products_sku_key
+// constraint, so unlike DynamoDB two tasks can't claim the same SKU at
+// once. Listings are filtered, ordered and, through ListWindow, cut to a
+// page by the database.
+//
+// Timestamps are stamped to the microsecond, the precision PostgreSQL
+// keeps, so a product reads back exactly as it was written.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresStore opens a pool to cfg.PostgresURL sized by cfg and pings
+// it so a bad URL fails at startup instead of on the first request. The
+// migrations are not applied; see Migrate.
+func NewPostgresStore(ctx context.Context, cfg Config) (*PostgresStore, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse POSTGRES_URL: %w", err)
+	}
+	poolCfg.MaxConns = int32(cfg.PostgresMaxConns)
+	poolCfg.MinConns = int32(cfg.PostgresMinConns)
+	poolCfg.MaxConnLifetime = cfg.PostgresMaxConnLifetime
+	poolCfg.MaxConnIdleTime = cfg.PostgresMaxConnIdleTime
+	if poolCfg.ConnConfig.ConnectTimeout == 0 {
+		// Bound dialing so an unreachable server surfaces as 503, not a
+		// hung request; connect_timeout in the URL overrides it
+		poolCfg.ConnConfig.ConnectTimeout = 2 * time.Second
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("connect to postgres at %s: %w", poolCfg.ConnConfig.Host, err)
+	}
+	return &PostgresStore{pool: pool}, nil
+}
+
+// Close closes the pool once its connections are returned
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+// Ping checks that a pooled connection answers, for the readiness probe
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	if err := s.pool.Ping(ctx); err != nil {
+		return postgresError("ping", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id int) (Product, error) {
+	p, err := scanPostgresProduct(s.pool.QueryRow(ctx,
+		`SELECT `+productColumns+` FROM products WHERE product_id = $1`, id))
+	if err != nil {
+		return Product{}, postgresError("get product", err)
+	}
+	return p, nil
+}
+
+func (s *PostgresStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	p, err := scanPostgresProduct(s.pool.QueryRow(ctx,
+		`SELECT `+productColumns+` FROM products WHERE sku = $1`, sku))
+	if err != nil {
+		return Product{}, postgresError("get product by sku", err)
+	}
+	return p, nil
+}
+
+func (s *PostgresStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	found := make(map[int]Product, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	rows, err := s.pool.Query(ctx, `SELECT `+productColumns+` FROM products WHERE product_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, postgresError("get products", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPostgresProduct(rows)
+		if err != nil {
+			return nil, postgresError("get products", err)
+		}
+		found[p.ProductID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, postgresError("get products", err)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) Put(ctx context.Context, p *Product) (bool, error) {
+	stampProduct(p, time.Time{}, postgresNow())
+	var inserted bool
+	err := s.pool.QueryRow(ctx, postgresUpsert, postgresArgs(p)...).Scan(&p.CreatedAt, &inserted)
+	if err != nil {
+		return false, s.writeError(ctx, "put product", p.SKU, err)
+	}
+	p.CreatedAt = p.CreatedAt.UTC()
+	return inserted, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, p *Product) error {
+	stampProduct(p, time.Time{}, postgresNow())
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		tag, err := s.pool.Exec(ctx, postgresInsert, postgresArgs(p)...)
+		if err != nil {
+			return s.writeError(ctx, "create product", p.SKU, err)
+		}
+		if tag.RowsAffected() == 1 {
+			return nil
+		}
+		existing, err := s.Get(ctx, p.ProductID)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since the insert found it; try again
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return &ProductExistsError{Existing: existing}
+	}
+	return fmt.Errorf("postgres create product %d: too many concurrent modifications", p.ProductID)
+}
+
+func (s *PostgresStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := make([]error, len(items))
+	if !atomic {
+		for i, p := range items {
+			_, errs[i] = s.Put(ctx, &p)
+		}
+		return errs
+	}
+	abort := func(i int, err error) []error {
+		for j := range errs {
+			errs[j] = ErrBatchAborted
+		}
+		errs[i] = err
+		return errs
+	}
+
+	// The constraint would stop a SKU claimed twice within the batch too,
+	// but the owner could only be named from inside the failed transaction
+	skuOwners := make(map[string]int, len(items))
+	for i, p := range items {
+		if owner, claimed := skuOwners[p.SKU]; claimed && owner != p.ProductID {
+			return abort(i, &DuplicateSKUError{SKU: p.SKU, ProductID: owner})
+		}
+		skuOwners[p.SKU] = p.ProductID
+	}
+
+	// Every upsert goes in one round trip inside one transaction, so
+	// readers see the whole batch or none of it
+	failed := -1
+	now := postgresNow()
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for i := range items {
+			p := items[i]
+			stampProduct(&p, time.Time{}, now)
+			batch.Queue(postgresUpsert, postgresArgs(&p)...)
+		}
+		results := tx.SendBatch(ctx, batch)
+		defer results.Close()
+		for i := range items {
+			if _, err := results.Exec(); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return results.Close()
+	})
+	if err == nil {
+		return errs
+	}
+	if failed >= 0 {
+		return abort(failed, s.writeError(ctx, "put batch", items[failed].SKU, err))
+	}
+	err = postgresError("put batch", err)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (s *PostgresStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	// The row stays locked from the read to the write, so concurrent
+	// updates of one product queue up instead of retrying
+	var updated Product
+	var fnErr error
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		existing, err := scanPostgresProduct(tx.QueryRow(ctx,
+			`SELECT `+productColumns+` FROM products WHERE product_id = $1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+
+		updated = existing
+		if fnErr = fn(&updated); fnErr != nil {
+			return fnErr
+		}
+		updated.ProductID = id
+		stampProduct(&updated, existing.CreatedAt, postgresNow())
+		_, err = tx.Exec(ctx, postgresUpdate, postgresArgs(&updated)...)
+		return err
+	})
+	switch {
+	case err == nil:
+		return updated, nil
+	case fnErr != nil:
+		return Product{}, fnErr
+	case errors.Is(err, ErrNotFound):
+		return Product{}, err
+	default:
+		return Product{}, s.writeError(ctx, "update product", updated.SKU, err)
+	}
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id int) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM products WHERE product_id = $1`, id)
+	if err != nil {
+		return postgresError("delete product", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIf removes the product if cond reports true, holding the row
+// locked from the read to the delete
+func (s *PostgresStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	removed := false
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		existing, err := scanPostgresProduct(tx.QueryRow(ctx,
+			`SELECT `+productColumns+` FROM products WHERE product_id = $1 FOR UPDATE`, id))
+		if err != nil || !cond(existing) {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM products WHERE product_id = $1`, id); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	if err != nil {
+		return false, postgresError("delete product", err)
+	}
+	return removed, nil
+}
+
+func (s *PostgresStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	where, args := listFilterWhere(filter, func(t time.Time) any { return t })
+	rows, err := s.pool.Query(ctx, `SELECT `+productColumns+` FROM products`+where+` ORDER BY product_id`, args...)
+	if err != nil {
+		return nil, postgresError("list products", err)
+	}
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Product, error) {
+		return scanPostgresProduct(row)
+	})
+	if err != nil {
+		return nil, postgresError("list products", err)
+	}
+	return items, nil
+}
+
+// ListWindow is List with LIMIT and OFFSET applied by the database, which
+// counts the matching products in the same query
+func (s *PostgresStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	where, args := listFilterWhere(filter, func(t time.Time) any { return t })
+	n := len(args)
+	args = append(args, limit, offset)
+	rows, err := s.pool.Query(ctx, `SELECT `+productColumns+`, count(*) OVER () FROM products`+where+
+		` ORDER BY product_id LIMIT $`+strconv.Itoa(n+1)+` OFFSET $`+strconv.Itoa(n+2), args...)
+	if err != nil {
+		return nil, 0, postgresError("list products", err)
+	}
+	total := 0
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Product, error) {
+		return scanPostgresProduct(row, &total)
+	})
+	if err != nil {
+		return nil, 0, postgresError("list products", err)
+	}
+	if len(items) == 0 && offset > 0 {
+		// A window past the end has no row to carry the count
+		if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM products`+where, args[:n]...).Scan(&total); err != nil {
+			return nil, 0, postgresError("count products", err)
+		}
+	}
+	if items == nil {
+		items = []Product{}
+	}
+	return items, total, nil
+}
+
+// writeError converts the error of a write of a product with sku: a
+// violation of the SKU constraint becomes *DuplicateSKUError naming the
+// owner, which had committed before the violating statement ran
+func (s *PostgresStore) writeError(ctx context.Context, op, sku string, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation || pgErr.ConstraintName != postgresSKUConstraint {
+		return postgresError(op, err)
+	}
+	owner := 0
+	if err := s.pool.QueryRow(ctx, `SELECT product_id FROM products WHERE sku = $1`, sku).Scan(&owner); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return postgresError(op, err)
+	}
+	return &DuplicateSKUError{SKU: sku, ProductID: owner}
+}
+
+// postgresNow is the time writes are stamped with, cut to what a
+// timestamptz keeps
+func postgresNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// postgresArgs returns p's columns in productColumns order, first cutting
+// the times set outside the store to microseconds as the database will
+func postgresArgs(p *Product) []any {
+	if p.DeletedAt != nil {
+		deletedAt := p.DeletedAt.Truncate(time.Microsecond)
+		p.DeletedAt = &deletedAt
+	}
+	if p.ExpiresAt != nil {
+		expiresAt := p.ExpiresAt.Truncate(time.Microsecond)
+		p.ExpiresAt = &expiresAt
+	}
+	return []any{p.ProductID, p.SKU, p.Manufacturer, p.CategoryID, p.Weight, p.SomeOtherID,
+		p.Name, p.Description, p.Price, p.Currency, p.Quantity,
+		p.CreatedAt, p.UpdatedAt, p.DeletedAt, p.ExpiresAt}
+}
+
+// scanPostgresProduct reads a row of productColumns, followed by extra if
+// the query selected more, mapping no row to ErrNotFound
+func scanPostgresProduct(row pgx.Row, extra ...any) (Product, error) {
+	var p Product
+	dest := append([]any{&p.ProductID, &p.SKU, &p.Manufacturer, &p.CategoryID, &p.Weight, &p.SomeOtherID,
+		&p.Name, &p.Description, &p.Price, &p.Currency, &p.Quantity,
+		&p.CreatedAt, &p.UpdatedAt, &p.DeletedAt, &p.ExpiresAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Product{}, ErrNotFound
+		}
+		return Product{}, err
+	}
+	// timestamptz comes back in the local zone; the API speaks UTC
+	p.CreatedAt, p.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	if p.DeletedAt != nil {
+		deletedAt := p.DeletedAt.UTC()
+		p.DeletedAt = &deletedAt
+	}
+	if p.ExpiresAt != nil {
+		expiresAt := p.ExpiresAt.UTC()
+		p.ExpiresAt = &expiresAt
+	}
+	return p, nil
+}
+
+// postgresError tags err with ErrStoreUnavailable unless the server
+// answered the statement itself: a refused statement, other than for a
+// lost connection, too many clients or shutdown, won't succeed by being
+// repeated. ErrNotFound and context errors are returned as-is.
+func postgresError(op string, err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, ErrNotFound), contextError(err):
+		return err
+	case errors.As(err, &pgErr) && !strings.HasPrefix(pgErr.Code, "08") &&
+		!strings.HasPrefix(pgErr.Code, "57P") && pgErr.Code != "53300":
+		return fmt.Errorf("postgres %s: %w", op, err)
+	default:
+		return fmt.Errorf("%w: postgres %s: %v", ErrStoreUnavailable, op, err)
+	}
+}
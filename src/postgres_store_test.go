@@ -0,0 +1,113 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// openTestPostgres returns a store over a new, empty schema in the database
+// at POSTGRES_TEST_DSN, dropped when the test ends. No migration has run on
+// it. The test is skipped without a DSN.
+func openTestPostgres(t *testing.T) *PostgresStore {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close(ctx)
+	schema := "products_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := admin.Exec(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close(context.Background())
+		conn.Exec(context.Background(), `DROP SCHEMA `+schema+` CASCADE`)
+	})
+
+	// Every pooled connection works in the new schema
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	s, err := NewPostgresStore(ctx, testConfig(t, map[string]string{"POSTGRES_URL": dsn, "POSTGRES_MAX_CONNS": "8"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPostgresMigrate(t *testing.T) {
+	s := openTestPostgres(t)
+	migrations, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tasks starting together each migrate; the lock lets one apply them
+	var wg sync.WaitGroup
+	applied := make([]int, 3)
+	errs := make([]error, 3)
+	for i := range applied {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = s.Migrate(context.Background())
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for i := range applied {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		total += applied[i]
+	}
+	if total != len(migrations) {
+		t.Errorf("concurrent migrations applied %d in all, want %d", total, len(migrations))
+	}
+	if n, err := s.Migrate(context.Background()); err != nil || n != 0 {
+		t.Errorf("migrating again: applied %d, %v; want 0", n, err)
+	}
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	s := openTestPostgres(t)
+	if _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	runStoreConformance(t, storeFactory{
+		open: func(t *testing.T) ProductStore {
+			emptyStore(t, s)
+			return s
+		},
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+)
+
+// Publish outcomes, as product_events_total labels
+const (
+	eventPublished = "published"
+	eventFailed    = "failed"
+	eventDropped   = "dropped"
+)
+
+// eventPublishTimeout bounds one SNS or SQS call, retries by the SDK
+// included
+const eventPublishTimeout = 10 * time.Second
+
+// ProductEvent is the JSON message published for every write
+type ProductEvent struct {
+	Event     string `json:"event"`
+	ProductID int    `json:"product_id"`
+	// Product is the product as stored; absent for product.deleted
+	Product   *Product  `json:"product,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Only with MULTI_TENANT
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// eventSender delivers one encoded ProductEvent
+type eventSender func(ctx context.Context, ev ProductEvent, body []byte) error
+
+// eventPublisher sends ProductEvents to SNS or SQS from one background
+// goroutine, so messages for a product leave in the order it was written.
+// Writes only ever enqueue; a full buffer drops the event, and failures are
+// logged and counted, never reported to the client.
+type eventPublisher struct {
+	target   string
+	buffer   chan ProductEvent
+	send     eventSender
+	failed   atomic.Int64
+	onResult func(result string)
+}
+
+// newEventPublisher returns the publisher EVENTS_SNS_TOPIC_ARN,
+// EVENTS_SQS_QUEUE_URL and EVENTS_DRY_RUN ask for, or nil if none is set.
+// Dry runs log each message instead of sending it, and need no AWS
+// credentials.
+func newEventPublisher(ctx context.Context, cfg Config, onResult func(result string)) (*eventPublisher, error) {
+	p := &eventPublisher{buffer: make(chan ProductEvent, cfg.EventsBufferSize), onResult: onResult}
+	switch {
+	case cfg.EventsDryRun:
+		p.target = "dry-run"
+		p.send = func(_ context.Context, ev ProductEvent, body []byte) error {
+			slog.Info("event dry run", "event", ev.Event, "product_id", ev.ProductID, "message", string(body))
+			return nil
+		}
+		return p, nil
+	case cfg.EventsSNSTopicARN == "" && cfg.EventsSQSQueueURL == "":
+		return nil, nil
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.AWSRegion != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.AWSRegion))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	if cfg.XRayEnabled {
+		awsv2.AWSV2Instrumentor(&awsCfg.APIOptions)
+	}
+
+	if arn := cfg.EventsSNSTopicARN; arn != "" {
+		p.target = arn
+		p.send = snsSender(sns.NewFromConfig(awsCfg), arn)
+	} else {
+		p.target = cfg.EventsSQSQueueURL
+		p.send = sqsSender(sqs.NewFromConfig(awsCfg), cfg.EventsSQSQueueURL)
+	}
+	return p, nil
+}
+
+// snsSender publishes to topicARN. FIFO topics group messages by product
+// so each product's events stay ordered.
+func snsSender(client *sns.Client, topicARN string) eventSender {
+	fifo := strings.HasSuffix(topicARN, ".fifo")
+	return func(ctx context.Context, ev ProductEvent, body []byte) error {
+		in := &sns.PublishInput{
+			TopicArn: aws.String(topicARN),
+			Message:  aws.String(string(body)),
+			MessageAttributes: map[string]snstypes.MessageAttributeValue{
+				"event": {DataType: aws.String("String"), StringValue: aws.String(ev.Event)},
+			},
+		}
+		if fifo {
+			in.MessageGroupId = aws.String(strconv.Itoa(ev.ProductID))
+			in.MessageDeduplicationId = aws.String(eventDedupID(ev))
+		}
+		_, err := client.Publish(ctx, in)
+		return err
+	}
+}
+
+// sqsSender sends to queueURL, grouping by product on FIFO queues
+func sqsSender(client *sqs.Client, queueURL string) eventSender {
+	fifo := strings.HasSuffix(queueURL, ".fifo")
+	return func(ctx context.Context, ev ProductEvent, body []byte) error {
+		in := &sqs.SendMessageInput{
+			QueueUrl:    aws.String(queueURL),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"event": {DataType: aws.String("String"), StringValue: aws.String(ev.Event)},
+			},
+		}
+		if fifo {
+			in.MessageGroupId = aws.String(strconv.Itoa(ev.ProductID))
+			in.MessageDeduplicationId = aws.String(eventDedupID(ev))
+		}
+		_, err := client.SendMessage(ctx, in)
+		return err
+	}
+}
+
+// eventDedupID identifies one write for FIFO deduplication
+func eventDedupID(ev ProductEvent) string {
+	return ev.Event + "-" + strconv.Itoa(ev.ProductID) + "-" + strconv.FormatInt(ev.Timestamp.UnixNano(), 10)
+}
+
+// publishChange queues the event for the write, dropping it if the buffer
+// is full
+func (p *eventPublisher) publishChange(tenant, event string, productID int, product *Product) {
+	ev := ProductEvent{Event: event, ProductID: productID, Product: product, Timestamp: time.Now().UTC(), TenantID: tenant}
+	select {
+	case p.buffer <- ev:
+	default:
+		p.failed.Add(1)
+		p.onResult(eventDropped)
+		slog.Warn("event buffer full, event dropped", "event", event, "product_id", productID)
+	}
+}
+
+// run sends buffered events until ctx is done, then sends whatever is
+// still buffered before returning
+func (p *eventPublisher) run(ctx context.Context) {
+	slog.Info("publishing product events", "target", p.target)
+	for {
+		select {
+		case ev := <-p.buffer:
+			p.sendOne(ev)
+		case <-ctx.Done():
+			for {
+				select {
+				case ev := <-p.buffer:
+					p.sendOne(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendOne publishes ev, logging and counting a failure
+func (p *eventPublisher) sendOne(ev ProductEvent) {
+	body, err := json.Marshal(ev)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+		err = p.send(ctx, ev, body)
+		cancel()
+	}
+	if err != nil {
+		p.failed.Add(1)
+		p.onResult(eventFailed)
+		slog.Error("event publish failed", "event", ev.Event, "product_id", ev.ProductID, "target", p.target, "error", err)
+		return
+	}
+	p.onResult(eventPublished)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxQuantityDelta bounds a single adjustment
+const maxQuantityDelta = 1_000_000_000
+
+// InsufficientQuantityError is returned by adjustQuantity when the delta
+// would take the quantity below zero; Quantity is the unchanged value
+type InsufficientQuantityError struct {
+	ProductID int
+	Quantity  int
+	Delta     int
+}
+
+func (e *InsufficientQuantityError) Error() string {
+	return "product " + strconv.Itoa(e.ProductID) + " has " + strconv.Itoa(e.Quantity) +
+		" units, cannot remove " + strconv.Itoa(-e.Delta)
+}
+
+// QuantityAdjustment is the body of POST /products/{productId}/quantity/adjust
+type QuantityAdjustment struct {
+	Delta *int `json:"delta"`
+}
+
+// QuantityResponse is returned by POST /products/{productId}/quantity/adjust
+type QuantityResponse struct {
+	ProductID int `json:"product_id"`
+	Quantity  int `json:"quantity"`
+}
+
+// adjustQuantity adds delta to the product's quantity and returns the
+// stored product. The read and the write happen inside one Update, which
+// every store runs atomically, so concurrent adjustments never lose each
+// other's changes.
+func adjustQuantity(ctx context.Context, store ProductStore, id, delta int) (Product, error) {
+	return store.Update(ctx, id, func(p *Product) error {
+		if p.Quantity+delta < 0 {
+			return &InsufficientQuantityError{ProductID: id, Quantity: p.Quantity, Delta: delta}
+		}
+		p.Quantity += delta
+		return nil
+	})
+}
+
+// adjustProductQuantity handles POST /products/{productId}/quantity/adjust
+// Adds delta (negative to remove stock) to the product's quantity in one
+// atomic step.
+// Returns 200 with the new quantity and ETag, 400 if bad ID or delta, 404
+// if not found, 409 INSUFFICIENT_QUANTITY if the quantity would go below zero
+func (a *API) adjustProductQuantity(c *gin.Context) {
+	productID, ok := parseProductID(c, "Invalid product ID in path")
+	if !ok {
+		return
+	}
+	var req QuantityAdjustment
+	if err := a.readJSON(c, &req); err != nil {
+		writeDecodeError(c, err)
+		return
+	}
+	if req.Delta == nil || *req.Delta == 0 || *req.Delta < -maxQuantityDelta || *req.Delta > maxQuantityDelta {
+		var value any
+		if req.Delta != nil {
+			value = *req.Delta
+		}
+		errs := []FieldError{{Field: "delta", Constraint: "must be a non-zero integer between -" +
+			strconv.Itoa(maxQuantityDelta) + " and " + strconv.Itoa(maxQuantityDelta), Value: value}}
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	p, err := adjustQuantity(c.Request.Context(), a.wrappedStore(), productID, *req.Delta)
+	var short *InsufficientQuantityError
+	switch {
+	case errors.As(err, &short):
+		writeError(c, http.StatusConflict, ErrorResponse{
+			Error:     "INSUFFICIENT_QUANTITY",
+			Message:   "Not enough units in stock",
+			Details:   short.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	case errors.Is(err, ErrNotFound):
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	case err != nil:
+		writeStoreError(c, err)
+		return
+	}
+	c.Header("ETag", productETag(p))
+	c.JSON(http.StatusOK, QuantityResponse{ProductID: productID, Quantity: p.Quantity})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// quantityRaceWorkers is how many requests adjust one product at once
+const quantityRaceWorkers = 300
+
+// testQuantityRace has quantityRaceWorkers requests adjust product 1 of
+// store at the same time, first adding and removing stock, then all trying
+// to take more than there is, and checks no adjustment was lost or let
+// through
+func testQuantityRace(t *testing.T, store ProductStore) {
+	p := testProduct(1)
+	p.Quantity = 1000
+	mustPut(t, store, p)
+	_, router := newTestAPI(t, store, nil)
+
+	// run sends delta i for each worker i, all released together, and
+	// returns how many of each status came back
+	run := func(delta func(i int) int) map[int]int {
+		var (
+			mu       sync.Mutex
+			statuses = map[int]int{}
+			start    = make(chan struct{})
+			wg       sync.WaitGroup
+		)
+		for i := range quantityRaceWorkers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				w := doRequest(router, http.MethodPost, "/v1/products/1/quantity/adjust", `{"delta":`+strconv.Itoa(delta(i))+`}`)
+				mu.Lock()
+				statuses[w.Code]++
+				mu.Unlock()
+			}()
+		}
+		close(start)
+		wg.Wait()
+		return statuses
+	}
+	quantity := func() int {
+		var q Product
+		if err := json.Unmarshal(doRequest(router, http.MethodGet, "/v1/products/1", "").Body.Bytes(), &q); err != nil {
+			t.Fatal(err)
+		}
+		return q.Quantity
+	}
+
+	// Even workers add 3 and odd ones take 1: 150*3 - 150 on top of 1000
+	if got := run(func(i int) int { return 3 - 4*(i%2) }); got[http.StatusOK] != quantityRaceWorkers {
+		t.Fatalf("mixed adjustments: got statuses %v", got)
+	}
+	if q := quantity(); q != 1300 {
+		t.Fatalf("after mixed adjustments: quantity %d, want 1300", q)
+	}
+
+	// 1300 units, 300 workers each taking 5: exactly 260 may succeed
+	got := run(func(int) int { return -5 })
+	if got[http.StatusOK] != 260 || got[http.StatusConflict] != 40 {
+		t.Errorf("overselling: got statuses %v, want 260 200s and 40 409s", got)
+	}
+	if q := quantity(); q != 0 {
+		t.Errorf("after overselling: quantity %d, want 0", q)
+	}
+}
+
+// yieldingStore lets other goroutines run after every Get, as the network
+// round trip of a remote store would, so a read-modify-write done outside
+// Update loses adjustments even on one CPU
+type yieldingStore struct {
+	*InMemoryStore
+}
+
+func (s yieldingStore) Get(ctx context.Context, id int) (Product, error) {
+	p, err := s.InMemoryStore.Get(ctx, id)
+	runtime.Gosched()
+	return p, err
+}
+
+func TestQuantityRaceInMemory(t *testing.T) {
+	testQuantityRace(t, yieldingStore{NewInMemoryStore(false)})
+}
+
+func TestQuantityRaceSQLite(t *testing.T) {
+	testQuantityRace(t, openTestSQLite(t, filepath.Join(t.TempDir(), "products.db")))
+}
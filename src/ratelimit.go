@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitSweepInterval is how often idle clients are dropped from the table
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket is one client's allowance: tokens refill at the limiter's rate
+// up to burst, and each request spends one
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-key token-bucket limiter. A bucket left alone long
+// enough to refill completely is indistinguishable from a new one, so the
+// periodic sweep drops those and the table only holds recently active clients.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns a limiter allowing rps requests per second per key
+// with bursts of up to burst requests
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:      rps,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// newRateLimiterFor returns the limiter cfg asks for, nil when RATE_LIMIT_RPS
+// is 0
+func newRateLimiterFor(cfg Config) *rateLimiter {
+	if cfg.RateLimitRPS <= 0 {
+		return nil
+	}
+	return newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+}
+
+// allow spends a token for key if one is available. When it isn't, the
+// returned duration is how long until the next token arrives.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweepLocked(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked drops buckets that have refilled to burst. Callers must hold mu.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitExempt routes are never limited: load balancer probes and
+// Prometheus scrapes come from a handful of IPs at a steady rate
+var rateLimitExempt = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/metrics": true}
+
+// rateLimit answers 429 with Retry-After once a client IP exceeds
+// RATE_LIMIT_RPS
+func (a *API) rateLimit(c *gin.Context) {
+	limiter := a.limiter.Load()
+	if limiter == nil || rateLimitExempt[c.FullPath()] {
+		c.Next()
+		return
+	}
+
+	ok, wait := limiter.allow(c.ClientIP(), time.Now())
+	if ok {
+		a.metrics.rateLimited.WithLabelValues("allowed").Inc()
+		c.Next()
+		return
+	}
+	a.metrics.rateLimited.WithLabelValues("rejected").Inc()
+
+	seconds := max(1, int(math.Ceil(wait.Seconds())))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	abortWithError(c, http.StatusTooManyRequests, ErrorResponse{
+		Error:     "RATE_LIMITED",
+		Message:   "Too many requests",
+		Details:   "Rate limit exceeded; retry after " + strconv.Itoa(seconds) + "s",
+		RequestID: requestID(c),
+	})
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisScanBatch is the COUNT hint used when scanning product keys
+const redisScanBatch = 500
+
+// redisPutScript stores a product and maintains the sku:{sku} ownership key
+// and the category:{id} sets in one atomic step.
+// KEYS[1] product key, KEYS[2] sku key
+// ARGV[1] product id, ARGV[2] JSON body, ARGV[3] sku, ARGV[4] category id,
+// ARGV[5] TTL in milliseconds (0 means no expiry), ARGV[6] "1" to refuse
+// overwriting an existing product
+// A replaced product's created_at is carried over into the new body.
+// With ARGV[6] set and the product present, returns {-1, stored body}.
+// Returns {conflicting owner ID, false} or, on success, {0, created_at of the
+// replaced product} where the second element is false for a new product.
+// (A Lua nil would end the reply array early; false becomes a Redis nil.)
+var redisPutScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[2])
+if owner and owner ~= ARGV[1] then
+  return {tonumber(owner), false}
+end
+local body = ARGV[2]
+local created_at = false
+local old = redis.call('GET', KEYS[1])
+if old and ARGV[6] == '1' then
+  return {-1, old}
+end
+if old then
+  local o = cjson.decode(old)
+  if o.sku ~= ARGV[3] then
+    redis.call('DEL', 'sku:' .. o.sku)
+  end
+  redis.call('SREM', 'category:' .. o.category_id, ARGV[1])
+  created_at = o.created_at or ''
+  if o.created_at then
+    local n = cjson.decode(body)
+    n.created_at = o.created_at
+    body = cjson.encode(n)
+  end
+end
+local ttl = tonumber(ARGV[5])
+if ttl > 0 then
+  redis.call('SET', KEYS[1], body, 'PX', ttl)
+  redis.call('SET', KEYS[2], ARGV[1], 'PX', ttl)
+else
+  redis.call('SET', KEYS[1], body)
+  redis.call('SET', KEYS[2], ARGV[1])
+end
+redis.call('SADD', 'category:' .. ARGV[4], ARGV[1])
+return {0, created_at}
+`)
+
+// redisDeleteScript removes a product together with its index entries.
+// KEYS[1] product key, ARGV[1] product id. Returns 1 if it existed.
+var redisDeleteScript = redis.NewScript(`
+local old = redis.call('GET', KEYS[1])
+if not old then
+  return 0
+end
+local o = cjson.decode(old)
+if redis.call('GET', 'sku:' .. o.sku) == ARGV[1] then
+  redis.call('DEL', 'sku:' .. o.sku)
+end
+redis.call('SREM', 'category:' .. o.category_id, ARGV[1])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// RedisStore is a ProductStore for sharing data across ECS tasks. Products
+// live at product:{id} as JSON; sku:{sku} holds the owning ID and
+// category:{id} is a set of product IDs. The scripts touch index keys they
+// derive themselves, so this targets a single Redis node, not Cluster.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisStore connects to addr and pings it so a bad address fails at
+// startup instead of on the first request. A zero ttl disables expiry.
+func NewRedisStore(ctx context.Context, addr, password string, ttl time.Duration) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		// Bound every call so an unresponsive Redis surfaces as 503, not a hung request
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+		PoolSize:     20,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to redis at %s: %w", addr, err)
+	}
+	return &RedisStore{client: client, ttl: ttl}, nil
+}
+
+// Ping checks that Redis answers, for the readiness probe
+func (s *RedisStore) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return redisUnavailable(err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, id int) (Product, error) {
+	raw, err := s.client.Get(ctx, redisProductKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Product{}, ErrNotFound
+	}
+	if err != nil {
+		return Product{}, redisUnavailable(err)
+	}
+	return decodeRedisProduct(raw)
+}
+
+func (s *RedisStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	id, err := s.client.Get(ctx, "sku:"+sku).Int()
+	if errors.Is(err, redis.Nil) {
+		return Product{}, ErrNotFound
+	}
+	if err != nil {
+		return Product{}, redisUnavailable(err)
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *RedisStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	found := make(map[int]Product, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	// A single MGET instead of one round trip per ID
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisProductKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, redisUnavailable(err)
+	}
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		p, err := decodeRedisProduct([]byte(str))
+		if err != nil {
+			return nil, err
+		}
+		found[p.ProductID] = p
+	}
+	return found, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, p *Product) (bool, error) {
+	stampProduct(p, time.Time{}, time.Now().UTC())
+	cmd := s.runPut(ctx, s.client, *p)
+	return putResult(cmd, p, cmd.Err())
+}
+
+func (s *RedisStore) Create(ctx context.Context, p *Product) error {
+	stampProduct(p, time.Time{}, time.Now().UTC())
+	cmd := s.runScript(ctx, s.client, *p, true)
+	_, err := putResult(cmd, p, cmd.Err())
+	return err
+}
+
+func (s *RedisStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := make([]error, len(items))
+	if !atomic {
+		// Pipeline the scripts so the whole batch is one round trip
+		cmds := make([]*redis.Cmd, len(items))
+		now := time.Now().UTC()
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, p := range items {
+				stampProduct(&p, time.Time{}, now)
+				cmds[i] = s.runPut(ctx, pipe, p)
+			}
+			return nil
+		})
+		for i, p := range items {
+			_, errs[i] = putResult(cmds[i], &p, err)
+		}
+		return errs
+	}
+
+	// Best-effort all-or-nothing: remember prior values, undo on failure
+	ids := make([]int, len(items))
+	for i, p := range items {
+		ids[i] = p.ProductID
+	}
+	prior, err := s.GetMany(ctx, ids)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, p := range items {
+		if _, err := s.Put(ctx, &p); err != nil {
+			// The rollback runs even if the caller has gone away, which may
+			// be what failed the write
+			rollback := context.WithoutCancel(ctx)
+			for j := i - 1; j >= 0; j-- {
+				if old, existed := prior[items[j].ProductID]; existed {
+					s.runPut(rollback, s.client, old)
+				} else {
+					s.Delete(rollback, items[j].ProductID)
+				}
+			}
+			for j := range errs {
+				errs[j] = ErrBatchAborted
+			}
+			errs[i] = err
+			return errs
+		}
+	}
+	return errs
+}
+
+func (s *RedisStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	key := redisProductKey(id)
+
+	var updated Product
+	txf := func(tx *redis.Tx) error {
+		raw, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return redisUnavailable(err)
+		}
+		existing, err := decodeRedisProduct(raw)
+		if err != nil {
+			return err
+		}
+
+		updated = existing
+		if err := fn(&updated); err != nil {
+			return err
+		}
+		stampProduct(&updated, existing.CreatedAt, time.Now().UTC())
+
+		// MULTI/EXEC fails with TxFailedErr if the key changed since WATCH
+		var cmd *redis.Cmd
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			cmd = s.runPut(ctx, pipe, updated)
+			return nil
+		})
+		if errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		_, err = putResult(cmd, &updated, err)
+		return err
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return Product{}, err
+		}
+		return updated, nil
+	}
+	return Product{}, fmt.Errorf("redis update product %d: too many concurrent modifications", id)
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id int) error {
+	n, err := redisDeleteScript.Run(ctx, s.client, []string{redisProductKey(id)}, id).Int()
+	if err != nil {
+		return redisUnavailable(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIf removes the product if cond reports true. The key is watched
+// from the read to the delete, as in Update.
+func (s *RedisStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	key := redisProductKey(id)
+
+	var removed bool
+	txf := func(tx *redis.Tx) error {
+		raw, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return redisUnavailable(err)
+		}
+		existing, err := decodeRedisProduct(raw)
+		if err != nil {
+			return err
+		}
+		if removed = cond(existing); !removed {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			redisDeleteScript.Eval(ctx, pipe, []string{key}, id)
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.TxFailedErr) {
+			return redisUnavailable(err)
+		}
+		return err
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return removed, nil
+	}
+	return false, fmt.Errorf("redis delete product %d: too many concurrent modifications", id)
+}
+
+func (s *RedisStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	var keys []string
+	if filter.CategoryID > 0 {
+		members, err := s.client.SMembers(ctx, "category:"+strconv.Itoa(filter.CategoryID)).Result()
+		if err != nil {
+			return nil, redisUnavailable(err)
+		}
+		for _, m := range members {
+			keys = append(keys, "product:"+m)
+		}
+	} else {
+		iter := s.client.Scan(ctx, 0, "product:*", redisScanBatch).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return nil, redisUnavailable(err)
+		}
+	}
+
+	// Fetch in MGET-sized chunks; entries that expired meanwhile come back nil
+	items := make([]Product, 0, len(keys))
+	for start := 0; start < len(keys); start += redisScanBatch {
+		end := min(start+redisScanBatch, len(keys))
+		values, err := s.client.MGet(ctx, keys[start:end]...).Result()
+		if err != nil {
+			return nil, redisUnavailable(err)
+		}
+		for _, v := range values {
+			str, ok := v.(string)
+			if !ok {
+				continue
+			}
+			p, err := decodeRedisProduct([]byte(str))
+			if err != nil {
+				return nil, err
+			}
+			if filter.matches(p) {
+				items = append(items, p)
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ProductID < items[j].ProductID
+	})
+	return items, nil
+}
+
+// runPut queues or runs redisPutScript for p
+func (s *RedisStore) runPut(ctx context.Context, c redis.Scripter, p Product) *redis.Cmd {
+	return s.runScript(ctx, c, p, false)
+}
+
+// runScript runs redisPutScript for p, refusing to overwrite with createOnly
+func (s *RedisStore) runScript(ctx context.Context, c redis.Scripter, p Product, createOnly bool) *redis.Cmd {
+	// Product has only plain fields and server-set times, so Marshal cannot fail
+	body, _ := json.Marshal(p)
+	mode := "0"
+	if createOnly {
+		mode = "1"
+	}
+	return redisPutScript.Run(ctx, c,
+		[]string{redisProductKey(p.ProductID), "sku:" + p.SKU},
+		p.ProductID, body, p.SKU, p.CategoryID, s.ttl.Milliseconds(), mode)
+}
+
+// putResult converts the reply of redisPutScript into Put's results,
+// copying the created_at the script kept into p
+func putResult(cmd *redis.Cmd, p *Product, pipeErr error) (bool, error) {
+	if cmd == nil {
+		return false, redisUnavailable(pipeErr)
+	}
+	reply, err := cmd.Slice()
+	if err != nil {
+		return false, redisUnavailable(err)
+	}
+	owner, _ := reply[0].(int64)
+	if owner == -1 && len(reply) > 1 {
+		raw, _ := reply[1].(string)
+		existing, err := decodeRedisProduct([]byte(raw))
+		if err != nil {
+			return false, err
+		}
+		return false, &ProductExistsError{Existing: existing}
+	}
+	if owner != 0 {
+		return false, &DuplicateSKUError{SKU: p.SKU, ProductID: int(owner)}
+	}
+	if len(reply) < 2 || reply[1] == nil {
+		return true, nil
+	}
+	if raw, _ := reply[1].(string); raw != "" {
+		if created, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			p.CreatedAt = created
+		}
+	}
+	return false, nil
+}
+
+// redisProductKey is the key a product is stored under
+func redisProductKey(id int) string {
+	return "product:" + strconv.Itoa(id)
+}
+
+// decodeRedisProduct parses a stored JSON value
+func decodeRedisProduct(raw []byte) (Product, error) {
+	var p Product
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Product{}, fmt.Errorf("decode product: %w", err)
+	}
+	return p, nil
+}
+
+// redisUnavailable tags a Redis client error with ErrStoreUnavailable. A
+// context error is returned as-is: the caller gave up, the store didn't fail.
+func redisUnavailable(err error) error {
+	if contextError(err) {
+		return err
+	}
+	return fmt.Errorf("%w: redis: %v", ErrStoreUnavailable, err)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// openTestRedis returns a store over the Redis at REDIS_TEST_ADDR, e.g.
+// localhost:6379, whose products the tests delete. The test is skipped
+// without one.
+func openTestRedis(t *testing.T) *RedisStore {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	s, err := NewRedisStore(context.Background(), addr, os.Getenv("REDIS_TEST_PASSWORD"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.client.Close() })
+	return s
+}
+
+func TestRedisStoreConformance(t *testing.T) {
+	s := openTestRedis(t)
+	runStoreConformance(t, storeFactory{
+		open: func(t *testing.T) ProductStore {
+			emptyStore(t, s)
+			return s
+		},
+		boundedUpdateRetries: true,
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reloadableKeys are the settings a reload applies to the running process;
+// a change to any other is reported as needing a restart. CHAOS_* keys are
+// reloadable too.
+var reloadableKeys = map[string]bool{
+	"RATE_LIMIT_RPS":       true,
+	"RATE_LIMIT_BURST":     true,
+	"MAX_BODY_BYTES":       true,
+	"MAX_BATCH_BODY_BYTES": true,
+	"LOG_LEVEL":            true,
+	"WEBHOOK_URLS":         true,
+	"WEBHOOK_SECRET":       true,
+	"QUOTA_DAILY_REQUESTS": true,
+	"QUOTA_RPS":            true,
+	"API_KEY_QUOTAS":       true,
+	// Read through live(), so a secret can be rotated without a restart
+	"REQUEST_SIGNING_SECRETS": true,
+	"REQUEST_SIGNING_WINDOW":  true,
+}
+
+func reloadable(key string) bool {
+	return reloadableKeys[key] || strings.HasPrefix(key, "CHAOS_")
+}
+
+// ReloadResponse is the body of POST /admin/reload: the keys whose change
+// was applied, and those whose change waits for a restart
+type ReloadResponse struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// live returns the configuration in force. a.cfg stays as loaded at
+// startup; middleware reading a reloadable setting reads it from here, once
+// per request, so a request never sees half of a reload.
+func (a *API) live() *Config {
+	return a.liveCfg.Load()
+}
+
+// reloadConfig loads the configuration again and applies the reloadable
+// settings that changed since the last load; the rest keep their value
+// until a restart. An invalid configuration is rejected as a whole, leaving
+// everything as it was. Chaos and the log level are only reset when their
+// keys changed, so a reload doesn't undo POST /admin/chaos or PUT
+// /admin/loglevel.
+func (a *API) reloadConfig() (ReloadResponse, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	loaded, err := LoadConfig()
+	if err != nil {
+		return ReloadResponse{}, err
+	}
+	prev := a.live()
+	resp := ReloadResponse{Applied: []string{}, RequiresRestart: []string{}}
+	next := *prev
+	next.env = maps.Clone(prev.env)
+	changed := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(loaded.env)) {
+		if loaded.env[key] == prev.env[key] {
+			continue
+		}
+		if !reloadable(key) {
+			// Kept at its old raw value, so every reload reports it until
+			// the restart
+			resp.RequiresRestart = append(resp.RequiresRestart, key)
+			continue
+		}
+		resp.Applied = append(resp.Applied, key)
+		next.env[key] = loaded.env[key]
+		changed[key] = true
+	}
+	if len(resp.Applied) == 0 {
+		return resp, nil
+	}
+
+	next.RateLimitRPS, next.RateLimitBurst = loaded.RateLimitRPS, loaded.RateLimitBurst
+	next.MaxBodyBytes, next.MaxBatchBodyBytes = loaded.MaxBodyBytes, loaded.MaxBatchBodyBytes
+	next.LogLevel = loaded.LogLevel
+	next.Chaos = loaded.Chaos
+	next.WebhookURLs, next.WebhookSecret = loaded.WebhookURLs, loaded.WebhookSecret
+	next.QuotaDefault, next.Quotas = loaded.QuotaDefault, loaded.Quotas
+	next.SigningSecrets, next.SigningWindow = loaded.SigningSecrets, loaded.SigningWindow
+
+	if changed["RATE_LIMIT_RPS"] || changed["RATE_LIMIT_BURST"] {
+		a.limiter.Store(newRateLimiterFor(next))
+	}
+	if slices.ContainsFunc(resp.Applied, func(key string) bool { return strings.HasPrefix(key, "CHAOS_") }) {
+		chaos := next.Chaos
+		a.chaos.Store(&chaos)
+	}
+	if changed["LOG_LEVEL"] {
+		logLevel.Set(next.LogLevel)
+	}
+	if changed["WEBHOOK_URLS"] || changed["WEBHOOK_SECRET"] {
+		a.webhooks.replaceConfigured(next.WebhookURLs, next.WebhookSecret)
+	}
+	if changed["QUOTA_DAILY_REQUESTS"] || changed["QUOTA_RPS"] || changed["API_KEY_QUOTAS"] {
+		a.usage.setQuotas(next.QuotaDefault, next.Quotas)
+	}
+	a.liveCfg.Store(&next)
+	return resp, nil
+}
+
+// reload handles POST /admin/reload
+// Re-reads the environment and CONFIG_FILE like SIGHUP does.
+// Returns 200 with what was applied and what needs a restart, 400 if the
+// new configuration is invalid, in which case nothing changes
+func (a *API) reload(c *gin.Context) {
+	resp, err := a.reloadConfig()
+	if err != nil {
+		a.logger.Warn("config reload rejected", "request_id", requestID(c), "error", err)
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_CONFIG",
+			Message:   "Configuration not reloaded",
+			Details:   err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	a.logger.Warn("config reloaded", "request_id", requestID(c), "applied", resp.Applied, "requires_restart", resp.RequiresRestart)
+	c.JSON(http.StatusOK, resp)
+}
+
+// reloadOnSIGHUP reloads the configuration, and the TLS certificate when
+// certs isn't nil, every time the process gets SIGHUP, until ctx is done
+func reloadOnSIGHUP(ctx context.Context, api *API, certs *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return
+		}
+		if resp, err := api.reloadConfig(); err != nil {
+			slog.Error("config reload rejected; keeping the current one", "error", err)
+		} else {
+			slog.Warn("config reloaded", "applied", resp.Applied, "requires_restart", resp.RequiresRestart)
+		}
+		if certs == nil {
+			continue
+		}
+		if err := certs.reload(); err != nil {
+			slog.Error("tls certificate reload failed; keeping the current one", "error", err)
+			continue
+		}
+		slog.Info("tls certificate reloaded", "cert_file", certs.certFile)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonContentType is the Content-Type c.JSON sends, which cached bodies
+// are served with so a hit can't be told from a miss
+const jsonContentType = "application/json; charset=utf-8"
+
+// responseKey names one cached GET /products/{productId} body
+type responseKey struct {
+	tenant string
+	id     int
+}
+
+// cachedResponse is the encoded body of one product with the headers sent
+// alongside it, and when it stops being served: RESPONSE_CACHE_TTL after
+// it was filled, or sooner when the product expires
+type cachedResponse struct {
+	key       responseKey
+	body      []byte
+	etag      string
+	updatedAt time.Time
+	expires   time.Time
+}
+
+// responseCache keeps the JSON body and ETag of recently read products, so
+// a hot product is encoded once rather than on every GET. Unlike
+// cachingStore, which saves trips to a remote store, it saves the encoding
+// and sits above every backend.
+//
+// Writes through wrappedStore drop the entry before they return, and, as
+// in cachingStore, bump a generation a miss checks before filling, so no
+// body read before a write on this instance is served after it. Writes
+// made by other instances show up once RESPONSE_CACHE_TTL runs out.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[responseKey]*list.Element
+	lru     list.List // of *cachedResponse, most recently used first
+
+	gens [cacheGenStripes]atomic.Uint64
+
+	hits, misses, evictions atomic.Int64
+}
+
+// newResponseCache caches up to maxEntries bodies for ttl each
+func newResponseCache(maxEntries int, ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[responseKey]*list.Element, maxEntries)}
+}
+
+// gen returns the write generation of key's stripe
+func (r *responseCache) gen(key responseKey) *atomic.Uint64 {
+	return &r.gens[uint(key.id)%cacheGenStripes]
+}
+
+// lookup returns the entry for key, counting the hit or miss. The entry is
+// shared and must not be modified.
+func (r *responseCache) lookup(key responseKey, now time.Time) (*cachedResponse, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if el, ok := r.entries[key]; ok {
+		e := el.Value.(*cachedResponse)
+		if now.Before(e.expires) {
+			r.lru.MoveToFront(el)
+			r.hits.Add(1)
+			return e, true
+		}
+		r.lru.Remove(el)
+		delete(r.entries, key)
+	}
+	r.misses.Add(1)
+	return nil, false
+}
+
+// fill caches e unless a write to its stripe happened since gen was read,
+// evicting the least recently used entry when full
+func (r *responseCache) fill(e *cachedResponse, gen uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gen(e.key).Load() != gen {
+		return
+	}
+	if el, ok := r.entries[e.key]; ok {
+		el.Value = e
+		r.lru.MoveToFront(el)
+		return
+	}
+	if len(r.entries) >= r.maxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cachedResponse).key)
+		r.evictions.Add(1)
+	}
+	r.entries[e.key] = r.lru.PushFront(e)
+}
+
+// invalidate drops the entries of ids in tenant and stops misses already
+// in flight for them from filling the cache
+func (r *responseCache) invalidate(tenant string, ids ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		key := responseKey{tenant: tenant, id: id}
+		r.gen(key).Add(1)
+		if el, ok := r.entries[key]; ok {
+			r.lru.Remove(el)
+			delete(r.entries, key)
+		}
+	}
+}
+
+// clear drops every entry, for writes that don't go product by product
+func (r *responseCache) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.gens {
+		r.gens[i].Add(1)
+	}
+	clear(r.entries)
+	r.lru.Init()
+}
+
+func (r *responseCache) counts() (hits, misses, evictions int64) {
+	return r.hits.Load(), r.misses.Load(), r.evictions.Load()
+}
+
+// cacheable reports whether the GET /products/{productId} response to c
+// can come from the cache: a whole product as JSON
+func (r *responseCache) cacheable(c *gin.Context, fields []string) bool {
+	if r == nil || fields != nil {
+		return false
+	}
+	format := c.GetString(formatKey)
+	return format != formatXML && format != formatMsgpack
+}
+
+// serve writes a cached product the way getProduct writes a stored one
+func (r *responseCache) serve(c *gin.Context, e *cachedResponse) {
+	c.Header("ETag", e.etag)
+	if !e.updatedAt.IsZero() {
+		c.Header("Last-Modified", e.updatedAt.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c.Request, e.etag, e.updatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, jsonContentType, e.body)
+}
+
+// encode returns the entry for p, nil if it can't be encoded
+func (r *responseCache) encode(key responseKey, p Product, now time.Time) *cachedResponse {
+	body, err := marshalJSON(p)
+	if err != nil {
+		return nil
+	}
+	expires := now.Add(r.ttl)
+	if p.ExpiresAt != nil && p.ExpiresAt.Before(expires) {
+		expires = *p.ExpiresAt
+	}
+	return &cachedResponse{key: key, body: body, etag: productETag(p), updatedAt: p.UpdatedAt, expires: expires}
+}
+
+// invalidatingStore drops the response cache entries of every product it
+// writes once the write returns, whether or not it succeeded, since a
+// failed write may still have been applied
+type invalidatingStore struct {
+	ProductStore
+	cache *responseCache
+}
+
+func (s invalidatingStore) Put(ctx context.Context, p *Product) (bool, error) {
+	defer s.cache.invalidate(tenantFrom(ctx), p.ProductID)
+	return s.ProductStore.Put(ctx, p)
+}
+
+func (s invalidatingStore) Create(ctx context.Context, p *Product) error {
+	defer s.cache.invalidate(tenantFrom(ctx), p.ProductID)
+	return s.ProductStore.Create(ctx, p)
+}
+
+func (s invalidatingStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	ids := make([]int, len(items))
+	for i, p := range items {
+		ids[i] = p.ProductID
+	}
+	defer s.cache.invalidate(tenantFrom(ctx), ids...)
+	return s.ProductStore.PutBatch(ctx, items, atomic)
+}
+
+func (s invalidatingStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	defer s.cache.invalidate(tenantFrom(ctx), id)
+	return s.ProductStore.Update(ctx, id, fn)
+}
+
+func (s invalidatingStore) Restore(ctx context.Context, id int) (Product, error) {
+	defer s.cache.invalidate(tenantFrom(ctx), id)
+	return s.ProductStore.(productRestorer).Restore(ctx, id)
+}
+
+func (s invalidatingStore) Delete(ctx context.Context, id int) error {
+	defer s.cache.invalidate(tenantFrom(ctx), id)
+	return s.ProductStore.Delete(ctx, id)
+}
+
+func (s invalidatingStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	return listWindow(ctx, s.ProductStore, filter, limit, offset)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var responseCacheEnv = map[string]string{"RESPONSE_CACHE_MAX_ENTRIES": "100"}
+
+// getCached reads product 1 through router, failing t unless it is found
+func getCached(t *testing.T, router http.Handler) Product {
+	t.Helper()
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: got %d %s", w.Code, w.Body)
+	}
+	var p Product
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestResponseCacheDroppedOnWrite(t *testing.T) {
+	a, router := newTestAPI(t, NewInMemoryStore(false), responseCacheEnv)
+	p := testProduct(1)
+	doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(p))
+	getCached(t, router)
+	if got := getCached(t, router); got.Weight != p.Weight {
+		t.Fatalf("cached read: weight %d, want %d", got.Weight, p.Weight)
+	}
+	if hits, misses, _ := a.responses.counts(); hits != 1 || misses != 1 {
+		t.Fatalf("got %d hits and %d misses, want 1 and 1", hits, misses)
+	}
+
+	for _, tc := range []struct {
+		name, method, target, body string
+		weight, quantity           int
+	}{
+		{"replace", http.MethodPost, "/v1/products/1/details", `{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":20,"some_other_id":1}`, 20, 0},
+		{"patch", http.MethodPatch, "/v1/products/1", `{"weight":30}`, 30, 0},
+		{"batch", http.MethodPost, "/v1/products/batch", `[{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":40,"some_other_id":1}]`, 40, 0},
+		{"quantity", http.MethodPost, "/v1/products/1/quantity/adjust", `{"delta":5}`, 40, 5},
+	} {
+		if w := doRequest(router, tc.method, tc.target, tc.body); w.Code >= 300 {
+			t.Fatalf("%s: got %d %s", tc.name, w.Code, w.Body)
+		}
+		if got := getCached(t, router); got.Weight != tc.weight || got.Quantity != tc.quantity {
+			t.Errorf("after %s: weight %d, quantity %d; want %d, %d", tc.name, got.Weight, got.Quantity, tc.weight, tc.quantity)
+		}
+		getCached(t, router) // cached again for the next write to drop
+	}
+
+	doRequest(router, http.MethodDelete, "/v1/products/1", "")
+	if w := doRequest(router, http.MethodGet, "/v1/products/1", ""); w.Code != http.StatusGone {
+		t.Errorf("after delete: got %d %s, want 410", w.Code, w.Body)
+	}
+	doRequest(router, http.MethodPost, "/v1/products/1/restore", "")
+	if got := getCached(t, router); got.Weight != 40 {
+		t.Errorf("after restore: weight %d, want 40", got.Weight)
+	}
+}
+
+func TestInvalidatingStoreDropsEntries(t *testing.T) {
+	ctx := context.Background()
+	key := responseKey{id: 1}
+	for _, tc := range []struct {
+		name  string
+		write func(s invalidatingStore)
+	}{
+		{"Put", func(s invalidatingStore) { p := testProduct(1); s.Put(ctx, &p) }},
+		{"Create", func(s invalidatingStore) { p := testProduct(1); s.Create(ctx, &p) }},
+		{"PutBatch", func(s invalidatingStore) { s.PutBatch(ctx, []Product{testProduct(2), testProduct(1)}, false) }},
+		{"Update", func(s invalidatingStore) { s.Update(ctx, 1, func(p *Product) error { p.Weight++; return nil }) }},
+		{"Delete", func(s invalidatingStore) { s.Delete(ctx, 1) }},
+		{"failed Update", func(s invalidatingStore) { s.Update(ctx, 1, func(*Product) error { return errPreconditionFailed }) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newResponseCache(10, time.Minute)
+			s := invalidatingStore{NewInMemoryStore(false), r}
+			now := time.Now()
+			r.fill(r.encode(key, testProduct(1), now), r.gen(key).Load())
+			if _, ok := r.lookup(key, now); !ok {
+				t.Fatal("entry not cached")
+			}
+			tc.write(s)
+			if _, ok := r.lookup(key, now); ok {
+				t.Error("entry still cached after the write")
+			}
+		})
+	}
+}
+
+// TestResponseCacheFillAfterWrite checks that a miss whose store read
+// raced a write doesn't cache the body it read
+func TestResponseCacheFillAfterWrite(t *testing.T) {
+	r := newResponseCache(10, time.Minute)
+	key := responseKey{id: 1}
+	now := time.Now()
+
+	gen := r.gen(key).Load()
+	stale := r.encode(key, testProduct(1), now)
+	r.invalidate("", 1)
+	r.fill(stale, gen)
+	if _, ok := r.lookup(key, now); ok {
+		t.Fatal("body read before a write was cached after it")
+	}
+
+	// A write to another product in the same stripe also holds the fill
+	// back, which costs a miss but never a stale read
+	gen = r.gen(key).Load()
+	r.invalidate("", 1+cacheGenStripes)
+	r.fill(stale, gen)
+	if _, ok := r.lookup(key, now); ok {
+		t.Error("fill went through after a write to its stripe")
+	}
+
+	gen = r.gen(key).Load()
+	r.invalidate("tenant-b", 2)
+	r.fill(stale, gen)
+	if _, ok := r.lookup(key, now); !ok {
+		t.Error("fill dropped after a write to a different stripe")
+	}
+}
+
+// writeOnGetStore runs write once, just after the first Get has read its
+// product, as a write from another request landing mid-miss would
+type writeOnGetStore struct {
+	*InMemoryStore
+	write func()
+}
+
+func (s *writeOnGetStore) Get(ctx context.Context, id int) (Product, error) {
+	p, err := s.InMemoryStore.Get(ctx, id)
+	if write := s.write; write != nil {
+		s.write = nil
+		write()
+	}
+	return p, err
+}
+
+func TestResponseCacheMissRacingWrite(t *testing.T) {
+	store := &writeOnGetStore{InMemoryStore: NewInMemoryStore(false)}
+	a, router := newTestAPI(t, store, responseCacheEnv)
+	doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1)))
+	store.write = func() {
+		p := testProduct(1)
+		p.Weight = 99
+		if _, err := a.wrappedStore().Put(context.Background(), &p); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := getCached(t, router); got.Weight != 10 {
+		t.Fatalf("racing read: weight %d, want the 10 it read", got.Weight)
+	}
+	if got := getCached(t, router); got.Weight != 99 {
+		t.Errorf("next read: weight %d, want 99 written during the miss", got.Weight)
+	}
+}
+
+// BenchmarkGetProductResponseCache compares GET /v1/products/{id} encoded
+// on every request with the same read served from the response cache
+func BenchmarkGetProductResponseCache(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		env  map[string]string
+	}{
+		{"uncached", nil},
+		{"cached", responseCacheEnv},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			_, router := newTestAPI(b, benchStore(b, 100), bc.env)
+			targets := make([]string, 100)
+			for i := range targets {
+				targets[i] = "/v1/products/" + strconv.Itoa(i+1)
+			}
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				if w := doRequest(router, http.MethodGet, targets[i%len(targets)], ""); w.Code != http.StatusOK {
+					b.Fatalf("got %d", w.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Outcomes of a store call that was retried at least once
+const (
+	retrySucceeded = "succeeded"
+	retryExhausted = "exhausted" // every attempt failed transiently
+	retryFailed    = "failed"    // a later attempt failed for good
+	retryDeadline  = "deadline"  // the caller's deadline left no time for another
+)
+
+// retryPolicy retries store calls that failed transiently, waiting a random
+// time up to baseDelay doubled per attempt and capped at maxDelay ("full
+// jitter") so callers that failed together don't retry together
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	onRetry     func(op string)
+	onOutcome   func(outcome string)
+}
+
+func newRetryPolicy(cfg Config, m *Metrics) *retryPolicy {
+	return &retryPolicy{
+		maxAttempts: cfg.StoreRetryMaxAttempts,
+		baseDelay:   cfg.StoreRetryBaseDelay,
+		maxDelay:    cfg.StoreRetryMaxDelay,
+		onRetry:     m.storeRetry,
+		onOutcome:   m.storeRetryOutcome,
+	}
+}
+
+// transientError reports whether a store call failing with err may succeed
+// if repeated. Writes are only repeated when the store is known to have
+// refused them unapplied, as when DynamoDB throttles: after a timeout or a
+// dropped connection the first attempt may have gone through, and doing an
+// Update twice would apply its change twice.
+func transientError(err error, write bool) bool {
+	var open *CircuitOpenError
+	switch {
+	// context.DeadlineExceeded is a net.Error too, but repeating the call
+	// can't help once the caller's context is done
+	case err == nil, errors.As(err, &open), contextError(err):
+		return false
+	case dynamoThrottled(err):
+		return true
+	case write:
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrStoreUnavailable) || dynamoServerError(err) ||
+		errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// do runs fn until it succeeds, fails for good or maxAttempts is reached,
+// never sleeping past ctx's deadline. Calls that needed a retry are counted
+// and logged at debug with their outcome.
+func (r *retryPolicy) do(ctx context.Context, op string, write bool, fn func() error) error {
+	err := fn()
+	if !transientError(err, write) {
+		return err
+	}
+	attempts, outcome := 1, retryExhausted
+	for attempts < r.maxAttempts {
+		wait := rand.N(min(r.baseDelay<<(attempts-1), r.maxDelay) + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			outcome = retryDeadline
+			break
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			outcome = retryDeadline
+		case <-timer.C:
+		}
+		timer.Stop()
+		if outcome == retryDeadline {
+			break
+		}
+		r.onRetry(op)
+		attempts++
+		if err = fn(); !transientError(err, write) {
+			outcome = retrySucceeded
+			if err != nil {
+				outcome = retryFailed
+			}
+			break
+		}
+	}
+	r.onOutcome(outcome)
+	slog.Debug("store call retried", "operation", op, "attempts", attempts, "outcome", outcome, "error", err)
+	return err
+}
+
+// retryStore repeats next's calls under policy within each call's ctx
+type retryStore struct {
+	next   ProductStore
+	policy *retryPolicy
+}
+
+func (s retryStore) Get(ctx context.Context, id int) (Product, error) {
+	var p Product
+	err := s.policy.do(ctx, "Get", false, func() (err error) {
+		p, err = s.next.Get(ctx, id)
+		return err
+	})
+	return p, err
+}
+
+func (s retryStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	var p Product
+	err := s.policy.do(ctx, "GetBySKU", false, func() (err error) {
+		p, err = s.next.GetBySKU(ctx, sku)
+		return err
+	})
+	return p, err
+}
+
+func (s retryStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	var found map[int]Product
+	err := s.policy.do(ctx, "GetMany", false, func() (err error) {
+		found, err = s.next.GetMany(ctx, ids)
+		return err
+	})
+	return found, err
+}
+
+func (s retryStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	var items []Product
+	err := s.policy.do(ctx, "List", false, func() (err error) {
+		items, err = s.next.List(ctx, filter)
+		return err
+	})
+	return items, err
+}
+
+func (s retryStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	var items []Product
+	var total int
+	err := s.policy.do(ctx, "ListWindow", false, func() (err error) {
+		items, total, err = listWindow(ctx, s.next, filter, limit, offset)
+		return err
+	})
+	return items, total, err
+}
+
+func (s retryStore) Put(ctx context.Context, p *Product) (bool, error) {
+	var created bool
+	err := s.policy.do(ctx, "Put", true, func() (err error) {
+		created, err = s.next.Put(ctx, p)
+		return err
+	})
+	return created, err
+}
+
+func (s retryStore) Create(ctx context.Context, p *Product) error {
+	return s.policy.do(ctx, "Create", true, func() error { return s.next.Create(ctx, p) })
+}
+
+// PutBatch is not retried: items can fail one by one, and repeating the
+// batch would rewrite those that succeeded
+func (s retryStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	return s.next.PutBatch(ctx, items, atomic)
+}
+
+func (s retryStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	var p Product
+	err := s.policy.do(ctx, "Update", true, func() (err error) {
+		p, err = s.next.Update(ctx, id, fn)
+		return err
+	})
+	return p, err
+}
+
+func (s retryStore) Delete(ctx context.Context, id int) error {
+	return s.policy.do(ctx, "Delete", true, func() error { return s.next.Delete(ctx, id) })
+}
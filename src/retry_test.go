@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// scriptedStore fails its Get, Put and Update calls with err until fails
+// of them have, then passes them to the embedded store
+type scriptedStore struct {
+	ProductStore
+	err   error
+	fails atomic.Int32
+	calls atomic.Int32
+}
+
+func (s *scriptedStore) failing() error {
+	s.calls.Add(1)
+	if s.fails.Add(-1) >= 0 {
+		return s.err
+	}
+	return nil
+}
+
+func (s *scriptedStore) Get(ctx context.Context, id int) (Product, error) {
+	if err := s.failing(); err != nil {
+		return Product{}, err
+	}
+	return s.ProductStore.Get(ctx, id)
+}
+
+func (s *scriptedStore) Put(ctx context.Context, p *Product) (bool, error) {
+	if err := s.failing(); err != nil {
+		return false, err
+	}
+	return s.ProductStore.Put(ctx, p)
+}
+
+func (s *scriptedStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	if err := s.failing(); err != nil {
+		return Product{}, err
+	}
+	return s.ProductStore.Update(ctx, id, fn)
+}
+
+// testRetryPolicy retries up to four times with millisecond waits,
+// recording the outcome of each retried call into outcome
+func testRetryPolicy(outcome *string) *retryPolicy {
+	return &retryPolicy{
+		maxAttempts: 4,
+		baseDelay:   time.Millisecond,
+		maxDelay:    2 * time.Millisecond,
+		onRetry:     func(string) {},
+		onOutcome:   func(o string) { *outcome = o },
+	}
+}
+
+func TestRetryStore(t *testing.T) {
+	throttled := &types.ThrottlingException{}
+	for _, tc := range []struct {
+		name      string
+		err       error
+		fails     int32
+		write     bool
+		wantCalls int32
+		wantErr   bool
+		outcome   string // "" if the first call's result stood
+	}{
+		{"read recovers", ErrStoreUnavailable, 2, false, 3, false, retrySucceeded},
+		{"read exhausted", ErrStoreUnavailable, 10, false, 4, true, retryExhausted},
+		{"read network error", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, 1, false, 2, false, retrySucceeded},
+		{"dynamo 500 on a read", &types.InternalServerError{}, 3, false, 4, false, retrySucceeded},
+		{"not found not retried", ErrNotFound, 1, false, 1, true, ""},
+		{"open circuit not retried", &CircuitOpenError{RetryAfter: time.Second}, 1, false, 1, true, ""},
+		{"caller deadline not retried", context.DeadlineExceeded, 1, false, 1, true, ""},
+		{"write throttled", throttled, 2, true, 3, false, retrySucceeded},
+		{"write maybe applied not retried", ErrStoreUnavailable, 1, true, 1, true, ""},
+		{"dynamo 500 on a write not retried", &types.InternalServerError{}, 1, true, 1, true, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := NewInMemoryStore(false)
+			mustPut(t, mem, testProduct(1))
+			fake := &scriptedStore{ProductStore: mem, err: tc.err}
+			fake.fails.Store(tc.fails)
+			var outcome string
+			s := retryStore{next: fake, policy: testRetryPolicy(&outcome)}
+
+			var err error
+			if tc.write {
+				_, err = s.Update(ctx, 1, func(p *Product) error { p.Quantity++; return nil })
+			} else {
+				_, err = s.Get(ctx, 1)
+			}
+			if (err != nil) != tc.wantErr || tc.wantErr && !errors.Is(err, tc.err) {
+				t.Errorf("got error %v, want %v", err, tc.err)
+			}
+			if got := fake.calls.Load(); got != tc.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tc.wantCalls)
+			}
+			if outcome != tc.outcome {
+				t.Errorf("outcome %q, want %q", outcome, tc.outcome)
+			}
+			if p, _ := mem.Get(ctx, 1); tc.write && !tc.wantErr && p.Quantity != 1 {
+				t.Errorf("quantity %d after a retried Update, want 1", p.Quantity)
+			}
+		})
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	fake := &scriptedStore{ProductStore: NewInMemoryStore(false), err: ErrStoreUnavailable}
+	fake.fails.Store(100)
+	var outcome string
+	policy := testRetryPolicy(&outcome)
+	policy.maxAttempts, policy.baseDelay, policy.maxDelay = 10, 40*time.Millisecond, 40*time.Millisecond
+	s := retryStore{next: fake, policy: policy}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.Get(ctx, 1); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("got %v, want the store's own error", err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("returned after %v, past the 60ms deadline", waited)
+	}
+	if outcome != retryDeadline || fake.calls.Load() >= 10 {
+		t.Errorf("outcome %q after %d calls, want %q before all 10", outcome, fake.calls.Load(), retryDeadline)
+	}
+}
+
+// TestRetryThroughAPI checks handlers see a store that fails twice as one
+// that works, and one that keeps failing as unavailable
+func TestRetryThroughAPI(t *testing.T) {
+	for _, fails := range []int32{2, 10} {
+		t.Run(fmt.Sprint(fails, " failures"), func(t *testing.T) {
+			mem := NewInMemoryStore(false)
+			mustPut(t, mem, testProduct(1))
+			fake := &scriptedStore{ProductStore: mem, err: ErrStoreUnavailable}
+			fake.fails.Store(fails)
+			a, router := newTestAPI(t, fake, nil)
+			var outcome string
+			a.retry = testRetryPolicy(&outcome)
+
+			w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+			want := http.StatusOK
+			if fails >= int32(a.retry.maxAttempts) {
+				want = http.StatusServiceUnavailable
+			}
+			if w.Code != want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// seedFileFormat returns "csv" or "json" from the SEED_FILE extension, ""
+// for anything else
+func seedFileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	}
+	return ""
+}
+
+// loadSeedFile stores the products in path, a CSV file in the export layout
+// or a JSON array of products, replacing any with the same IDs. Records are
+// read one at a time and written exportChunkSize at a time, so large files
+// are never held in memory. Invalid records are skipped and logged; an
+// error means the file could not be read to the end.
+func (a *API) loadSeedFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	// Straight to the store: seeding is not a change anyone subscribed to
+	imp := &importer{ctx: context.Background(), store: a.store, validate: a.validateWrite, upsert: true, resp: ImportResponse{Errors: []ImportRowError{}}}
+	if seedFileFormat(path) == "csv" {
+		err = seedCSV(imp, f)
+	} else {
+		err = seedJSON(imp, f)
+	}
+	imp.flush()
+
+	for _, e := range imp.resp.Errors {
+		slog.Warn("seed record skipped", "path", path, "record", e.Line, "product_id", e.ProductID, "error", e.Error)
+	}
+	slog.Info("seed file loaded", "path", path, "loaded", imp.resp.Imported, "skipped", imp.resp.Skipped,
+		"duration_ms", time.Since(start).Milliseconds())
+	return err
+}
+
+// seedCSV queues every data row of r; records are numbered by line
+func seedCSV(imp *importer, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	cr.Comment = '#' // the snapshot line of an export
+	header, err := cr.Read()
+	if err == nil {
+		err = checkImportHeader(header)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		line, _ := cr.FieldPos(0)
+		if errors.Is(err, csv.ErrFieldCount) {
+			imp.fail(ImportRowError{Line: line, Error: "row has the wrong number of fields"})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		imp.add(line, record)
+	}
+}
+
+// seedJSON queues every element of the JSON array in r; records are
+// numbered from 1
+func seedJSON(imp *importer, r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return errors.New("JSON seed file must hold an array of products")
+	}
+	for n := 1; dec.More(); n++ {
+		var p Product
+		if err := dec.Decode(&p); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return fmt.Errorf("record %d: %w", n, err)
+			}
+			// The decoder read the whole element, so the rest can still be loaded
+			imp.fail(ImportRowError{Line: n, ProductID: p.ProductID, Error: err.Error()})
+			continue
+		}
+		normalizeProduct(&p)
+		if errs := imp.validate(p, ""); errs != nil {
+			imp.fail(ImportRowError{Line: n, ProductID: p.ProductID, Error: fieldErrorsDetails(errs), Fields: errs})
+			continue
+		}
+		imp.queue(n, p)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultSnapshotInterval is used when SNAPSHOT_INTERVAL is not set
+const defaultSnapshotInterval = 30 * time.Second
+
+// snapshotFile is the on-disk layout written by saveSnapshot.
+// WALSeq is the last write-ahead log record already reflected in Products.
+type snapshotFile struct {
+	SavedAt  time.Time `json:"saved_at"`
+	WALSeq   uint64    `json:"wal_seq,omitempty"`
+	Products []Product `json:"products"`
+}
+
+// Snapshot returns a copy of every stored product and the sequence number of
+// the last WAL record it includes (0 without a WAL). The read lock is held
+// only while copying, so callers can do slow work (disk I/O) without
+// blocking writers. Unlike List, every shard is held at once so the copy is
+// a single point in time that matches the WAL sequence.
+func (s *InMemoryStore) Snapshot() ([]Product, uint64) {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	items := []Product{}
+	for i := range s.shards {
+		for _, p := range s.shards[i].products {
+			items = append(items, p)
+		}
+	}
+
+	// WAL appends happen under a shard's write lock, so the sequence can't move here
+	var seq uint64
+	if s.wal != nil {
+		seq = s.wal.LastSeq()
+	}
+	return items, seq
+}
+
+// Restore adds items to the store, rebuilding the secondary indexes.
+// Items that fail validation or collide on SKU are skipped and counted.
+func (s *InMemoryStore) Restore(items []Product) (loaded, skipped int) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	for _, p := range items {
+		if validateProduct(p) != nil || s.putLocked(p) != nil {
+			skipped++
+			continue
+		}
+		loaded++
+	}
+	return loaded, skipped
+}
+
+// saveSnapshot writes items to path atomically: the data goes to a temp
+// file in the same directory which is fsynced and then renamed over path,
+// so a crash mid-write never leaves a truncated snapshot behind.
+func saveSnapshot(path string, items []Product, walSeq uint64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := json.NewEncoder(tmp).Encode(snapshotFile{SavedAt: time.Now().UTC(), WALSeq: walSeq, Products: items}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	return nil
+}
+
+// loadSnapshot reads a snapshot written by saveSnapshot. A missing file is
+// not an error and yields no products.
+func loadSnapshot(path string) (snapshotFile, error) {
+	var snap snapshotFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshotFile{}, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// restoreSnapshot loads path into store before the server starts and returns
+// the WAL sequence it covers. A corrupt or unreadable snapshot is logged and
+// the store starts empty.
+func restoreSnapshot(store *InMemoryStore, path string) uint64 {
+	snap, err := loadSnapshot(path)
+	if err != nil {
+		slog.Warn("ignoring snapshot, starting empty", "path", path, "error", err)
+		return 0
+	}
+	loaded, skipped := store.Restore(snap.Products)
+	slog.Info("snapshot restored", "path", path, "loaded", loaded, "skipped", skipped)
+	return snap.WALSeq
+}
+
+// runSnapshotter writes the store to path every interval until ctx is done,
+// then writes one final snapshot
+func runSnapshotter(ctx context.Context, store *InMemoryStore, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			writeSnapshot(store, path)
+		case <-ctx.Done():
+			writeSnapshot(store, path)
+			return
+		}
+	}
+}
+
+// writeSnapshot saves one snapshot and, if a WAL is attached, drops the log
+// records the snapshot now covers
+func writeSnapshot(store *InMemoryStore, path string) {
+	items, seq := store.Snapshot()
+	if err := saveSnapshot(path, items, seq); err != nil {
+		slog.Error("snapshot failed", "path", path, "error", err)
+		return
+	}
+	if store.wal != nil {
+		if err := store.wal.Compact(seq); err != nil {
+			slog.Error("wal compaction failed", "error", err)
+		}
+	}
+}
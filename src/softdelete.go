@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrDeleted is returned in place of a soft-deleted product. It wraps
+// ErrNotFound, so callers that don't care treat the product as missing.
+var ErrDeleted = fmt.Errorf("%w: deleted", ErrNotFound)
+
+// errNotDeleted is returned by Restore when the product is not deleted
+var errNotDeleted = errors.New("product is not deleted")
+
+// productRestorer is implemented by every store wrappedStore returns
+type productRestorer interface {
+	// Restore clears DeletedAt on a soft-deleted product and returns it,
+	// ErrNotFound if it doesn't exist and errNotDeleted if it isn't deleted
+	Restore(ctx context.Context, id int) (Product, error)
+}
+
+// conditionalDeleter is implemented by every store a.store can be, so the
+// purge can check a product and remove it in one step
+type conditionalDeleter interface {
+	// DeleteIf removes the product if cond, called with it while no other
+	// write can change it, reports true, and whether it did so. It returns
+	// ErrNotFound if the product doesn't exist. cond must not modify p.
+	DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error)
+}
+
+// errNoConditionalDelete is returned by deleteIf for a store without
+// DeleteIf
+var errNoConditionalDelete = errors.New("store cannot delete conditionally")
+
+// deleteIf calls store's DeleteIf, for the wrappers that pass it on
+func deleteIf(ctx context.Context, store ProductStore, id int, cond func(p Product) bool) (bool, error) {
+	d, ok := store.(conditionalDeleter)
+	if !ok {
+		return false, errNoConditionalDelete
+	}
+	return d.DeleteIf(ctx, id, cond)
+}
+
+// PurgeResponse is the body returned by DELETE /admin/products/deleted
+type PurgeResponse struct {
+	XMLName xml.Name `json:"-" xml:"purge"`
+	Purged  int      `json:"purged" xml:"purged"`
+}
+
+// softDeleteStore turns Delete into setting DeletedAt and hides the
+// products it marked from reads. Soft-deleted products keep their SKU, so a
+// restore never conflicts; listings leave them out unless
+// ListFilter.IncludeDeleted is set, which every store checks in matches.
+// Writes that replace a product (Put, PutBatch) bring it back; patches and
+// other updates see it as missing.
+type softDeleteStore struct {
+	ProductStore
+}
+
+func (s softDeleteStore) Get(ctx context.Context, id int) (Product, error) {
+	p, err := s.ProductStore.Get(ctx, id)
+	if err == nil && p.DeletedAt != nil {
+		return Product{}, ErrDeleted
+	}
+	return p, err
+}
+
+func (s softDeleteStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	p, err := s.ProductStore.GetBySKU(ctx, sku)
+	if err == nil && p.DeletedAt != nil {
+		return Product{}, ErrDeleted
+	}
+	return p, err
+}
+
+func (s softDeleteStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	found, err := s.ProductStore.GetMany(ctx, ids)
+	for id, p := range found {
+		if p.DeletedAt != nil {
+			delete(found, id)
+		}
+	}
+	return found, err
+}
+
+func (s softDeleteStore) Put(ctx context.Context, p *Product) (bool, error) {
+	p.DeletedAt = nil
+	return s.ProductStore.Put(ctx, p)
+}
+
+func (s softDeleteStore) Create(ctx context.Context, p *Product) error {
+	p.DeletedAt = nil
+	return s.ProductStore.Create(ctx, p)
+}
+
+func (s softDeleteStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	for i := range items {
+		items[i].DeletedAt = nil
+	}
+	return s.ProductStore.PutBatch(ctx, items, atomic)
+}
+
+func (s softDeleteStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	return s.ProductStore.Update(ctx, id, func(p *Product) error {
+		if p.DeletedAt != nil {
+			return ErrDeleted
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+		p.DeletedAt = nil
+		return nil
+	})
+}
+
+// ListWindow passes the window on; listings leave deleted products out
+// through the filter, as List does
+func (s softDeleteStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	return listWindow(ctx, s.ProductStore, filter, limit, offset)
+}
+
+// Delete marks the product deleted; deleting it again returns ErrDeleted
+func (s softDeleteStore) Delete(ctx context.Context, id int) error {
+	_, err := s.ProductStore.Update(ctx, id, func(p *Product) error {
+		if p.DeletedAt != nil {
+			return ErrDeleted
+		}
+		now := time.Now().UTC()
+		p.DeletedAt = &now
+		return nil
+	})
+	return err
+}
+
+func (s softDeleteStore) Restore(ctx context.Context, id int) (Product, error) {
+	return s.ProductStore.Update(ctx, id, func(p *Product) error {
+		if p.DeletedAt == nil {
+			return errNotDeleted
+		}
+		p.DeletedAt = nil
+		return nil
+	})
+}
+
+// purgeDeleted permanently removes the products soft-deleted before cutoff
+// from store, which must not hide deleted products, and returns how many
+// went. Each is checked again as it is removed, so one restored since the
+// listing is kept.
+func purgeDeleted(ctx context.Context, store ProductStore, cutoff time.Time) (int, error) {
+	items, err := store.List(ctx, ListFilter{IncludeDeleted: true})
+	if err != nil {
+		return 0, err
+	}
+	purgeable := func(p Product) bool {
+		return p.DeletedAt != nil && p.DeletedAt.Before(cutoff)
+	}
+	purged := 0
+	for _, p := range items {
+		if !purgeable(p) {
+			continue
+		}
+		removed, err := deleteIf(ctx, store, p.ProductID, purgeable)
+		switch {
+		case removed:
+			purged++
+		case err != nil && !errors.Is(err, ErrNotFound):
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// writeDeleted writes the response for a soft-deleted product: 410, or the
+// usual 404 with DELETED_RETURNS_410=false
+func (a *API) writeDeleted(c *gin.Context, productID int) {
+	if !a.cfg.DeletedReturns410 {
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	writeError(c, http.StatusGone, ErrorResponse{
+		Error:     "GONE",
+		Message:   "Product was deleted",
+		Details:   "Product " + strconv.Itoa(productID) + " was deleted; POST /products/" + strconv.Itoa(productID) + "/restore brings it back",
+		RequestID: requestID(c),
+	})
+}
+
+// restoreProduct handles POST /products/{productId}/restore
+// Brings back a soft-deleted product as it was when deleted.
+// Returns 200 with the product, its ETag and Last-Modified, 400 if bad ID,
+// 404 if not found (including already purged), 409 NOT_DELETED if it isn't
+// deleted
+func (a *API) restoreProduct(c *gin.Context) {
+	productID, ok := parseProductID(c, "Invalid product ID")
+	if !ok {
+		return
+	}
+
+	p, err := a.wrappedStore().(productRestorer).Restore(c.Request.Context(), productID)
+	switch {
+	case errors.Is(err, errNotDeleted):
+		writeError(c, http.StatusConflict, ErrorResponse{
+			Error:     "NOT_DELETED",
+			Message:   "Product is not deleted",
+			Details:   "Product " + strconv.Itoa(productID) + " is not deleted, so there is nothing to restore",
+			RequestID: requestID(c),
+		})
+		return
+	case errors.Is(err, ErrNotFound):
+		writeError(c, http.StatusNotFound, ErrorResponse{
+			Error:     "NOT_FOUND",
+			Message:   "Product not found",
+			Details:   "No product found with ID " + strconv.Itoa(productID),
+			RequestID: requestID(c),
+		})
+		return
+	case err != nil:
+		writeStoreError(c, err)
+		return
+	}
+	setProductValidators(c, p)
+	writeProducts(c, http.StatusOK, p)
+}
+
+// purgeDeletedProducts handles DELETE /admin/products/deleted?before=
+// Permanently removes the products soft-deleted before the RFC 3339 time,
+// which is required; they can no longer be restored. No change events are
+// sent, as subscribers already had product.deleted.
+// Returns 200 with the number purged, 400 if before is missing or invalid
+func (a *API) purgeDeletedProducts(c *gin.Context) {
+	cutoff, err := time.Parse(time.RFC3339Nano, c.Query("before"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid before",
+			Details:   "before is required and must be an RFC 3339 timestamp, e.g. 2024-05-01T12:00:00Z",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	purged, err := purgeDeleted(c.Request.Context(), a.store, cutoff)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	a.logger.Warn("deleted products purged", "request_id", requestID(c), "before", cutoff, "purged", purged)
+	writeProducts(c, http.StatusOK, PurgeResponse{Purged: purged})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listHookStore runs afterList once List has returned, to interleave a
+// write between the purge's listing and its removals
+type listHookStore struct {
+	*InMemoryStore
+	afterList func()
+}
+
+func (s listHookStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	items, err := s.InMemoryStore.List(ctx, filter)
+	s.afterList()
+	return items, err
+}
+
+func TestPurgeDeletedKeepsProductRestoredMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStore(false)
+	soft := softDeleteStore{mem}
+	for _, id := range []int{1, 2} {
+		p := testProduct(id)
+		if _, err := soft.Put(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+		if err := soft.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := listHookStore{mem, func() {
+		if _, err := soft.Restore(ctx, 1); err != nil {
+			t.Errorf("restore: %v", err)
+		}
+	}}
+	purged, err := purgeDeleted(ctx, store, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d products, want 1", purged)
+	}
+	if p, err := mem.Get(ctx, 1); err != nil || p.DeletedAt != nil {
+		t.Errorf("restored product: got %+v, %v; want it kept and not deleted", p, err)
+	}
+	if _, err := mem.Get(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted product: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestPurgeDeletedHonoursCutoff(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStore(false)
+	soft := softDeleteStore{mem}
+	for _, id := range []int{1, 2, 3} {
+		p := testProduct(id)
+		if _, err := soft.Put(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := soft.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if err := soft.Delete(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := purgeDeleted(ctx, mem, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d products, want 1", purged)
+	}
+	for id, want := range map[int]error{1: ErrNotFound, 2: nil, 3: nil} {
+		if _, err := mem.Get(ctx, id); !errors.Is(err, want) {
+			t.Errorf("product %d: got %v, want %v", id, err, want)
+		}
+	}
+}
+
+func TestInMemoryDeleteIf(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStore(false)
+	p := testProduct(1)
+	if _, err := mem.Put(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := mem.DeleteIf(ctx, 1, func(Product) bool { return false }); removed || err != nil {
+		t.Errorf("false condition: got %v, %v; want false, nil", removed, err)
+	}
+	if _, err := mem.Get(ctx, 1); err != nil {
+		t.Errorf("product removed despite a false condition: %v", err)
+	}
+	if removed, err := mem.DeleteIf(ctx, 1, func(Product) bool { return true }); !removed || err != nil {
+		t.Errorf("true condition: got %v, %v; want true, nil", removed, err)
+	}
+	if _, err := mem.GetBySKU(ctx, p.SKU); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SKU still indexed after DeleteIf: %v", err)
+	}
+	if _, err := mem.DeleteIf(ctx, 1, func(Product) bool { return true }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing product: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestRestoreAndPurgeNegotiate(t *testing.T) {
+	_, router := newTestAPI(t, NewInMemoryStore(false), map[string]string{"ADMIN_ENABLED": "true"})
+	doRequest(router, http.MethodPost, "/v1/products/1/details", productJSON(testProduct(1)))
+	doRequest(router, http.MethodDelete, "/v1/products/1", "")
+
+	w := doRequest(router, http.MethodPost, "/v1/products/1/restore", "", "Accept", "application/xml")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "<product>") {
+		t.Fatalf("restore: got %d %s, want an XML product", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("restore: ETag %q, Last-Modified %q; want both set", w.Header().Get("ETag"), w.Header().Get("Last-Modified"))
+	}
+
+	doRequest(router, http.MethodDelete, "/v1/products/1", "")
+	purge := "/admin/products/deleted?before=" + time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	if w := doRequest(router, http.MethodDelete, purge, "", "Accept", "text/csv"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("purge with Accept text/csv: got %d, want 406", w.Code)
+	}
+	w = doRequest(router, http.MethodDelete, purge, "", "Accept", "application/xml")
+	if w.Code != http.StatusOK || w.Body.String() != "<purge><purged>1</purged></purge>" {
+		t.Errorf("purge: got %d %s", w.Code, w.Body)
+	}
+}
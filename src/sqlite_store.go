@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteUpsert writes every column of a product keyed on product_id; the
+// store has already read the created_at it keeps
+const sqliteUpsert = `INSERT INTO products (` + productColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT (product_id) DO UPDATE SET
+	sku = excluded.sku, manufacturer = excluded.manufacturer, category_id = excluded.category_id,
+	weight = excluded.weight, some_other_id = excluded.some_other_id, name = excluded.name,
+	description = excluded.description, price = excluded.price, currency = excluded.currency,
+	quantity = excluded.quantity, created_at = excluded.created_at, updated_at = excluded.updated_at,
+	deleted_at = excluded.deleted_at, expires_at = excluded.expires_at`
+
+// sqliteBusyTimeout is how long a connection waits on a lock held by
+// another before giving up with SQLITE_BUSY, in milliseconds
+const sqliteBusyTimeout = 5000
+
+// SQLiteStore is a ProductStore backed by a products table in an SQLite
+// file, for persistence without a database server. The schema is created
+// by the migrations under migrations/sqlite when the store opens.
+//
+// The file is in WAL mode, and writes go through a single connection
+// whose transactions take the write lock up front, so they run one at a
+// time and a write's SKU check sees every write before it. Reads use a
+// pool of read-only connections that, in WAL mode, are never blocked by
+// the writer and see the last committed state, so a GET during a write
+// doesn't wait for it or fail with SQLITE_BUSY.
+//
+// Times are kept as Unix nanoseconds, so a product reads back exactly as
+// it was written.
+type SQLiteStore struct {
+	writer *sql.DB
+	reader *sql.DB
+}
+
+// NewSQLiteStore opens, creating if needed, the database file at path and
+// applies the migrations it hasn't had yet
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	if path == "" || path == ":memory:" || strings.HasPrefix(path, "file:") {
+		// Each connection to :memory: would get a database of its own
+		return nil, fmt.Errorf("SQLITE_PATH must be a file path, got %q", path)
+	}
+	busy := "busy_timeout(" + strconv.Itoa(sqliteBusyTimeout) + ")"
+	writer, err := sql.Open("sqlite", path+"?"+url.Values{
+		"_pragma": {busy, "journal_mode(WAL)", "synchronous(NORMAL)"},
+		"_txlock": {"immediate"},
+	}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	reader, err := sql.Open("sqlite", path+"?"+url.Values{
+		"_pragma": {busy, "query_only(1)"},
+	}.Encode())
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	readers := 4 * runtime.GOMAXPROCS(0)
+	reader.SetMaxOpenConns(readers)
+	reader.SetMaxIdleConns(readers)
+
+	s := &SQLiteStore{writer: writer, reader: reader}
+	if err := s.migrate(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("open sqlite database %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the database once its connections are returned
+func (s *SQLiteStore) Close() error {
+	return errors.Join(s.reader.Close(), s.writer.Close())
+}
+
+// migrate applies the embedded migrations not yet recorded in
+// schema_migrations, all in one transaction; the write lock it holds
+// keeps other processes opening the file from applying them too
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		return err
+	}
+	var applied []migration
+	err = s.write(ctx, "migrate", func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	applied_at INTEGER NOT NULL
+)`); err != nil {
+			return err
+		}
+		done := make(map[int]bool)
+		rows, err := tx.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int
+			if err := rows.Scan(&v); err != nil {
+				return err
+			}
+			done[v] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if done[m.version] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+				return fmt.Errorf("apply %s: %w", m.name, err)
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				m.version, m.name, time.Now().UnixNano()); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		slog.Info("sqlite migration applied", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+// Ping checks that the database answers a query, for the readiness probe
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	if _, err := s.reader.ExecContext(ctx, `SELECT 1`); err != nil {
+		return sqliteError("ping", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id int) (Product, error) {
+	p, err := scanSQLiteProduct(s.reader.QueryRowContext(ctx,
+		`SELECT `+productColumns+` FROM products WHERE product_id = $1`, id))
+	if err != nil {
+		return Product{}, sqliteError("get product", err)
+	}
+	return p, nil
+}
+
+func (s *SQLiteStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	p, err := scanSQLiteProduct(s.reader.QueryRowContext(ctx,
+		`SELECT `+productColumns+` FROM products WHERE sku = $1`, sku))
+	if err != nil {
+		return Product{}, sqliteError("get product by sku", err)
+	}
+	return p, nil
+}
+
+func (s *SQLiteStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	found := make(map[int]Product, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	params := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		params[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id
+	}
+	rows, err := s.reader.QueryContext(ctx, `SELECT `+productColumns+` FROM products WHERE product_id IN (`+
+		strings.Join(params, ", ")+`)`, args...)
+	if err != nil {
+		return nil, sqliteError("get products", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanSQLiteProduct(rows)
+		if err != nil {
+			return nil, sqliteError("get products", err)
+		}
+		found[p.ProductID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError("get products", err)
+	}
+	return found, nil
+}
+
+func (s *SQLiteStore) Put(ctx context.Context, p *Product) (bool, error) {
+	var created bool
+	err := s.write(ctx, "put product", func(tx *sql.Tx) (err error) {
+		created, err = sqlitePut(ctx, tx, p, sqliteNow())
+		return err
+	})
+	return created, err
+}
+
+func (s *SQLiteStore) Create(ctx context.Context, p *Product) error {
+	return s.write(ctx, "create product", func(tx *sql.Tx) error {
+		existing, err := scanSQLiteProduct(tx.QueryRowContext(ctx,
+			`SELECT `+productColumns+` FROM products WHERE product_id = $1`, p.ProductID))
+		if err == nil {
+			return &ProductExistsError{Existing: existing}
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		_, err = sqlitePut(ctx, tx, p, sqliteNow())
+		return err
+	})
+}
+
+func (s *SQLiteStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := make([]error, len(items))
+	if !atomic {
+		for i, p := range items {
+			_, errs[i] = s.Put(ctx, &p)
+		}
+		return errs
+	}
+
+	// Every write goes in one transaction, so readers see the whole batch
+	// or none of it; each sees the SKUs claimed by those before it
+	failed := -1
+	now := sqliteNow()
+	err := s.write(ctx, "put batch", func(tx *sql.Tx) error {
+		for i := range items {
+			p := items[i]
+			if _, err := sqlitePut(ctx, tx, &p, now); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return errs
+	}
+	for i := range errs {
+		errs[i] = err
+	}
+	if failed >= 0 {
+		for i := range errs {
+			errs[i] = ErrBatchAborted
+		}
+		errs[failed] = err
+	}
+	return errs
+}
+
+func (s *SQLiteStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	// The write lock is held from the read to the write, so concurrent
+	// updates of one product queue up instead of retrying
+	var updated Product
+	var fnErr error
+	err := s.write(ctx, "update product", func(tx *sql.Tx) error {
+		existing, err := scanSQLiteProduct(tx.QueryRowContext(ctx,
+			`SELECT `+productColumns+` FROM products WHERE product_id = $1`, id))
+		if err != nil {
+			return err
+		}
+
+		updated = existing
+		if fnErr = fn(&updated); fnErr != nil {
+			return fnErr
+		}
+		updated.ProductID = id
+		if err := sqliteClaimSKU(ctx, tx, &updated); err != nil {
+			return err
+		}
+		stampProduct(&updated, existing.CreatedAt, sqliteNow())
+		_, err = tx.ExecContext(ctx, sqliteUpsert, sqliteArgs(&updated)...)
+		return err
+	})
+	switch {
+	case err == nil:
+		return updated, nil
+	case fnErr != nil:
+		return Product{}, fnErr
+	default:
+		return Product{}, err
+	}
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, id int) error {
+	return s.write(ctx, "delete product", func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM products WHERE product_id = $1`, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+// DeleteIf removes the product if cond reports true, within one write
+// transaction
+func (s *SQLiteStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	removed := false
+	err := s.write(ctx, "delete product", func(tx *sql.Tx) error {
+		existing, err := scanSQLiteProduct(tx.QueryRowContext(ctx,
+			`SELECT `+productColumns+` FROM products WHERE product_id = $1`, id))
+		if err != nil || !cond(existing) {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM products WHERE product_id = $1`, id); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	return removed, err
+}
+
+func (s *SQLiteStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	where, args := listFilterWhere(filter, sqliteTime)
+	rows, err := s.reader.QueryContext(ctx, `SELECT `+productColumns+` FROM products`+where+` ORDER BY product_id`, args...)
+	if err != nil {
+		return nil, sqliteError("list products", err)
+	}
+	defer rows.Close()
+	items := []Product{}
+	for rows.Next() {
+		p, err := scanSQLiteProduct(rows)
+		if err != nil {
+			return nil, sqliteError("list products", err)
+		}
+		items = append(items, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError("list products", err)
+	}
+	return items, nil
+}
+
+// ListWindow is List with LIMIT and OFFSET applied by the database, which
+// counts the matching products in the same query
+func (s *SQLiteStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	where, args := listFilterWhere(filter, sqliteTime)
+	n := len(args)
+	args = append(args, limit, offset)
+	// One read transaction, so the count of a window past the end is of
+	// the same snapshot
+	tx, err := s.reader.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, sqliteError("list products", err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT `+productColumns+`, count(*) OVER () FROM products`+where+
+		` ORDER BY product_id LIMIT $`+strconv.Itoa(n+1)+` OFFSET $`+strconv.Itoa(n+2), args...)
+	if err != nil {
+		return nil, 0, sqliteError("list products", err)
+	}
+	defer rows.Close()
+	total := 0
+	items := []Product{}
+	for rows.Next() {
+		p, err := scanSQLiteProduct(rows, &total)
+		if err != nil {
+			return nil, 0, sqliteError("list products", err)
+		}
+		items = append(items, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, sqliteError("list products", err)
+	}
+	if len(items) == 0 && offset > 0 {
+		// A window past the end has no row to carry the count
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM products`+where, args[:n]...).Scan(&total); err != nil {
+			return nil, 0, sqliteError("count products", err)
+		}
+	}
+	return items, total, nil
+}
+
+// write runs fn in a transaction on the writer connection, holding the
+// write lock throughout, and commits it unless fn fails
+func (s *SQLiteStore) write(ctx context.Context, op string, fn func(tx *sql.Tx) error) error {
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(op, err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return sqliteError(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return sqliteError(op, err)
+	}
+	return nil
+}
+
+// sqlitePut writes p within tx as Put does, stamped with now, and reports
+// whether its ID was new
+func sqlitePut(ctx context.Context, tx *sql.Tx, p *Product, now time.Time) (bool, error) {
+	if err := sqliteClaimSKU(ctx, tx, p); err != nil {
+		return false, err
+	}
+	var createdAt int64
+	err := tx.QueryRowContext(ctx, `SELECT created_at FROM products WHERE product_id = $1`, p.ProductID).Scan(&createdAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	created := errors.Is(err, sql.ErrNoRows)
+	if created {
+		stampProduct(p, time.Time{}, now)
+	} else {
+		stampProduct(p, time.Unix(0, createdAt).UTC(), now)
+	}
+	if _, err := tx.ExecContext(ctx, sqliteUpsert, sqliteArgs(p)...); err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// sqliteClaimSKU returns *DuplicateSKUError if p's SKU belongs to another
+// product. Writes are serialized, so nothing can claim it between this
+// check and p's write.
+func sqliteClaimSKU(ctx context.Context, tx *sql.Tx, p *Product) error {
+	var owner int
+	err := tx.QueryRowContext(ctx, `SELECT product_id FROM products WHERE sku = $1 AND product_id <> $2`,
+		p.SKU, p.ProductID).Scan(&owner)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	return &DuplicateSKUError{SKU: p.SKU, ProductID: owner}
+}
+
+// sqliteNow is the time writes are stamped with
+func sqliteNow() time.Time {
+	return time.Now().UTC()
+}
+
+// sqliteTime is t as the store keeps it
+func sqliteTime(t time.Time) any {
+	return t.UnixNano()
+}
+
+// sqliteArgs returns p's columns in productColumns order
+func sqliteArgs(p *Product) []any {
+	var price, deletedAt, expiresAt sql.NullInt64
+	if p.Price != nil {
+		price = sql.NullInt64{Int64: int64(*p.Price), Valid: true}
+	}
+	if p.DeletedAt != nil {
+		deletedAt = sql.NullInt64{Int64: p.DeletedAt.UnixNano(), Valid: true}
+	}
+	if p.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: p.ExpiresAt.UnixNano(), Valid: true}
+	}
+	return []any{p.ProductID, p.SKU, p.Manufacturer, p.CategoryID, p.Weight, p.SomeOtherID,
+		p.Name, p.Description, price, p.Currency, p.Quantity,
+		p.CreatedAt.UnixNano(), p.UpdatedAt.UnixNano(), deletedAt, expiresAt}
+}
+
+// scanSQLiteProduct reads a row of productColumns, followed by extra if
+// the query selected more, mapping no row to ErrNotFound
+func scanSQLiteProduct(row interface{ Scan(dest ...any) error }, extra ...any) (Product, error) {
+	var p Product
+	var price, deletedAt, expiresAt sql.NullInt64
+	var createdAt, updatedAt int64
+	dest := append([]any{&p.ProductID, &p.SKU, &p.Manufacturer, &p.CategoryID, &p.Weight, &p.SomeOtherID,
+		&p.Name, &p.Description, &price, &p.Currency, &p.Quantity,
+		&createdAt, &updatedAt, &deletedAt, &expiresAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Product{}, ErrNotFound
+		}
+		return Product{}, err
+	}
+	p.CreatedAt, p.UpdatedAt = time.Unix(0, createdAt).UTC(), time.Unix(0, updatedAt).UTC()
+	if price.Valid {
+		v := int(price.Int64)
+		p.Price = &v
+	}
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64).UTC()
+		p.DeletedAt = &t
+	}
+	if expiresAt.Valid {
+		t := time.Unix(0, expiresAt.Int64).UTC()
+		p.ExpiresAt = &t
+	}
+	return p, nil
+}
+
+// sqliteError tags err with ErrStoreUnavailable when the database was
+// locked past the busy timeout or the file couldn't be read or written,
+// which may pass. The store's own errors and context errors are returned
+// as-is.
+func sqliteError(op string, err error) error {
+	var sqlErr *sqlite.Error
+	var dup *DuplicateSKUError
+	var exists *ProductExistsError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.As(err, &dup), errors.As(err, &exists), contextError(err):
+		return err
+	case errors.As(err, &sqlErr):
+		switch sqlErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_IOERR, sqlite3.SQLITE_FULL, sqlite3.SQLITE_CANTOPEN:
+			return fmt.Errorf("%w: sqlite %s: %v", ErrStoreUnavailable, op, err)
+		}
+	}
+	return fmt.Errorf("sqlite %s: %w", op, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// openTestSQLite opens a store over a new database file in a temporary
+// directory, closed when the test ends
+func openTestSQLite(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	runStoreConformance(t, storeFactory{
+		open: func(t *testing.T) ProductStore {
+			return openTestSQLite(t, filepath.Join(t.TempDir(), "products.db"))
+		},
+	})
+}
+
+func TestSQLiteStoreRejectsSharedMemory(t *testing.T) {
+	for _, path := range []string{"", ":memory:", "file:products.db"} {
+		if s, err := NewSQLiteStore(context.Background(), path); err == nil {
+			s.Close()
+			t.Errorf("path %q: opened, want an error", path)
+		}
+	}
+}
+
+func TestSQLiteStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "products.db")
+	s, err := NewSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := mustPut(t, s, testProduct(1))[0]
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSQLite(t, path)
+	got, err := s.Get(ctx, 1)
+	if err != nil || !sameProduct(got, stored) {
+		t.Errorf("after reopening: got %+v, %v; want %+v to the nanosecond", got, err, stored)
+	}
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded int
+	if err := s.reader.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations`).Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != len(migrations) {
+		t.Errorf("schema_migrations holds %d rows after reopening, want %d", recorded, len(migrations))
+	}
+}
+
+func TestSQLiteStoreListWindow(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "products.db"))
+	for id := 1; id <= 9; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+	filter := ListFilter{CategoryID: 1}
+	all, err := s.List(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ limit, offset int }{{2, 0}, {2, 1}, {10, 0}, {5, 10}} {
+		items, total, err := s.ListWindow(ctx, filter, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := all[min(tt.offset, len(all)):min(tt.offset+tt.limit, len(all))]
+		if !slices.Equal(productIDs(items), productIDs(want)) || total != len(all) {
+			t.Errorf("limit %d offset %d: got %v of %d, want %v of %d",
+				tt.limit, tt.offset, productIDs(items), total, productIDs(want), len(all))
+		}
+	}
+}
+
+// TestSQLiteStoreReadsDuringWrites checks that reads go on while the writer
+// holds its lock, without SQLITE_BUSY; run with -race
+func TestSQLiteStoreReadsDuringWrites(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "products.db"))
+	mustPut(t, s, testProduct(1))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range 25 {
+				p := testProduct(1)
+				p.Weight = w*100 + i + 1
+				if _, err := s.Put(ctx, &p); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := s.Get(ctx, 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
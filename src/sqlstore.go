@@ -0,0 +1,108 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// productColumns are the products columns of PostgresStore and
+// SQLiteStore, in the order each store's scan reads them and its args
+// function writes them
+const productColumns = `product_id, sku, manufacturer, category_id, weight, some_other_id,
+	name, description, price, currency, quantity, created_at, updated_at, deleted_at, expires_at`
+
+// migrationFiles holds the schema each SQL store expects, under
+// migrations/{backend}, one file per step named NNNN_description.sql and
+// applied in version order. A migration that has been released is never
+// edited; a change is a new file.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migration is one embedded migration
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations returns the embedded migrations of backend by version
+func loadMigrations(backend string) ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/"+backend+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, 0, len(paths))
+	for _, p := range paths {
+		name := strings.TrimSuffix(path.Base(p), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", p)
+		}
+		body, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", migrations[i-1].name, migrations[i].name)
+		}
+	}
+	return migrations, nil
+}
+
+// listFilterWhere turns filter into a WHERE clause with numbered $n
+// parameters, which both SQL stores take, so the database filters through
+// its indexes instead of the store reading every row. Its conditions are
+// those of ListFilter.matches; timeArg converts UpdatedSince to the form
+// the store keeps times in.
+func listFilterWhere(filter ListFilter, timeArg func(t time.Time) any) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if !filter.IncludeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if filter.CategoryID != 0 {
+		add("category_id = ?", filter.CategoryID)
+	}
+	switch {
+	case filter.Manufacturer == "":
+	case filter.ManufacturerFold:
+		add("lower(manufacturer) = lower(?)", filter.Manufacturer)
+	default:
+		add("manufacturer = ?", filter.Manufacturer)
+	}
+	if !filter.UpdatedSince.IsZero() {
+		add("updated_at > ?", timeArg(filter.UpdatedSince))
+	}
+	if filter.MinWeight != nil {
+		add("weight >= ?", *filter.MinWeight)
+	}
+	if filter.MaxWeight != nil {
+		add("weight <= ?", *filter.MaxWeight)
+	}
+	if filter.IDFrom != nil {
+		add("product_id >= ?", *filter.IDFrom)
+	}
+	if filter.IDTo != nil {
+		add("product_id <= ?", *filter.IDTo)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	"unsafe"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StoreStats summarises the catalogue for GET /stats
+type StoreStats struct {
+	Products              int
+	ProductsPerCategory   map[int]int
+	DistinctManufacturers int
+	TotalWeight           int64
+	// MemoryBytes is an estimate, 0 if the store can't tell
+	MemoryBytes int64
+	// Expired and Evicted count products removed by TTL and by
+	// MAX_PRODUCTS since startup
+	Expired int64
+	Evicted int64
+}
+
+// statsProvider is implemented by stores that keep running totals, so
+// /stats doesn't have to walk every product
+type statsProvider interface {
+	Stats() StoreStats
+}
+
+// StatsResponse is the body returned by GET /stats
+type StatsResponse struct {
+	Store                 string      `json:"store"`
+	Products              int         `json:"products"`
+	ProductsPerCategory   map[int]int `json:"products_per_category"`
+	DistinctManufacturers int         `json:"distinct_manufacturers"`
+	TotalWeight           int64       `json:"total_weight"`
+	AverageWeight         float64     `json:"average_weight"`
+	MemoryBytesEstimate   int64       `json:"memory_bytes_estimate,omitempty"`
+	ExpiredProducts       int64       `json:"expired_products"`
+	EvictedProducts       int64       `json:"evicted_products"`
+	UptimeSeconds         float64     `json:"uptime_seconds"`
+	// Failed or dropped since startup
+	WebhookDeliveriesFailed int64 `json:"webhook_deliveries_failed"`
+	// Only with SNS, SQS or a dry run configured
+	EventPublishFailed *int64 `json:"event_publish_failed,omitempty"`
+}
+
+// stats handles GET /stats
+// The in-memory store answers from counters maintained on every write;
+// other backends are listed in full, so keep it off hot paths there.
+// Returns 200, 401 without an admin key when ADMIN_API_KEYS is set
+func (a *API) stats(c *gin.Context) {
+	var st StoreStats
+	if sp, ok := a.baseStore(c.Request.Context()).(statsProvider); ok {
+		st = sp.Stats()
+	} else {
+		items, err := a.wrappedStore().List(c.Request.Context(), ListFilter{})
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		st = computeStats(items)
+	}
+
+	resp := StatsResponse{
+		Store:                 a.cfg.StoreBackend,
+		Products:              st.Products,
+		ProductsPerCategory:   st.ProductsPerCategory,
+		DistinctManufacturers: st.DistinctManufacturers,
+		TotalWeight:           st.TotalWeight,
+		MemoryBytesEstimate:   st.MemoryBytes,
+		ExpiredProducts:       st.Expired,
+		EvictedProducts:       st.Evicted,
+		UptimeSeconds:         time.Since(a.startedAt).Seconds(),
+
+		WebhookDeliveriesFailed: a.webhooks.failed.Load(),
+	}
+	if a.events != nil {
+		failed := a.events.failed.Load()
+		resp.EventPublishFailed = &failed
+	}
+	if st.Products > 0 {
+		resp.AverageWeight = float64(st.TotalWeight) / float64(st.Products)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// computeStats derives StoreStats from a full listing
+func computeStats(items []Product) StoreStats {
+	st := StoreStats{Products: len(items), ProductsPerCategory: make(map[int]int)}
+	manufacturers := make(map[string]bool)
+	for _, p := range items {
+		st.ProductsPerCategory[p.CategoryID]++
+		manufacturers[p.Manufacturer] = true
+		st.TotalWeight += int64(p.Weight)
+	}
+	st.DistinctManufacturers = len(manufacturers)
+	return st
+}
+
+// productIndexOverhead approximates what one product costs beyond its own
+// fields: its products map slot, its SKU index entry and its category and
+// manufacturer slots
+const productIndexOverhead = 104
+
+// productMemoryEstimate is the approximate heap footprint of storing p
+func productMemoryEstimate(p Product) int64 {
+	n := int64(unsafe.Sizeof(p)) + 2*int64(len(p.SKU)) + int64(len(p.Manufacturer)) + int64(len(p.Name)) +
+		int64(len(p.Description)) + int64(len(p.Currency)) + productIndexOverhead
+	if p.Price != nil {
+		n += int64(unsafe.Sizeof(*p.Price))
+	}
+	if p.DeletedAt != nil {
+		n += int64(unsafe.Sizeof(*p.DeletedAt))
+	}
+	if p.ExpiresAt != nil {
+		n += int64(unsafe.Sizeof(*p.ExpiresAt))
+	}
+	return n
+}
+
+// Stats reads the running totals each shard keeps, one shard at a time,
+// after sweeping out expired products so they aren't counted. The cost
+// grows with the number of categories and manufacturers, not products.
+func (s *InMemoryStore) Stats() StoreStats {
+	s.SweepExpired(time.Now())
+	st := StoreStats{ProductsPerCategory: make(map[int]int)}
+	manufacturers := make(map[string]struct{})
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		st.Products += len(sh.products) - sh.deleted
+		for categoryID, ids := range sh.categoryIndex {
+			st.ProductsPerCategory[categoryID] += len(ids)
+		}
+		for m := range sh.manufacturerIndex {
+			manufacturers[m] = struct{}{}
+		}
+		st.TotalWeight += sh.weight
+		st.MemoryBytes += sh.bytes
+		sh.mu.RUnlock()
+	}
+	st.DistinctManufacturers = len(manufacturers)
+	st.Expired = s.expiredCount.Load()
+	st.Evicted = s.evictedCount.Load()
+	return st
+}
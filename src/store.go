@@ -0,0 +1,786 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound is returned by a ProductStore when the requested product does not exist
+var ErrNotFound = errors.New("product not found")
+
+// ErrStoreUnavailable wraps errors caused by the backing store being
+// unreachable; handlers report them as 503 rather than 500
+var ErrStoreUnavailable = errors.New("store unavailable")
+
+// ErrStoreFull is returned when a write would add a product beyond
+// MAX_PRODUCTS and eviction is off
+var ErrStoreFull = errors.New("store is full")
+
+// ErrBatchAborted marks items of an atomic batch that were not applied
+// because another item in the same batch was rejected
+var ErrBatchAborted = errors.New("batch aborted")
+
+// AtomicBatchTooLargeError is returned for every item of an atomic batch
+// with more products than the store can write in one transaction; nothing
+// is written
+type AtomicBatchTooLargeError struct {
+	Limit int
+}
+
+func (e *AtomicBatchTooLargeError) Error() string {
+	return "an atomic batch may hold at most " + strconv.Itoa(e.Limit) + " products"
+}
+
+// DuplicateSKUError is returned when a write would give a product a SKU
+// that already belongs to a different product
+type DuplicateSKUError struct {
+	SKU       string
+	ProductID int
+}
+
+func (e *DuplicateSKUError) Error() string {
+	return "sku " + e.SKU + " already belongs to product " + strconv.Itoa(e.ProductID)
+}
+
+// ProductExistsError is returned by ProductStore.Create when the ID is
+// already taken; Existing is the stored product
+type ProductExistsError struct {
+	Existing Product
+}
+
+func (e *ProductExistsError) Error() string {
+	return "product " + strconv.Itoa(e.Existing.ProductID) + " already exists"
+}
+
+// contextError reports whether a store call failed because its context was
+// canceled or ran out of time, rather than because of the store
+func contextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ListFilter narrows the products returned by ProductStore.List.
+// Zero values mean "no filter".
+type ListFilter struct {
+	CategoryID   int
+	Manufacturer string
+	// ManufacturerFold matches Manufacturer ignoring letter case
+	ManufacturerFold bool
+	// UpdatedSince keeps only products whose UpdatedAt is after it
+	UpdatedSince time.Time
+	// Inclusive bounds on weight and product_id; nil leaves a side open
+	MinWeight, MaxWeight *int
+	IDFrom, IDTo         *int
+	// IncludeDeleted also lists soft-deleted products
+	IncludeDeleted bool
+}
+
+// matches reports whether p passes every filter. Stores apply it to
+// whatever an index returned, so the index only has to narrow the search.
+func (f ListFilter) matches(p Product) bool {
+	return (f.IncludeDeleted || p.DeletedAt == nil) && !p.expired(time.Now()) &&
+		(f.CategoryID == 0 || p.CategoryID == f.CategoryID) &&
+		f.matchesManufacturer(p.Manufacturer) &&
+		(f.UpdatedSince.IsZero() || p.UpdatedAt.After(f.UpdatedSince)) &&
+		inRange(p.Weight, f.MinWeight, f.MaxWeight) &&
+		inRange(p.ProductID, f.IDFrom, f.IDTo)
+}
+
+// inRange reports whether lo <= n <= hi, ignoring a nil bound
+func inRange(n int, lo, hi *int) bool {
+	return (lo == nil || n >= *lo) && (hi == nil || n <= *hi)
+}
+
+// matchesManufacturer reports whether name passes the Manufacturer filter
+func (f ListFilter) matchesManufacturer(name string) bool {
+	switch {
+	case f.Manufacturer == "":
+		return true
+	case f.ManufacturerFold:
+		return strings.EqualFold(name, f.Manufacturer)
+	default:
+		return name == f.Manufacturer
+	}
+}
+
+// stampProduct sets the server-owned timestamps on p before it is written.
+// created is the CreatedAt of the product being replaced, zero if p is new.
+func stampProduct(p *Product, created, now time.Time) {
+	if created.IsZero() {
+		created = now
+	}
+	p.CreatedAt = created
+	p.UpdatedAt = now
+}
+
+// ProductStore is the persistence boundary used by the HTTP handlers.
+// Implementations must be safe for concurrent use.
+type ProductStore interface {
+	// Get returns the product with the given ID or ErrNotFound
+	Get(ctx context.Context, id int) (Product, error)
+	// GetBySKU returns the product owning sku or ErrNotFound
+	GetBySKU(ctx context.Context, sku string) (Product, error)
+	// GetMany looks up several IDs at once; missing IDs are absent from the result
+	GetMany(ctx context.Context, ids []int) (map[int]Product, error)
+	// Put creates or replaces a product, returning *DuplicateSKUError if its
+	// SKU belongs to another product, and reports whether the ID was new.
+	// Every write sets UpdatedAt and keeps the existing CreatedAt, whatever
+	// the caller put in those fields; both are written back into p.
+	Put(ctx context.Context, p *Product) (created bool, err error)
+	// Create is Put that never overwrites: if the ID exists nothing is
+	// written and *ProductExistsError is returned. The check and the insert
+	// are atomic.
+	Create(ctx context.Context, p *Product) error
+	// PutBatch writes items in order and returns one error per item (nil on
+	// success). With atomic set, either every item is stored or none are and
+	// the items that were not at fault report ErrBatchAborted; a store
+	// with a cap on transaction size reports *AtomicBatchTooLargeError
+	// for every item of a batch over it.
+	PutBatch(ctx context.Context, items []Product, atomic bool) []error
+	// Update applies fn to a copy of the stored product and stores the result
+	// atomically. An error from fn aborts the update and is returned as-is.
+	Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error)
+	// Delete removes a product or returns ErrNotFound
+	Delete(ctx context.Context, id int) error
+	// List returns the products matching filter ordered by product_id
+	List(ctx context.Context, filter ListFilter) ([]Product, error)
+}
+
+// storeShards is how many independently locked partitions an InMemoryStore
+// is split into
+const storeShards = 64
+
+// storeShard holds the products whose ID maps to it, plus category and
+// manufacturer indexes and running totals covering just those products. All
+// are guarded by mu. Soft-deleted products stay in products, ids and the SKU
+// index, but not in the category and manufacturer indexes or the weight.
+type storeShard struct {
+	mu                sync.RWMutex
+	products          map[int]Product
+	ids               []int // keys of products, ascending
+	categoryIndex     map[int][]int
+	manufacturerIndex map[string][]int
+	weight            int64
+	deleted           int   // soft-deleted products in products
+	bytes             int64 // estimated memory held by this shard's products
+	history           map[int]*productHistory
+	expiry            expiryHeap // products with an ExpiresAt, soonest first
+
+	// CLOCK ring of product IDs for EVICTION=lru; see SetCapacity
+	clockIDs  []int
+	clockRefs []uint32 // 1 if read since the hand last passed; set atomically under mu.RLock
+	clockPos  map[int]int
+	clockHand int
+}
+
+// skuShard maps SKUs hashing to it to the single product that owns each
+type skuShard struct {
+	mu    sync.RWMutex
+	owner map[string]int
+}
+
+// InMemoryStore is the default ProductStore.
+// Products are spread over storeShards shards by product_id, each with its
+// own map and sync.RWMutex, so writes to different products rarely contend.
+// Each shard keeps category and manufacturer indexes for its own products so
+// filtered listings don't scan every map. SKU uniqueness spans shards, so SKUs are
+// partitioned separately by hash. With foldSKU set, SKUs are keyed in lower
+// case so "ab-1" and "AB-1" collide.
+//
+// Locks are always taken product shards first, then SKU shards, each in
+// ascending index order. A write holds its product shard and the SKU shards
+// of the old and new SKU while it appends to the WAL, so writes that could
+// conflict on replay are logged in the order they took effect.
+type InMemoryStore struct {
+	shards  [storeShards]storeShard
+	skus    [storeShards]skuShard
+	skuSeed maphash.Seed
+	foldSKU bool
+	wal     *WAL
+
+	// Versions kept per product, 0 for none; see EnableHistory
+	historySize        int
+	keepDeletedHistory bool
+
+	// Products removed by SweepExpired since startup
+	expiredCount atomic.Int64
+
+	// Cap on stored products, 0 for none, and whether reaching it evicts
+	// rather than refuses; see SetCapacity
+	maxProducts  int64
+	evictLRU     bool
+	size         atomic.Int64
+	evictedCount atomic.Int64
+	// The CLOCK hand sweeps one shard's ring after another; evictMu
+	// serialises evictions and guards clockShard, the shard it is in
+	evictMu    sync.Mutex
+	clockShard int
+}
+
+// NewInMemoryStore returns an empty InMemoryStore. caseInsensitiveSKUs makes
+// SKU uniqueness and GetBySKU ignore letter case.
+func NewInMemoryStore(caseInsensitiveSKUs bool) *InMemoryStore {
+	s := &InMemoryStore{skuSeed: maphash.MakeSeed(), foldSKU: caseInsensitiveSKUs}
+	s.clearLocked()
+	return s
+}
+
+func (s *InMemoryStore) Get(_ context.Context, id int) (Product, error) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	p, exists := sh.products[id]
+	if exists && s.evictLRU {
+		sh.touch(id)
+	}
+	sh.mu.RUnlock()
+
+	if !exists || p.expired(time.Now()) {
+		return Product{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *InMemoryStore) GetBySKU(_ context.Context, sku string) (Product, error) {
+	key := s.skuKey(sku)
+	sk := s.skuShardFor(key)
+	sk.mu.RLock()
+	id, exists := sk.owner[key]
+	sk.mu.RUnlock()
+	if !exists {
+		return Product{}, ErrNotFound
+	}
+
+	// The SKU shard is released first to keep the lock order; if the SKU
+	// moved in between, answer as if this read came before it arrived
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	p, exists := sh.products[id]
+	if exists && s.evictLRU {
+		sh.touch(id)
+	}
+	sh.mu.RUnlock()
+	if !exists || s.skuKey(p.SKU) != key || p.expired(time.Now()) {
+		return Product{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *InMemoryStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Hold every shard read at once, taken in index order as lockAll
+	// takes them, so an atomic batch is seen whole or not at all
+	var held [storeShards]bool
+	for _, id := range ids {
+		held[uint(id)%storeShards] = true
+	}
+	for i := range s.shards {
+		if held[i] {
+			s.shards[i].mu.RLock()
+		}
+	}
+	found := make(map[int]Product, len(ids))
+	now := time.Now()
+	for _, id := range ids {
+		sh := s.shardFor(id)
+		p, exists := sh.products[id]
+		if exists && s.evictLRU {
+			sh.touch(id)
+		}
+		if exists && !p.expired(now) {
+			found[id] = p
+		}
+	}
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		if held[i] {
+			s.shards[i].mu.RUnlock()
+		}
+	}
+	return found, nil
+}
+
+func (s *InMemoryStore) Put(_ context.Context, p *Product) (bool, error) {
+	// Deferred first so it runs once every lock below is released
+	defer s.evictOverflow()
+	// The existence check and the write share one critical section
+	sh := s.shardFor(p.ProductID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now().UTC()
+	old, exists := sh.products[p.ProductID]
+	defer s.lockSKUs(p.SKU, old.SKU, exists)()
+	live := exists && !old.expired(now)
+	created := time.Time{}
+	if live {
+		created = old.CreatedAt
+	}
+	stampProduct(p, created, now)
+	if err := s.putLocked(*p); err != nil {
+		return false, err
+	}
+	s.recordLocked(*p)
+	return !live, nil
+}
+
+func (s *InMemoryStore) Create(_ context.Context, p *Product) error {
+	defer s.evictOverflow()
+	sh := s.shardFor(p.ProductID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now().UTC()
+	existing, exists := sh.products[p.ProductID]
+	if exists && !existing.expired(now) {
+		return &ProductExistsError{Existing: existing}
+	}
+	// An expired product not yet swept is replaced
+	defer s.lockSKUs(p.SKU, existing.SKU, exists)()
+	stampProduct(p, time.Time{}, now)
+	if err := s.putLocked(*p); err != nil {
+		return err
+	}
+	s.recordLocked(*p)
+	return nil
+}
+
+func (s *InMemoryStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := make([]error, len(items))
+	// Nothing is written once the caller is gone, rather than after waiting
+	// for every shard lock
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// Items can land on any shard; take them all at once so an atomic batch
+	// is never observed half applied
+	defer s.evictOverflow()
+	s.lockAll()
+	defer s.unlockAll()
+
+	now := time.Now().UTC()
+	if !atomic {
+		for i, p := range items {
+			stampProduct(&p, s.liveCreatedAt(p.ProductID, now), now)
+			if errs[i] = s.putLocked(p); errs[i] == nil {
+				s.recordLocked(p)
+			}
+		}
+		return errs
+	}
+
+	type priorState struct {
+		product Product
+		existed bool
+	}
+	undo := make([]priorState, 0, len(items))
+	stored := make([]Product, 0, len(items))
+
+	for i, p := range items {
+		old, existed := s.shardFor(p.ProductID).products[p.ProductID]
+		stampProduct(&p, s.liveCreatedAt(p.ProductID, now), now)
+		if err := s.putLocked(p); err != nil {
+			// Roll back in reverse order so readers never observe a partial batch
+			for j := len(undo) - 1; j >= 0; j-- {
+				if undo[j].existed {
+					s.putLocked(undo[j].product)
+				} else {
+					s.deleteLocked(items[j].ProductID)
+				}
+			}
+			for j := range errs {
+				errs[j] = ErrBatchAborted
+			}
+			errs[i] = err
+			return errs
+		}
+		undo = append(undo, priorState{product: old, existed: existed})
+		stored = append(stored, p)
+	}
+	// Only now is nothing going to be rolled back
+	for _, p := range stored {
+		s.recordLocked(p)
+	}
+	return errs
+}
+
+func (s *InMemoryStore) Update(_ context.Context, id int, fn func(p *Product) error) (Product, error) {
+	// Read, merge and store in a single critical section on the product's shard
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	existing, exists := sh.products[id]
+	if !exists || existing.expired(time.Now()) {
+		return Product{}, ErrNotFound
+	}
+
+	updated := existing.clone()
+	if err := fn(&updated); err != nil {
+		return Product{}, err
+	}
+	defer s.lockSKUs(updated.SKU, existing.SKU, true)()
+	stampProduct(&updated, existing.CreatedAt, time.Now().UTC())
+	if err := s.putLocked(updated); err != nil {
+		return Product{}, err
+	}
+	s.recordLocked(updated)
+	return updated, nil
+}
+
+func (s *InMemoryStore) Delete(_ context.Context, id int) error {
+	// Write lock so concurrent readers never see a torn state
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	p, exists := sh.products[id]
+	if !exists || p.expired(time.Now()) {
+		return ErrNotFound
+	}
+	defer s.lockSKUs(p.SKU, "", false)()
+	return s.deleteLocked(id)
+}
+
+// DeleteIf removes the product if cond reports true, checking it under the
+// same shard lock as the removal
+func (s *InMemoryStore) DeleteIf(_ context.Context, id int, cond func(p Product) bool) (bool, error) {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	p, exists := sh.products[id]
+	if !exists || p.expired(time.Now()) {
+		return false, ErrNotFound
+	}
+	if !cond(p) {
+		return false, nil
+	}
+	defer s.lockSKUs(p.SKU, "", false)()
+	return true, s.deleteLocked(id)
+}
+
+// Clear removes every product and returns how many there were. All shards
+// are held for the duration, so no reader sees a partly emptied store.
+func (s *InMemoryStore) Clear() (int, error) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	n := 0
+	for i := range s.shards {
+		n += len(s.shards[i].products)
+	}
+	if s.wal != nil {
+		if err := s.wal.appendClear(); err != nil {
+			return 0, err
+		}
+	}
+	s.clearLocked()
+	return n, nil
+}
+
+// Count returns the number of stored products, expired ones swept first
+func (s *InMemoryStore) Count() int {
+	s.SweepExpired(time.Now())
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.products)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+func (s *InMemoryStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	// Copy one shard at a time under its read lock, then sort outside the
+	// locks so writers aren't blocked. Each shard is consistent on its own;
+	// a write to another shard may land between two shards being copied.
+	// A canceled ctx stops the copy at the next shard.
+	var snapshot []Product
+	for i := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		// The indexes only hold live products, so listing deleted ones scans
+		switch {
+		case filter.CategoryID > 0 && !filter.IncludeDeleted:
+			for _, id := range sh.categoryIndex[filter.CategoryID] {
+				if p := sh.products[id]; filter.matches(p) {
+					snapshot = append(snapshot, p)
+				}
+			}
+		case filter.Manufacturer != "" && !filter.IncludeDeleted:
+			// Folded lookups walk the shard's distinct names, not its products
+			for name, ids := range sh.manufacturerIndex {
+				if !filter.matchesManufacturer(name) {
+					continue
+				}
+				for _, id := range ids {
+					if p := sh.products[id]; filter.matches(p) {
+						snapshot = append(snapshot, p)
+					}
+				}
+			}
+		default:
+			for _, p := range sh.products {
+				if filter.matches(p) {
+					snapshot = append(snapshot, p)
+				}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	if snapshot == nil {
+		snapshot = []Product{}
+	}
+
+	// Map iteration order is random; sort for a stable view across pages
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].ProductID < snapshot[j].ProductID
+	})
+	return snapshot, nil
+}
+
+// shardFor returns the shard holding product id
+func (s *InMemoryStore) shardFor(id int) *storeShard {
+	return &s.shards[uint(id)%storeShards]
+}
+
+// skuShardFor returns the shard holding skuKey key
+func (s *InMemoryStore) skuShardFor(key string) *skuShard {
+	return &s.skus[s.skuShardIndex(key)]
+}
+
+func (s *InMemoryStore) skuShardIndex(key string) uint64 {
+	return maphash.String(s.skuSeed, key) % storeShards
+}
+
+// lockSKUs write-locks the SKU shards of sku and, if hasOld, oldSKU, in
+// ascending order, and returns the function that unlocks them. Callers must
+// already hold the product's shard.
+func (s *InMemoryStore) lockSKUs(sku, oldSKU string, hasOld bool) func() {
+	a := s.skuShardIndex(s.skuKey(sku))
+	b := a
+	if hasOld {
+		b = s.skuShardIndex(s.skuKey(oldSKU))
+	}
+	if a > b {
+		a, b = b, a
+	}
+	s.skus[a].mu.Lock()
+	if b != a {
+		s.skus[b].mu.Lock()
+	}
+	return func() {
+		if b != a {
+			s.skus[b].mu.Unlock()
+		}
+		s.skus[a].mu.Unlock()
+	}
+}
+
+// lockAll write-locks every shard, for operations spanning many products
+func (s *InMemoryStore) lockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	for i := range s.skus {
+		s.skus[i].mu.Lock()
+	}
+}
+
+func (s *InMemoryStore) unlockAll() {
+	for i := len(s.skus) - 1; i >= 0; i-- {
+		s.skus[i].mu.Unlock()
+	}
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+// rlockAll read-locks every product shard, freezing the whole store: every
+// write holds a product shard for writing
+func (s *InMemoryStore) rlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.RLock()
+	}
+}
+
+func (s *InMemoryStore) runlockAll() {
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mu.RUnlock()
+	}
+}
+
+// putLocked stores p and keeps the secondary indexes in sync, moving the
+// product between category buckets if its category changed. SKUs are
+// unique: if p.SKU already belongs to a different product nothing is
+// written and a *DuplicateSKUError is returned.
+// Callers must hold p's shard and the SKU shards of its old and new SKU
+// for writing.
+func (s *InMemoryStore) putLocked(p Product) error {
+	key := s.skuKey(p.SKU)
+	sk := s.skuShardFor(key)
+	if ownerID, taken := sk.owner[key]; taken && ownerID != p.ProductID {
+		return &DuplicateSKUError{SKU: p.SKU, ProductID: ownerID}
+	}
+	sh := s.shardFor(p.ProductID)
+	old, exists := sh.products[p.ProductID]
+	if !exists && !s.reserve() {
+		return ErrStoreFull
+	}
+	if s.wal != nil {
+		if err := s.wal.appendPut(p); err != nil {
+			if !exists {
+				s.size.Add(-1)
+			}
+			return err
+		}
+	}
+
+	sh.products[p.ProductID] = p
+	if exists {
+		sh.account(old, -1)
+	} else {
+		i, _ := slices.BinarySearch(sh.ids, p.ProductID)
+		sh.ids = slices.Insert(sh.ids, i, p.ProductID)
+		if s.evictLRU {
+			sh.clockAdd(p.ProductID)
+		}
+	}
+	sh.account(p, 1)
+	if p.ExpiresAt != nil && (!exists || old.ExpiresAt == nil || !old.ExpiresAt.Equal(*p.ExpiresAt)) {
+		heap.Push(&sh.expiry, expiryEntry{at: *p.ExpiresAt, id: p.ProductID})
+	}
+	if oldKey := s.skuKey(old.SKU); exists && oldKey != key {
+		delete(s.skuShardFor(oldKey).owner, oldKey)
+	}
+	sk.owner[key] = p.ProductID
+
+	// Soft-deleted products are left out of the category and manufacturer
+	// indexes, so a delete or restore moves the product like a change would
+	wasIndexed, indexed := exists && old.DeletedAt == nil, p.DeletedAt == nil
+	moved := wasIndexed != indexed
+	if moved || old.CategoryID != p.CategoryID {
+		if wasIndexed {
+			removeFromIndex(sh.categoryIndex, old.CategoryID, old.ProductID)
+		}
+		if indexed {
+			sh.categoryIndex[p.CategoryID] = append(sh.categoryIndex[p.CategoryID], p.ProductID)
+		}
+	}
+	if moved || old.Manufacturer != p.Manufacturer {
+		if wasIndexed {
+			removeFromIndex(sh.manufacturerIndex, old.Manufacturer, old.ProductID)
+		}
+		if indexed {
+			sh.manufacturerIndex[p.Manufacturer] = append(sh.manufacturerIndex[p.Manufacturer], p.ProductID)
+		}
+	}
+	return nil
+}
+
+// deleteLocked removes a product and its index entries, returning
+// ErrNotFound if it did not exist. Callers must hold the product's shard and
+// its SKU's shard for writing.
+func (s *InMemoryStore) deleteLocked(id int) error {
+	sh := s.shardFor(id)
+	p, exists := sh.products[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if s.wal != nil {
+		if err := s.wal.appendDelete(id); err != nil {
+			return err
+		}
+	}
+	key := s.skuKey(p.SKU)
+	delete(sh.products, id)
+	if i, found := slices.BinarySearch(sh.ids, id); found {
+		sh.ids = slices.Delete(sh.ids, i, i+1)
+	}
+	sh.account(p, -1)
+	s.size.Add(-1)
+	if s.evictLRU {
+		sh.clockRemove(id)
+	}
+	delete(s.skuShardFor(key).owner, key)
+	if p.DeletedAt == nil {
+		removeFromIndex(sh.categoryIndex, p.CategoryID, id)
+		removeFromIndex(sh.manufacturerIndex, p.Manufacturer, id)
+	}
+	if !s.keepDeletedHistory {
+		delete(sh.history, id)
+	}
+	return nil
+}
+
+// clearLocked empties every shard. Callers must hold all shards for writing.
+func (s *InMemoryStore) clearLocked() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.products = make(map[int]Product)
+		sh.ids = nil
+		sh.categoryIndex = make(map[int][]int)
+		sh.manufacturerIndex = make(map[string][]int)
+		sh.weight, sh.deleted, sh.bytes = 0, 0, 0
+		sh.history = make(map[int]*productHistory)
+		sh.expiry = nil
+		sh.clockIDs, sh.clockRefs, sh.clockHand = nil, nil, 0
+		sh.clockPos = make(map[int]int)
+		s.skus[i].owner = make(map[string]int)
+	}
+	s.size.Store(0)
+}
+
+// skuKey returns the key sku is indexed under
+func (s *InMemoryStore) skuKey(sku string) string {
+	if s.foldSKU {
+		return strings.ToLower(sku)
+	}
+	return sku
+}
+
+// account adds p to the shard's running totals, or removes it with sign -1.
+// A soft-deleted product counts as deleted instead of towards the weight.
+// Callers must hold sh.mu for writing.
+func (sh *storeShard) account(p Product, sign int) {
+	if p.DeletedAt != nil {
+		sh.deleted += sign
+	} else {
+		sh.weight += int64(sign * p.Weight)
+	}
+	sh.bytes += int64(sign) * productMemoryEstimate(p)
+}
+
+// removeFromIndex drops productID from the index bucket for key, deleting
+// the bucket once it is empty. Callers must hold the shard's mu for writing.
+func removeFromIndex[K comparable](index map[K][]int, key K, productID int) {
+	ids := index[key]
+	for i, id := range ids {
+		if id == productID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = ids
+}
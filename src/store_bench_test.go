@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// benchProducts is how many products the store benchmarks preload
+const benchProducts = 10000
+
+// benchStore returns an in-memory store holding products 1 to n
+func benchStore(b *testing.B, n int) *InMemoryStore {
+	b.Helper()
+	s := NewInMemoryStore(false)
+	for id := 1; id <= n; id++ {
+		p := testProduct(id)
+		if _, err := s.Put(context.Background(), &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+// runParallelOps runs op from GOMAXPROCS goroutines until b.N calls are
+// done. Each goroutine draws from its own generator, seeded by its index,
+// so runs pick the same sequence of products.
+func runParallelOps(b *testing.B, op func(rng *rand.Rand) error) {
+	b.Helper()
+	var seed atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		s := seed.Add(1)
+		rng := rand.New(rand.NewPCG(s, s))
+		for pb.Next() {
+			if err := op(rng); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkStoreGet(b *testing.B) {
+	s := benchStore(b, benchProducts)
+	ctx := context.Background()
+	runParallelOps(b, func(rng *rand.Rand) error {
+		_, err := s.Get(ctx, 1+rng.IntN(benchProducts))
+		return err
+	})
+}
+
+func BenchmarkStorePut(b *testing.B) {
+	s := benchStore(b, benchProducts)
+	ctx := context.Background()
+	runParallelOps(b, func(rng *rand.Rand) error {
+		p := testProduct(1 + rng.IntN(benchProducts))
+		_, err := s.Put(ctx, &p)
+		return err
+	})
+}
+
+// BenchmarkStoreMixed is nine reads to every write, the mix the load
+// generator defaults to
+func BenchmarkStoreMixed(b *testing.B) {
+	s := benchStore(b, benchProducts)
+	ctx := context.Background()
+	runParallelOps(b, func(rng *rand.Rand) error {
+		id := 1 + rng.IntN(benchProducts)
+		if rng.IntN(10) > 0 {
+			_, err := s.Get(ctx, id)
+			return err
+		}
+		p := testProduct(id)
+		_, err := s.Put(ctx, &p)
+		return err
+	})
+}
+
+// singleLockStore puts one lock around a whole InMemoryStore, the way the
+// store was guarded before it was sharded. It is the baseline the sharded
+// store is measured against below.
+type singleLockStore struct {
+	mu sync.RWMutex
+	s  *InMemoryStore
+}
+
+func (l *singleLockStore) Get(ctx context.Context, id int) (Product, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.s.Get(ctx, id)
+}
+
+func (l *singleLockStore) Put(ctx context.Context, p *Product) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.Put(ctx, p)
+}
+
+// getPutStore is the part of ProductStore the locking benchmarks use
+type getPutStore interface {
+	Get(ctx context.Context, id int) (Product, error)
+	Put(ctx context.Context, p *Product) (bool, error)
+}
+
+// benchmarkLocking runs bench against the single-lock baseline and the
+// sharded store, each preloaded with benchProducts products
+func benchmarkLocking(b *testing.B, bench func(b *testing.B, s getPutStore)) {
+	b.Run("single-lock", func(b *testing.B) {
+		bench(b, &singleLockStore{s: benchStore(b, benchProducts)})
+	})
+	b.Run("sharded", func(b *testing.B) {
+		bench(b, benchStore(b, benchProducts))
+	})
+}
+
+func BenchmarkConcurrentPut(b *testing.B) {
+	benchmarkLocking(b, func(b *testing.B, s getPutStore) {
+		ctx := context.Background()
+		runParallelOps(b, func(rng *rand.Rand) error {
+			p := testProduct(1 + rng.IntN(benchProducts))
+			_, err := s.Put(ctx, &p)
+			return err
+		})
+	})
+}
+
+// BenchmarkMixedReadWrite is BenchmarkStoreMixed against both stores, run
+// with -cpu 1,4,16 to see the single lock stop scaling
+func BenchmarkMixedReadWrite(b *testing.B) {
+	benchmarkLocking(b, func(b *testing.B, s getPutStore) {
+		ctx := context.Background()
+		runParallelOps(b, func(rng *rand.Rand) error {
+			id := 1 + rng.IntN(benchProducts)
+			if rng.IntN(10) > 0 {
+				_, err := s.Get(ctx, id)
+				return err
+			}
+			p := testProduct(id)
+			_, err := s.Put(ctx, &p)
+			return err
+		})
+	})
+}
+
+// BenchmarkStoreGetCapacity is BenchmarkStoreGet with no cap, with a cap
+// that rejects, which reads never touch, and with EVICTION=lru, where every
+// read marks the CLOCK ring
+func BenchmarkStoreGetCapacity(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		max  int
+		lru  bool
+	}{
+		{"uncapped", 0, false},
+		{"reject", 2 * benchProducts, false},
+		{"lru", 2 * benchProducts, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := benchStore(b, benchProducts)
+			s.SetCapacity(bc.max, bc.lru)
+			ctx := context.Background()
+			runParallelOps(b, func(rng *rand.Rand) error {
+				_, err := s.Get(ctx, 1+rng.IntN(benchProducts))
+				return err
+			})
+		})
+	}
+}
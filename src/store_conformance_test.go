@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// storeFactory describes a ProductStore backend to runStoreConformance:
+// how to get an empty store and what the backend can't do
+type storeFactory struct {
+	// open returns an empty store for one subtest
+	open func(t *testing.T) ProductStore
+	// atomicBatchLimit is the most products an atomic PutBatch takes, 0
+	// if there is no limit
+	atomicBatchLimit int
+	// pointOpsIgnoreContext is set for a store whose Get, GetBySKU, Put,
+	// Create, Update and Delete never wait on anything a canceled context
+	// could cut short, and so don't check it
+	pointOpsIgnoreContext bool
+	// boundedUpdateRetries is set for a store whose Update retries a
+	// conflicting write a fixed number of times and then fails, so under
+	// contention some Updates may fail, though none may be lost
+	boundedUpdateRetries bool
+	// skuCheckedBeforeWrite is set for a store that looks for the SKU's
+	// owner before writing rather than as part of the write, so two
+	// concurrent Puts can both claim a SKU
+	skuCheckedBeforeWrite bool
+}
+
+// runStoreConformance checks a backend against the ProductStore contract.
+// Every store the API can run on must pass it, so behaviour does not
+// change with STORE_BACKEND.
+func runStoreConformance(t *testing.T, f storeFactory) {
+	t.Run("Get", func(t *testing.T) { testStoreGet(t, f.open(t)) })
+	t.Run("Create", func(t *testing.T) { testStoreCreate(t, f.open(t)) })
+	t.Run("Put", func(t *testing.T) { testStorePut(t, f.open(t)) })
+	t.Run("PutBatch", func(t *testing.T) { testStorePutBatch(t, f.open(t), f.atomicBatchLimit) })
+	t.Run("Update", func(t *testing.T) { testStoreUpdate(t, f.open(t)) })
+	t.Run("Delete", func(t *testing.T) { testStoreDelete(t, f.open(t)) })
+	t.Run("List", func(t *testing.T) { testStoreList(t, f.open(t)) })
+	t.Run("Paging", func(t *testing.T) { testStorePaging(t, f.open(t)) })
+	t.Run("ContextCanceled", func(t *testing.T) { testStoreCanceled(t, f.open(t), !f.pointOpsIgnoreContext) })
+	t.Run("Concurrency", func(t *testing.T) { testStoreConcurrency(t, f.open(t), f) })
+}
+
+// emptyStore deletes every product in s, for backends whose tests share a
+// database
+func emptyStore(t *testing.T, s ProductStore) {
+	t.Helper()
+	items, err := s.List(context.Background(), ListFilter{IncludeDeleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range items {
+		if err := s.Delete(context.Background(), p.ProductID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustPut stores products in s, failing t on any error
+func mustPut(t *testing.T, s ProductStore, products ...Product) []Product {
+	t.Helper()
+	for i := range products {
+		if _, err := s.Put(context.Background(), &products[i]); err != nil {
+			t.Fatalf("put %d: %v", products[i].ProductID, err)
+		}
+	}
+	return products
+}
+
+// sameProduct reports whether two products are equal, comparing times by
+// instant since backends hand them back in different locations
+func sameProduct(a, b Product) bool {
+	utc := func(p Product) Product {
+		p.CreatedAt, p.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+		if p.DeletedAt != nil {
+			d := p.DeletedAt.UTC()
+			p.DeletedAt = &d
+		}
+		if p.ExpiresAt != nil {
+			e := p.ExpiresAt.UTC()
+			p.ExpiresAt = &e
+		}
+		return p
+	}
+	return reflect.DeepEqual(utc(a), utc(b))
+}
+
+func testStoreGet(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	price := 1999
+	p := testProduct(1)
+	p.Name, p.Description, p.Price, p.Currency, p.Quantity = "Bolt", "M6, zinc — 50 pack", &price, "EUR", 7
+	stored := mustPut(t, s, p, testProduct(2))
+
+	got, err := s.Get(ctx, 1)
+	if err != nil || !sameProduct(got, stored[0]) {
+		t.Errorf("Get: got %+v, %v; want %+v", got, err, stored[0])
+	}
+	if _, err := s.Get(ctx, 99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing: got %v, want ErrNotFound", err)
+	}
+	got, err = s.GetBySKU(ctx, "SKU-2")
+	if err != nil || !sameProduct(got, stored[1]) {
+		t.Errorf("GetBySKU: got %+v, %v; want %+v", got, err, stored[1])
+	}
+	if _, err := s.GetBySKU(ctx, "SKU-99"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBySKU missing: got %v, want ErrNotFound", err)
+	}
+	many, err := s.GetMany(ctx, []int{2, 99, 1})
+	if err != nil || len(many) != 2 || !sameProduct(many[1], stored[0]) || !sameProduct(many[2], stored[1]) {
+		t.Errorf("GetMany: got %v, %v; want products 1 and 2 only", many, err)
+	}
+}
+
+func testStoreCreate(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	p := testProduct(1)
+	if err := s.Create(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.CreatedAt.IsZero() || !p.CreatedAt.Equal(p.UpdatedAt) {
+		t.Errorf("Create stamped created_at %v, updated_at %v; want both set and equal", p.CreatedAt, p.UpdatedAt)
+	}
+
+	again := testProduct(1)
+	again.Weight = 999
+	var exists *ProductExistsError
+	if err := s.Create(ctx, &again); !errors.As(err, &exists) {
+		t.Fatalf("Create existing: got %v, want *ProductExistsError", err)
+	}
+	if !sameProduct(exists.Existing, p) {
+		t.Errorf("ProductExistsError names %+v, want %+v", exists.Existing, p)
+	}
+	if got, _ := s.Get(ctx, 1); got.Weight != p.Weight {
+		t.Errorf("Create existing overwrote the product: weight %d, want %d", got.Weight, p.Weight)
+	}
+
+	clash := testProduct(2)
+	clash.SKU = p.SKU
+	var dup *DuplicateSKUError
+	if err := s.Create(ctx, &clash); !errors.As(err, &dup) || dup.ProductID != 1 {
+		t.Errorf("Create with a taken SKU: got %v, want *DuplicateSKUError naming product 1", err)
+	}
+	if _, err := s.Get(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Create with a taken SKU stored the product: %v", err)
+	}
+}
+
+func testStorePut(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	p := testProduct(1)
+	created, err := s.Put(ctx, &p)
+	if err != nil || !created {
+		t.Fatalf("first Put: created %v, %v; want true", created, err)
+	}
+	first := p
+
+	time.Sleep(2 * time.Millisecond)
+	p = testProduct(1)
+	p.Weight = 500
+	p.SKU = "SKU-1B"
+	p.CreatedAt = time.Unix(0, 0) // server-owned; must be ignored
+	created, err = s.Put(ctx, &p)
+	if err != nil || created {
+		t.Fatalf("second Put: created %v, %v; want false", created, err)
+	}
+	if !p.CreatedAt.Equal(first.CreatedAt) || !p.UpdatedAt.After(first.UpdatedAt) {
+		t.Errorf("replace stamped created_at %v, updated_at %v; want created_at %v kept and updated_at after %v",
+			p.CreatedAt, p.UpdatedAt, first.CreatedAt, first.UpdatedAt)
+	}
+	if got, err := s.Get(ctx, 1); err != nil || !sameProduct(got, p) {
+		t.Errorf("Get after replace: got %+v, %v; want %+v", got, err, p)
+	}
+	if _, err := s.GetBySKU(ctx, "SKU-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old SKU still resolves after the product moved off it: %v", err)
+	}
+
+	clash := testProduct(2)
+	clash.SKU = "SKU-1B"
+	var dup *DuplicateSKUError
+	if _, err := s.Put(ctx, &clash); !errors.As(err, &dup) || dup.ProductID != 1 || dup.SKU != "SKU-1B" {
+		t.Errorf("Put with a taken SKU: got %v, want *DuplicateSKUError for SKU-1B of product 1", err)
+	}
+	// The SKU freed above can be claimed by another product
+	reuse := testProduct(2)
+	reuse.SKU = "SKU-1"
+	if _, err := s.Put(ctx, &reuse); err != nil {
+		t.Errorf("Put claiming a freed SKU: %v", err)
+	}
+}
+
+func testStorePutBatch(t *testing.T, s ProductStore, limit int) {
+	ctx := context.Background()
+	mustPut(t, s, testProduct(1))
+	changed := testProduct(1)
+	changed.Weight = 777
+	clash := testProduct(11)
+	clash.SKU = "SKU-1"
+	batch := []Product{testProduct(10), changed, clash}
+
+	errs := s.PutBatch(ctx, batch, true)
+	var dup *DuplicateSKUError
+	if len(errs) != 3 || !errors.Is(errs[0], ErrBatchAborted) || !errors.Is(errs[1], ErrBatchAborted) || !errors.As(errs[2], &dup) {
+		t.Fatalf("atomic batch: got %v, want ErrBatchAborted, ErrBatchAborted, *DuplicateSKUError", errs)
+	}
+	if _, err := s.Get(ctx, 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("aborted batch left product 10 behind: %v", err)
+	}
+	if got, _ := s.Get(ctx, 1); got.Weight != testProduct(1).Weight {
+		t.Errorf("aborted batch left product 1 at weight %d", got.Weight)
+	}
+	if _, err := s.GetBySKU(ctx, "SKU-10"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("aborted batch left SKU-10 claimed: %v", err)
+	}
+
+	errs = s.PutBatch(ctx, []Product{testProduct(10), testProduct(12)}, true)
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("atomic batch without conflicts: %v", errs)
+	}
+	if got, err := s.GetMany(ctx, []int{10, 12}); err != nil || len(got) != 2 {
+		t.Errorf("atomic batch stored %v, %v; want both products", got, err)
+	}
+
+	errs = s.PutBatch(ctx, []Product{testProduct(20), clash}, false)
+	if errs[0] != nil || !errors.As(errs[1], &dup) {
+		t.Errorf("non-atomic batch: got %v, want nil, *DuplicateSKUError", errs)
+	}
+	if _, err := s.Get(ctx, 20); err != nil {
+		t.Errorf("non-atomic batch dropped the good item: %v", err)
+	}
+
+	if limit > 0 {
+		over := make([]Product, limit+1)
+		for i := range over {
+			over[i] = testProduct(1000 + i)
+		}
+		var tooLarge *AtomicBatchTooLargeError
+		for i, err := range s.PutBatch(ctx, over, true) {
+			if !errors.As(err, &tooLarge) || tooLarge.Limit != limit {
+				t.Fatalf("item %d of an oversized batch: got %v, want *AtomicBatchTooLargeError with limit %d", i, err, limit)
+			}
+		}
+		if _, err := s.Get(ctx, 1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("oversized batch stored product 1000: %v", err)
+		}
+	}
+}
+
+func testStoreUpdate(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	before := mustPut(t, s, testProduct(1))[0]
+
+	time.Sleep(2 * time.Millisecond)
+	updated, err := s.Update(ctx, 1, func(p *Product) error {
+		p.Weight = 321
+		return nil
+	})
+	if err != nil || updated.Weight != 321 || !updated.CreatedAt.Equal(before.CreatedAt) || !updated.UpdatedAt.After(before.UpdatedAt) {
+		t.Fatalf("Update: got %+v, %v", updated, err)
+	}
+	if got, err := s.Get(ctx, 1); err != nil || !sameProduct(got, updated) {
+		t.Errorf("Get after Update: got %+v, %v; want %+v", got, err, updated)
+	}
+
+	errVeto := errors.New("veto")
+	_, err = s.Update(ctx, 1, func(p *Product) error {
+		p.Weight = 1
+		return errVeto
+	})
+	if !errors.Is(err, errVeto) {
+		t.Errorf("Update with a failing fn: got %v, want its error", err)
+	}
+	if got, _ := s.Get(ctx, 1); got.Weight != 321 {
+		t.Errorf("failed Update wrote weight %d", got.Weight)
+	}
+
+	called := false
+	if _, err := s.Update(ctx, 99, func(*Product) error { called = true; return nil }); !errors.Is(err, ErrNotFound) || called {
+		t.Errorf("Update missing: got %v, fn called %v; want ErrNotFound without calling fn", err, called)
+	}
+}
+
+func testStoreDelete(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	mustPut(t, s, testProduct(1), testProduct(2))
+
+	if err := s.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetBySKU(ctx, "SKU-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBySKU after Delete: got %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete missing: got %v, want ErrNotFound", err)
+	}
+	reuse := testProduct(3)
+	reuse.SKU = "SKU-1"
+	if _, err := s.Put(ctx, &reuse); err != nil {
+		t.Errorf("Put claiming a deleted product's SKU: %v", err)
+	}
+
+	if removed, err := deleteIf(ctx, s, 2, func(Product) bool { return false }); removed || err != nil {
+		t.Errorf("deleteIf, false condition: got %v, %v", removed, err)
+	}
+	if _, err := s.Get(ctx, 2); err != nil {
+		t.Errorf("deleteIf removed the product despite a false condition: %v", err)
+	}
+	if removed, err := deleteIf(ctx, s, 2, func(p Product) bool { return p.SKU == "SKU-2" }); !removed || err != nil {
+		t.Errorf("deleteIf, true condition: got %v, %v", removed, err)
+	}
+	if _, err := deleteIf(ctx, s, 2, func(Product) bool { return true }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleteIf missing: got %v, want ErrNotFound", err)
+	}
+}
+
+func testStoreList(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	product := func(id, category, weight int, manufacturer string) Product {
+		p := testProduct(id)
+		p.CategoryID, p.Weight, p.Manufacturer = category, weight, manufacturer
+		return p
+	}
+	// Written out of ID order, so the order List returns is its own
+	first := mustPut(t, s,
+		product(5, 1, 50, "Acme"),
+		product(2, 2, 20, "acme"),
+		product(7, 1, 70, "Globex"),
+	)
+	time.Sleep(2 * time.Millisecond)
+	deletedAt := time.Now().UTC()
+	gone := product(4, 1, 40, "Acme")
+	gone.DeletedAt = &deletedAt
+	mustPut(t, s, product(1, 2, 10, "Acme"), product(6, 1, 60, "Acme"), gone)
+	since := first[len(first)-1].UpdatedAt
+
+	lo, hi := 20, 60
+	idFrom, idTo := 2, 5
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   []int
+	}{
+		{"all", ListFilter{}, []int{1, 2, 5, 6, 7}},
+		{"include deleted", ListFilter{IncludeDeleted: true}, []int{1, 2, 4, 5, 6, 7}},
+		{"category", ListFilter{CategoryID: 1}, []int{5, 6, 7}},
+		{"manufacturer", ListFilter{Manufacturer: "Acme"}, []int{1, 5, 6}},
+		{"manufacturer fold", ListFilter{Manufacturer: "ACME", ManufacturerFold: true}, []int{1, 2, 5, 6}},
+		{"weight range", ListFilter{MinWeight: &lo, MaxWeight: &hi}, []int{2, 5, 6}},
+		{"id range", ListFilter{IDFrom: &idFrom, IDTo: &idTo}, []int{2, 5}},
+		{"updated since", ListFilter{UpdatedSince: since}, []int{1, 6}},
+		{"combined", ListFilter{CategoryID: 1, Manufacturer: "Acme", MinWeight: &hi}, []int{6}},
+		{"no match", ListFilter{CategoryID: 3}, []int{}},
+	}
+	for _, tt := range tests {
+		items, err := s.List(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := productIDs(items); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testStorePaging pages through s as cursor pagination does, listing from
+// just past the last ID seen, while products are added and removed between
+// pages. Products there throughout must each come back once, in order, and
+// a product deleted before its page was read must not.
+func testStorePaging(t *testing.T, s ProductStore) {
+	ctx := context.Background()
+	// Even IDs, leaving the odd ones for writes between pages
+	for id := 2; id <= 60; id += 2 {
+		mustPut(t, s, testProduct(id))
+	}
+	const pageSize = 7
+	deleted := make(map[int]bool)
+	var seen []int
+	for from := 0; ; {
+		items, err := s.List(ctx, ListFilter{IDFrom: &from})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		page := productIDs(items[:min(pageSize, len(items))])
+		seen = append(seen, page...)
+		last := page[len(page)-1]
+		from = last + 1
+
+		// One write behind the cursor, one ahead of it and a delete ahead,
+		// all within the first 60 IDs so the paging ends
+		mustPut(t, s, testProduct(last-1))
+		if last+pageSize < 60 {
+			mustPut(t, s, testProduct(last+pageSize))
+		}
+		if ahead := last + 4; ahead%2 == 0 && ahead <= 60 && !deleted[ahead] {
+			if err := s.Delete(ctx, ahead); err != nil {
+				t.Fatal(err)
+			}
+			deleted[ahead] = true
+		}
+	}
+
+	if !slices.IsSorted(seen) || len(slices.Compact(slices.Clone(seen))) != len(seen) {
+		t.Fatalf("paged IDs out of order or repeated: %v", seen)
+	}
+	for id := 2; id <= 60; id += 2 {
+		if found := slices.Contains(seen, id); found == deleted[id] {
+			t.Errorf("product %d: paged %v, deleted before its page %v", id, found, deleted[id])
+		}
+	}
+}
+
+// testStoreCanceled calls s with a canceled context. List, GetMany and
+// PutBatch must report context.Canceled without writing anything, and so
+// must the single-product calls when pointOps is set.
+func testStoreCanceled(t *testing.T, s ProductStore, pointOps bool) {
+	stored := mustPut(t, s, testProduct(1))[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	check := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", op, err)
+		}
+	}
+
+	_, err := s.List(ctx, ListFilter{})
+	check("List", err)
+	_, err = s.GetMany(ctx, []int{1})
+	check("GetMany", err)
+	for _, atomic := range []bool{true, false} {
+		for _, err := range s.PutBatch(ctx, []Product{testProduct(2), testProduct(3)}, atomic) {
+			check("PutBatch", err)
+		}
+	}
+	if pointOps {
+		_, err = s.Get(ctx, 1)
+		check("Get", err)
+		_, err = s.GetBySKU(ctx, "SKU-1")
+		check("GetBySKU", err)
+		p := testProduct(4)
+		_, err = s.Put(ctx, &p)
+		check("Put", err)
+		p = testProduct(5)
+		check("Create", s.Create(ctx, &p))
+		_, err = s.Update(ctx, 1, func(p *Product) error { p.Weight = 1; return nil })
+		check("Update", err)
+		check("Delete", s.Delete(ctx, 1))
+	}
+
+	if got, err := s.Get(context.Background(), 1); err != nil || !sameProduct(got, stored) {
+		t.Errorf("product 1 after canceled calls: got %+v, %v; want %+v", got, err, stored)
+	}
+	if got, err := s.GetMany(context.Background(), []int{2, 3, 4, 5}); err != nil || len(got) != 0 {
+		t.Errorf("canceled calls stored %v, %v", got, err)
+	}
+}
+
+// testStoreConcurrency runs goroutines against s at once: Updates of one
+// product, Creates racing for the same IDs, Puts racing for the same SKUs,
+// and reads throughout. No Update may be lost, and each race must have
+// exactly one winner. Run it under -race.
+func testStoreConcurrency(t *testing.T, s ProductStore, f storeFactory) {
+	const workers, rounds = 8, 10
+	ctx := context.Background()
+	mustPut(t, s, testProduct(1))
+
+	var wg sync.WaitGroup
+	var updated, created, claimed atomic.Int32
+	errs := make(chan error, workers*rounds*5)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rounds {
+				switch _, err := s.Update(ctx, 1, func(p *Product) error { p.Quantity++; return nil }); {
+				case err == nil:
+					updated.Add(1)
+				case !f.boundedUpdateRetries:
+					errs <- fmt.Errorf("Update: %w", err)
+				}
+				p := testProduct(100 + r)
+				var exists *ProductExistsError
+				switch err := s.Create(ctx, &p); {
+				case err == nil:
+					created.Add(1)
+				case !errors.As(err, &exists):
+					errs <- fmt.Errorf("Create: %w", err)
+				}
+				if !f.skuCheckedBeforeWrite {
+					q := testProduct(1000 + w*rounds + r)
+					q.SKU = "RACE-" + strconv.Itoa(r)
+					var dup *DuplicateSKUError
+					switch _, err := s.Put(ctx, &q); {
+					case err == nil:
+						claimed.Add(1)
+					case !errors.As(err, &dup):
+						errs <- fmt.Errorf("Put: %w", err)
+					}
+				}
+				if _, err := s.Get(ctx, 1); err != nil {
+					errs <- fmt.Errorf("Get: %w", err)
+				}
+				if _, err := s.List(ctx, ListFilter{}); err != nil {
+					errs <- fmt.Errorf("List: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got, err := s.Get(ctx, 1); err != nil || got.Quantity != int(updated.Load()) {
+		t.Errorf("after %d successful Updates: quantity %d, %v", updated.Load(), got.Quantity, err)
+	}
+	if created.Load() != rounds {
+		t.Errorf("%d Creates won, want one for each of the %d IDs", created.Load(), rounds)
+	}
+	if !f.skuCheckedBeforeWrite && claimed.Load() != rounds {
+		t.Errorf("%d SKU claims won, want one for each of the %d SKUs", claimed.Load(), rounds)
+	}
+	items, err := s.List(ctx, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := make(map[string]int)
+	for _, p := range items {
+		if other, taken := owners[p.SKU]; taken {
+			t.Errorf("products %d and %d both own SKU %s", other, p.ProductID, p.SKU)
+		}
+		owners[p.SKU] = p.ProductID
+	}
+}
+
+func TestInMemoryStoreConformance(t *testing.T) {
+	runStoreConformance(t, storeFactory{
+		open:                  func(*testing.T) ProductStore { return NewInMemoryStore(false) },
+		pointOpsIgnoreContext: true,
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAtomicBatchNeverSeenHalfApplied has a writer apply atomic batches,
+// every third one aborted by a SKU conflict on its last item, while readers
+// check that the products GetMany and Snapshot return all come from the
+// same committed batch
+func TestAtomicBatchNeverSeenHalfApplied(t *testing.T) {
+	const (
+		batchSize = 16
+		// Long enough for the scheduler to switch goroutines mid-read even
+		// on one CPU
+		runFor = 300 * time.Millisecond
+	)
+	ctx := context.Background()
+	s := NewInMemoryStore(false)
+	ids := make([]int, batchSize)
+	for i := range ids {
+		// Spread over shards, each product on its own
+		ids[i] = 1 + i*7
+		p := testProduct(ids[i])
+		p.Weight = 0
+		mustPut(t, s, p)
+	}
+	taken := mustPut(t, s, testProduct(1000))[0]
+
+	// consistent fails t if the weights read come from more than one batch
+	// or from an aborted one
+	consistent := func(weights []int) bool {
+		for _, w := range weights {
+			if w != weights[0] {
+				t.Errorf("read a half-applied batch: weights %v", weights)
+				return false
+			}
+		}
+		if weights[0]%3 == 2 {
+			t.Errorf("read batch %d, which was aborted", weights[0])
+			return false
+		}
+		return true
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			found, err := s.GetMany(ctx, ids)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			weights := make([]int, len(ids))
+			for i, id := range ids {
+				weights[i] = found[id].Weight
+			}
+			if !consistent(weights) {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			items, _ := s.Snapshot()
+			var weights []int
+			for _, p := range items {
+				if p.ProductID != taken.ProductID {
+					weights = append(weights, p.Weight)
+				}
+			}
+			if !consistent(weights) {
+				return
+			}
+		}
+	}()
+
+	for round, stop := 1, time.Now().Add(runFor); time.Now().Before(stop); round++ {
+		batch := make([]Product, 0, batchSize+1)
+		for _, id := range ids {
+			p := testProduct(id)
+			p.Weight = round
+			batch = append(batch, p)
+		}
+		aborted := round%3 == 2
+		if aborted {
+			conflict := testProduct(2000)
+			conflict.SKU = taken.SKU
+			batch = append(batch, conflict)
+		}
+		errs := s.PutBatch(ctx, batch, true)
+		if failed := errs[len(errs)-1] != nil; failed != aborted {
+			t.Errorf("batch %d: last item error %v, want an error %v", round, errs[len(errs)-1], aborted)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// TestInMemoryDeleteWhileReading hard-deletes products while readers look
+// them up by ID and SKU and list them; run with -race. The SKU index must
+// end up as empty as the products.
+func TestInMemoryDeleteWhileReading(t *testing.T) {
+	const n = 500
+	ctx := context.Background()
+	s := NewInMemoryStore(false)
+	for id := 1; id <= n; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			id := 1 + i%n
+			if p, err := s.Get(ctx, id); err == nil && p.ProductID != id {
+				t.Errorf("get %d: got product %d", id, p.ProductID)
+			} else if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			sku := testProduct(1 + i%n).SKU
+			if p, err := s.GetBySKU(ctx, sku); err == nil && p.SKU != sku {
+				t.Errorf("get %s: got %s", sku, p.SKU)
+			} else if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := s.List(ctx, ListFilter{}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	for id := 1; id <= n; id++ {
+		if err := s.Delete(ctx, id); err != nil {
+			t.Errorf("delete %d: %v", id, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if items, _ := s.List(ctx, ListFilter{IncludeDeleted: true}); len(items) != 0 || s.Count() != 0 {
+		t.Errorf("%d products listed, Count %d; want none", len(items), s.Count())
+	}
+	for id := 1; id <= n; id++ {
+		if _, err := s.GetBySKU(ctx, testProduct(id).SKU); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("SKU of deleted product %d: got %v, want ErrNotFound", id, err)
+		}
+		if err := s.Delete(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("second delete of %d: got %v, want ErrNotFound", id, err)
+		}
+	}
+}
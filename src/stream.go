@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stream tuning: events a subscriber may fall behind by before it is cut
+// off, events kept for Last-Event-ID replay, and how often an idle stream
+// gets a comment so the ALB doesn't close it
+const (
+	streamSubscriberBuffer = 64
+	streamReplaySize       = 1000
+	streamHeartbeat        = 15 * time.Second
+)
+
+// streamEvent is one change as sent on GET /products/stream
+type streamEvent struct {
+	seq       uint64
+	event     string
+	productID int
+	data      []byte
+	// Only subscribers to the same tenant see the event
+	tenant string
+}
+
+// id is the SSE event id: the product_id, then the hub sequence number
+// Last-Event-ID resumes from
+func (e streamEvent) id() string {
+	return strconv.Itoa(e.productID) + "-" + strconv.FormatUint(e.seq, 10)
+}
+
+// streamSubscriber is one open stream. ch is closed when the subscriber
+// fell too far behind or the hub shut down.
+type streamSubscriber struct {
+	ch     chan streamEvent
+	tenant string
+}
+
+// streamHub fans every write out to the open streams and keeps the latest
+// events for replay. Writers never wait: a subscriber whose buffer is full
+// is disconnected instead.
+type streamHub struct {
+	mu     sync.Mutex
+	seq    uint64
+	recent []streamEvent // oldest first, at most streamReplaySize
+	subs   map[*streamSubscriber]struct{}
+	closed bool
+}
+
+// newStreamHub returns a hub with no subscribers
+func newStreamHub() *streamHub {
+	return &streamHub{subs: make(map[*streamSubscriber]struct{})}
+}
+
+// publishChange sends the write to every subscriber to tenant
+func (h *streamHub) publishChange(tenant, event string, productID int, product *Product) {
+	var payload any = map[string]int{"product_id": productID}
+	if product != nil {
+		payload = product
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("stream event encoding failed", "event", event, "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.seq++
+	ev := streamEvent{seq: h.seq, event: event, productID: productID, data: data, tenant: tenant}
+	if len(h.recent) == streamReplaySize {
+		h.recent = append(h.recent[:0], h.recent[1:]...)
+	}
+	h.recent = append(h.recent, ev)
+
+	for sub := range h.subs {
+		if sub.tenant != tenant {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe opens a stream of tenant's writes and returns it with the kept
+// events for tenant after lastSeq, 0 meaning none. It fails once the hub is
+// closed.
+func (h *streamHub) subscribe(tenant string, lastSeq uint64) (*streamSubscriber, []streamEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+	var replay []streamEvent
+	if lastSeq > 0 {
+		for _, ev := range h.recent {
+			if ev.seq > lastSeq && ev.tenant == tenant {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	sub := &streamSubscriber{ch: make(chan streamEvent, streamSubscriberBuffer), tenant: tenant}
+	h.subs[sub] = struct{}{}
+	return sub, replay, true
+}
+
+// unsubscribe closes sub unless the hub already has
+func (h *streamHub) unsubscribe(sub *streamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// close ends every stream and refuses new ones, so shutdown isn't held up
+// by connections that never finish on their own
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// parseLastEventID returns the sequence number in a Last-Event-ID header
+// from this stream, 0 if there is none
+func parseLastEventID(header string) uint64 {
+	i := strings.LastIndexByte(header, '-')
+	if i < 0 {
+		return 0
+	}
+	seq, err := strconv.ParseUint(header[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// streamProducts handles GET /products/stream
+// Sends a Server-Sent Event per product create, update and delete until the
+// client goes away, falls streamSubscriberBuffer events behind, or the
+// server shuts down. The data is the product as stored, or just its
+// product_id for product.deleted. With Last-Event-ID, kept events after it
+// are sent first; older ones are gone.
+// Returns 200 with text/event-stream, 503 while shutting down
+func (a *API) streamProducts(c *gin.Context) {
+	sub, replay, ok := a.stream.subscribe(tenantFrom(c.Request.Context()), parseLastEventID(c.GetHeader("Last-Event-ID")))
+	if !ok {
+		writeError(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "SHUTTING_DOWN",
+			Message:   "Server is shutting down",
+			Details:   "Reconnect to another instance",
+			RequestID: requestID(c),
+		})
+		return
+	}
+	defer a.stream.unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// WRITE_TIMEOUT is meant for single responses; give each write a fresh one
+	rc := http.NewResponseController(c.Writer)
+	send := func(b []byte) bool {
+		rc.SetWriteDeadline(time.Now().Add(a.cfg.WriteTimeout))
+		if _, err := c.Writer.Write(b); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+	if !send([]byte(": connected\n\n")) {
+		return
+	}
+	for _, ev := range replay {
+		if !send(formatStreamEvent(ev)) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !send([]byte(": keepalive\n\n")) {
+				return
+			}
+		case ev, open := <-sub.ch:
+			if !open {
+				return
+			}
+			if !send(formatStreamEvent(ev)) {
+				return
+			}
+		}
+	}
+}
+
+// formatStreamEvent renders ev in the text/event-stream format
+func formatStreamEvent(ev streamEvent) []byte {
+	b := make([]byte, 0, len(ev.data)+64)
+	b = append(b, "event: "...)
+	b = append(b, ev.event...)
+	b = append(b, "\nid: "...)
+	b = append(b, ev.id()...)
+	b = append(b, "\ndata: "...)
+	b = append(b, ev.data...)
+	return append(b, "\n\n"...)
+}
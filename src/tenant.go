@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantHeader names the tenant a request is for when MULTI_TENANT is on
+const tenantHeader = "X-Tenant-ID"
+
+// tenantIDKey is the gin context key holding the request's tenant, for the
+// request log and audit
+const tenantIDKey = "tenant_id"
+
+// tenantIDPattern is what an X-Tenant-ID must look like: a lower-case slug
+// of at most 32 characters that doesn't start or end with a hyphen
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// errNoTenant is returned by a tenantStore write whose context names no
+// tenant, which only code outside a tenant-scoped request can cause
+var errNoTenant = errors.New("no tenant in context")
+
+type tenantContextKey struct{}
+
+// withTenant returns ctx carrying tenant for the store calls made with it
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFrom returns the tenant withTenant put in ctx, empty if none
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// tenantData is everything one tenant owns
+type tenantData struct {
+	products   *InMemoryStore
+	categories *CategoryStore
+}
+
+// tenantStore is the store of MULTI_TENANT=true: every tenant gets its own
+// InMemoryStore and CategoryStore, picked by the tenant in each call's
+// context, so IDs, SKUs, indexes and stats never cross tenants. A tenant's
+// stores are made on its first create; until then its reads are answered
+// from an empty pair nothing is ever written to.
+type tenantStore struct {
+	newStore func() *InMemoryStore
+	empty    *tenantData
+
+	mu      sync.RWMutex
+	tenants map[string]*tenantData
+}
+
+// newTenantStore returns a tenantStore whose tenants' stores are set up
+// from cfg the way main sets up the single in-memory store
+func newTenantStore(cfg Config) *tenantStore {
+	newStore := func() *InMemoryStore {
+		s := NewInMemoryStore(cfg.SKUCaseInsensitive)
+		if cfg.HistorySize > 0 {
+			s.EnableHistory(cfg.HistorySize, cfg.HistoryKeepOnDelete)
+		}
+		if cfg.MaxProducts > 0 {
+			s.SetCapacity(cfg.MaxProducts, cfg.Eviction == evictionLRU)
+		}
+		return s
+	}
+	return &tenantStore{
+		newStore: newStore,
+		empty:    &tenantData{products: newStore(), categories: NewCategoryStore()},
+		tenants:  make(map[string]*tenantData),
+	}
+}
+
+// tenant returns the data of the tenant in ctx. With create false an
+// unknown tenant gets the shared empty pair, which must not be written to.
+func (s *tenantStore) tenant(ctx context.Context, create bool) (*tenantData, error) {
+	id := tenantFrom(ctx)
+	s.mu.RLock()
+	t, ok := s.tenants[id]
+	s.mu.RUnlock()
+	switch {
+	case ok:
+		return t, nil
+	case !create:
+		return s.empty, nil
+	case id == "":
+		return nil, errNoTenant
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok = s.tenants[id]; !ok {
+		t = &tenantData{products: s.newStore(), categories: NewCategoryStore()}
+		s.tenants[id] = t
+	}
+	return t, nil
+}
+
+// products returns the store reads of the tenant in ctx go to
+func (s *tenantStore) products(ctx context.Context) *InMemoryStore {
+	t, _ := s.tenant(ctx, false)
+	return t.products
+}
+
+func (s *tenantStore) Get(ctx context.Context, id int) (Product, error) {
+	return s.products(ctx).Get(ctx, id)
+}
+
+func (s *tenantStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	return s.products(ctx).GetBySKU(ctx, sku)
+}
+
+func (s *tenantStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	return s.products(ctx).GetMany(ctx, ids)
+}
+
+func (s *tenantStore) Put(ctx context.Context, p *Product) (bool, error) {
+	t, err := s.tenant(ctx, true)
+	if err != nil {
+		return false, err
+	}
+	return t.products.Put(ctx, p)
+}
+
+func (s *tenantStore) Create(ctx context.Context, p *Product) error {
+	t, err := s.tenant(ctx, true)
+	if err != nil {
+		return err
+	}
+	return t.products.Create(ctx, p)
+}
+
+func (s *tenantStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	t, err := s.tenant(ctx, true)
+	if err != nil {
+		errs := make([]error, len(items))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	return t.products.PutBatch(ctx, items, atomic)
+}
+
+// Update and Delete only ever find products in a tenant that created some,
+// so an unknown tenant's ErrNotFound comes from the empty store
+func (s *tenantStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	return s.products(ctx).Update(ctx, id, fn)
+}
+
+func (s *tenantStore) Delete(ctx context.Context, id int) error {
+	return s.products(ctx).Delete(ctx, id)
+}
+
+func (s *tenantStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	return s.products(ctx).DeleteIf(ctx, id, cond)
+}
+
+func (s *tenantStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	return s.products(ctx).List(ctx, filter)
+}
+
+// each calls fn with every tenant's data, in no particular order
+func (s *tenantStore) each(fn func(id string, t *tenantData)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for id, t := range s.tenants {
+		fn(id, t)
+	}
+}
+
+// SweepExpired sweeps every tenant
+func (s *tenantStore) SweepExpired(now time.Time) int {
+	n := 0
+	s.each(func(_ string, t *tenantData) { n += t.products.SweepExpired(now) })
+	return n
+}
+
+// Count and Evicted add up every tenant, for the products_in_store and
+// eviction metrics
+func (s *tenantStore) Count() int {
+	n := 0
+	s.each(func(_ string, t *tenantData) { n += t.products.Count() })
+	return n
+}
+
+func (s *tenantStore) Evicted() int64 {
+	var n int64
+	s.each(func(_ string, t *tenantData) { n += t.products.Evicted() })
+	return n
+}
+
+// TenantSummary is one tenant's entry in GET /admin/tenants
+type TenantSummary struct {
+	TenantID string `json:"tenant_id"`
+	Products int    `json:"products"`
+}
+
+// summaries returns every tenant that has created something, by tenant ID
+func (s *tenantStore) summaries() []TenantSummary {
+	var tenants []TenantSummary
+	s.each(func(id string, t *tenantData) {
+		tenants = append(tenants, TenantSummary{TenantID: id, Products: t.products.Stats().Products})
+	})
+	slices.SortFunc(tenants, func(x, y TenantSummary) int { return strings.Compare(x.TenantID, y.TenantID) })
+	return tenants
+}
+
+// baseStore returns the store optional capabilities (storeClearer,
+// statsProvider, ...) are checked on for a call with ctx: a.store, or with
+// MULTI_TENANT the in-memory store of the tenant in ctx
+func (a *API) baseStore(ctx context.Context) ProductStore {
+	if ts, ok := a.store.(*tenantStore); ok {
+		return ts.products(ctx)
+	}
+	return a.store
+}
+
+// categoriesFor returns the categories of the tenant in ctx, a.categories
+// without MULTI_TENANT. Like a tenantStore read, an unknown tenant gets an
+// empty store, so only categoriesToCreateIn's may have categories added.
+func (a *API) categoriesFor(ctx context.Context) *CategoryStore {
+	if ts, ok := a.store.(*tenantStore); ok {
+		t, _ := ts.tenant(ctx, false)
+		return t.categories
+	}
+	return a.categories
+}
+
+// categoriesToCreateIn is categoriesFor for adding a category, making the
+// tenant's stores if this is its first write
+func (a *API) categoriesToCreateIn(ctx context.Context) (*CategoryStore, error) {
+	ts, ok := a.store.(*tenantStore)
+	if !ok {
+		return a.categories, nil
+	}
+	t, err := ts.tenant(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.categories, nil
+}
+
+// tenantScoped reports whether route touches tenant data: the product,
+// manufacturer and category endpoints of every version, /stats, and the
+// admin endpoints acting on products
+func tenantScoped(route string) bool {
+	route = strings.TrimPrefix(route, apiV1Prefix)
+	for _, prefix := range []string{"/products", "/manufacturers", "/categories", adminPrefix + "products", adminPrefix + "seed"} {
+		if strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return route == "/stats"
+}
+
+// requireTenant applies MULTI_TENANT: a request to a tenant-scoped route
+// must name its tenant in X-Tenant-ID, which is put in the request context
+// for the store. A missing or malformed one is 400. Probes, metrics, the
+// docs and the other admin endpoints are left alone.
+func (a *API) requireTenant(c *gin.Context) {
+	if !a.cfg.MultiTenant || !tenantScoped(c.FullPath()) {
+		c.Next()
+		return
+	}
+	tenant := c.GetHeader(tenantHeader)
+	if !tenantIDPattern.MatchString(tenant) {
+		details := tenantHeader + " is required"
+		if tenant != "" {
+			details = tenantHeader + " must be 1 to 32 lower-case letters, digits and inner hyphens"
+		}
+		abortWithError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_TENANT",
+			Message:   "Missing or invalid tenant",
+			Details:   details,
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Set(tenantIDKey, tenant)
+	c.Request = c.Request.WithContext(withTenant(c.Request.Context(), tenant))
+	c.Next()
+}
+
+// listTenants handles GET /admin/tenants
+// Returns 200 with every tenant that has stored a product or category and
+// its live product count, by tenant_id; an empty list without MULTI_TENANT
+func (a *API) listTenants(c *gin.Context) {
+	tenants := []TenantSummary{}
+	if ts, ok := a.store.(*tenantStore); ok {
+		tenants = append(tenants, ts.summaries()...)
+	}
+	c.JSON(http.StatusOK, tenants)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testProduct returns a valid product with the given ID and a SKU derived
+// from it
+func testProduct(id int) Product {
+	return Product{
+		ProductID:    id,
+		SKU:          "SKU-" + strconv.Itoa(id),
+		Manufacturer: "Acme",
+		CategoryID:   1 + id%3,
+		Weight:       10 * id,
+		SomeOtherID:  1,
+	}
+}
+
+// testConfig loads the configuration main would from env, failing t if it
+// is invalid
+func testConfig(t testing.TB, env map[string]string) Config {
+	t.Helper()
+	cfg, err := loadConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestAPI returns an API over store configured from env, with its logs
+// discarded, and the router serving it
+func newTestAPI(t testing.TB, store ProductStore, env map[string]string) (*API, *gin.Engine) {
+	t.Helper()
+	a := NewAPI(store, testConfig(t, env), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return a, SetupRouter(a)
+}
+
+// doRequest serves one request through h. A non-empty body is sent as
+// JSON; header holds name, value pairs set after that.
+func doRequest(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// productJSON is the request body creating p
+func productJSON(p Product) string {
+	return `{"product_id":` + strconv.Itoa(p.ProductID) + `,"sku":"` + p.SKU + `","manufacturer":"` + p.Manufacturer +
+		`","category_id":` + strconv.Itoa(p.CategoryID) + `,"weight":` + strconv.Itoa(p.Weight) + `,"some_other_id":` + strconv.Itoa(p.SomeOtherID) + `}`
+}
+
+// productIDs lists the IDs of items in order
+func productIDs(items []Product) []int {
+	ids := make([]int, len(items))
+	for i, p := range items {
+		ids[i] = p.ProductID
+	}
+	return ids
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeoutExempt routes run for as long as they need; export and the event
+// stream renew their write deadline per write, import reads uploads up to
+// MAX_BATCH_BODY_BYTES and CPU profiles and traces last ?seconds=
+var timeoutExempt = map[string]bool{
+	"/products/export":     true,
+	"/products/stream":     true,
+	importRoute:            true,
+	"/debug/pprof/profile": true,
+	"/debug/pprof/trace":   true,
+}
+
+// requestTimeout gives each request REQUEST_TIMEOUT to respond. The request
+// context carries the deadline; if it passes before the handler finishes,
+// the client gets 504 TIMEOUT straight away and whatever the handler writes
+// afterwards is dropped. Handlers write into a buffer until they return, so
+// the 504 and a late response can never both reach the connection. The 504
+// is flushed as soon as it is written, so a handler stuck past its deadline
+// doesn't hold the client until it returns.
+func (a *API) requestTimeout(c *gin.Context) {
+	if a.cfg.RequestTimeout <= 0 || timeoutExempt[routeTemplate(c.FullPath())] {
+		c.Next()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), a.cfg.RequestTimeout)
+	c.Request = c.Request.WithContext(ctx)
+	tw := newTimeoutWriter(c.Writer)
+	c.Writer = tw
+	contentType, body := encodeError(c, http.StatusGatewayTimeout, ErrorResponse{
+		Error:     "TIMEOUT",
+		Message:   "Request timed out",
+		Details:   "No response within " + a.cfg.RequestTimeout.String(),
+		RequestID: requestID(c),
+	})
+	stop := context.AfterFunc(ctx, func() {
+		// A client that went away cancels ctx too; only the deadline answers
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			tw.timeout(contentType, body)
+		}
+	})
+	defer func() {
+		stop()
+		// Discards the buffer if the handler panicked, leaving the writer
+		// clean for recoverPanics
+		tw.close()
+		c.Writer = tw.ResponseWriter
+		cancel()
+	}()
+
+	c.Next()
+	tw.flush()
+}
+
+// timeoutWriter holds a handler's response until the handler returns, so it
+// can be thrown away if the 504 went out first. mu orders the two.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	// timedOut is set once the 504 is sent, done once the handler's own
+	// response is (or can no longer be)
+	timedOut bool
+	done     bool
+}
+
+func newTimeoutWriter(w gin.ResponseWriter) *timeoutWriter {
+	// Headers set by earlier middleware, such as X-Request-ID, carry over
+	return &timeoutWriter{ResponseWriter: w, header: w.Header().Clone(), status: http.StatusOK}
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && !w.wroteHeader {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.body.WriteString(s)
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.wroteHeader {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wroteHeader
+}
+
+// Flush is a no-op: nothing reaches the client before the handler returns
+func (w *timeoutWriter) Flush() {}
+
+// timeout sends and flushes the 504 unless the handler's response already
+// went out. It goes around gzipWriter, which would hold a body this small
+// until the handler returns, and sets Content-Length so the client has the
+// whole response once it is flushed.
+func (w *timeoutWriter) timeout(contentType string, body []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.timedOut, w.done = true, true
+	out := w.ResponseWriter
+	if gz, ok := out.(*gzipWriter); ok {
+		out = gz.ResponseWriter
+	}
+	h := out.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	out.WriteHeader(http.StatusGatewayTimeout)
+	out.Write(body)
+	out.Flush()
+}
+
+// flush sends the handler's buffered response unless the 504 went out first
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+	dst := w.ResponseWriter.Header()
+	clear(dst)
+	for name, values := range w.header {
+		dst[name] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+// close stops a late timeout from writing once the handler has returned
+func (w *timeoutWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+}
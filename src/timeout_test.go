@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// blockingStore is a store whose Get waits for the request's context to
+// end, as a network call to a stuck backend would, and reports entering
+type blockingStore struct {
+	ProductStore
+	entered chan struct{}
+}
+
+func (s blockingStore) Get(ctx context.Context, id int) (Product, error) {
+	s.entered <- struct{}{}
+	<-ctx.Done()
+	return Product{}, ctx.Err()
+}
+
+// checkNoGoroutineLeak fails t if more goroutines are running than before
+// once the ones winding down have had a second to exit
+func checkNoGoroutineLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running, %d before the request", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientGoneCancelsStoreCall(t *testing.T) {
+	store := blockingStore{NewInMemoryStore(false), make(chan struct{})}
+	_, router := newTestAPI(t, store, nil)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/v1/products/1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(served)
+	}()
+	<-store.entered
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("handler still running a second after the client went away")
+	}
+
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("got %d, want %d", w.Code, statusClientClosedRequest)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("got body %s for a client that is gone", w.Body)
+	}
+	checkNoGoroutineLeak(t, before)
+}
+
+func TestStoreCallPastDeadlineTimesOut(t *testing.T) {
+	store := blockingStore{NewInMemoryStore(false), make(chan struct{}, 1)}
+	_, router := newTestAPI(t, store, map[string]string{"REQUEST_TIMEOUT": "50ms"})
+	before := runtime.NumGoroutine()
+
+	start := time.Now()
+	w := doRequest(router, http.MethodGet, "/v1/products/1", "")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("answered after %v with a 50ms timeout", elapsed)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got %d %s, want 504", w.Code, w.Body)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != "TIMEOUT" {
+		t.Errorf("got error %s, want TIMEOUT", code)
+	}
+	checkNoGoroutineLeak(t, before)
+}
+
+func TestInMemoryListStopsOnCanceledContext(t *testing.T) {
+	s := NewInMemoryStore(false)
+	for id := 1; id <= 100; id++ {
+		p := testProduct(id)
+		if _, err := s.Put(context.Background(), &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if items, err := s.List(ctx, ListFilter{}); !errors.Is(err, context.Canceled) || items != nil {
+		t.Errorf("got %d products, %v; want none and context.Canceled", len(items), err)
+	}
+}
+
+// stuckStore is a store whose Get ignores its context and returns only once
+// release is closed, as a call into a client library without deadlines would
+type stuckStore struct {
+	ProductStore
+	release chan struct{}
+}
+
+func (s stuckStore) Get(context.Context, int) (Product, error) {
+	<-s.release
+	return Product{}, ErrNotFound
+}
+
+// TestTimeoutReachesClientBeforeHandlerReturns checks over a real
+// connection that the 504 is on the wire at the deadline, not once a
+// handler ignoring its context finally returns
+func TestTimeoutReachesClientBeforeHandlerReturns(t *testing.T) {
+	for _, encoding := range []string{"identity", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			store := stuckStore{NewInMemoryStore(false), make(chan struct{})}
+			_, router := newTestAPI(t, store, map[string]string{"REQUEST_TIMEOUT": "100ms", "COMPRESS_MIN_BYTES": "1"})
+			srv := httptest.NewServer(router)
+			defer srv.Close()
+			defer close(store.release)
+
+			req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+"/v1/products/1", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			client := &http.Client{Timeout: 2 * time.Second}
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("reading the 504 body: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("504 arrived after %v with a 100ms timeout", elapsed)
+			}
+			if resp.StatusCode != http.StatusGatewayTimeout || errorCode(t, body) != "TIMEOUT" {
+				t.Errorf("got %d %s, want 504 TIMEOUT", resp.StatusCode, body)
+			}
+			if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+				t.Errorf("504 sent with Content-Encoding %q", ce)
+			}
+		})
+	}
+}
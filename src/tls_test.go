@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a fresh self-signed certificate for
+// 127.0.0.1 and its key as PEM files in dir, returning their paths and the
+// parsed certificate
+func writeSelfSignedCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile, cert
+}
+
+// startTLSServer serves the API over HTTPS with certs, as main does. It
+// wraps the listener itself because StartTLS would add httptest's own
+// certificate, which takes precedence over GetCertificate.
+func startTLSServer(t *testing.T, certs *certReloader) string {
+	t.Helper()
+	_, router := newTestAPI(t, NewInMemoryStore(false), nil)
+	srv := httptest.NewUnstartedServer(router)
+	srv.Listener = tls.NewListener(srv.Listener, serverTLSConfig(certs))
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return "https://" + srv.Listener.Addr().String()
+}
+
+// tlsClient trusts only roots, opening a new connection per request so each
+// one sees the server's current certificate
+func tlsClient(roots ...*x509.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	for _, c := range roots {
+		pool.AddCert(c)
+	}
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		DisableKeepAlives: true,
+	}}
+}
+
+// servedCert is the certificate the server at url presents to client
+func servedCert(t *testing.T, client *http.Client, url string) *x509.Certificate {
+	t.Helper()
+	resp, err := client.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Fatalf("got %d, TLS %v", resp.StatusCode, resp.TLS)
+	}
+	return resp.TLS.PeerCertificates[0]
+}
+
+func TestTLSServesCertificate(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedCert(t, t.TempDir(), "first")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := startTLSServer(t, certs)
+
+	if got := servedCert(t, tlsClient(cert), url); !got.Equal(cert) {
+		t.Errorf("served %q, want the self-signed %q", got.Subject.CommonName, cert.Subject.CommonName)
+	}
+	if _, err := tlsClient().Get(url + "/healthz"); err == nil {
+		t.Error("a client without the certificate in its roots connected")
+	}
+
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS11,
+	}}}
+	if _, err := old.Get(url + "/healthz"); err == nil {
+		t.Error("TLS 1.1 handshake accepted")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeSelfSignedCert(t, dir, "first")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := startTLSServer(t, certs)
+
+	// Overwrite the files in place, as a renewal would
+	_, _, second := writeSelfSignedCert(t, dir, "second")
+	client := tlsClient(first, second)
+	if got := servedCert(t, client, url); !got.Equal(first) {
+		t.Fatalf("served %q before the reload, want first", got.Subject.CommonName)
+	}
+	if err := certs.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedCert(t, client, url); !got.Equal(second) {
+		t.Errorf("served %q after the reload, want second", got.Subject.CommonName)
+	}
+
+	// A half-written renewal is refused and the loaded pair kept
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Error("reload accepted a corrupt key")
+	}
+	if got := servedCert(t, client, url); !got.Equal(second) {
+		t.Errorf("served %q after a failed reload, want second", got.Subject.CommonName)
+	}
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _, _ := writeSelfSignedCert(t, dir, "a")
+	otherDir := t.TempDir()
+	_, otherKey, _ := writeSelfSignedCert(t, otherDir, "b")
+	for _, tc := range []struct{ name, cert, key string }{
+		{"missing files", filepath.Join(dir, "none.pem"), filepath.Join(dir, "none.key")},
+		{"mismatched key", certFile, otherKey},
+	} {
+		if _, err := newCertReloader(tc.cert, tc.key); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		port         int
+		target, want string
+	}{
+		{443, "http://example.com/v1/products/1?fields=sku", "https://example.com/v1/products/1?fields=sku"},
+		{443, "http://example.com:8080/v1/products", "https://example.com/v1/products"},
+		{8443, "http://example.com:8080/health", "https://example.com:8443/health"},
+		{8443, "http://[::1]:8080/", "https://[::1]:8443/"},
+	} {
+		w := httptest.NewRecorder()
+		httpsRedirect(tc.port).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.target, nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.want {
+			t.Errorf("%s to port %d: got %d to %q, want 301 to %q", tc.target, tc.port, w.Code, w.Header().Get("Location"), tc.want)
+		}
+	}
+}
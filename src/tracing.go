@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"text/main/buildinfo"
+)
+
+// Trace exporters selectable with OTEL_TRACES_EXPORTER
+const (
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+	traceExporterOTLP   = "otlp"
+)
+
+// tracerName identifies spans created by this service's own code
+const tracerName = "text/main"
+
+// maxErrorBodyCapture is how much of a 4xx/5xx body is kept to read its
+// ErrorResponse code; error bodies are far smaller
+const maxErrorBodyCapture = 4 << 10
+
+// newTracerProvider builds the provider for cfg.TraceExporter and installs
+// it, with W3C trace context propagation, as the global one. It returns nil
+// when tracing is off. The OTLP exporter reads its endpoint and headers from
+// the standard OTEL_EXPORTER_OTLP_* variables.
+func newTracerProvider(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TraceExporter {
+	case traceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case traceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	build := buildinfo.Get()
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(build.Version),
+		attribute.String("build.commit", build.Commit),
+		attribute.String("build.time", build.BuildTime),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+// traceRequests picks the tracing middleware for the configured backend.
+// With tracing off it only calls Next, so the hot path allocates nothing.
+func (a *API) traceRequests() gin.HandlerFunc {
+	switch {
+	case a.cfg.XRayEnabled:
+		return a.xrayTracing
+	case a.tracer != nil:
+		return a.tracing
+	default:
+		return func(c *gin.Context) { c.Next() }
+	}
+}
+
+// tracing opens a server span per request, continuing any incoming
+// traceparent. Spans are named by route template so /products/1 and
+// /products/2 group together. Following the HTTP conventions only 5xx mark
+// the span as failed; any ErrorResponse code is recorded as error.type.
+func (a *API) tracing(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := a.tracer.Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(c.Request.URL.Path),
+			semconv.ClientAddress(c.ClientIP()),
+			attribute.String("request.id", requestID(c)),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	w := &errorBodyWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	status := w.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status < http.StatusBadRequest {
+		return
+	}
+	code := w.errorCode()
+	if code != "" {
+		span.SetAttributes(semconv.ErrorTypeKey.String(code))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, code)
+	}
+}
+
+// errorBodyWriter keeps the start of error responses so tracing can
+// record their ErrorResponse code
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorBodyWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorBodyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorBodyWriter) capture(b []byte) {
+	if w.Status() < http.StatusBadRequest || w.body.Len() >= maxErrorBodyCapture {
+		return
+	}
+	w.body.Write(b[:min(len(b), maxErrorBodyCapture-w.body.Len())])
+}
+
+// errorCode is the code of the error response written, if any
+func (w *errorBodyWriter) errorCode() string {
+	return errorCodeOf(w.body.Bytes())
+}
+
+// wrappedStore returns the store handlers and the gRPC server call: a.store,
+// retrying transient failures within the caller's deadline when a.retry is
+// set, behind softDeleteStore, with tracing on a wrapper recording a child
+// span per call, dropping the a.responses entries of what it writes, and
+// passing writes to a.changeSinks. Optional capabilities
+// (productExporter, statsProvider, ...) are still checked on a.baseStore.
+func (a *API) wrappedStore() ProductStore {
+	store := a.store
+	if a.retry != nil {
+		store = retryStore{next: store, policy: a.retry}
+	}
+	store = softDeleteStore{store}
+	if a.storeSpans != nil {
+		store = tracedStore{next: store, spans: a.storeSpans}
+	}
+	if a.responses != nil {
+		store = invalidatingStore{ProductStore: store, cache: a.responses}
+	}
+	return notifyingStore{ProductStore: store, sinks: a.changeSinks}
+}
+
+// storeSpanner opens one span per store call for a tracing backend. The
+// returned context carries the span, so the store's own client spans nest
+// inside it.
+type storeSpanner interface {
+	start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, storeSpan)
+}
+
+// storeSpan is an open store call span
+type storeSpan interface {
+	setAttributes(attrs ...attribute.KeyValue)
+	// end records err, unless it is ErrNotFound, and closes the span
+	end(err error)
+}
+
+// otelStoreSpans opens OpenTelemetry client spans
+type otelStoreSpans struct {
+	tracer  trace.Tracer
+	backend string
+}
+
+func (o otelStoreSpans) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, storeSpan) {
+	ctx, span := o.tracer.Start(ctx, "store."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, semconv.DBSystemKey.String(o.backend), semconv.DBOperationName(op))...),
+	)
+	return ctx, otelStoreSpan{span}
+}
+
+type otelStoreSpan struct{ span trace.Span }
+
+func (s otelStoreSpan) setAttributes(attrs ...attribute.KeyValue) { s.span.SetAttributes(attrs...) }
+
+func (s otelStoreSpan) end(err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// tracedStore wraps every ProductStore call in a span under the call's ctx
+type tracedStore struct {
+	next  ProductStore
+	spans storeSpanner
+}
+
+func (s tracedStore) Get(ctx context.Context, id int) (Product, error) {
+	ctx, span := s.spans.start(ctx, "Get", attribute.Int("product.id", id))
+	p, err := s.next.Get(ctx, id)
+	span.end(err)
+	return p, err
+}
+
+func (s tracedStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	ctx, span := s.spans.start(ctx, "GetBySKU", attribute.String("product.sku", sku))
+	p, err := s.next.GetBySKU(ctx, sku)
+	span.end(err)
+	return p, err
+}
+
+func (s tracedStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	ctx, span := s.spans.start(ctx, "GetMany", attribute.Int("product.count", len(ids)))
+	found, err := s.next.GetMany(ctx, ids)
+	span.end(err)
+	return found, err
+}
+
+func (s tracedStore) Put(ctx context.Context, p *Product) (bool, error) {
+	ctx, span := s.spans.start(ctx, "Put", attribute.Int("product.id", p.ProductID))
+	created, err := s.next.Put(ctx, p)
+	span.end(err)
+	return created, err
+}
+
+func (s tracedStore) Create(ctx context.Context, p *Product) error {
+	ctx, span := s.spans.start(ctx, "Create", attribute.Int("product.id", p.ProductID))
+	err := s.next.Create(ctx, p)
+	span.end(err)
+	return err
+}
+
+func (s tracedStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	ctx, span := s.spans.start(ctx, "PutBatch", attribute.Int("product.count", len(items)), attribute.Bool("batch.atomic", atomic))
+	errs := s.next.PutBatch(ctx, items, atomic)
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	span.setAttributes(attribute.Int("batch.failed", failed))
+	span.end(nil)
+	return errs
+}
+
+func (s tracedStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	ctx, span := s.spans.start(ctx, "Update", attribute.Int("product.id", id))
+	p, err := s.next.Update(ctx, id, fn)
+	span.end(err)
+	return p, err
+}
+
+func (s tracedStore) Delete(ctx context.Context, id int) error {
+	ctx, span := s.spans.start(ctx, "Delete", attribute.Int("product.id", id))
+	err := s.next.Delete(ctx, id)
+	span.end(err)
+	return err
+}
+
+func (s tracedStore) Restore(ctx context.Context, id int) (Product, error) {
+	ctx, span := s.spans.start(ctx, "Restore", attribute.Int("product.id", id))
+	p, err := s.next.(productRestorer).Restore(ctx, id)
+	span.end(err)
+	return p, err
+}
+
+func (s tracedStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	ctx, span := s.spans.start(ctx, "List", attribute.Int("filter.category_id", filter.CategoryID),
+		attribute.String("filter.manufacturer", filter.Manufacturer))
+	items, err := s.next.List(ctx, filter)
+	span.end(err)
+	return items, err
+}
+
+func (s tracedStore) ListWindow(ctx context.Context, filter ListFilter, limit, offset int) ([]Product, int, error) {
+	ctx, span := s.spans.start(ctx, "ListWindow", attribute.Int("filter.category_id", filter.CategoryID),
+		attribute.String("filter.manufacturer", filter.Manufacturer), attribute.Int("limit", limit), attribute.Int("offset", offset))
+	items, total, err := listWindow(ctx, s.next, filter, limit, offset)
+	span.end(err)
+	return items, total, err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTTLSeconds caps ?ttl_seconds= and X-TTL at a year
+const maxTTLSeconds = 365 * 24 * 60 * 60
+
+// expirySweepBatch is how many expiry entries the sweeper handles per hold
+// of a shard's lock, so writers to that shard wait at most one batch
+const expirySweepBatch = 256
+
+// expiringStore is implemented by stores that honour Product.ExpiresAt
+type expiringStore interface {
+	// SweepExpired removes every product expired at now and returns how
+	// many it removed
+	SweepExpired(now time.Time) int
+}
+
+// expired reports whether p has a deadline and it has passed at now
+func (p Product) expired(now time.Time) bool {
+	return p.ExpiresAt != nil && !now.Before(*p.ExpiresAt)
+}
+
+// expiryEntry schedules product id to expire at at. Entries stay behind
+// when the product is rewritten or deleted; the sweeper skips those.
+type expiryEntry struct {
+	at time.Time
+	id int
+}
+
+// expiryHeap is a min-heap of expiryEntry by at, for container/heap
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// liveCreatedAt returns the CreatedAt a write to id keeps: the stored
+// product's, or zero if there is none or it has expired. Callers must hold
+// id's shard.
+func (s *InMemoryStore) liveCreatedAt(id int, now time.Time) time.Time {
+	if old, exists := s.shardFor(id).products[id]; exists && !old.expired(now) {
+		return old.CreatedAt
+	}
+	return time.Time{}
+}
+
+// SweepExpired removes the products expired at now, one shard and at most
+// expirySweepBatch entries at a time. Reads already treat them as missing;
+// this frees their memory and SKUs and takes them out of the counts. No
+// change events are sent.
+func (s *InMemoryStore) SweepExpired(now time.Time) int {
+	removed := 0
+	for i := range s.shards {
+		for {
+			n, more, err := s.sweepShard(&s.shards[i], now)
+			removed += n
+			if err != nil {
+				slog.Error("expiry sweep failed", "shard", i, "error", err)
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	s.expiredCount.Add(int64(removed))
+	return removed
+}
+
+// sweepShard removes up to expirySweepBatch of sh's expired products and
+// reports how many went and whether more entries may be due
+func (s *InMemoryStore) sweepShard(sh *storeShard, now time.Time) (removed int, more bool, err error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for range expirySweepBatch {
+		if len(sh.expiry) == 0 || sh.expiry[0].at.After(now) {
+			return removed, false, nil
+		}
+		e := heap.Pop(&sh.expiry).(expiryEntry)
+		p, exists := sh.products[e.id]
+		if !exists || p.ExpiresAt == nil || !p.ExpiresAt.Equal(e.at) {
+			continue
+		}
+		unlock := s.lockSKUs(p.SKU, "", false)
+		err := s.deleteLocked(e.id)
+		unlock()
+		if err != nil {
+			// Keep the entry so the next sweep retries it
+			heap.Push(&sh.expiry, e)
+			return removed, false, err
+		}
+		removed++
+	}
+	return removed, true, nil
+}
+
+// runExpirySweeper calls SweepExpired every interval until ctx is done
+func runExpirySweeper(ctx context.Context, store expiringStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n := store.SweepExpired(time.Now()); n > 0 {
+				slog.Debug("expired products removed", "removed", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseTTL reads the time-to-live of a write from ?ttl_seconds= or the
+// X-TTL header and returns its deadline, nil if neither is given. It writes
+// a 400 if the value is not a whole number of seconds between 1 and a year
+// or the two disagree, and a 501 if the store can't expire products. The
+// bool result reports whether to continue.
+func (a *API) parseTTL(c *gin.Context) (*time.Time, bool) {
+	raw, present := c.GetQuery("ttl_seconds")
+	if header := c.GetHeader("X-TTL"); header != "" {
+		if present && strings.TrimSpace(raw) != strings.TrimSpace(header) {
+			writeError(c, http.StatusBadRequest, ErrorResponse{
+				Error:     "INVALID_INPUT",
+				Message:   "Conflicting TTL",
+				Details:   "ttl_seconds and X-TTL disagree; send one of them",
+				RequestID: requestID(c),
+			})
+			return nil, false
+		}
+		raw, present = header, true
+	}
+	if !present {
+		return nil, true
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || seconds < 1 || seconds > maxTTLSeconds {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Invalid TTL",
+			Details:   "ttl_seconds (or X-TTL) must be a whole number of seconds between 1 and " + strconv.Itoa(maxTTLSeconds),
+			RequestID: requestID(c),
+		})
+		return nil, false
+	}
+	if _, ok := a.store.(expiringStore); !ok {
+		writeError(c, http.StatusNotImplemented, ErrorResponse{
+			Error:     "NOT_IMPLEMENTED",
+			Message:   "Products cannot expire",
+			Details:   "ttl_seconds needs STORE_BACKEND=memory",
+			RequestID: requestID(c),
+		})
+		return nil, false
+	}
+	deadline := time.Now().UTC().Add(time.Duration(seconds) * time.Second)
+	return &deadline, true
+}
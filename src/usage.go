@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anonymousKeyID is the usage bucket of requests made without a valid API key
+const anonymousKeyID = "anonymous"
+
+// Quota is one API key's budget; a zero field means no limit
+type Quota struct {
+	DailyRequests int
+	RPS           float64
+}
+
+// parseQuotas reads API_KEY_QUOTAS entries, each "id:daily:rps" with either
+// number left empty to keep def's. id must name a key in keys or be
+// anonymousKeyID.
+func parseQuotas(entries []string, keys []APIKey, def Quota) (map[string]Quota, error) {
+	quotas := make(map[string]Quota, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			errs = append(errs, errors.New("API_KEY_QUOTAS: "+entry+": must be id:daily:rps"))
+			continue
+		}
+		id := parts[0]
+		if id != anonymousKeyID && !slices.ContainsFunc(keys, func(k APIKey) bool { return k.ID == id }) {
+			errs = append(errs, errors.New("API_KEY_QUOTAS: "+id+" is not an API_KEYS id or "+anonymousKeyID))
+			continue
+		}
+		q := def
+		if parts[1] != "" {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n < 0 {
+				errs = append(errs, errors.New("API_KEY_QUOTAS: "+entry+": daily must be a whole number >= 0"))
+				continue
+			}
+			q.DailyRequests = n
+		}
+		if parts[2] != "" {
+			rps, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil || rps < 0 || math.IsInf(rps, 0) {
+				errs = append(errs, errors.New("API_KEY_QUOTAS: "+entry+": rps must be a number >= 0"))
+				continue
+			}
+			q.RPS = rps
+		}
+		quotas[id] = q
+	}
+	return quotas, errors.Join(errs...)
+}
+
+// keyUsage is one key's counters for the UTC day starting at day, and its
+// per-second allowance, which outlasts the day
+type keyUsage struct {
+	day      time.Time
+	requests int
+	errors   int
+	rejected int
+	bucket   *tokenBucket
+}
+
+// usageTracker counts requests per API key ID and enforces their quotas.
+// The counters live as long as the process: a reload only swaps the quotas.
+type usageTracker struct {
+	mu     sync.Mutex
+	def    Quota
+	quotas map[string]Quota
+	keys   map[string]*keyUsage
+}
+
+func newUsageTracker(cfg Config) *usageTracker {
+	return &usageTracker{def: cfg.QuotaDefault, quotas: cfg.Quotas, keys: make(map[string]*keyUsage)}
+}
+
+// setQuotas replaces the quotas in force, keeping every counter
+func (t *usageTracker) setQuotas(def Quota, quotas map[string]Quota) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.def, t.quotas = def, quotas
+}
+
+func (t *usageTracker) quotaLocked(id string) Quota {
+	if q, ok := t.quotas[id]; ok {
+		return q
+	}
+	return t.def
+}
+
+// usageLocked returns id's counters, reset if a new UTC day began since
+// they were last touched. Callers must hold mu.
+func (t *usageTracker) usageLocked(id string, now time.Time) *keyUsage {
+	day := utcDay(now)
+	u, ok := t.keys[id]
+	if !ok {
+		u = &keyUsage{day: day}
+		t.keys[id] = u
+	}
+	if !u.day.Equal(day) {
+		u.day, u.requests, u.errors, u.rejected = day, 0, 0, 0
+	}
+	return u
+}
+
+// admit counts a request by id against its quota. When the quota is blown
+// the request is counted as rejected instead, and resetAt is when a retry
+// can succeed: the next UTC midnight for the daily budget, the next token
+// for the per-second rate.
+func (t *usageTracker) admit(id string, now time.Time) (ok bool, resetAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usageLocked(id, now)
+	q := t.quotaLocked(id)
+	if q.DailyRequests > 0 && u.requests >= q.DailyRequests {
+		u.rejected++
+		return false, u.day.AddDate(0, 0, 1)
+	}
+	if q.RPS > 0 {
+		// The same token bucket as RATE_LIMIT_RPS, bursting to one second's worth
+		burst := math.Max(1, math.Ceil(q.RPS))
+		if u.bucket == nil {
+			u.bucket = &tokenBucket{tokens: burst, last: now}
+		}
+		b := u.bucket
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*q.RPS)
+		b.last = now
+		if b.tokens < 1 {
+			u.rejected++
+			return false, now.Add(time.Duration((1 - b.tokens) / q.RPS * float64(time.Second)))
+		}
+		b.tokens--
+	}
+	u.requests++
+	return true, time.Time{}
+}
+
+// finish records the status an admitted request by id was answered with
+func (t *usageTracker) finish(id string, status int, now time.Time) {
+	if status < http.StatusBadRequest {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usageLocked(id, now).errors++
+}
+
+// KeyUsage is one API key's entry in GET /admin/usage
+type KeyUsage struct {
+	KeyID    string `json:"key_id"`
+	Requests int    `json:"requests"`
+	// Admitted requests answered 4xx or 5xx
+	Errors int `json:"errors"`
+	// Requests refused with QUOTA_EXCEEDED, not counted in Requests
+	Rejected     int     `json:"rejected"`
+	DailyLimit   int     `json:"daily_limit"`
+	Remaining    *int    `json:"remaining"`
+	RateLimitRPS float64 `json:"rate_limit_rps"`
+}
+
+// UsageResponse is the body of GET /admin/usage
+type UsageResponse struct {
+	Day      string     `json:"day"`
+	ResetsAt time.Time  `json:"resets_at"`
+	Keys     []KeyUsage `json:"keys"`
+}
+
+// snapshot returns today's usage of every key in ids and every other key
+// seen, sorted by key ID
+func (t *usageTracker) snapshot(ids []string, now time.Time) UsageResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	day := utcDay(now)
+	for id := range t.keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	resp := UsageResponse{Day: day.Format(time.DateOnly), ResetsAt: day.AddDate(0, 0, 1), Keys: make([]KeyUsage, 0, len(ids))}
+	for _, id := range ids {
+		q := t.quotaLocked(id)
+		ku := KeyUsage{KeyID: id, DailyLimit: q.DailyRequests, RateLimitRPS: q.RPS}
+		if u, ok := t.keys[id]; ok && u.day.Equal(day) {
+			ku.Requests, ku.Errors, ku.Rejected = u.requests, u.errors, u.rejected
+		}
+		if q.DailyRequests > 0 {
+			remaining := max(0, q.DailyRequests-ku.Requests)
+			ku.Remaining = &remaining
+		}
+		resp.Keys = append(resp.Keys, ku)
+	}
+	return resp
+}
+
+// utcDay returns the midnight UTC starting t's day
+func utcDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// enforceQuota counts every request against the quota of the API key that
+// made it, anonymousKeyID without one, and answers 429 QUOTA_EXCEEDED with
+// Retry-After once the key's daily budget or per-second rate is used up.
+// Probes and the operator endpoints are neither limited nor counted.
+func (a *API) enforceQuota(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" || isAdminRoute(route) || rateLimitExempt[route] || route == "/stats" {
+		c.Next()
+		return
+	}
+	id := c.GetString(apiKeyIDKey)
+	if id == "" {
+		id = anonymousKeyID
+	}
+
+	ok, resetAt := a.usage.admit(id, time.Now())
+	if !ok {
+		seconds := max(1, int(math.Ceil(time.Until(resetAt).Seconds())))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		abortWithError(c, http.StatusTooManyRequests, ErrorResponse{
+			Error:     "QUOTA_EXCEEDED",
+			Message:   "API key quota exceeded",
+			Details:   "Quota of " + id + " is used up until " + resetAt.UTC().Format(time.RFC3339),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	c.Next()
+	a.usage.finish(id, c.Writer.Status(), time.Now())
+}
+
+// getUsage handles GET /admin/usage
+// Returns 200 with today's (UTC) requests, errors, rejections and remaining
+// daily quota per API key, anonymous included. Counts start over each UTC
+// day and on restart, but not on a reload.
+func (a *API) getUsage(c *gin.Context) {
+	ids := []string{anonymousKeyID}
+	for _, k := range a.cfg.APIKeys {
+		ids = append(ids, k.ID)
+	}
+	c.JSON(http.StatusOK, a.usage.snapshot(ids, time.Now()))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// errEmptyBody is returned by decodeJSON for a missing or blank body
+var errEmptyBody = errors.New("request body is empty")
+
+// errTrailingData is returned by decodeJSON in strict mode when the body
+// holds more than one JSON value
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// errInvalidUTF8 is returned by decodeJSON for a body that is not UTF-8,
+// which encoding/json would otherwise quietly decode with U+FFFD in place
+// of the bad bytes
+var errInvalidUTF8 = errors.New("request body is not valid UTF-8")
+
+// duplicateKeyError reports an object key that appears twice, which strict
+// decoding rejects because only the last occurrence would take effect
+type duplicateKeyError struct {
+	Field string
+}
+
+func (e *duplicateKeyError) Error() string {
+	return "duplicate field " + strconv.Quote(e.Field)
+}
+
+// FieldError describes one field of a request body that failed validation
+type FieldError struct {
+	Field      string `json:"field" xml:"name"`
+	Constraint string `json:"constraint" xml:"constraint"`
+	Value      any    `json:"value" xml:"value"`
+}
+
+// defaultSKUPattern is SKU_PATTERN when unset: uppercase letters and digits
+// in dash-separated groups, such as ABC-12345
+const defaultSKUPattern = `[A-Z0-9]+(-[A-Z0-9]+)*`
+
+// textConstraint is reported for strings a product may not hold
+const textConstraint = "must be valid UTF-8 without control characters"
+
+// normalizeProduct puts the text fields of p into canonical form before
+// validation and storage: Unicode NFC, so one SKU typed with precomposed or
+// combining accents is one SKU, and no surrounding whitespace on the
+// single-line fields
+func normalizeProduct(p *Product) {
+	p.SKU = normalizeLine(p.SKU)
+	p.Manufacturer = normalizeLine(p.Manufacturer)
+	p.Name = normalizeLine(p.Name)
+	p.Description = norm.NFC.String(p.Description)
+}
+
+// normalizeLine trims and NFC-normalizes a single-line text value
+func normalizeLine(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+// validText reports whether s is valid UTF-8 free of control characters;
+// multiline text may also contain newlines and tabs
+func validText(s string, multiline bool) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) && !(multiline && (r == '\n' || r == '\r' || r == '\t')) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateProduct checks all field constraints from the api.yaml schema and
+// returns every violation, or nil if p is valid. Lengths count characters,
+// not bytes.
+func validateProduct(p Product) []FieldError {
+	var errs []FieldError
+	check := func(ok bool, field, constraint string, value any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Constraint: constraint, Value: value})
+		}
+	}
+	checkLength := func(s string, minLen, maxLen int, field, constraint string) {
+		n := utf8.RuneCountInString(s)
+		check(n >= minLen && n <= maxLen, field, constraint, s)
+	}
+	check(p.ProductID >= 1, "product_id", "must be >= 1", p.ProductID)
+	checkLength(p.SKU, 1, 100, "sku", "must be between 1 and 100 characters")
+	check(validText(p.SKU, false), "sku", textConstraint, p.SKU)
+	checkLength(p.Manufacturer, 1, 200, "manufacturer", "must be between 1 and 200 characters")
+	check(validText(p.Manufacturer, false), "manufacturer", textConstraint, p.Manufacturer)
+	check(p.CategoryID >= 1, "category_id", "must be >= 1", p.CategoryID)
+	check(p.Weight >= 0, "weight", "must be >= 0", p.Weight)
+	check(p.SomeOtherID >= 1, "some_other_id", "must be >= 1", p.SomeOtherID)
+	check(p.Quantity >= 0, "quantity", "must be >= 0", p.Quantity)
+	checkLength(p.Name, 0, 200, "name", "must be at most 200 characters")
+	check(validText(p.Name, false), "name", textConstraint, p.Name)
+	checkLength(p.Description, 0, 2000, "description", "must be at most 2000 characters")
+	check(validText(p.Description, true), "description", textConstraint, p.Description)
+	if p.Price != nil {
+		check(*p.Price >= 0, "price", "must be >= 0", *p.Price)
+		check(isCurrencyCode(p.Currency), "currency", "must be a 3-letter uppercase ISO 4217 code when price is set", p.Currency)
+	} else {
+		check(p.Currency == "", "currency", "must only be set together with price", p.Currency)
+	}
+	return errs
+}
+
+// isCurrencyCode reports whether s looks like an ISO 4217 code such as USD
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for i := range len(s) {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateWrite is validateProduct plus the SKU_PATTERN rule. The pattern
+// only applies to SKUs being written: storedSKU is the SKU the product
+// already has, if any, and keeping it is allowed even if it predates the rule.
+func (a *API) validateWrite(p Product, storedSKU string) []FieldError {
+	errs := validateProduct(p)
+	if re := a.cfg.SKUPattern; re != nil && p.SKU != "" && p.SKU != storedSKU && !re.MatchString(p.SKU) {
+		source := strings.TrimSuffix(strings.TrimPrefix(re.String(), "^(?:"), ")$")
+		errs = append(errs, FieldError{Field: "sku", Constraint: "must match " + source, Value: p.SKU})
+	}
+	return errs
+}
+
+// fieldErrorsDetails joins field errors into the one-line Details string,
+// e.g. "sku must be between 1 and 100 characters; weight must be >= 0"
+func fieldErrorsDetails(errs []FieldError) string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.Field + " " + e.Constraint
+	}
+	return strings.Join(parts, "; ")
+}
+
+// decodeJSON decodes body into v. In strict mode unknown fields, repeated
+// keys and trailing data after the value are rejected as well.
+func decodeJSON(body []byte, v any, strict bool) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return errEmptyBody
+	}
+	if !utf8.Valid(body) {
+		return errInvalidUTF8
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		var dup *duplicateKeyError
+		if err := checkDuplicateKeys(json.NewDecoder(bytes.NewReader(body)), ""); errors.As(err, &dup) {
+			return err
+		}
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if strict && dec.More() {
+		return errTrailingData
+	}
+	return nil
+}
+
+// checkDuplicateKeys walks one JSON value and returns *duplicateKeyError for
+// the first object, at any depth, that repeats a key. Any other error, from
+// malformed or truncated JSON, ends the walk; the real decode reports it.
+func checkDuplicateKeys(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		// More keeps reporting another value after a truncated array, so
+		// the error has to stop the loops below
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyTok.(string)
+			field := joinFieldPath(path, key)
+			if seen[key] {
+				return &duplicateKeyError{Field: field}
+			}
+			seen[key] = true
+			if err := checkDuplicateKeys(dec, field); err != nil {
+				return err
+			}
+		}
+		dec.Token() // closing brace
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := checkDuplicateKeys(dec, joinFieldPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		dec.Token() // closing bracket
+	}
+	return nil
+}
+
+// joinFieldPath builds the dotted field names used in FieldError, matching
+// the form encoding/json reports ("0.product_id")
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// describeDecodeError explains why a request body could not be decoded.
+// A value of the wrong JSON type, an unknown field or a repeated key is
+// reported against its field; anything else (malformed JSON, empty body)
+// yields a message and no fields.
+func describeDecodeError(err error) (string, []FieldError) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fe := FieldError{
+			Field:      typeErr.Field,
+			Constraint: "must be " + jsonTypeName(typeErr.Type),
+			Value:      typeErr.Value,
+		}
+		return fieldErrorsDetails([]FieldError{fe}) + ", got " + typeErr.Value, []FieldError{fe}
+	}
+	var dupErr *duplicateKeyError
+	if errors.As(err, &dupErr) {
+		fe := FieldError{Field: dupErr.Field, Constraint: "must appear only once"}
+		return fieldErrorsDetails([]FieldError{fe}), []FieldError{fe}
+	}
+	// encoding/json has no typed error for DisallowUnknownFields
+	if rest, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		name, uerr := strconv.Unquote(rest)
+		if uerr != nil {
+			name = rest
+		}
+		fe := FieldError{Field: name, Constraint: "is not a recognized field"}
+		return fieldErrorsDetails([]FieldError{fe}), []FieldError{fe}
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return "malformed JSON: " + syntaxErr.Error(), nil
+	}
+	return err.Error(), nil
+}
+
+// jsonTypeName names the JSON type a Go type decodes from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct:
+		if t.String() == "time.Time" {
+			return "an RFC 3339 timestamp"
+		}
+		return "an object"
+	case reflect.Map:
+		return "an object"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// decodeSeeds are request bodies that have tripped JSON decoders before
+var decodeSeeds = []string{
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1}`,
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1,"price":250,"currency":"USD"}`,
+	"",
+	"null",
+	"{}",
+	// Truncated
+	`{"product_id":1,"sku":"SK`,
+	`{"product_id":`,
+	`{`,
+	// More reported another element after the input ended, and the
+	// duplicate-key walk never returned
+	`[`,
+	`[[[`,
+	`{"a":[`,
+	// Duplicate keys, at the top and nested
+	`{"product_id":1,"product_id":2,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1}`,
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1,"x":{"a":1,"a":2}}`,
+	// Numbers beyond int
+	`{"product_id":1e400,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1}`,
+	`{"product_id":99999999999999999999999,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1}`,
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":-9223372036854775809,"some_other_id":1}`,
+	`{"product_id":1.5,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1}`,
+	// Invalid UTF-8, raw and escaped
+	"{\"product_id\":1,\"sku\":\"SKU-\xff\",\"manufacturer\":\"Acme\",\"category_id\":1,\"weight\":10,\"some_other_id\":1}",
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"\ud800","category_id":1,"weight":10,"some_other_id":1}`,
+	"{\"product_id\":1,\"sku\":\"SKU-1\",\"manufacturer\":\"Ac\x00me\",\"category_id\":1,\"weight\":10,\"some_other_id\":1}",
+	// Deep nesting
+	strings.Repeat("[", 20000) + strings.Repeat("]", 20000),
+	strings.Repeat(`{"a":`, 20000),
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1,"x":` + strings.Repeat("[", 5000) + strings.Repeat("]", 5000) + `}`,
+	// Trailing data
+	`{"product_id":1,"sku":"SKU-1","manufacturer":"Acme","category_id":1,"weight":10,"some_other_id":1} {}`,
+}
+
+// FuzzDecodeProduct sends arbitrary bodies to POST /products/1/details. Each
+// must be answered, not hang, and the answer must be 201 or 204 exactly
+// when the body decodes to a valid product 1, and otherwise a structured
+// 400.
+func FuzzDecodeProduct(f *testing.F) {
+	for _, seed := range decodeSeeds {
+		f.Add([]byte(seed))
+	}
+	a, router := newTestAPI(f, NewInMemoryStore(false), nil)
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		if int64(len(body)) > a.cfg.MaxBodyBytes {
+			return // 413, not what this target is about
+		}
+		var p Product
+		valid := decodeJSON(body, &p, a.cfg.StrictJSON) == nil
+		if valid {
+			normalizeProduct(&p)
+			valid = a.validateWrite(p, "") == nil && p.ProductID == 1
+		}
+
+		answered := make(chan *http.Response, 1)
+		go func() {
+			answered <- doRequest(router, http.MethodPost, "/v1/products/1/details", string(body), "Content-Type", "application/json").Result()
+		}()
+		var resp *http.Response
+		select {
+		case resp = <-answered:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no answer after 10s for %q", body)
+		}
+
+		switch {
+		case valid && (resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusNoContent):
+		case !valid && resp.StatusCode == http.StatusBadRequest:
+			var e ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+				t.Fatalf("400 body is not an ErrorResponse: %v", err)
+			}
+			if e.Error != "INVALID_INPUT" || e.Message == "" || e.RequestID == "" {
+				t.Fatalf("400 body %+v, want INVALID_INPUT with a message and request_id", e)
+			}
+		default:
+			t.Fatalf("got %d for %q, which is %s", resp.StatusCode, body, map[bool]string{true: "a valid product", false: "invalid"}[valid])
+		}
+	})
+}
+
+// productFields are the JSON names validateProduct may report errors for
+var productFields = map[string]bool{
+	"product_id": true, "sku": true, "manufacturer": true, "category_id": true, "weight": true,
+	"some_other_id": true, "quantity": true, "name": true, "description": true, "price": true, "currency": true,
+}
+
+// FuzzValidateProduct checks validateProduct on arbitrary field values: a
+// product it accepts meets every constraint in api.yaml and survives a
+// JSON round trip unchanged and still valid
+func FuzzValidateProduct(f *testing.F) {
+	f.Add(1, "SKU-1", "Acme", 1, 10, 1, "", "", 0, false, "", 0)
+	f.Add(1, " é ", "Ac\x00me", 1, -1, 1, strings.Repeat("n", 201), "line\nbreak", 5, true, "usd", -1)
+	f.Add(0, "\xff", "", 0, 0, 0, "\t", "\x7f", -1, true, "EURO", 3)
+	f.Add(1, strings.Repeat("ß", 100), strings.Repeat("é", 200), 1<<31, 1<<62, 1, "", strings.Repeat("x", 2000), 1<<40, true, "JPY", 1<<30)
+
+	f.Fuzz(func(t *testing.T, id int, sku, manufacturer string, category, weight, other int, name, description string, price int, hasPrice bool, currency string, quantity int) {
+		p := Product{ProductID: id, SKU: sku, Manufacturer: manufacturer, CategoryID: category, Weight: weight,
+			SomeOtherID: other, Name: name, Description: description, Currency: currency, Quantity: quantity}
+		if hasPrice {
+			p.Price = &price
+		}
+		normalizeProduct(&p)
+		once := p
+		normalizeProduct(&p)
+		if p.SKU != once.SKU || p.Manufacturer != once.Manufacturer || p.Name != once.Name || p.Description != once.Description {
+			t.Fatalf("normalizeProduct is not idempotent: %+v then %+v", once, p)
+		}
+
+		errs := validateProduct(p)
+		for _, e := range errs {
+			if !productFields[e.Field] {
+				t.Fatalf("field error names unknown field %q", e.Field)
+			}
+		}
+		if errs != nil {
+			return
+		}
+		runes := utf8.RuneCountInString
+		if p.ProductID < 1 || p.CategoryID < 1 || p.SomeOtherID < 1 || p.Weight < 0 || p.Quantity < 0 ||
+			runes(p.SKU) < 1 || runes(p.SKU) > 100 || runes(p.Manufacturer) < 1 || runes(p.Manufacturer) > 200 ||
+			runes(p.Name) > 200 || runes(p.Description) > 2000 ||
+			!validText(p.SKU, false) || !validText(p.Manufacturer, false) || !validText(p.Name, false) || !validText(p.Description, true) ||
+			(p.Price == nil) != (p.Currency == "") || (p.Price != nil && *p.Price < 0) {
+			t.Fatalf("accepted %+v", p)
+		}
+
+		body, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Product
+		if err := decodeJSON(body, &back, true); err != nil {
+			t.Fatalf("valid product does not decode back: %v", err)
+		}
+		if !sameProduct(back, p) || validateProduct(back) != nil {
+			t.Fatalf("round trip changed %+v into %+v", p, back)
+		}
+	})
+}
+
+func TestValidateProductText(t *testing.T) {
+	with := func(edit func(p *Product)) Product {
+		p := testProduct(1)
+		edit(&p)
+		return p
+	}
+	for _, tc := range []struct {
+		name  string
+		p     Product
+		field string // the one field reported, "" if valid
+	}{
+		// 450 bytes, which a byte count would have rejected
+		{"multibyte within the limit", with(func(p *Product) { p.Manufacturer = strings.Repeat("日本", 75) }), ""},
+		{"multibyte over the limit", with(func(p *Product) { p.Manufacturer = strings.Repeat("日", 201) }), "manufacturer"},
+		{"emoji counted as characters", with(func(p *Product) { p.Name = strings.Repeat("\U0001F680", 201) }), "name"},
+		{"combining characters counted separately", with(func(p *Product) { p.Name = strings.Repeat("e\u0301", 101) }), "name"},
+		{"newline in description", with(func(p *Product) { p.Description = "one\r\ntwo\tthree" }), ""},
+		{"newline in name", with(func(p *Product) { p.Name = "one\ntwo" }), "name"},
+		{"control character", with(func(p *Product) { p.Manufacturer = "Ac\x07me" }), "manufacturer"},
+		{"C1 control character", with(func(p *Product) { p.Description = "a\u0085b" }), "description"},
+		{"invalid UTF-8", with(func(p *Product) { p.Manufacturer = "Acme \xff" }), "manufacturer"},
+		{"truncated sequence", with(func(p *Product) { p.SKU = "SKU-\xe6\x97" }), "sku"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := validateProduct(tc.p)
+			switch {
+			case tc.field == "" && errs != nil:
+				t.Errorf("got %v, want valid", errs)
+			case tc.field != "" && (len(errs) != 1 || errs[0].Field != tc.field):
+				t.Errorf("got %v, want one error on %s", errs, tc.field)
+			}
+		})
+	}
+}
+
+func TestNormalizeProduct(t *testing.T) {
+	p := Product{SKU: " CAFE\u0301-1\t", Manufacturer: " Cre\u0300me ", Name: " n ", Description: " e\u0301 \n"}
+	normalizeProduct(&p)
+	want := Product{SKU: "CAF\u00c9-1", Manufacturer: "Cr\u00e8me", Name: "n", Description: " \u00e9 \n"}
+	if p != want {
+		t.Errorf("got %+q, want %+q", []string{p.SKU, p.Manufacturer, p.Name, p.Description},
+			[]string{want.SKU, want.Manufacturer, want.Name, want.Description})
+	}
+}
+
+func TestProductTextThroughAPI(t *testing.T) {
+	router := seededRouter(t, 0, map[string]string{"SKU_PATTERN": "none"})
+	body := func(id int, sku, manufacturer string) string {
+		p := testProduct(id)
+		p.SKU, p.Manufacturer = sku, manufacturer
+		return productJSON(p)
+	}
+
+	// Composed and decomposed forms of one SKU are one SKU
+	if w := doRequest(router, http.MethodPost, "/v1/products/1/details", body(1, " CAFE\u0301 ", "日本語メーカー")); w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	if w := doRequest(router, http.MethodPost, "/v1/products/2/details", body(2, "CAF\u00c9", "Acme")); w.Code != http.StatusConflict || errorCode(t, w.Body.Bytes()) != "DUPLICATE_SKU" {
+		t.Errorf("composed duplicate: got %d %s, want 409 DUPLICATE_SKU", w.Code, w.Body)
+	}
+	for _, sku := range []string{"CAF\u00c9", "CAFE\u0301", "CAF%C3%89", "CAFE%CC%81"} {
+		w := doRequest(router, http.MethodGet, "/v1/products/sku/"+sku, "")
+		var p Product
+		if err := json.Unmarshal(w.Body.Bytes(), &p); w.Code != http.StatusOK || err != nil || p.SKU != "CAF\u00c9" || p.Manufacturer != "日本語メーカー" {
+			t.Errorf("GET by SKU %+q: got %d %s", sku, w.Code, w.Body)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, body, details string
+	}{
+		{"invalid UTF-8", body(3, "SKU-3", "Acme \xff\xfe"), "request body is not valid UTF-8"},
+		{"overlong encoding", body(3, "SKU-3", "Acme \xc0\xaf"), "request body is not valid UTF-8"},
+		{"escaped control character", body(3, "SKU-3", `Acme\u001b[31m`), "manufacturer " + textConstraint},
+		{"over 200 characters", body(3, "SKU-3", strings.Repeat("é", 201)), "manufacturer must be between 1 and 200 characters"},
+	} {
+		w := doRequest(router, http.MethodPost, "/v1/products/3/details", tc.body)
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Error != "INVALID_INPUT" || resp.Details != tc.details {
+			t.Errorf("%s: got %d %s, want 400 with details %q", tc.name, w.Code, w.Body, tc.details)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resource routes are mounted under /v<N>, one group per API version, each
+// free to use its own request and response schemas over the same store.
+// The unversioned paths the API first shipped at stay as deprecated aliases
+// of v1 until LEGACY_ROUTES_SUNSET.
+const (
+	apiV1       = "1"
+	apiV1Prefix = "/v" + apiV1
+)
+
+// legacyRoutesDeprecatedAt is when the unversioned aliases were deprecated,
+// sent in their Deprecation header
+var legacyRoutesDeprecatedAt = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// versionPrefix matches the /v<N> segment a versioned route starts with
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+(/|$)`)
+
+// routeTemplate strips the version from route, e.g. /v1/products/:productId
+// becomes /products/:productId, so per-route settings keyed by template
+// apply to every version and to the aliases alike
+func routeTemplate(route string) string {
+	if loc := versionPrefix.FindStringIndex(route); loc != nil {
+		return "/" + route[loc[1]:]
+	}
+	return route
+}
+
+// routePrefix returns the version prefix of c's route, "" for an alias, for
+// building links that stay on the version the client called
+func routePrefix(c *gin.Context) string {
+	route := c.FullPath()
+	return strings.TrimSuffix(route, routeTemplate(route))
+}
+
+// registerV1 mounts the v1 resource routes on g
+func (a *API) registerV1(g gin.IRoutes) {
+	g.GET("/products", a.listProducts)
+	g.GET("/products/:productId", a.getProduct)
+	g.GET("/products/sku/:sku", a.getProductBySKU)
+	g.GET("/products/export", a.exportProducts)
+	g.GET("/products/stream", a.streamProducts)
+	g.GET("/products/:productId/history", a.productHistory)
+	g.POST("/products/:productId/details", a.addProductDetails)
+	g.POST("/products/:productId/quantity/adjust", a.adjustProductQuantity)
+	g.POST("/products/:productId/restore", a.restoreProduct)
+	g.POST("/products/batch", a.addProductsBatch)
+	g.POST("/products/import", a.importProducts)
+	g.PATCH("/products/:productId", a.patchProduct)
+	g.DELETE("/products/:productId", a.deleteProduct)
+	g.GET("/manufacturers", a.listManufacturers)
+	g.GET("/manufacturers/:name/products", a.listManufacturerProducts)
+	g.GET("/categories", a.listCategories)
+	g.GET("/categories/:categoryId", a.getCategory)
+	g.GET("/categories/:categoryId/products", a.listCategoryProducts)
+	g.POST("/categories", a.createCategory)
+	g.DELETE("/categories/:categoryId", a.deleteCategory)
+}
+
+// apiVersion reports the version a route group serves in X-API-Version
+func apiVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-API-Version", version)
+		c.Next()
+	}
+}
+
+// deprecateAlias marks a response from an unversioned alias as deprecated
+// (RFC 9745) with the date it stops being served (RFC 8594), and links the
+// v1 path replacing it
+func (a *API) deprecateAlias(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("Deprecation", "@"+strconv.FormatInt(legacyRoutesDeprecatedAt.Unix(), 10))
+	h.Set("Sunset", a.cfg.LegacyRoutesSunset.UTC().Format(http.TimeFormat))
+	h.Set("Link", "<"+apiV1Prefix+c.Request.URL.Path+`>; rel="successor-version"`)
+	c.Next()
+}
+
+// legacyAliases lists the routes that are unversioned aliases of a v1
+// route, which api.yaml documents only under /v1
+func legacyAliases(routes gin.RoutesInfo) map[string]bool {
+	registered := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		registered[r.Method+" "+r.Path] = true
+	}
+	aliases := make(map[string]bool)
+	for _, r := range routes {
+		if registered[r.Method+" "+apiV1Prefix+r.Path] {
+			aliases[r.Path] = true
+		}
+	}
+	return aliases
+}
+
+// specRoute returns the route api.yaml documents route under
+func (a *API) specRoute(route string) string {
+	if a.legacyAliases[route] {
+		return apiV1Prefix + route
+	}
+	return route
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// comparableBody decodes a JSON body without the fields that differ
+// between two otherwise identical runs: timestamps and request IDs
+func comparableBody(t *testing.T, body []byte) any {
+	t.Helper()
+	if len(body) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("%v in %s", err, body)
+	}
+	var strip func(v any)
+	strip = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for _, key := range []string{"created_at", "updated_at", "deleted_at", "request_id"} {
+				delete(v, key)
+			}
+			for _, e := range v {
+				strip(e)
+			}
+		case []any:
+			for _, e := range v {
+				strip(e)
+			}
+		}
+	}
+	strip(v)
+	return v
+}
+
+// TestLegacyAliasesMatchV1 replays the same requests against /v1 and the
+// unversioned aliases on two identical stores, and checks the answers only
+// differ by the alias's deprecation headers
+func TestLegacyAliasesMatchV1(t *testing.T) {
+	v1, alias := seededRouter(t, 3, nil), seededRouter(t, 3, nil)
+	sunset := time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	deprecation := "@" + strconv.FormatInt(legacyRoutesDeprecatedAt.Unix(), 10)
+
+	for _, step := range []struct{ method, path, body string }{
+		{"GET", "/products?limit=2", ""},
+		{"GET", "/products/1", ""},
+		{"GET", "/products/sku/SKU-2", ""},
+		{"GET", "/products/99", ""},
+		{"GET", "/products/abc", ""},
+		{"POST", "/products/4/details", productJSON(testProduct(4))},
+		{"POST", "/products/1/details", `{"product_id":1,"sku":"","weight":-1}`},
+		{"PATCH", "/products/1", `{"weight":3}`},
+		{"POST", "/products/1/quantity/adjust", `{"delta":5}`},
+		{"POST", "/products/batch", "[" + productJSON(testProduct(5)) + "]"},
+		{"DELETE", "/products/2", ""},
+		{"GET", "/products/2", ""},
+		{"POST", "/products/2/restore", ""},
+		{"GET", "/products?limit=10", ""},
+		{"GET", "/manufacturers", ""},
+		{"GET", "/categories", ""},
+		{"GET", "/manufacturers/Acme/products", ""},
+		{"PUT", "/products/1", productJSON(testProduct(1))},
+	} {
+		name := step.method + " " + step.path
+		want := doRequest(v1, step.method, apiV1Prefix+step.path, step.body)
+		got := doRequest(alias, step.method, step.path, step.body)
+		if got.Code != want.Code {
+			t.Errorf("%s: alias got %d, /v1 got %d", name, got.Code, want.Code)
+			continue
+		}
+		// Error details echo the path called, which is the one difference
+		path, _, _ := strings.Cut(step.path, "?")
+		wantBody := strings.ReplaceAll(want.Body.String(), apiV1Prefix+path, path)
+		if g, w := comparableBody(t, got.Body.Bytes()), comparableBody(t, []byte(wantBody)); !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: alias answered %s\n/v1 answered %s", name, got.Body, want.Body)
+		}
+		for _, key := range []string{"Content-Type", "Allow", "X-API-Version"} {
+			if g, w := got.Header().Get(key), want.Header().Get(key); g != w {
+				t.Errorf("%s: alias %s %q, /v1 %q", name, key, g, w)
+			}
+		}
+		if g, w := got.Header().Get("Location"), want.Header().Get("Location"); apiV1Prefix+g != w && g != w {
+			t.Errorf("%s: alias Location %q, /v1 %q", name, g, w)
+		}
+
+		if want.Code == http.StatusMethodNotAllowed {
+			continue // no route matched, so neither group's middleware ran
+		}
+		for key, value := range map[string]string{
+			"Deprecation": deprecation,
+			"Sunset":      sunset,
+			"Link":        "<" + apiV1Prefix + path + `>; rel="successor-version"`,
+		} {
+			if g := got.Header().Get(key); g != value {
+				t.Errorf("%s: alias %s %q, want %q", name, key, g, value)
+			}
+			if w := want.Header().Get(key); w != "" {
+				t.Errorf("%s: /v1 sent %s %q", name, key, w)
+			}
+		}
+	}
+}
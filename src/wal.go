@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WAL operations
+const (
+	walOpPut    = "put"
+	walOpDelete = "delete"
+	walOpClear  = "clear"
+)
+
+// walRecord is one line of the write-ahead log
+type walRecord struct {
+	Seq       uint64   `json:"seq"`
+	Op        string   `json:"op"`
+	ProductID int      `json:"product_id"`
+	Product   *Product `json:"product,omitempty"`
+}
+
+// WAL is an append-only JSON-lines log of store mutations. Records carry a
+// monotonically increasing sequence number so a snapshot can state which
+// prefix of the log it already contains.
+type WAL struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	fsync bool
+	seq   uint64
+}
+
+// OpenWAL opens (or creates) the log at path and returns it together with the
+// complete records it already holds. A torn final line left by a crash
+// mid-write is logged and truncated so new appends start on a clean boundary.
+// A corrupt record anywhere else fails the open and leaves the file as it
+// is, since truncating there would drop every record after it.
+func OpenWAL(path string, fsync bool) (*WAL, []walRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open wal: %w", err)
+	}
+
+	records, good, err := readWAL(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > good {
+		slog.Warn("wal: discarding torn tail after the last complete record", "path", path, "bytes", info.Size()-good)
+		if err := file.Truncate(good); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("truncate wal: %w", err)
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("seek wal: %w", err)
+	}
+
+	w := &WAL{path: path, file: file, fsync: fsync}
+	if len(records) > 0 {
+		w.seq = records[len(records)-1].Seq
+	}
+	return w, records, nil
+}
+
+// readWAL decodes records from r until EOF, returning the byte offset just
+// past the last good record. Only a final line without its newline is taken
+// as a torn write; a complete line that doesn't decode is an error.
+func readWAL(r io.Reader) ([]walRecord, int64, error) {
+	var records []walRecord
+	var good int64
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything without a trailing newline is a torn write
+			return records, good, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("read wal: %w", err)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return nil, 0, fmt.Errorf("read wal: corrupt record %d at byte %d: %w", len(records)+1, good, err)
+		}
+		records = append(records, rec)
+		good += int64(len(line))
+	}
+}
+
+// LastSeq returns the sequence number of the most recent record
+func (w *WAL) LastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// advanceTo makes sure new records are numbered after seq, used when a
+// snapshot is newer than every record left in a compacted log
+func (w *WAL) advanceTo(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq > w.seq {
+		w.seq = seq
+	}
+}
+
+// appendPut logs that p was stored
+func (w *WAL) appendPut(p Product) error {
+	return w.append(walRecord{Op: walOpPut, ProductID: p.ProductID, Product: &p})
+}
+
+// appendDelete logs that the product with id was removed
+func (w *WAL) appendDelete(id int) error {
+	return w.append(walRecord{Op: walOpDelete, ProductID: id})
+}
+
+// appendClear logs that every product was removed
+func (w *WAL) appendClear() error {
+	return w.append(walRecord{Op: walOpClear})
+}
+
+func (w *WAL) append(rec walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec.Seq = w.seq + 1
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode wal record: %w", err)
+	}
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("append wal: %w", err)
+	}
+	if w.fsync {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("sync wal: %w", err)
+		}
+	}
+	w.seq = rec.Seq
+	return nil
+}
+
+// Compact rewrites the log keeping only records newer than upTo, which a
+// just-completed snapshot already covers. Appends wait while it runs.
+func (w *WAL) Compact(upTo uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek wal: %w", err)
+	}
+	records, _, err := readWAL(w.file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp wal: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	bw := bufio.NewWriter(tmp)
+	for _, rec := range records {
+		if rec.Seq <= upTo {
+			continue
+		}
+		line, _ := json.Marshal(rec)
+		bw.Write(append(line, '\n'))
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp wal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp wal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("rename wal: %w", err)
+	}
+
+	// tmp now is the log; keep appending to it
+	w.file.Close()
+	w.file = tmp
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek wal: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the log file
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Replay applies records newer than afterSeq to the store without logging
+// them again. It must run before AttachWAL.
+func (s *InMemoryStore) Replay(records []walRecord, afterSeq uint64) (applied int) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	for _, rec := range records {
+		if rec.Seq <= afterSeq {
+			continue
+		}
+		switch {
+		case rec.Op == walOpPut && rec.Product != nil:
+			if s.putLocked(*rec.Product) == nil {
+				applied++
+			}
+		case rec.Op == walOpDelete:
+			s.deleteLocked(rec.ProductID)
+			applied++
+		case rec.Op == walOpClear:
+			s.clearLocked()
+			applied++
+		}
+	}
+	return applied
+}
+
+// AttachWAL makes every subsequent mutation append to w before it is applied
+func (s *InMemoryStore) AttachWAL(w *WAL) {
+	s.lockAll()
+	defer s.unlockAll()
+	s.wal = w
+}
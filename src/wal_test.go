@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestWAL logs three puts to a new WAL in a temporary directory and
+// returns its path and contents
+func writeTestWAL(t *testing.T) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "products.wal")
+	w, _, err := OpenWAL(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 3; id++ {
+		if err := w.appendPut(testProduct(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestOpenWALTruncatesTornTail(t *testing.T) {
+	path, data := writeTestWAL(t)
+	if err := os.WriteFile(path, append(bytes.Clone(data), `{"seq":4,"op":"put","prod`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, records, err := OpenWAL(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || w.LastSeq() != 3 {
+		t.Fatalf("got %d records up to seq %d, want the 3 complete ones", len(records), w.LastSeq())
+	}
+	if err := w.appendDelete(1); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// The next append starts where the torn line was cut off
+	_, records, err = OpenWAL(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[3].Op != walOpDelete || records[3].Seq != 4 {
+		t.Errorf("after appending past the torn tail: got %+v", records)
+	}
+}
+
+func TestOpenWALRejectsCorruptRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(lines []string)
+	}{
+		{"middle record", func(lines []string) { lines[1] = `{"seq":2,"op":` }},
+		{"blank line", func(lines []string) { lines[1] = "" }},
+		{"last complete record", func(lines []string) { lines[2] = "not json" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, data := writeTestWAL(t)
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			tc.corrupt(lines)
+			corrupt := []byte(strings.Join(lines, "\n") + "\n")
+			if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := OpenWAL(path, false); err == nil || !strings.Contains(err.Error(), "corrupt record") {
+				t.Fatalf("got %v, want a corrupt record error", err)
+			}
+			if after, _ := os.ReadFile(path); !bytes.Equal(after, corrupt) {
+				t.Errorf("open changed the corrupt log from %q to %q", corrupt, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers sent with every delivery
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Delivery outcomes, as webhook_deliveries_total labels
+const (
+	webhookDelivered = "delivered"
+	webhookFailed    = "failed"
+	webhookDropped   = "dropped"
+)
+
+// webhookBaseBackoff is the wait before the first retry; each further retry
+// waits twice as long
+const webhookBaseBackoff = 500 * time.Millisecond
+
+// Webhook is a subscription to product change events. Secret is write-only:
+// responses only say whether one is set.
+type Webhook struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	Secret    string `json:"secret,omitempty"`
+	HasSecret bool   `json:"has_secret"`
+}
+
+// redacted returns w as it is shown to clients
+func (w Webhook) redacted() Webhook {
+	w.HasSecret = w.Secret != ""
+	w.Secret = ""
+	return w
+}
+
+// WebhookEvent is the body POSTed to subscribers
+type WebhookEvent struct {
+	Event string `json:"event"`
+	// Product is the product as stored, or just its product_id for
+	// product.deleted
+	Product   any       `json:"product"`
+	Timestamp time.Time `json:"timestamp"`
+	// Only with MULTI_TENANT
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// webhookDelivery is one event on its way to one subscriber
+type webhookDelivery struct {
+	hook  Webhook
+	id    string
+	event string
+	body  []byte
+}
+
+// webhookDispatcher holds the subscriptions and delivers events to them on
+// a bounded queue, so writes never wait for a subscriber. A full queue
+// drops the delivery rather than block the write.
+type webhookDispatcher struct {
+	mu     sync.RWMutex
+	hooks  map[string]Webhook
+	nextID int
+	// IDs of the subscriptions made from WEBHOOK_URLS
+	configured []string
+
+	queue       chan webhookDelivery
+	client      *http.Client
+	maxAttempts int
+	failed      atomic.Int64
+	onResult    func(result string)
+}
+
+// newWebhookDispatcher returns a dispatcher subscribed to WEBHOOK_URLS, all
+// signed with WEBHOOK_SECRET. Deliveries start once run is called.
+func newWebhookDispatcher(cfg Config, onResult func(result string)) *webhookDispatcher {
+	d := &webhookDispatcher{
+		hooks:       make(map[string]Webhook),
+		queue:       make(chan webhookDelivery, cfg.WebhookQueueSize),
+		client:      &http.Client{Timeout: cfg.WebhookTimeout},
+		maxAttempts: cfg.WebhookMaxAttempts,
+		onResult:    onResult,
+	}
+	d.replaceConfigured(cfg.WebhookURLs, cfg.WebhookSecret)
+	return d
+}
+
+// replaceConfigured swaps the subscriptions made from WEBHOOK_URLS for
+// ones to urls, leaving those made through /admin/webhooks alone
+func (d *webhookDispatcher) replaceConfigured(urls []string, secret string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range d.configured {
+		delete(d.hooks, id)
+	}
+	d.configured = d.configured[:0]
+	for _, u := range urls {
+		d.nextID++
+		w := Webhook{ID: "wh-" + strconv.Itoa(d.nextID), URL: u, Secret: secret}
+		d.hooks[w.ID] = w
+		d.configured = append(d.configured, w.ID)
+	}
+}
+
+// add stores w under a new ID and returns it
+func (d *webhookDispatcher) add(w Webhook) Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	w.ID = "wh-" + strconv.Itoa(d.nextID)
+	d.hooks[w.ID] = w
+	return w
+}
+
+// get returns the subscription with the given ID
+func (d *webhookDispatcher) get(id string) (Webhook, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	w, ok := d.hooks[id]
+	return w, ok
+}
+
+// replace overwrites an existing subscription, reporting whether it existed
+func (d *webhookDispatcher) replace(w Webhook) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hooks[w.ID]; !ok {
+		return false
+	}
+	d.hooks[w.ID] = w
+	return true
+}
+
+// remove deletes a subscription, reporting whether it existed
+func (d *webhookDispatcher) remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return false
+	}
+	delete(d.hooks, id)
+	return true
+}
+
+// snapshot returns every subscription in no particular order
+func (d *webhookDispatcher) snapshot() []Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	hooks := make([]Webhook, 0, len(d.hooks))
+	for _, w := range d.hooks {
+		hooks = append(hooks, w)
+	}
+	return hooks
+}
+
+// list returns every subscription in the order they were created
+func (d *webhookDispatcher) list() []Webhook {
+	hooks := d.snapshot()
+	slices.SortFunc(hooks, func(x, y Webhook) int {
+		return cmp.Or(cmp.Compare(len(x.ID), len(y.ID)), strings.Compare(x.ID, y.ID))
+	})
+	return hooks
+}
+
+// publishChange queues event for every subscriber
+func (d *webhookDispatcher) publishChange(tenant, event string, productID int, product *Product) {
+	hooks := d.snapshot()
+	if len(hooks) == 0 {
+		return
+	}
+	msg := WebhookEvent{Event: event, Product: map[string]int{"product_id": productID}, Timestamp: time.Now().UTC(), TenantID: tenant}
+	if product != nil {
+		msg.Product = product
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("webhook event encoding failed", "event", event, "error", err)
+		return
+	}
+	id := newDeliveryID()
+	for _, w := range hooks {
+		select {
+		case d.queue <- webhookDelivery{hook: w, id: id, event: event, body: body}:
+		default:
+			d.failed.Add(1)
+			d.onResult(webhookDropped)
+			slog.Warn("webhook queue full, delivery dropped", "webhook_id", w.ID, "event", event)
+		}
+	}
+}
+
+// run delivers queued events with workers goroutines until ctx is done.
+// Deliveries still queued then are abandoned.
+func (d *webhookDispatcher) run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case del := <-d.queue:
+					d.deliver(ctx, del)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(d.queue); n > 0 {
+		slog.Warn("webhook deliveries abandoned at shutdown", "pending", n)
+	}
+}
+
+// deliver POSTs del until the subscriber answers 2xx, retrying with
+// exponential backoff up to maxAttempts
+func (d *webhookDispatcher) deliver(ctx context.Context, del webhookDelivery) {
+	backoff := webhookBaseBackoff
+	var err error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if err = d.post(ctx, del); err == nil {
+			d.onResult(webhookDelivered)
+			return
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	d.failed.Add(1)
+	d.onResult(webhookFailed)
+	slog.Warn("webhook delivery failed", "webhook_id", del.hook.ID, "event", del.event,
+		"delivery_id", del.id, "attempts", d.maxAttempts, "error", err)
+}
+
+// post makes one delivery attempt
+func (d *webhookDispatcher) post(ctx context.Context, del webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.hook.URL, bytes.NewReader(del.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, del.event)
+	req.Header.Set(webhookDeliveryHeader, del.id)
+	if del.hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhook(del.hook.Secret, del.body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("subscriber answered " + resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the X-Webhook-Signature value for body: "sha256="
+// and the hex HMAC-SHA256 of the body under secret
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeliveryID returns a random ID shared by every delivery of one event,
+// so subscribers can drop retried duplicates
+func newDeliveryID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validateWebhook checks a subscription sent to /admin/webhooks
+func validateWebhook(w Webhook) []FieldError {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []FieldError{{Field: "url", Constraint: "must be an absolute http or https URL", Value: w.URL}}
+	}
+	return nil
+}
+
+// listWebhooks handles GET /admin/webhooks
+// Returns 200 with every subscription, secrets redacted
+func (a *API) listWebhooks(c *gin.Context) {
+	hooks := a.webhooks.list()
+	for i := range hooks {
+		hooks[i] = hooks[i].redacted()
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// getWebhook handles GET /admin/webhooks/{webhookId}
+// Returns 200 with the subscription, 404 if not found
+func (a *API) getWebhook(c *gin.Context) {
+	w, ok := a.webhooks.get(c.Param("webhookId"))
+	if !ok {
+		writeWebhookNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, w.redacted())
+}
+
+// createWebhook handles POST /admin/webhooks
+// Returns 201 with Location and the subscription, 400 if invalid input
+func (a *API) createWebhook(c *gin.Context) {
+	w, ok := a.readWebhook(c)
+	if !ok {
+		return
+	}
+	w = a.webhooks.add(w)
+	c.Header("Location", "/admin/webhooks/"+w.ID)
+	c.JSON(http.StatusCreated, w.redacted())
+}
+
+// replaceWebhook handles PUT /admin/webhooks/{webhookId}
+// An omitted secret clears it.
+// Returns 200 with the subscription, 400 if invalid input, 404 if not found
+func (a *API) replaceWebhook(c *gin.Context) {
+	w, ok := a.readWebhook(c)
+	if !ok {
+		return
+	}
+	w.ID = c.Param("webhookId")
+	if !a.webhooks.replace(w) {
+		writeWebhookNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, w.redacted())
+}
+
+// deleteWebhook handles DELETE /admin/webhooks/{webhookId}
+// Deliveries already queued still go out.
+// Returns 204 on success, 404 if not found
+func (a *API) deleteWebhook(c *gin.Context) {
+	if !a.webhooks.remove(c.Param("webhookId")) {
+		writeWebhookNotFound(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// readWebhook decodes and validates a subscription body, writing a 400 if
+// it is invalid
+func (a *API) readWebhook(c *gin.Context) (Webhook, bool) {
+	var w Webhook
+	if err := a.readJSON(c, &w); err != nil {
+		writeDecodeError(c, err)
+		return w, false
+	}
+	if errs := validateWebhook(w); errs != nil {
+		writeError(c, http.StatusBadRequest, ErrorResponse{
+			Error:     "INVALID_INPUT",
+			Message:   "Validation failed",
+			Details:   fieldErrorsDetails(errs),
+			Fields:    errs,
+			RequestID: requestID(c),
+		})
+		return w, false
+	}
+	w.HasSecret = false
+	return w, true
+}
+
+// writeWebhookNotFound writes the 404 for an unknown webhookId
+func writeWebhookNotFound(c *gin.Context) {
+	writeError(c, http.StatusNotFound, ErrorResponse{
+		Error:     "NOT_FOUND",
+		Message:   "Webhook not found",
+		Details:   "No webhook found with ID " + c.Param("webhookId"),
+		RequestID: requestID(c),
+	})
+}
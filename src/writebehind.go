@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeBehindBaseBackoff is the wait before the second try of a flush,
+// doubling each time after
+const writeBehindBaseBackoff = 200 * time.Millisecond
+
+// writeBehindFlushTimeout bounds one flush attempt to the backing store
+const writeBehindFlushTimeout = 30 * time.Second
+
+// bulkWriter is implemented by backing stores that take many writes in one
+// call
+type bulkWriter interface {
+	// WriteBatch stores puts as given and removes deletes, which need not
+	// exist
+	WriteBatch(ctx context.Context, puts []Product, deletes []int) error
+}
+
+// writeBehindCounter is implemented by stores that persist writes in the
+// background
+type writeBehindCounter interface {
+	QueueDepth() int
+	WriteBehindCounts() (flushed, dropped, failed int64)
+}
+
+// writeBehindStore serves every request from an InMemoryStore and copies
+// its writes to a backing store in the background. A write returns once the
+// in-memory store has it; its product ID is then queued for one of the
+// workers, picked by ID so each product is always flushed by the same one.
+// A worker collects up to batchSize IDs, waiting at most linger after the
+// first, reads their current state from memory and writes it out, so
+// repeated writes to one product cost one backing write and the backing
+// store ends up with the newest state whatever order the writes queued in.
+//
+// The backing store lags by whatever is queued or being flushed; that is
+// what a crash loses. Writes that find their worker's queue full, and
+// flushes still failing after maxAttempts, are logged and counted but never
+// retried, leaving the backing store behind until the product is next
+// written.
+type writeBehindStore struct {
+	mem         *InMemoryStore
+	backing     ProductStore
+	queues      []chan int
+	batchSize   int
+	linger      time.Duration
+	maxAttempts int
+
+	flushed, dropped, failed atomic.Int64
+}
+
+// newWriteBehindStore loads every product of backing into memory, within
+// ctx, and returns a store writing back to it. Call run to start the workers.
+func newWriteBehindStore(ctx context.Context, backing ProductStore, cfg Config) (*writeBehindStore, error) {
+	items, err := backing.List(ctx, ListFilter{IncludeDeleted: true})
+	if err != nil {
+		return nil, fmt.Errorf("load backing store: %w", err)
+	}
+	mem := NewInMemoryStore(cfg.SKUCaseInsensitive)
+	if loaded, skipped := mem.Restore(items); skipped > 0 {
+		slog.Warn("write-behind load skipped products", "loaded", loaded, "skipped", skipped)
+	}
+	s := &writeBehindStore{
+		mem:         mem,
+		backing:     backing,
+		queues:      make([]chan int, cfg.WriteBehindWorkers),
+		batchSize:   cfg.WriteBehindBatchSize,
+		linger:      cfg.WriteBehindLinger,
+		maxAttempts: cfg.WriteBehindMaxAttempts,
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan int, max(cfg.WriteBehindQueueSize/len(s.queues), 1))
+	}
+	return s, nil
+}
+
+// enqueue hands id to its worker, dropping it if that worker's queue is full
+func (s *writeBehindStore) enqueue(id int) {
+	select {
+	case s.queues[uint(id)%uint(len(s.queues))] <- id:
+	default:
+		s.dropped.Add(1)
+		slog.Error("write-behind queue full; change not persisted", "product_id", id)
+	}
+}
+
+func (s *writeBehindStore) Get(ctx context.Context, id int) (Product, error) {
+	return s.mem.Get(ctx, id)
+}
+
+func (s *writeBehindStore) GetBySKU(ctx context.Context, sku string) (Product, error) {
+	return s.mem.GetBySKU(ctx, sku)
+}
+
+func (s *writeBehindStore) GetMany(ctx context.Context, ids []int) (map[int]Product, error) {
+	return s.mem.GetMany(ctx, ids)
+}
+
+func (s *writeBehindStore) List(ctx context.Context, filter ListFilter) ([]Product, error) {
+	return s.mem.List(ctx, filter)
+}
+
+// The in-memory store's read-only capabilities are passed through. Those
+// that remove products (Clear, SweepExpired, eviction) are not, as the
+// backing store would never hear of it; that leaves TTLs unsupported too.
+
+func (s *writeBehindStore) Count() int                         { return s.mem.Count() }
+func (s *writeBehindStore) Stats() StoreStats                  { return s.mem.Stats() }
+func (s *writeBehindStore) ManufacturerCounts() map[string]int { return s.mem.ManufacturerCounts() }
+func (s *writeBehindStore) Export() ([]Product, time.Time)     { return s.mem.Export() }
+
+func (s *writeBehindStore) ListPage(filter ListFilter, afterID, limit int) ([]Product, error) {
+	return s.mem.ListPage(filter, afterID, limit)
+}
+
+func (s *writeBehindStore) Put(ctx context.Context, p *Product) (bool, error) {
+	created, err := s.mem.Put(ctx, p)
+	if err == nil {
+		s.enqueue(p.ProductID)
+	}
+	return created, err
+}
+
+func (s *writeBehindStore) Create(ctx context.Context, p *Product) error {
+	err := s.mem.Create(ctx, p)
+	if err == nil {
+		s.enqueue(p.ProductID)
+	}
+	return err
+}
+
+func (s *writeBehindStore) PutBatch(ctx context.Context, items []Product, atomic bool) []error {
+	errs := s.mem.PutBatch(ctx, items, atomic)
+	for i, err := range errs {
+		if err == nil {
+			s.enqueue(items[i].ProductID)
+		}
+	}
+	return errs
+}
+
+func (s *writeBehindStore) Update(ctx context.Context, id int, fn func(p *Product) error) (Product, error) {
+	p, err := s.mem.Update(ctx, id, fn)
+	if err == nil {
+		s.enqueue(id)
+	}
+	return p, err
+}
+
+func (s *writeBehindStore) Delete(ctx context.Context, id int) error {
+	err := s.mem.Delete(ctx, id)
+	if err == nil {
+		s.enqueue(id)
+	}
+	return err
+}
+
+func (s *writeBehindStore) DeleteIf(ctx context.Context, id int, cond func(p Product) bool) (bool, error) {
+	removed, err := s.mem.DeleteIf(ctx, id, cond)
+	if removed {
+		s.enqueue(id)
+	}
+	return removed, err
+}
+
+// Ping checks the backing store, as falling behind it is what readiness
+// should catch
+func (s *writeBehindStore) Ping(ctx context.Context) error {
+	if p, ok := s.backing.(storePinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *writeBehindStore) QueueDepth() int {
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func (s *writeBehindStore) WriteBehindCounts() (flushed, dropped, failed int64) {
+	return s.flushed.Load(), s.dropped.Load(), s.failed.Load()
+}
+
+// run flushes the queues until ctx is done, then drains them: everything
+// queued by then is flushed before run returns, so stop the servers first.
+// observe is called with the duration of every successful flush.
+func (s *writeBehindStore) run(ctx context.Context, observe func(time.Duration)) {
+	var wg sync.WaitGroup
+	for _, q := range s.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx, q, observe)
+		}()
+	}
+	wg.Wait()
+}
+
+// work batches the IDs arriving on q and flushes them
+func (s *writeBehindStore) work(ctx context.Context, q chan int, observe func(time.Duration)) {
+	batch := make(map[int]struct{}, s.batchSize)
+	for {
+		select {
+		case id := <-q:
+			batch[id] = struct{}{}
+		case <-ctx.Done():
+			s.drain(ctx, q, batch, observe)
+			return
+		}
+		linger := time.NewTimer(s.linger)
+	collect:
+		for len(batch) < s.batchSize {
+			select {
+			case id := <-q:
+				batch[id] = struct{}{}
+			case <-linger.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+		linger.Stop()
+		s.flush(ctx, batch, observe)
+		clear(batch)
+	}
+}
+
+// drain flushes batch and everything left in q, without waiting for more
+func (s *writeBehindStore) drain(ctx context.Context, q chan int, batch map[int]struct{}, observe func(time.Duration)) {
+	for {
+		select {
+		case id := <-q:
+			batch[id] = struct{}{}
+			if len(batch) < s.batchSize {
+				continue
+			}
+		default:
+		}
+		if len(batch) == 0 {
+			return
+		}
+		s.flush(ctx, batch, observe)
+		clear(batch)
+	}
+}
+
+// flush writes the current state of the products in batch to the backing
+// store, retrying with exponential backoff up to maxAttempts. Once ctx is
+// done it retries without waiting, so shutdown isn't held up by backoff.
+func (s *writeBehindStore) flush(ctx context.Context, batch map[int]struct{}, observe func(time.Duration)) {
+	var puts []Product
+	var deletes []int
+	now := time.Now()
+	for id := range batch {
+		p, exists := s.current(id, now)
+		if exists {
+			puts = append(puts, p)
+		} else {
+			deletes = append(deletes, id)
+		}
+	}
+
+	backoff := writeBehindBaseBackoff
+	var err error
+	for attempt := 1; attempt <= s.maxAttempts; attempt++ {
+		start := time.Now()
+		if err = s.write(puts, deletes); err == nil {
+			observe(time.Since(start))
+			s.flushed.Add(int64(len(batch)))
+			return
+		}
+		if attempt == s.maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	s.failed.Add(int64(len(batch)))
+	slog.Error("write-behind flush failed", "products", len(batch), "attempts", s.maxAttempts, "error", err)
+}
+
+// current returns the stored state of id as the backing store should hold
+// it, soft-deleted products included
+func (s *writeBehindStore) current(id int, now time.Time) (Product, bool) {
+	sh := s.mem.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	p, exists := sh.products[id]
+	if !exists || p.expired(now) {
+		return Product{}, false
+	}
+	return p.clone(), true
+}
+
+// write makes one attempt at storing puts and removing deletes
+func (s *writeBehindStore) write(puts []Product, deletes []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeBehindFlushTimeout)
+	defer cancel()
+	if bw, ok := s.backing.(bulkWriter); ok {
+		return bw.WriteBatch(ctx, puts, deletes)
+	}
+	for i := range puts {
+		if _, err := s.backing.Put(ctx, &puts[i]); err != nil {
+			return err
+		}
+	}
+	for _, id := range deletes {
+		if err := s.backing.Delete(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
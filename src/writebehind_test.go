@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBacking is a backing store taking bulk writes, failing the first
+// failures of them
+type flakyBacking struct {
+	*InMemoryStore
+	failures atomic.Int32
+	batches  atomic.Int32
+}
+
+func (b *flakyBacking) WriteBatch(ctx context.Context, puts []Product, deletes []int) error {
+	b.batches.Add(1)
+	if b.failures.Add(-1) >= 0 {
+		return errors.New("throttled")
+	}
+	for i := range puts {
+		if _, err := b.Put(ctx, &puts[i]); err != nil {
+			return err
+		}
+	}
+	for _, id := range deletes {
+		if err := b.Delete(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBehindConfig is the configuration of the write-behind tests: few
+// workers and a short linger so batches fill and flush quickly
+func writeBehindConfig(t *testing.T) Config {
+	cfg := testConfig(t, nil)
+	cfg.WriteBehindWorkers, cfg.WriteBehindBatchSize, cfg.WriteBehindLinger = 2, 25, 5*time.Millisecond
+	return cfg
+}
+
+// startWriteBehind returns a write-behind store over backing with its
+// workers running, and a stop func that shuts them down and waits for the
+// final drain, as main does
+func startWriteBehind(t *testing.T, backing ProductStore, cfg Config) (*writeBehindStore, func()) {
+	t.Helper()
+	s, err := newWriteBehindStore(context.Background(), backing, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, func(time.Duration) {})
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return s, stop
+}
+
+// sameContents fails t unless a and b hold the same products, ignoring
+// the timestamps each store sets on its own writes
+func sameContents(t *testing.T, got, want ProductStore) {
+	t.Helper()
+	list := func(s ProductStore) map[int]Product {
+		items, err := s.List(context.Background(), ListFilter{IncludeDeleted: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[int]Product, len(items))
+		for _, p := range items {
+			p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{}
+			m[p.ProductID] = p
+		}
+		return m
+	}
+	g, w := list(got), list(want)
+	if len(g) != len(w) {
+		t.Errorf("got %d products, want %d", len(g), len(w))
+	}
+	for id, p := range w {
+		if !sameProduct(g[id], p) {
+			t.Errorf("product %d: got %+v, want %+v", id, g[id], p)
+		}
+	}
+}
+
+// TestWriteBehindDurability writes through the store from several
+// goroutines, including rewrites and deletes, and checks that once the
+// workers have shut down the backing store matches memory exactly, and a
+// store started over it again loads the same products
+func TestWriteBehindDurability(t *testing.T) {
+	ctx := context.Background()
+	backing := &flakyBacking{InMemoryStore: NewInMemoryStore(false)}
+	mustPut(t, backing, testProduct(1000))
+	s, stop := startWriteBehind(t, backing, writeBehindConfig(t))
+	if _, err := s.Get(ctx, 1000); err != nil {
+		t.Fatalf("product already in the backing store not loaded: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				id := 1 + (w*100+i)%150
+				p := testProduct(id)
+				p.Weight = w*1000 + i
+				if _, err := s.Put(ctx, &p); err != nil {
+					t.Error(err)
+					return
+				}
+				switch i % 10 {
+				case 3:
+					s.Update(ctx, id, func(p *Product) error { p.Quantity += 2; return nil })
+				case 7:
+					s.Delete(ctx, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	batch := []Product{testProduct(2000), testProduct(2001)}
+	s.PutBatch(ctx, batch, true)
+	s.Delete(ctx, 1000)
+	stop()
+
+	sameContents(t, backing, s.mem)
+	flushed, dropped, failed := s.WriteBehindCounts()
+	if dropped != 0 || failed != 0 || flushed == 0 || s.QueueDepth() != 0 {
+		t.Errorf("flushed %d, dropped %d, failed %d, %d still queued", flushed, dropped, failed, s.QueueDepth())
+	}
+
+	reloaded, _ := startWriteBehind(t, backing, writeBehindConfig(t))
+	sameContents(t, reloaded, s.mem)
+}
+
+func TestWriteBehindRetriesFailedFlush(t *testing.T) {
+	backing := &flakyBacking{InMemoryStore: NewInMemoryStore(false)}
+	backing.failures.Store(2)
+	s, stop := startWriteBehind(t, backing, writeBehindConfig(t))
+	mustPut(t, s, testProduct(1))
+
+	// Base backoff 200ms, then 400ms: the third try lands after ~600ms
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := backing.Get(context.Background(), 1); err != nil; _, err = backing.Get(context.Background(), 1) {
+		if time.Now().After(deadline) {
+			t.Fatal("product never reached the backing store")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	if _, _, failed := s.WriteBehindCounts(); failed != 0 || backing.batches.Load() != 3 {
+		t.Errorf("failed %d after %d attempts, want 0 after 3", failed, backing.batches.Load())
+	}
+}
+
+func TestWriteBehindGivesUp(t *testing.T) {
+	backing := &flakyBacking{InMemoryStore: NewInMemoryStore(false)}
+	backing.failures.Store(1 << 30)
+	cfg := writeBehindConfig(t)
+	cfg.WriteBehindMaxAttempts = 3
+	s, stop := startWriteBehind(t, backing, cfg)
+	mustPut(t, s, testProduct(1), testProduct(2))
+
+	// Shutting down retries without the backoff, so this doesn't wait it out
+	start := time.Now()
+	stop()
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("shutdown took %v with a failing backing store", waited)
+	}
+	if _, _, failed := s.WriteBehindCounts(); failed != 2 {
+		t.Errorf("failed %d, want both products", failed)
+	}
+	if got := backing.batches.Load(); got%3 != 0 || got == 0 {
+		t.Errorf("%d attempts, want 3 per batch", got)
+	}
+	if _, err := s.Get(context.Background(), 1); err != nil {
+		t.Errorf("memory lost the product the backing store refused: %v", err)
+	}
+}
+
+func TestWriteBehindDropsWhenQueueFull(t *testing.T) {
+	cfg := writeBehindConfig(t)
+	cfg.WriteBehindWorkers, cfg.WriteBehindQueueSize = 1, 4
+	// Workers not started, so nothing drains the queue
+	s, err := newWriteBehindStore(context.Background(), NewInMemoryStore(false), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 6; id++ {
+		mustPut(t, s, testProduct(id))
+	}
+	if _, dropped, _ := s.WriteBehindCounts(); dropped != 2 || s.QueueDepth() != 4 {
+		t.Errorf("dropped %d with %d queued, want 2 and 4", dropped, s.QueueDepth())
+	}
+}
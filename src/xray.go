@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+
+	"text/main/buildinfo"
+)
+
+// configureXRay points the X-Ray SDK at the daemon. Calls made without a
+// segment in their context, such as startup and background work, are
+// skipped silently instead of logging an error each.
+func configureXRay(cfg Config) error {
+	return xray.Configure(xray.Config{
+		DaemonAddr:             cfg.XRayDaemonAddr,
+		ServiceVersion:         buildinfo.Get().Version,
+		ContextMissingStrategy: ctxmissing.NewDefaultIgnoreErrorStrategy(),
+	})
+}
+
+// xrayTracing opens an X-Ray segment per request, named after the service
+// and joined to the trace in X-Amzn-Trace-Id when the ALB sent one. The
+// route template, request ID and any ErrorResponse code are annotations, so
+// they can be searched on in the console.
+func (a *API) xrayTracing(c *gin.Context) {
+	incoming := header.FromString(c.GetHeader(xray.TraceIDHeaderKey))
+	ctx, seg := xray.NewSegmentFromHeader(c.Request.Context(), a.cfg.ServiceName, c.Request, incoming)
+	defer seg.Close(nil)
+	c.Request = c.Request.WithContext(ctx)
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	seg.Lock()
+	req := seg.GetHTTP().GetRequest()
+	req.Method = c.Request.Method
+	req.URL = c.Request.URL.Path
+	req.ClientIP = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+	seg.Unlock()
+	seg.AddAnnotation("route", route)
+	seg.AddAnnotation("request_id", requestID(c))
+	seg.AddAnnotation("commit", buildinfo.Get().Commit)
+	c.Header(xray.TraceIDHeaderKey, "Root="+seg.TraceID)
+
+	w := &errorBodyWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	seg.Lock()
+	seg.GetHTTP().GetResponse().ContentLength = max(w.Size(), 0)
+	seg.Unlock()
+	xray.HttpCaptureResponse(seg, w.Status())
+	if code := w.errorCode(); code != "" {
+		seg.AddAnnotation("error_code", code)
+	}
+}
+
+// xrayStoreSpans opens X-Ray subsegments; the SDK's DynamoDB subsegments
+// nest inside them
+type xrayStoreSpans struct{}
+
+func (xrayStoreSpans) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, storeSpan) {
+	ctx, seg := xray.BeginSubsegment(ctx, "store."+op)
+	s := xrayStoreSpan{seg}
+	s.setAttributes(attrs...)
+	return ctx, s
+}
+
+// xrayStoreSpan is a subsegment; it is nil when the request isn't traced
+type xrayStoreSpan struct{ seg *xray.Segment }
+
+// setAttributes records attrs as annotations. Annotation keys may not
+// contain dots and values must be strings, numbers or booleans.
+func (s xrayStoreSpan) setAttributes(attrs ...attribute.KeyValue) {
+	if s.seg == nil {
+		return
+	}
+	for _, kv := range attrs {
+		var value any
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			value = kv.Value.AsBool()
+		case attribute.INT64:
+			value = int(kv.Value.AsInt64())
+		case attribute.FLOAT64:
+			value = kv.Value.AsFloat64()
+		default:
+			value = kv.Value.Emit()
+		}
+		s.seg.AddAnnotation(strings.ReplaceAll(string(kv.Key), ".", "_"), value)
+	}
+}
+
+func (s xrayStoreSpan) end(err error) {
+	if s.seg == nil {
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	s.seg.Close(err)
+}